@@ -0,0 +1,83 @@
+package leveldb
+
+import "math"
+
+// tableOptions returns the options new tables are written with. Under
+// Options.AdaptiveFilter their bloom filters get the bits per key chosen by
+// filterBitsPerKey.
+func (db *DB) tableOptions() *Options {
+	if db.opts.AdaptiveFilter == nil || db.opts.FilterPolicy != FilterPolicyBloom {
+		return db.opts
+	}
+	opts := *db.opts
+	opts.FilterBitsPerKey = db.filterBitsPerKey()
+	db.filterBits.Store(int64(opts.FilterBitsPerKey))
+	return &opts
+}
+
+// filterBitsPerKey chooses the bits per key of the next bloom filter. Every
+// live table whose filter answered enough lookups asks for the bits that bring
+// its false positives per lookup to FilterFPRate, given the share of its
+// lookups that were for absent keys, plus a correction when its measured false
+// positives stray from the predicted ones. The tables weigh in by their number
+// of keys, which is what their filters cost. Without such a table, the last
+// choice stands, and before the first one filters are sized as without
+// AdaptiveFilter.
+func (db *DB) filterBitsPerKey() int {
+	af := db.opts.AdaptiveFilter
+	db.mu.RLock()
+	version := db.current
+	version.ref()
+	db.mu.RUnlock()
+	defer version.unref()
+
+	var bits, keys float64
+	for _, num := range version.tables {
+		c := db.tableIO(num)
+		checks := c.Filter.Checks.Load()
+		if checks < af.MinChecks {
+			continue
+		}
+		reader, err := db.findTable(num)
+		if err != nil {
+			continue
+		}
+		props := reader.Properties()
+		reader.Unref()
+		if props.FilterPolicy != FilterPolicyBloom || props.NumEntries == 0 {
+			continue
+		}
+		falsePositives := float64(c.Filter.FalsePositives.Load())
+		absent := float64(c.Filter.Negatives.Load()) + falsePositives
+		want := bloomBitsPerKey(db.opts.FilterFPRate * float64(checks) / max(absent, 1))
+		// A few expected false positives say little about the measured rate.
+		if predicted := absent * props.FilterFPRate; predicted >= 10 {
+			want += math.Log(max(falsePositives, 1)/predicted) / (math.Ln2 * math.Ln2)
+		}
+		bits += float64(props.NumEntries) * max(want, 0)
+		keys += float64(props.NumEntries)
+	}
+
+	var chosen float64
+	switch {
+	case keys > 0:
+		chosen = bits / keys
+	case db.filterBits.Load() > 0:
+		// Compactions replace the tables together with their statistics.
+		chosen = float64(db.filterBits.Load())
+	case db.opts.FilterBitsPerKey > 0:
+		chosen = float64(db.opts.FilterBitsPerKey)
+	default:
+		chosen = bloomBitsPerKey(db.opts.FilterFPRate)
+	}
+	return min(max(int(math.Round(chosen)), af.MinBitsPerKey), af.MaxBitsPerKey)
+}
+
+// bloomBitsPerKey returns the bits per key of a bloom filter with false
+// positive rate rate, 0 for a rate of 1 or more.
+func bloomBitsPerKey(rate float64) float64 {
+	if rate >= 1 {
+		return 0
+	}
+	return -math.Log(rate) / (math.Ln2 * math.Ln2)
+}
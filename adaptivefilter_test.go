@@ -0,0 +1,62 @@
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAdaptiveFilter(t *testing.T) {
+	db, err := OpenInMemory(&Options{AdaptiveFilter: &AdaptiveFilterOptions{MinChecks: 100}, DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("OpenInMemory failed: %v", err)
+	}
+	defer db.Close()
+	write := func(start int) {
+		t.Helper()
+		for i := start; i < start+1000; i += 2 {
+			if err := db.Put(WriteOptions{}, []byte(fmt.Sprintf("k%05d", i)), []byte("v")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	bitsOf := func(num int) int {
+		t.Helper()
+		r, err := db.findTable(num)
+		if err != nil {
+			t.Fatalf("findTable failed: %v", err)
+		}
+		defer r.Unref()
+		return r.Properties().FilterBitsPerKey
+	}
+
+	// Without statistics the filter is sized for FilterFPRate.
+	write(0)
+	if bits := bitsOf(db.current.tables[0]); bits != 10 {
+		t.Errorf("First table has %d bits per key, want 10", bits)
+	}
+
+	// Lookups of keys the table holds need no filter.
+	for i := 0; i < 1000; i += 2 {
+		db.Get([]byte(fmt.Sprintf("k%05d", i)))
+	}
+	write(1000)
+	if bits := bitsOf(db.current.tables[1]); bits != DefaultMinFilterBitsPerKey {
+		t.Errorf("Table after lookups of present keys has %d bits per key, want %d", bits, DefaultMinFilterBitsPerKey)
+	}
+
+	// Lookups of absent keys call for larger filters again: half of the
+	// lookups of the first table and all of those of the second were absent.
+	for i := 1; i < 1999; i += 2 {
+		db.Get([]byte(fmt.Sprintf("k%05d", i)))
+	}
+	write(2000)
+	if bits := bitsOf(db.current.tables[2]); bits < 6 || bits > 10 {
+		t.Errorf("Table after lookups of absent keys has %d bits per key, want about 8", bits)
+	}
+	if stats := db.FilterStats(); stats.BitsPerKey != bitsOf(db.current.tables[2]) {
+		t.Errorf("FilterStats reports %d bits per key", stats.BitsPerKey)
+	}
+}
@@ -0,0 +1,243 @@
+package leveldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"github.com/quangh33/Go-LevelDB/wal"
+	"io"
+	"log"
+	"path/filepath"
+)
+
+// retireWAL disposes of a WAL whose records are all in SSTables. It is moved
+// to Options.WALArchiveDir if one is set, and deleted otherwise.
+func retireWAL(path string, opts *Options) error {
+	fs := opts.FS
+	if opts.WALArchiveDir == "" {
+		return fs.Remove(path)
+	}
+	if err := fs.MkdirAll(opts.WALArchiveDir); err != nil {
+		return err
+	}
+	archived := filepath.Join(opts.WALArchiveDir, filepath.Base(path))
+	if err := fs.Rename(path, archived); err == nil {
+		return fs.SyncDir(opts.WALArchiveDir)
+	}
+	// The archive may be on another file system.
+	if err := copyFile(fs, path, archived); err != nil {
+		return err
+	}
+	return fs.Remove(path)
+}
+
+// copyFile copies src to dst and syncs the copy.
+func copyFile(fs FS, src, dst string) error {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// CreateCheckpoint writes a copy of the database to dir, which must not exist
+// yet. The memtable is flushed first, so the copy consists of SSTables and a
+// state file only. It can be opened like any database, or serve as the
+// starting point of RestoreToSequence. SSTables are hard linked when dir is on
+// the same file system.
+func (db *DB) CreateCheckpoint(dir string) error {
+	db.writeMu.Lock()
+	if db.closed.Load() {
+		db.writeMu.Unlock()
+		return ErrClosed
+	}
+	if err := db.flushLocked(); err != nil {
+		db.writeMu.Unlock()
+		return err
+	}
+	// With writers held off, the tables hold exactly the writes up to the
+	// sequence number and the WALs from logNumber on hold the rest.
+	db.mu.RLock()
+	version := db.current
+	version.ref()
+	state := db.stateLocked()
+	db.mu.RUnlock()
+	db.writeMu.Unlock()
+	defer version.unref()
+
+	fs := db.opts.FS
+	if err := fs.Mkdir(dir); err != nil {
+		return err
+	}
+	if err := copyTables(fs, db.dataDir, dir, state.ActiveSSTables); err != nil {
+		return err
+	}
+	if err := writeState(fs, dir, state); err != nil {
+		return err
+	}
+	log.Printf("Created checkpoint %s at sequence %d", dir, state.LastSequence)
+	return nil
+}
+
+// copyTables links or copies the SSTables nums from src to dst.
+func copyTables(fs FS, src, dst string, nums []int) error {
+	for _, num := range nums {
+		name := fmt.Sprintf("%05d.sst", num)
+		from, to := filepath.Join(src, name), filepath.Join(dst, name)
+		if err := fs.Link(from, to); err == nil {
+			continue
+		}
+		if err := copyFile(fs, from, to); err != nil {
+			return fmt.Errorf("failed to copy SSTable %s: %w", name, err)
+		}
+	}
+	return fs.SyncDir(dst)
+}
+
+// RestoreToSequence rebuilds the database as it was at sequence number seq in
+// targetDir, which must not exist yet. It starts from a checkpoint taken at or
+// before seq and adds the records of the WALs archived in archiveDir, see
+// Options.WALArchiveDir, up to the last write batch that ends at or before
+// seq. The result is opened like any database. It returns the sequence number
+// actually reached, which is lower than seq if the archive ends earlier.
+//
+// WALs are only archived once their memtable is flushed, so flush a database
+// that is still running before restoring from its archive. The WAL does not
+// record wall-clock time; to restore to a point in time, note the database's
+// sequence number, for example around deploys, and restore to that.
+//
+// Writes made with WriteOptions.DisableWAL and bulk loads never reach the WAL.
+// If they fall between the checkpoint and seq, the archive misses their
+// sequence numbers and RestoreToSequence fails rather than silently dropping
+// them; take a checkpoint after such writes instead. Such writes after the
+// last archived record cannot be detected.
+func RestoreToSequence(checkpointDir, archiveDir, targetDir string, seq uint64) (uint64, error) {
+	fs := OSFS{}
+	state, err := readState(fs, filepath.Join(checkpointDir, stateFile))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if state.LastSequence > seq {
+		return 0, fmt.Errorf("checkpoint at sequence %d is newer than %d", state.LastSequence, seq)
+	}
+	if err := fs.Mkdir(targetDir); err != nil {
+		return 0, err
+	}
+	if err := copyTables(fs, checkpointDir, targetDir, state.ActiveSSTables); err != nil {
+		return 0, err
+	}
+	if err := writeState(fs, targetDir, state); err != nil {
+		return 0, err
+	}
+
+	archived, err := vfs.Glob(fs, archiveDir, "wal-*.log")
+	if err != nil {
+		return 0, err
+	}
+	reached := state.LastSequence
+	for _, path := range archived {
+		if num, ok := walFileNumber(path); !ok || num < state.LogNumber {
+			continue // Already in the checkpoint's tables
+		}
+		last, done, err := copyWALUntil(fs, path, filepath.Join(targetDir, filepath.Base(path)), seq, reached+1)
+		if err != nil {
+			return 0, fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		reached = max(reached, last)
+		if done {
+			break
+		}
+	}
+	if err := fs.SyncDir(targetDir); err != nil {
+		return 0, err
+	}
+	log.Printf("Restored %s to sequence %d", targetDir, reached)
+	return reached, nil
+}
+
+// copyWALUntil copies the records of the WAL src to dst up to the last one
+// that ends at or before seq. It returns the last sequence number copied, and
+// whether a later record was found, so that no further WAL is needed. next is
+// the first sequence number not restored yet; a record starting after it
+// means that writes are missing.
+func copyWALUntil(fs FS, src, dst string, seq, next uint64) (uint64, bool, error) {
+	in, err := fs.Open(src)
+	if err != nil {
+		return 0, false, err
+	}
+	defer in.Close()
+	reader, err := wal.NewReader(in)
+	if err != nil {
+		return 0, false, err
+	}
+
+	out, err := fs.Create(dst)
+	if err != nil {
+		return 0, false, err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	w.Write(wal.Header(reader.Checksum()))
+
+	var last uint64
+	done := false
+	for {
+		record, offset, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		entry, err := reader.Decode(record, offset)
+		if err != nil {
+			return 0, false, err
+		}
+		first := entry.SeqNum
+		if first > next && first <= seq {
+			return 0, false, fmt.Errorf("sequence numbers %d to %d are not in the archive, they were written without the WAL", next, first-1)
+		}
+		end := first
+		switch entry.Op {
+		case OpPrepare, OpRollbackPrepared:
+			// Without sequence numbers, they go with the records around them.
+			if _, err := w.Write(record); err != nil {
+				return 0, false, err
+			}
+			continue
+		case OpBatch, OpCommitPrepared:
+			if len(entry.Value) < 4 {
+				return 0, false, &CorruptionError{File: src, Offset: offset, Err: errors.New("short batch record")}
+			}
+			end += uint64(binary.LittleEndian.Uint32(entry.Value[0:4])) - 1
+		}
+		if end > seq {
+			done = true
+			break
+		}
+		if _, err := w.Write(record); err != nil {
+			return 0, false, err
+		}
+		last = end
+		next = max(next, end+1)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, false, err
+	}
+	return last, done, out.Sync()
+}
@@ -0,0 +1,105 @@
+package leveldb
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreToSequence(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "archive")
+	db, err := Open(filepath.Join(root, "db"), &Options{WALArchiveDir: archive})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+	}
+	put(0, 10) // Sequence numbers 1 to 10
+	checkpoint := filepath.Join(root, "checkpoint")
+	if err := db.CreateCheckpoint(checkpoint); err != nil {
+		t.Fatalf("CreateCheckpoint failed: %v", err)
+	}
+	put(10, 20)
+	batch := NewWriteBatch()
+	batch.Put(generateKey(20), []byte("v"))
+	batch.Put(generateKey(21), []byte("v"))
+	if err := db.Write(WriteOptions{}, batch); err != nil { // Sequence numbers 21 and 22
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	put(22, 30)
+	db.Close() // The last writes stay in the active WAL and are not archived
+
+	archived, _ := filepath.Glob(filepath.Join(archive, "wal-*.log"))
+	if len(archived) == 0 {
+		t.Fatalf("No WAL was archived")
+	}
+	if _, err := RestoreToSequence(checkpoint, archive, filepath.Join(root, "old"), 5); err == nil {
+		t.Errorf("Expected restoring to before the checkpoint to fail")
+	}
+
+	for _, tc := range []struct {
+		seq, reached uint64
+		keys         int
+	}{
+		{seq: 10, reached: 10, keys: 10},
+		{seq: 15, reached: 15, keys: 15},
+		{seq: 21, reached: 20, keys: 20}, // The batch is restored as a whole or not at all
+		{seq: 100, reached: 22, keys: 22},
+	} {
+		target := filepath.Join(root, fmt.Sprintf("restored-%d", tc.seq))
+		reached, err := RestoreToSequence(checkpoint, archive, target, tc.seq)
+		if err != nil {
+			t.Fatalf("RestoreToSequence(%d) failed: %v", tc.seq, err)
+		}
+		if reached != tc.reached {
+			t.Errorf("RestoreToSequence(%d) reached %d, want %d", tc.seq, reached, tc.reached)
+		}
+		restored, err := NewDB(target)
+		if err != nil {
+			t.Fatalf("Failed to open restored DB: %v", err)
+		}
+		for i := 0; i < 30; i++ {
+			if _, err := restored.Get(generateKey(i)); (err == nil) != (i < tc.keys) {
+				t.Errorf("Restored to %d: key %d: %v", tc.seq, i, err)
+			}
+		}
+		restored.Close()
+	}
+}
+
+func TestRestoreFailsOnUnarchivedWrites(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "archive")
+	db, err := Open(filepath.Join(root, "db"), &Options{WALArchiveDir: archive})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	db.Put(WriteOptions{}, generateKey(0), []byte("v")) // Sequence number 1
+	checkpoint := filepath.Join(root, "checkpoint")
+	if err := db.CreateCheckpoint(checkpoint); err != nil {
+		t.Fatalf("CreateCheckpoint failed: %v", err)
+	}
+	db.Put(WriteOptions{}, generateKey(1), []byte("v"))
+	db.Put(WriteOptions{DisableWAL: true}, generateKey(2), []byte("v")) // Sequence number 3
+	db.Put(WriteOptions{}, generateKey(3), []byte("v"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if reached, err := RestoreToSequence(checkpoint, archive, filepath.Join(root, "before"), 2); err != nil || reached != 2 {
+		t.Errorf("RestoreToSequence(2) = %d, %v, want 2, nil", reached, err)
+	}
+	if _, err := RestoreToSequence(checkpoint, archive, filepath.Join(root, "after"), 4); err == nil {
+		t.Errorf("Expected restoring past a write made without the WAL to fail")
+	}
+}
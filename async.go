@@ -0,0 +1,69 @@
+package leveldb
+
+import (
+	"context"
+	"sync"
+)
+
+// GetResult is the outcome of a GetAsync lookup. Err is ErrNotFound if the
+// key does not exist.
+type GetResult struct {
+	Value []byte
+	Err   error
+}
+
+type asyncGet struct {
+	key    []byte
+	result chan GetResult
+}
+
+// asyncGets is the worker pool behind GetAsync. It is started on first use.
+type asyncGets struct {
+	mu      sync.RWMutex // Held for reading while queueing, for writing by stop
+	once    sync.Once
+	queue   chan asyncGet
+	stopped bool
+	workers sync.WaitGroup
+}
+
+// GetAsync looks up key on a pool of Options.AsyncGetWorkers goroutines and
+// returns a channel that receives the result once. Servers can issue many
+// lookups and overlap their disk reads without a goroutine per outstanding
+// read. If the queue of pending lookups is full, GetAsync blocks until a worker
+// takes one. Lookups queued before Close still complete; later ones fail with
+// ErrClosed.
+func (db *DB) GetAsync(key []byte) <-chan GetResult {
+	result := make(chan GetResult, 1)
+	p := &db.asyncGets
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped || db.closed.Load() {
+		result <- GetResult{Err: ErrClosed}
+		return result
+	}
+	p.once.Do(func() {
+		p.queue = make(chan asyncGet, AsyncGetQueueSize)
+		for range db.opts.AsyncGetWorkers {
+			p.workers.Go(func() {
+				for req := range p.queue {
+					val, err := db.GetContext(context.Background(), req.key)
+					req.result <- GetResult{Value: val, Err: err}
+				}
+			})
+		}
+	})
+	p.queue <- asyncGet{key: key, result: result}
+	return result
+}
+
+// stopAsyncGets lets the workers finish the queued lookups and waits for them.
+func (db *DB) stopAsyncGets() {
+	p := &db.asyncGets
+	p.mu.Lock()
+	p.stopped = true
+	if p.queue != nil {
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	p.workers.Wait()
+}
@@ -0,0 +1,47 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGetAsync(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{AsyncGetWorkers: 4})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if i == 50 {
+			if err := db.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+		}
+	}
+
+	results := make([]<-chan GetResult, 200)
+	for i := range results {
+		results[i] = db.GetAsync(generateKey(i))
+	}
+	for i, ch := range results {
+		res := <-ch
+		if res.Err != nil && !errors.Is(res.Err, ErrNotFound) {
+			t.Fatalf("GetAsync(%d) failed: %v", i, res.Err)
+		}
+		if want := i < 100; (res.Err == nil) != want || (want && string(res.Value) != fmt.Sprint(i)) {
+			t.Errorf("GetAsync(%d) = %q, %v", i, res.Value, res.Err)
+		}
+	}
+
+	pending := db.GetAsync(generateKey(1))
+	db.Close()
+	if res := <-pending; res.Err != nil {
+		t.Errorf("Lookup queued before Close = %+v, want found", res)
+	}
+	if res := <-db.GetAsync(generateKey(1)); !errors.Is(res.Err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %+v", res)
+	}
+}
@@ -0,0 +1,138 @@
+package leveldb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// backgroundJobs is the worker pool that runs flushes and compactions. It is
+// started on first use. Jobs queue without bound, so scheduling one never
+// blocks, even under db.mu. Flushes have strict priority, since writers may
+// be waiting for them: they run ahead of queued compactions, and a running
+// compaction yields to them, see yieldToFlushes.
+type backgroundJobs struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	once    sync.Once
+	flushes []func()
+	others  []func()
+	stopped bool
+	workers sync.WaitGroup
+	queued  atomic.Int32 // len(flushes), read without mu
+}
+
+// schedule runs job on one of Options.MaxBackgroundJobs goroutines. The
+// caller accounts for the job in db.wg, so Close waits for it.
+func (db *DB) schedule(job func(), flush bool) {
+	p := &db.jobs
+	p.once.Do(func() {
+		p.cond.L = &p.mu
+		if db.opts.ManualBackgroundJobs {
+			return
+		}
+		for range db.opts.MaxBackgroundJobs {
+			p.workers.Go(p.run)
+		}
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if flush {
+		p.flushes = append(p.flushes, job)
+		p.queued.Add(1)
+	} else {
+		p.others = append(p.others, job)
+	}
+	p.cond.Signal()
+}
+
+func (p *backgroundJobs) run() {
+	for {
+		p.mu.Lock()
+		for len(p.flushes) == 0 && len(p.others) == 0 && !p.stopped {
+			p.cond.Wait()
+		}
+		job := p.popLocked()
+		p.mu.Unlock()
+		if job == nil {
+			return
+		}
+		job()
+	}
+}
+
+// popLocked dequeues the next job, flushes first, or returns nil. The caller
+// must hold p.mu.
+func (p *backgroundJobs) popLocked() func() {
+	var job func()
+	switch {
+	case len(p.flushes) > 0:
+		job, p.flushes = p.flushes[0], p.flushes[1:]
+		p.queued.Add(-1)
+	case len(p.others) > 0:
+		job, p.others = p.others[0], p.others[1:]
+	}
+	return job
+}
+
+// RunBackgroundJob runs the next queued flush or compaction on the calling
+// goroutine and reports whether there was one. It is how a database opened
+// with Options.ManualBackgroundJobs makes progress in the background.
+func (db *DB) RunBackgroundJob() bool {
+	p := &db.jobs
+	p.mu.Lock()
+	job := p.popLocked()
+	p.mu.Unlock()
+	if job == nil {
+		return false
+	}
+	job()
+	return true
+}
+
+// waitForJobLocked waits on flushCond for a background job to finish. With
+// Options.ManualBackgroundJobs, it runs the next queued job instead. The
+// caller must hold db.mu, which is released meanwhile.
+func (db *DB) waitForJobLocked() {
+	if db.opts.ManualBackgroundJobs {
+		db.mu.Unlock()
+		ran := db.RunBackgroundJob()
+		db.mu.Lock()
+		if ran {
+			return
+		}
+	}
+	db.flushCond.Wait()
+}
+
+// yieldToFlushes runs the queued flushes on the calling goroutine. Long
+// compactions call it as they go, so a flush never waits for one, even when
+// all workers are busy.
+func (db *DB) yieldToFlushes() {
+	p := &db.jobs
+	if p.queued.Load() == 0 {
+		return
+	}
+	for {
+		p.mu.Lock()
+		if len(p.flushes) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		job := p.flushes[0]
+		p.flushes = p.flushes[1:]
+		p.queued.Add(-1)
+		p.mu.Unlock()
+		job()
+	}
+}
+
+// stopBackgroundJobs lets the workers finish the queued jobs and waits for
+// them.
+func (db *DB) stopBackgroundJobs() {
+	p := &db.jobs
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.workers.Wait()
+}
@@ -0,0 +1,177 @@
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+)
+
+// BackupSession pins a consistent set of files of a database so they can be
+// copied at leisure while writes, flushes and compactions go on. Compactions
+// keep the pinned SSTables on disk and flushes keep the pinned WALs, even
+// once the database no longer needs them, until Close. A backup made of
+// State written as StateFile, the Tables and the first Size bytes of each of
+// the WALFiles opens like any database and holds every write up to
+// LastSequence.
+type BackupSession struct {
+	*LiveFiles
+	db    *DB
+	paths map[string]string // Where each pinned file is now, by name
+}
+
+// BeginBackup starts a backup session. Unlike GetLiveFiles(true), it does not
+// flush the memtable: the WALs hold the writes that are not in the tables yet.
+// The caller must Close the session.
+func (db *DB) BeginBackup() (*BackupSession, error) {
+	s := &BackupSession{db: db, paths: make(map[string]string)}
+	lf, err := db.liveFiles(false, func(lf *LiveFiles) {
+		for _, wal := range lf.WALFiles {
+			s.paths[wal.Name] = filepath.Join(db.dataDir, wal.Name)
+		}
+		db.backupMu.Lock()
+		if db.backups == nil {
+			db.backups = make(map[*BackupSession]bool)
+		}
+		db.backups[s] = true
+		db.backupMu.Unlock()
+	})
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	s.LiveFiles = lf
+	db.backupMu.Lock()
+	for _, table := range lf.Tables {
+		s.paths[table.Name] = filepath.Join(db.dataDir, table.Name)
+	}
+	db.backupMu.Unlock()
+	return s, nil
+}
+
+// Open opens the pinned file name, one of the Tables or WALFiles, for reading
+// the contents that belong to the backup.
+func (s *BackupSession) Open(name string) (io.ReadCloser, error) {
+	s.db.backupMu.Lock()
+	path, ok := s.paths[name]
+	s.db.backupMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s is not part of the backup", name)
+	}
+	file, err := s.db.opts.FS.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, wal := range s.WALFiles {
+		if wal.Name == name {
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(file, wal.Size), file}, nil
+		}
+	}
+	return file, nil
+}
+
+// CopyTo writes the backup to dir, which must not exist yet.
+func (s *BackupSession) CopyTo(dir string) error {
+	fs := s.db.opts.FS
+	if err := fs.Mkdir(dir); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(s.Tables)+len(s.WALFiles))
+	for _, table := range s.Tables {
+		names = append(names, table.Name)
+	}
+	for _, wal := range s.WALFiles {
+		names = append(names, wal.Name)
+	}
+	for _, name := range names {
+		if err := s.copyFile(name, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+	}
+	if err := writeFileSync(fs, filepath.Join(dir, s.StateFile), s.State); err != nil {
+		return err
+	}
+	if err := fs.SyncDir(dir); err != nil {
+		return err
+	}
+	log.Printf("Backed up %s to %s at sequence %d", s.Dir, dir, s.LastSequence)
+	return nil
+}
+
+func (s *BackupSession) copyFile(name, dst string) error {
+	in, err := s.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := s.db.opts.FS.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Close ends the session. The SSTables and WALs that only the session kept
+// are deleted.
+func (s *BackupSession) Close() {
+	if s.LiveFiles != nil {
+		s.LiveFiles.Release()
+	}
+	s.release()
+}
+
+// release unregisters the session, and retires the WALs held for the last
+// session once it is gone.
+func (s *BackupSession) release() {
+	db := s.db
+	db.backupMu.Lock()
+	delete(db.backups, s)
+	var held []string
+	if len(db.backups) == 0 {
+		held, db.heldWALs = db.heldWALs, nil
+	}
+	db.backupMu.Unlock()
+	for _, path := range held {
+		if err := retireWAL(path, db.opts); err != nil {
+			log.Printf("ERROR: Failed to delete rotated WAL %s: %v", path, err)
+		} else {
+			db.dropFileIO(path)
+		}
+	}
+}
+
+// holdWAL keeps the flushed WAL at path instead of retiring it while backup
+// sessions are open, and reports whether it did.
+func (db *DB) holdWAL(path string) bool {
+	db.backupMu.Lock()
+	defer db.backupMu.Unlock()
+	if len(db.backups) == 0 {
+		return false
+	}
+	db.heldWALs = append(db.heldWALs, path)
+	return true
+}
+
+// renameBackupFiles follows the rename of a WAL pinned by backup sessions.
+func (db *DB) renameBackupFiles(oldPath, newPath string) {
+	db.backupMu.Lock()
+	defer db.backupMu.Unlock()
+	for s := range db.backups {
+		for name, path := range s.paths {
+			if path == oldPath {
+				s.paths[name] = newPath
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package leveldb
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBackupSession(t *testing.T) {
+	fs := NewMemFS()
+	db, err := Open("db", &Options{FS: fs, MaxMemtableSize: 16 * 1024})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	wo := WriteOptions{}
+	const pinned, total = 300, 1000
+	for i := 0; i < pinned; i++ {
+		db.Put(wo, generateKey(i), generateValue(100))
+	}
+
+	s, err := db.BeginBackup()
+	if err != nil {
+		t.Fatalf("BeginBackup failed: %v", err)
+	}
+	if len(s.WALFiles) == 0 {
+		t.Fatalf("Backup pinned no WAL")
+	}
+	// Flushes rotate and retire the pinned WALs, compaction replaces the
+	// pinned tables.
+	for i := pinned; i < total; i++ {
+		db.Put(wo, generateKey(i), generateValue(100))
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if _, err := s.Open("00999.sst"); err == nil {
+		t.Errorf("Open of a file outside the backup succeeded")
+	}
+	if err := s.CopyTo("backup"); err != nil {
+		t.Fatalf("CopyTo failed: %v", err)
+	}
+	active, err := s.Open("db.wal")
+	if err != nil {
+		t.Fatalf("Open of the pinned WAL failed: %v", err)
+	}
+	data, _ := io.ReadAll(active)
+	active.Close()
+	for _, wal := range s.WALFiles {
+		if wal.Name == "db.wal" && int64(len(data)) != wal.Size {
+			t.Errorf("Read %d bytes of the pinned WAL, want %d", len(data), wal.Size)
+		}
+	}
+	s.Close()
+
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	names, _ := fs.List("db")
+	for _, name := range names {
+		if strings.HasPrefix(name, "wal-") {
+			t.Errorf("WAL %s kept after the backup was closed", name)
+		}
+	}
+
+	backup, err := Open("backup", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Open of the backup failed: %v", err)
+	}
+	defer backup.Close()
+	for i := 0; i < pinned; i++ {
+		if _, err := backup.Get(generateKey(i)); err != nil {
+			t.Fatalf("Get %d from the backup failed: %v", i, err)
+		}
+	}
+	if _, err := backup.Get(generateKey(pinned)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Backup holds a write made after BeginBackup: %v", err)
+	}
+}
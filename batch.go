@@ -0,0 +1,194 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrKeyTooLarge   = errors.New("key too large")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrBatchTooLarge = errors.New("write batch too large")
+)
+
+// WriteBatch holds a collection of updates that are applied to the DB atomically.
+type WriteBatch struct {
+	entries    []batchEntry
+	onCommit   []func(SeqRange)
+	onRollback []func(error)
+	xid        string // Id of a prepared batch while CommitPrepared writes it
+}
+
+// SeqRange is the range of sequence numbers, both inclusive, that a written
+// batch received.
+type SeqRange struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+type batchEntry struct {
+	op    OpType
+	key   []byte
+	value []byte
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put queues a key-value pair to be stored when the batch is written.
+func (b *WriteBatch) Put(key, value []byte) {
+	b.entries = append(b.entries, batchEntry{op: OpTypePut, key: key, value: value})
+}
+
+// Delete queues the removal of key when the batch is written.
+func (b *WriteBatch) Delete(key []byte) {
+	b.entries = append(b.entries, batchEntry{op: OpTypeDelete, key: key})
+}
+
+// Len returns the number of updates in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Each calls fn with every update of the batch, in order. The value is nil for
+// a Delete. An Increment comes as OpTypeMerge with the delta as a little-endian
+// int64.
+func (b *WriteBatch) Each(fn func(op OpType, key, value []byte)) {
+	for _, e := range b.entries {
+		fn(e.op, e.key, e.value)
+	}
+}
+
+// Reset clears all updates and hooks from the batch so it can be reused.
+func (b *WriteBatch) Reset() {
+	b.entries = b.entries[:0]
+	b.onCommit, b.onRollback = nil, nil
+}
+
+// OnCommit registers fn to run once Write or WriteContext has logged and
+// applied the batch, with the sequence numbers it received. Hooks run in
+// registration order on the writing goroutine, without locks held, before
+// the write returns. They also run when only the WAL sync failed after the
+// batch was applied, in which case the write returns an error wrapping
+// ErrUnsynced.
+func (b *WriteBatch) OnCommit(fn func(SeqRange)) {
+	b.onCommit = append(b.onCommit, fn)
+}
+
+// OnRollback registers fn to run with the error of Write or WriteContext when
+// the batch could not be written, so none of it was applied.
+func (b *WriteBatch) OnRollback(fn func(error)) {
+	b.onRollback = append(b.onRollback, fn)
+}
+
+// finish runs the hooks for the outcome of writing the batch and returns err.
+// An ErrUnsynced error commits, since the batch was applied.
+func (b *WriteBatch) finish(seqs SeqRange, err error) error {
+	if err != nil && !errors.Is(err, ErrUnsynced) {
+		for _, fn := range b.onRollback {
+			fn(err)
+		}
+		return err
+	}
+	for _, fn := range b.onCommit {
+		fn(seqs)
+	}
+	return err
+}
+
+// checkBatch rejects batches that write to the reserved index keyspace or
+// exceed the configured size limits.
+func (db *DB) checkBatch(b *WriteBatch) error {
+	if b.hasReservedKey() {
+		return ErrReservedKey
+	}
+	total := 4
+	for _, e := range b.entries {
+		if e.op == OpTypeMerge && db.opts.TimestampSize > 0 {
+			return errors.New("Increment is not supported with timestamps")
+		}
+		if len(e.key) < db.opts.TimestampSize {
+			return fmt.Errorf("%w: %d bytes, want at least %d", ErrTimestampSize, len(e.key), db.opts.TimestampSize)
+		}
+		if len(e.key) > db.opts.MaxKeySize {
+			return fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrKeyTooLarge, len(e.key), db.opts.MaxKeySize)
+		}
+		if len(e.value) > db.opts.MaxValueSize {
+			return fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrValueTooLarge, len(e.value), db.opts.MaxValueSize)
+		}
+		total += 1 + 4 + 4 + len(e.key) + len(e.value)
+	}
+	if total > maxEncodedSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrBatchTooLarge, total, maxEncodedSize)
+	}
+	return nil
+}
+
+// logEntry converts the batch into a single WAL record. A batch with one update is
+// logged as a plain Put/Delete record, larger batches are wrapped in an OpBatch record
+// so that the whole batch is covered by one checksum. A prepared batch is logged as
+// an OpCommitPrepared record.
+func (b *WriteBatch) logEntry(seqNum uint64) *LogEntry {
+	if b.xid != "" {
+		return &LogEntry{Op: OpCommitPrepared, Key: []byte(b.xid), Value: b.encode(), SeqNum: seqNum}
+	}
+	if len(b.entries) == 1 {
+		e := b.entries[0]
+		return &LogEntry{Op: e.op, Key: e.key, Value: e.value, SeqNum: seqNum}
+	}
+	return &LogEntry{Op: OpBatch, Value: b.encode(), SeqNum: seqNum}
+}
+
+// encode serializes the batch as:
+// [Count (4 bytes)] followed by Count x [Operation (1 byte)][Key Size (4 bytes)][Value Size (4 bytes)][Key][Value]
+func (b *WriteBatch) encode() []byte {
+	size := 4
+	for _, e := range b.entries {
+		size += 1 + 4 + 4 + len(e.key) + len(e.value)
+	}
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(b.entries)))
+	offset := 4
+	for _, e := range b.entries {
+		buf[offset] = e.op
+		binary.LittleEndian.PutUint32(buf[offset+1:offset+5], uint32(len(e.key)))
+		binary.LittleEndian.PutUint32(buf[offset+5:offset+9], uint32(len(e.value)))
+		offset += 9
+		offset += copy(buf[offset:], e.key)
+		offset += copy(buf[offset:], e.value)
+	}
+	return buf
+}
+
+// decodeBatch parses the payload of an OpBatch record.
+func decodeBatch(data []byte) (*WriteBatch, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("batch record too short")
+	}
+	count := binary.LittleEndian.Uint32(data[0:4])
+	if count == 0 {
+		return nil, fmt.Errorf("empty batch record")
+	}
+	batch := &WriteBatch{entries: make([]batchEntry, 0, count)}
+	offset := 4
+	for i := uint32(0); i < count; i++ {
+		if len(data)-offset < 9 {
+			return nil, fmt.Errorf("batch record truncated at entry %d", i)
+		}
+		op := data[offset]
+		keySize := int(binary.LittleEndian.Uint32(data[offset+1 : offset+5]))
+		valueSize := int(binary.LittleEndian.Uint32(data[offset+5 : offset+9]))
+		offset += 9
+		if len(data)-offset < keySize+valueSize {
+			return nil, fmt.Errorf("batch record truncated at entry %d", i)
+		}
+		key := data[offset : offset+keySize]
+		offset += keySize
+		value := data[offset : offset+valueSize]
+		offset += valueSize
+		batch.entries = append(batch.entries, batchEntry{op: op, key: key, value: value})
+	}
+	return batch, nil
+}
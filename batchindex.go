@@ -0,0 +1,215 @@
+package leveldb
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// WriteBatchWithIndex is a WriteBatch that indexes its updates by key, so the
+// writes it accumulates can be read back, merged with the database, before
+// the batch is written.
+type WriteBatchWithIndex struct {
+	batch  *WriteBatch
+	latest map[string]batchEntry // Latest update of each key
+}
+
+func NewWriteBatchWithIndex() *WriteBatchWithIndex {
+	return &WriteBatchWithIndex{batch: NewWriteBatch(), latest: make(map[string]batchEntry)}
+}
+
+// Put queues a key-value pair to be stored when the batch is written.
+func (b *WriteBatchWithIndex) Put(key, value []byte) {
+	b.batch.Put(key, value)
+	b.latest[string(key)] = batchEntry{op: OpTypePut, key: key, value: value}
+}
+
+// Delete queues the removal of key when the batch is written.
+func (b *WriteBatchWithIndex) Delete(key []byte) {
+	b.batch.Delete(key)
+	b.latest[string(key)] = batchEntry{op: OpTypeDelete, key: key}
+}
+
+// Len returns the number of updates in the batch.
+func (b *WriteBatchWithIndex) Len() int {
+	return b.batch.Len()
+}
+
+// Reset clears all updates from the batch so it can be reused.
+func (b *WriteBatchWithIndex) Reset() {
+	b.batch.Reset()
+	clear(b.latest)
+}
+
+// Batch returns the updates as a WriteBatch to pass to DB.Write.
+func (b *WriteBatchWithIndex) Batch() *WriteBatch {
+	return b.batch
+}
+
+// Get returns the value of key as if the batch had been written to db: the
+// latest update of key in the batch, or else its value in db. It returns
+// ErrNotFound if the key is deleted by the batch or missing.
+func (b *WriteBatchWithIndex) Get(db *DB, key []byte) ([]byte, error) {
+	if e, ok := b.latest[string(key)]; ok {
+		if e.op == OpTypeDelete {
+			return nil, ErrNotFound
+		}
+		return e.value, nil
+	}
+	return db.Get(key)
+}
+
+// NewIterator returns an iterator over db as if the batch had been written to
+// it. The iterator sees the updates in the batch when it is created.
+func (b *WriteBatchWithIndex) NewIterator(db *DB) Iterator {
+	delta := make([]batchEntry, 0, len(b.latest))
+	for _, e := range b.latest {
+		delta = append(delta, e)
+	}
+	slices.SortFunc(delta, func(x, y batchEntry) int { return strings.Compare(string(x.key), string(y.key)) })
+	return &baseDeltaIterator{base: db.NewIterator(), delta: delta}
+}
+
+// baseDeltaIterator merges the keys of a database iterator, the base, with
+// the updates of a batch, the delta, which shadow the base keys they match.
+// In forward direction base and pos are at or after the current key, in
+// reverse direction at or before it.
+type baseDeltaIterator struct {
+	base    Iterator
+	delta   []batchEntry // Latest update of each key, sorted by key
+	pos     int
+	reverse bool
+	// The current key comes from the delta if fromDelta is set, else from the
+	// base. onBase is set if the base is at the current key.
+	fromDelta, onBase bool
+	valid             bool
+}
+
+func (it *baseDeltaIterator) deltaValid() bool {
+	return it.pos >= 0 && it.pos < len(it.delta)
+}
+
+// settle moves past deleted keys to the next key in the current direction.
+func (it *baseDeltaIterator) settle() {
+	for {
+		baseValid, deltaValid := it.base.Valid(), it.deltaValid()
+		if !baseValid && !deltaValid {
+			it.valid = false
+			return
+		}
+		cmp := 0 // Order of the delta key against the base key, in the current direction
+		switch {
+		case !baseValid:
+			cmp = -1
+		case !deltaValid:
+			cmp = 1
+		default:
+			cmp = strings.Compare(string(it.delta[it.pos].key), it.base.Key().UserKey)
+			if it.reverse {
+				cmp = -cmp
+			}
+		}
+		it.fromDelta, it.onBase = cmp <= 0, cmp >= 0
+		if !it.fromDelta || it.delta[it.pos].op != OpTypeDelete {
+			it.valid = true
+			return
+		}
+		it.step()
+	}
+}
+
+// step moves the sources off the current key in the current direction.
+func (it *baseDeltaIterator) step() {
+	if it.fromDelta {
+		if it.reverse {
+			it.pos--
+		} else {
+			it.pos++
+		}
+	}
+	if it.onBase {
+		if it.reverse {
+			it.base.Prev()
+		} else {
+			it.base.Next()
+		}
+	}
+}
+
+func (it *baseDeltaIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *baseDeltaIterator) Key() InternalKey {
+	if it.fromDelta {
+		return InternalKey{UserKey: string(it.delta[it.pos].key), Type: OpTypePut}
+	}
+	return it.base.Key()
+}
+
+func (it *baseDeltaIterator) Value() []byte {
+	if it.fromDelta {
+		return it.delta[it.pos].value
+	}
+	return it.base.Value()
+}
+
+func (it *baseDeltaIterator) Next() {
+	if it.reverse {
+		// Seek both sources to the current key, then step past it.
+		key := []byte(it.Key().UserKey)
+		it.Seek(key)
+		if !it.valid || it.Key().UserKey != string(key) {
+			return
+		}
+	}
+	it.step()
+	it.settle()
+}
+
+func (it *baseDeltaIterator) Prev() {
+	if !it.reverse {
+		key := []byte(it.Key().UserKey)
+		it.SeekForPrev(key)
+		if !it.valid || it.Key().UserKey != string(key) {
+			return
+		}
+	}
+	it.step()
+	it.settle()
+}
+
+func (it *baseDeltaIterator) Close() error {
+	it.valid = false
+	return it.base.Close()
+}
+
+func (it *baseDeltaIterator) Error() error {
+	return it.base.Error()
+}
+
+func (it *baseDeltaIterator) SeekToFirst() {
+	it.base.SeekToFirst()
+	it.pos, it.reverse = 0, false
+	it.settle()
+}
+
+func (it *baseDeltaIterator) SeekToLast() {
+	it.base.SeekToLast()
+	it.pos, it.reverse = len(it.delta)-1, true
+	it.settle()
+}
+
+func (it *baseDeltaIterator) Seek(key []byte) {
+	it.base.Seek(key)
+	it.pos = sort.Search(len(it.delta), func(i int) bool { return string(it.delta[i].key) >= string(key) })
+	it.reverse = false
+	it.settle()
+}
+
+func (it *baseDeltaIterator) SeekForPrev(key []byte) {
+	it.base.SeekForPrev(key)
+	it.pos = sort.Search(len(it.delta), func(i int) bool { return string(it.delta[i].key) > string(key) }) - 1
+	it.reverse = true
+	it.settle()
+}
@@ -0,0 +1,72 @@
+package leveldb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteBatchWithIndex(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	for _, k := range []string{"a", "c", "e"} {
+		db.Put(wo, []byte(k), []byte("db-"+k))
+	}
+
+	b := NewWriteBatchWithIndex()
+	b.Put([]byte("b"), []byte("batch-b"))
+	b.Put([]byte("c"), []byte("batch-c"))
+	b.Delete([]byte("e"))
+	b.Put([]byte("f"), []byte("old"))
+	b.Put([]byte("f"), []byte("batch-f"))
+
+	for key, want := range map[string]string{"a": "db-a", "b": "batch-b", "c": "batch-c", "f": "batch-f"} {
+		if val, err := b.Get(db, []byte(key)); err != nil || string(val) != want {
+			t.Fatalf("Get(%q) = %q, %v; want %q", key, val, err, want)
+		}
+	}
+	if _, err := b.Get(db, []byte("e")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for a key deleted by the batch, got %v", err)
+	}
+
+	want := []string{"a=db-a", "b=batch-b", "c=batch-c", "f=batch-f"}
+	it := b.NewIterator(db)
+	defer it.Close()
+	var got []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		got = append(got, it.Key().UserKey+"="+string(it.Value()))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Forward iteration got %v, want %v", got, want)
+	}
+	got = got[:0]
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		got = append(got, it.Key().UserKey+"="+string(it.Value()))
+	}
+	for i := range want {
+		if got[len(got)-1-i] != want[i] {
+			t.Fatalf("Reverse iteration got %v, want %v reversed", got, want)
+		}
+	}
+
+	// Changing direction in the middle of the keys.
+	it.Seek([]byte("d"))
+	if it.Key().UserKey != "f" {
+		t.Fatalf("Seek(d) landed on %q, want f", it.Key().UserKey)
+	}
+	if it.Prev(); it.Key().UserKey != "c" {
+		t.Fatalf("Prev landed on %q, want c", it.Key().UserKey)
+	}
+	if it.Next(); it.Key().UserKey != "f" {
+		t.Fatalf("Next landed on %q, want f", it.Key().UserKey)
+	}
+
+	if err := db.Write(wo, b.Batch()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := db.Get([]byte("e")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected e to be deleted, got %v", err)
+	}
+	if val, _ := db.Get([]byte("f")); string(val) != "batch-f" {
+		t.Fatalf("Expected f=batch-f, got %q", val)
+	}
+}
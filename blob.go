@@ -0,0 +1,323 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"strings"
+)
+
+// Large values written with PutReader are split into chunks stored under the
+// reserved blob keyspace. The user key itself holds a small blobRef with type
+// OpTypeBlobRef that locates the chunks.
+//
+// A marker under blobPendingPrefix records the id of a blob whose chunks are
+// being written. It is deleted in the batch that writes the reference, so a
+// marker left behind by a failed PutReader or a crash names orphan chunks,
+// which the next Open deletes.
+const (
+	blobKeyPrefix     = "\x00__blob__\x00"
+	blobPendingPrefix = "\x00__blobpending__\x00"
+	blobChunkSize     = 1024 * 1024 // 1 MB
+	blobRefSize       = 8 + 8 + 4
+)
+
+type blobRef struct {
+	id     uint64 // Random identifier shared by all chunks of the blob
+	size   int64
+	chunks uint32
+}
+
+func (r blobRef) encode() []byte {
+	buf := make([]byte, blobRefSize)
+	binary.LittleEndian.PutUint64(buf[0:8], r.id)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(r.size))
+	binary.LittleEndian.PutUint32(buf[16:20], r.chunks)
+	return buf
+}
+
+func decodeBlobRef(data []byte) (blobRef, error) {
+	if len(data) != blobRefSize {
+		return blobRef{}, fmt.Errorf("invalid blob reference of %d bytes", len(data))
+	}
+	return blobRef{
+		id:     binary.LittleEndian.Uint64(data[0:8]),
+		size:   int64(binary.LittleEndian.Uint64(data[8:16])),
+		chunks: binary.LittleEndian.Uint32(data[16:20]),
+	}, nil
+}
+
+// chunkKey returns the key of chunk i. Big-endian encoding keeps the chunks of
+// one blob adjacent and in order.
+func (r blobRef) chunkKey(i uint32) []byte {
+	key := make([]byte, 0, len(blobKeyPrefix)+8+4)
+	key = append(key, blobKeyPrefix...)
+	key = binary.BigEndian.AppendUint64(key, r.id)
+	return binary.BigEndian.AppendUint32(key, i)
+}
+
+// chunkPrefix returns the prefix shared by the keys of all chunks of blob id.
+func chunkPrefix(id uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(blobKeyPrefix), id)
+}
+
+// pendingKey returns the key of the marker written while the chunks of blob id
+// are being written.
+func pendingKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(blobPendingPrefix), id)
+}
+
+// PutReader stores a value of exactly size bytes read from r under key. The
+// value is written in chunks, so it never has to be held in memory at once and
+// is not subject to Options.MaxValueSize. The new value becomes visible
+// atomically once every chunk has been written.
+func (db *DB) PutReader(wo WriteOptions, key []byte, r io.Reader, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid blob size %d", size)
+	}
+	if db.opts.TimestampSize > 0 {
+		return fmt.Errorf("blobs are not supported with timestamps")
+	}
+	db.writeMu.Lock()
+	indexed := len(db.indexes) > 0
+	db.writeMu.Unlock()
+	if indexed {
+		return ErrIndexedBlob
+	}
+	// Validate the key up front so that no chunks are written for a rejected key.
+	keyCheck := NewWriteBatch()
+	keyCheck.Delete(key)
+	if err := db.checkBatch(keyCheck); err != nil {
+		return err
+	}
+	if err := db.markHasBlobs(); err != nil {
+		return err
+	}
+
+	ref := blobRef{id: rand.Uint64(), size: size}
+	chunkWO := wo
+	chunkWO.Sync = false // Syncing the final reference also syncs the chunks before it
+	marker := NewWriteBatch()
+	marker.Put(pendingKey(ref.id), nil)
+	if err := db.writeInternal(chunkWO, marker); err != nil {
+		return err
+	}
+	if err := db.writeBlobChunks(chunkWO, &ref, r); err != nil {
+		if cerr := db.deleteBlob(ref.id); cerr != nil {
+			log.Printf("ERROR: Failed to delete chunks of blob %016x: %v", ref.id, cerr)
+		}
+		return err
+	}
+
+	batch := NewWriteBatch()
+	batch.entries = append(batch.entries, batchEntry{op: OpTypeBlobRef, key: key, value: ref.encode()})
+	batch.Delete(pendingKey(ref.id))
+	if err := db.writeInternal(wo, batch); err != nil {
+		return err
+	}
+	if db.tracer != nil {
+		db.tracer.record(&TraceRecord{Op: traceOpWrite, Sync: wo.Sync, DisableWAL: wo.DisableWAL,
+			Entries: []TraceEntry{{Key: key, ValueSize: int(size)}}})
+	}
+	return nil
+}
+
+// writeBlobChunks writes the ref.size bytes of r as chunks of blob ref,
+// counting them in ref.chunks.
+func (db *DB) writeBlobChunks(wo WriteOptions, ref *blobRef, r io.Reader) error {
+	buf := make([]byte, blobChunkSize)
+	for remaining := ref.size; remaining > 0; {
+		n := int(min(remaining, blobChunkSize))
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return fmt.Errorf("reading blob chunk %d: %w", ref.chunks, err)
+		}
+		chunk := NewWriteBatch()
+		chunk.Put(ref.chunkKey(ref.chunks), append([]byte(nil), buf[:n]...))
+		if err := db.writeInternal(wo, chunk); err != nil {
+			return err
+		}
+		ref.chunks++
+		remaining -= int64(n)
+	}
+	if n, _ := r.Read(buf[:1]); n > 0 {
+		return fmt.Errorf("blob reader has more than %d bytes", ref.size)
+	}
+	return nil
+}
+
+// deleteBlob deletes every chunk of blob id and its pending marker.
+func (db *DB) deleteBlob(id uint64) error {
+	prefix := chunkPrefix(id)
+	batch := NewWriteBatch()
+	it := db.newMergingIterator(db.sequenceNum.Load(), nil)
+	for it.Seek(prefix); it.Valid() && bytes.HasPrefix([]byte(it.Key().UserKey), prefix); it.Next() {
+		batch.Delete([]byte(it.Key().UserKey))
+	}
+	err := it.Error()
+	it.Close()
+	if err != nil {
+		return err
+	}
+	batch.Delete(pendingKey(id))
+	return db.writeInternal(WriteOptions{}, batch)
+}
+
+// collectOrphanBlobs deletes the chunks of the blobs whose PutReader did not
+// complete, as recorded by their pending markers.
+func (db *DB) collectOrphanBlobs() error {
+	var ids []uint64
+	it := db.newMergingIterator(db.sequenceNum.Load(), nil)
+	for it.Seek([]byte(blobPendingPrefix)); it.Valid() && strings.HasPrefix(it.Key().UserKey, blobPendingPrefix); it.Next() {
+		if id := it.Key().UserKey[len(blobPendingPrefix):]; len(id) == 8 {
+			ids = append(ids, binary.BigEndian.Uint64([]byte(id)))
+		}
+	}
+	err := it.Error()
+	it.Close()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.deleteBlob(id); err != nil {
+			return err
+		}
+		log.Printf("Deleted the chunks of incomplete blob %016x", id)
+	}
+	return nil
+}
+
+// GetReader returns a reader over the value of key, streaming chunked values
+// written by PutReader one chunk at a time. It returns ErrNotFound if the key
+// does not exist.
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	seq := db.sequenceNum.Load()
+	ik, val, found, err := db.getEntry(context.Background(), key, seq)
+	if err != nil {
+		return nil, err
+	}
+	if !found || ik.Type == OpTypeDelete {
+		return nil, ErrNotFound
+	}
+	if ik.Type != OpTypeBlobRef {
+		if val, err = db.resolveValue(seq, ik, val); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(val)), nil
+	}
+	ref, err := decodeBlobRef(val)
+	if err != nil {
+		return nil, err
+	}
+	return &blobReader{db: db, ref: ref, seq: seq}, nil
+}
+
+type blobReader struct {
+	db    *DB
+	ref   blobRef
+	seq   uint64 // Chunks are read as of the sequence the reference was found at
+	next  uint32
+	chunk []byte
+}
+
+func (br *blobReader) Read(p []byte) (int, error) {
+	for len(br.chunk) == 0 {
+		if br.next >= br.ref.chunks {
+			return 0, io.EOF
+		}
+		chunk, err := br.db.GetAt(br.seq, br.ref.chunkKey(br.next))
+		if errors.Is(err, ErrNotFound) {
+			return 0, fmt.Errorf("blob %016x is missing chunk %d", br.ref.id, br.next)
+		}
+		if err != nil {
+			return 0, err
+		}
+		br.chunk = chunk
+		br.next++
+	}
+	n := copy(p, br.chunk)
+	br.chunk = br.chunk[n:]
+	return n, nil
+}
+
+func (br *blobReader) Close() error {
+	br.chunk = nil
+	br.next = br.ref.chunks
+	return nil
+}
+
+// resolveValue returns the user-visible value of an entry, reading all chunks
+// when the entry is a blob reference.
+func (db *DB) resolveValue(seq uint64, ik InternalKey, val []byte) ([]byte, error) {
+	if ik.Type == OpTypeMerge {
+		return db.resolveMerge(ik, val)
+	}
+	if ik.Type != OpTypeBlobRef {
+		return val, nil
+	}
+	ref, err := decodeBlobRef(val)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, ref.size))
+	if _, err := io.Copy(buf, &blobReader{db: db, ref: ref, seq: seq}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// markHasBlobs records in the state file that blobs exist, which enables chunk
+// cleanup on overwrites and deletes from then on.
+func (db *DB) markHasBlobs() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.hasBlobs {
+		return nil
+	}
+	db.hasBlobs = true
+	return db.saveState()
+}
+
+// withBlobCleanup adds deletes for the chunks of every blob that the batch
+// overwrites or deletes. The caller must hold db.writeMu. It fails if a
+// previous value cannot be read, since its chunks would leak.
+func (db *DB) withBlobCleanup(batch *WriteBatch) (*WriteBatch, error) {
+	db.mu.RLock()
+	hasBlobs := db.hasBlobs
+	db.mu.RUnlock()
+	if !hasBlobs {
+		return batch, nil
+	}
+	db.waitForInserts()
+
+	var cleanup []batchEntry
+	seen := make(map[string]bool)
+	for _, e := range batch.entries {
+		if isReservedKey(e.key) || seen[string(e.key)] {
+			continue
+		}
+		seen[string(e.key)] = true
+		ik, val, found, err := db.getEntry(context.Background(), e.key, db.sequenceNum.Load())
+		if err != nil {
+			return nil, fmt.Errorf("reading previous value of %q: %w", e.key, err)
+		}
+		if !found || ik.Type != OpTypeBlobRef {
+			continue
+		}
+		ref, err := decodeBlobRef(val)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < ref.chunks; i++ {
+			cleanup = append(cleanup, batchEntry{op: OpTypeDelete, key: ref.chunkKey(i)})
+		}
+	}
+	if len(cleanup) == 0 {
+		return batch, nil
+	}
+	return &WriteBatch{entries: append(cleanup, batch.entries...)}, nil
+}
@@ -0,0 +1,93 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPutReaderStreamsChunks(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	wo := WriteOptions{}
+	blob := generateValue(2*blobChunkSize + 12345)
+	if err := db.PutReader(wo, []byte("big"), bytes.NewReader(blob), int64(len(blob))); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	if err := db.PutReader(wo, []byte("short"), bytes.NewReader(blob[:10]), 20); err == nil {
+		t.Fatalf("Expected error for a reader shorter than size")
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+
+	r, err := db.GetReader([]byte("big"))
+	if err != nil {
+		t.Fatalf("GetReader failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, blob) {
+		t.Fatalf("Streamed blob mismatch: %d bytes, err=%v", len(got), err)
+	}
+	if val, err := db.Get([]byte("big")); err != nil || !bytes.Equal(val, blob) {
+		t.Fatalf("Get returned %d bytes (%v), expected the whole blob", len(val), err)
+	}
+	if _, err := db.Get([]byte("short")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Failed PutReader must not publish the key")
+	}
+
+	// Overwriting the blob removes its chunks.
+	if err := db.Put(wo, []byte("big"), []byte("small")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	it := db.newMergingIterator(db.sequenceNum.Load(), nil)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if bytes.HasPrefix([]byte(it.Key().UserKey), []byte(blobKeyPrefix)) {
+			t.Errorf("Blob chunk %q still live after overwrite", it.Key().UserKey)
+		}
+	}
+	it.Close()
+	if _, err := db.GetReader([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestOrphanBlobChunksCollectedOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	if err := db.markHasBlobs(); err != nil {
+		t.Fatalf("markHasBlobs failed: %v", err)
+	}
+	// A PutReader that crashed after its first chunk.
+	ref := blobRef{id: 42}
+	batch := NewWriteBatch()
+	batch.Put(pendingKey(ref.id), nil)
+	batch.Put(ref.chunkKey(0), []byte("chunk"))
+	if err := db.writeInternal(WriteOptions{}, batch); err != nil {
+		t.Fatalf("writeInternal failed: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	it := db.newMergingIterator(db.sequenceNum.Load(), nil)
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		t.Errorf("Key %q of the incomplete blob is still live", it.Key().UserKey)
+	}
+}
@@ -0,0 +1,222 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// ErrBulkLoadOrder is returned for a write during a bulk load whose key is not
+// greater than every key loaded before it.
+var ErrBulkLoadOrder = errors.New("bulk load keys out of order")
+
+// bulkLoad is the state of a bulk load, guarded by db.writeMu.
+type bulkLoad struct {
+	w       *SSTableWriter   // Table being written, nil between tables
+	num     int              // File number of w
+	tables  []int            // Finished tables, installed by EndBulkLoad
+	seqs    map[int]SeqRange // Sequence numbers of the finished tables
+	lastKey string
+	hasLast bool
+	err     error // First failure; the load can only be ended after it
+}
+
+// BeginBulkLoad switches the database into bulk-load mode. Until EndBulkLoad,
+// writes must arrive in strictly increasing key order. They bypass the WAL and
+// the memtable and are written straight into SSTables of
+// Options.BulkLoadTableSize bytes, and automatic compactions are paused. The
+// loaded data becomes visible and durable only once EndBulkLoad installs the
+// tables; if the database is closed or the process stops before that, it is
+// lost. Databases with secondary indexes or blobs cannot be bulk loaded.
+func (db *DB) BeginBulkLoad() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	return db.beginBulkLoadLocked()
+}
+
+// beginBulkLoadLocked starts a bulk load. The caller must hold db.writeMu.
+func (db *DB) beginBulkLoadLocked() error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	if err := db.readOnlyErr(); err != nil {
+		return err
+	}
+	if db.bulk != nil {
+		return fmt.Errorf("bulk load already in progress")
+	}
+	db.mu.RLock()
+	hasBlobs := db.hasBlobs
+	db.mu.RUnlock()
+	if len(db.indexes) > 0 || hasBlobs || db.opts.TimestampSize > 0 {
+		return fmt.Errorf("bulk load is not supported with secondary indexes, blobs or timestamps")
+	}
+	// The loaded tables are installed as the newest, so everything written
+	// before must be in a table already.
+	if err := db.flushLocked(); err != nil {
+		return err
+	}
+	db.PauseBackgroundWork()
+	db.bulk = &bulkLoad{}
+	log.Println("Bulk load started")
+	return nil
+}
+
+// EndBulkLoad finishes the last table of the bulk load, installs all loaded
+// tables and resumes automatic compactions. If the load failed, its tables
+// are discarded and the error is returned.
+func (db *DB) EndBulkLoad() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	return db.endBulkLoadLocked()
+}
+
+// endBulkLoadLocked ends the bulk load. The caller must hold db.writeMu.
+func (db *DB) endBulkLoadLocked() error {
+	b := db.bulk
+	if b == nil {
+		return fmt.Errorf("no bulk load in progress")
+	}
+	db.bulk = nil
+	defer db.ContinueBackgroundWork()
+
+	err := b.err
+	if err == nil && b.w != nil {
+		err = db.finishBulkTable(b)
+	}
+	if err == nil {
+		err = db.opts.FS.SyncDir(db.dataDir)
+	}
+	if err != nil {
+		db.abortBulkLoad(b)
+		return err
+	}
+	if len(b.tables) == 0 {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tables := append(append([]int(nil), db.current.tables...), b.tables...)
+	if err := db.installVersionLocked(tables, b.seqs); err != nil {
+		// The tables stay on disk as orphans, the state may still list them.
+		return fmt.Errorf("failed to install bulk loaded tables: %w", err)
+	}
+	log.Printf("Bulk load finished, installed SSTables %v", b.tables)
+	return nil
+}
+
+// KVReader is a stream of key/value pairs in strictly increasing key order.
+type KVReader interface {
+	// Next returns the next pair, or io.EOF after the last one. The returned
+	// slices are only used until the following call.
+	Next() (key, value []byte, err error)
+}
+
+// ImportSorted loads every pair of r into the database through a bulk load
+// that holds off all other writers until it is done. The pairs are sliced into
+// SSTables of Options.BulkLoadTableSize bytes with filters and properties, and
+// the tables are installed together once r is exhausted, so a failed import
+// leaves the database unchanged.
+func (db *DB) ImportSorted(r KVReader) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if err := db.beginBulkLoadLocked(); err != nil {
+		return err
+	}
+	batch := NewWriteBatch()
+	for {
+		key, value, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			batch.Reset()
+			batch.Put(key, value)
+			if err = db.checkBatch(batch); err == nil {
+				err = db.bulkWriteLocked(batch, db.sequenceNum.Load()+1)
+			}
+		}
+		if err != nil {
+			db.bulk.err = fmt.Errorf("import failed: %w", err)
+			break
+		}
+	}
+	return db.endBulkLoadLocked()
+}
+
+// bulkWriteLocked adds the batch to the bulk load. The whole batch is checked
+// first, so a batch out of order adds nothing. The caller must hold
+// db.writeMu.
+func (db *DB) bulkWriteLocked(batch *WriteBatch, firstSeq uint64) error {
+	b := db.bulk
+	if b.err != nil {
+		return b.err
+	}
+	last, hasLast := b.lastKey, b.hasLast
+	for _, e := range batch.entries {
+		if hasLast && string(e.key) <= last {
+			return fmt.Errorf("%w: %q after %q", ErrBulkLoadOrder, e.key, last)
+		}
+		last, hasLast = string(e.key), true
+	}
+
+	for i, e := range batch.entries {
+		if b.w == nil {
+			db.mu.Lock()
+			b.num = db.nextFileNumber
+			db.nextFileNumber++
+			db.mu.Unlock()
+			if b.w, b.err = NewSSTableWriter(tablePath(db.dataDir, b.num)+".tmp", db.tableOptions()); b.err != nil {
+				return b.err
+			}
+		}
+		key := InternalKey{UserKey: string(e.key), SeqNum: firstSeq + uint64(i), Type: e.op}
+		if b.err = b.w.Add(key, e.value); b.err != nil {
+			return b.err
+		}
+		if b.w.EstimatedSize() >= int64(db.opts.BulkLoadTableSize) {
+			if b.err = db.finishBulkTable(b); b.err != nil {
+				return b.err
+			}
+		}
+	}
+	b.lastKey, b.hasLast = last, true
+	db.sequenceNum.Store(firstSeq + uint64(batch.Len()) - 1)
+	return nil
+}
+
+// finishBulkTable completes the table being written and moves it to its final
+// name.
+func (db *DB) finishBulkTable(b *bulkLoad) error {
+	w := b.w
+	b.w = nil
+	path := tablePath(db.dataDir, b.num)
+	if err := w.Finish(); err != nil {
+		db.opts.FS.Remove(path + ".tmp")
+		return err
+	}
+	if err := db.opts.FS.Rename(path+".tmp", path); err != nil {
+		db.opts.FS.Remove(path + ".tmp")
+		return err
+	}
+	b.tables = append(b.tables, b.num)
+	if b.seqs == nil {
+		b.seqs = make(map[int]SeqRange)
+	}
+	b.seqs[b.num] = SeqRange{First: w.Properties().SmallestSeq, Last: w.Properties().LargestSeq}
+	return nil
+}
+
+// abortBulkLoad removes the tables of a bulk load that will not be installed.
+func (db *DB) abortBulkLoad(b *bulkLoad) {
+	if b.w != nil {
+		b.w.Abort()
+		b.w = nil
+	}
+	for _, num := range b.tables {
+		db.opts.FS.Remove(tablePath(db.dataDir, num))
+	}
+	log.Printf("Bulk load aborted, discarded SSTables %v", b.tables)
+}
@@ -0,0 +1,148 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/wal"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{BulkLoadTableSize: 16 * 1024})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("existing"), []byte("old")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.BeginBulkLoad(); err != nil {
+		t.Fatalf("BeginBulkLoad failed: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Put(WriteOptions{}, generateKey(5), nil); !errors.Is(err, ErrBulkLoadOrder) {
+		t.Errorf("Expected ErrBulkLoadOrder, got %v", err)
+	}
+	if _, err := db.Get(generateKey(1)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Bulk loaded data visible before EndBulkLoad")
+	}
+	if err := db.EndBulkLoad(); err != nil {
+		t.Fatalf("EndBulkLoad failed: %v", err)
+	}
+	if n := len(db.current.tables); n < 3 {
+		t.Errorf("Expected several bulk loaded tables, have %d", n)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "db.wal")); err != nil || info.Size() != int64(wal.HeaderSize) {
+		t.Errorf("Expected the bulk load to bypass the WAL, got %v, %v", info, err)
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	for _, i := range []int{0, 500, 999} {
+		if val, err := db.Get(generateKey(i)); err != nil || string(val) != fmt.Sprint(i) {
+			t.Errorf("Get(%d) = %q, %v", i, val, err)
+		}
+	}
+	if val, err := db.Get([]byte("existing")); err != nil || string(val) != "old" {
+		t.Errorf("Get(existing) = %q, %v", val, err)
+	}
+}
+
+func TestBulkLoadDiscardedOnClose(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	if err := db.BeginBulkLoad(); err != nil {
+		t.Fatalf("BeginBulkLoad failed: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Get([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an unfinished bulk load to be discarded")
+	}
+	if tables, _ := filepath.Glob(filepath.Join(dir, "*.sst*")); len(tables) != 0 {
+		t.Errorf("Expected no tables left, found %v", tables)
+	}
+}
+
+// sliceKVReader yields the pairs of a slice, then io.EOF or err.
+type sliceKVReader struct {
+	pairs [][2]string
+	err   error
+}
+
+func (r *sliceKVReader) Next() ([]byte, []byte, error) {
+	if len(r.pairs) == 0 {
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+		return nil, nil, io.EOF
+	}
+	p := r.pairs[0]
+	r.pairs = r.pairs[1:]
+	return []byte(p[0]), []byte(p[1]), nil
+}
+
+func TestImportSorted(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{BulkLoadTableSize: 16 * 1024})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	var pairs [][2]string
+	for i := 0; i < 1000; i++ {
+		pairs = append(pairs, [2]string{string(generateKey(i)), fmt.Sprint(i)})
+	}
+
+	// A failing stream imports nothing.
+	if err := db.ImportSorted(&sliceKVReader{pairs: pairs, err: errors.New("broken pipe")}); err == nil {
+		t.Fatalf("Expected the stream error to fail the import")
+	}
+	if _, err := db.Get(generateKey(0)); !errors.Is(err, ErrNotFound) || len(db.current.tables) != 0 {
+		t.Fatalf("Failed import left data behind")
+	}
+
+	if err := db.ImportSorted(&sliceKVReader{pairs: pairs}); err != nil {
+		t.Fatalf("ImportSorted failed: %v", err)
+	}
+	if n := len(db.current.tables); n < 3 {
+		t.Errorf("Expected the import to be sliced into several tables, have %d", n)
+	}
+	r, err := db.findTable(db.current.tables[0])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	if r.Properties().FilterPolicy != FilterPolicyBloom || r.Footer().FilterSize == 0 {
+		t.Errorf("Imported table has no filter: %+v", r.Properties())
+	}
+	r.Unref()
+	for _, i := range []int{0, 500, 999} {
+		if val, err := db.Get(generateKey(i)); err != nil || string(val) != fmt.Sprint(i) {
+			t.Errorf("Get(%d) = %q, %v", i, val, err)
+		}
+	}
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Errorf("Put after import failed: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Package cache holds the block cache that the SSTables of a database share.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Cache is an LRU cache bounded by the total charge of its entries
+// rather than their count. It holds the data, index and filter blocks of
+// SSTables under one budget. Index and filter blocks have priority: as long as
+// they fit in metaCapacity they are only evicted by other metadata, so scans
+// that churn through data blocks never evict what point reads depend on.
+type Cache struct {
+	mu           sync.Mutex
+	capacity     int64
+	metaCapacity int64
+	used         atomic.Int64 // Total charge of all entries, changed under mu
+	metaUsed     int64        // Charge of the index and filter blocks
+	data         *list.List   // Data blocks, most recently used first
+	meta         *list.List   // Index and filter blocks, most recently used first
+	items        map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	value  any
+	charge int64
+	meta   bool
+}
+
+// New returns a cache of capacity bytes, of which index and filter blocks may
+// take up to metaCapacity.
+func New(capacity, metaCapacity int64) *Cache {
+	return &Cache{
+		capacity:     capacity,
+		metaCapacity: min(metaCapacity, capacity),
+		data:         list.New(),
+		meta:         list.New(),
+		items:        make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value cached under key and marks it as recently used.
+func (c *Cache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.meta {
+			c.meta.MoveToFront(elem)
+		} else {
+			c.data.MoveToFront(elem)
+		}
+		return entry.value, true
+	}
+	return nil, false
+}
+
+// Add inserts value, an index or filter block if meta is set, and evicts least
+// recently used entries until the cache is within capacity again, data blocks
+// before metadata. An entry larger than the capacity is not cached.
+func (c *Cache) Add(key string, value any, charge int64, meta bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	if charge > c.capacity {
+		return
+	}
+	entry := &cacheEntry{key: key, value: value, charge: charge, meta: meta}
+	if meta {
+		c.items[key] = c.meta.PushFront(entry)
+		c.metaUsed += charge
+	} else {
+		c.items[key] = c.data.PushFront(entry)
+	}
+	c.used.Add(charge)
+	for c.metaUsed > c.metaCapacity && c.meta.Len() > 1 {
+		c.removeElement(c.meta.Back())
+	}
+	for c.used.Load() > c.capacity {
+		if c.data.Len() > 0 {
+			c.removeElement(c.data.Back())
+		} else {
+			c.removeElement(c.meta.Back())
+		}
+	}
+}
+
+// Usage returns the total charge of the cached entries.
+func (c *Cache) Usage() int64 {
+	return c.used.Load()
+}
+
+// MetaUsage returns the charge of the cached index and filter blocks.
+func (c *Cache) MetaUsage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.metaUsed
+}
+
+func (c *Cache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	if entry.meta {
+		c.meta.Remove(elem)
+		c.metaUsed -= entry.charge
+	} else {
+		c.data.Remove(elem)
+	}
+	delete(c.items, entry.key)
+	c.used.Add(-entry.charge)
+}
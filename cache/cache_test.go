@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMetaCacheSurvivesScans(t *testing.T) {
+	c := New(100, 100)
+	c.Add("1:index", []int{}, 60, true)
+	c.Add("2:index", []int{}, 30, true)
+	c.Get("1:index")
+	c.Add("3:index", []int{}, 30, true) // Evicts the least recently used entry
+	if _, ok := c.Get("2:index"); ok {
+		t.Errorf("Expected 2:index to be evicted")
+	}
+	if _, ok := c.Get("1:index"); !ok {
+		t.Errorf("Expected recently used 1:index to stay cached")
+	}
+	c.Add("big", []int{}, 101, true)
+	if _, ok := c.Get("big"); ok || c.Usage() != 90 {
+		t.Errorf("Expected an oversized entry not to be cached, used %d", c.Usage())
+	}
+
+	// Data blocks share the budget but are evicted before metadata.
+	c = New(100, 50)
+	c.Add("1:index", []int{}, 40, true)
+	for i := 0; i < 10; i++ {
+		c.Add(fmt.Sprintf("1:%d", i), make([]byte, 20), 20, false)
+	}
+	if _, ok := c.Get("1:index"); !ok {
+		t.Errorf("Expected data blocks not to evict the index")
+	}
+	if c.Usage() > 100 {
+		t.Errorf("Cache holds %d bytes, over its capacity of 100", c.Usage())
+	}
+}
@@ -0,0 +1,17 @@
+package leveldb
+
+import "github.com/quangh33/Go-LevelDB/internal/base"
+
+// ChecksumType selects the function that checksums WAL records and SSTable
+// data blocks. The type is stored in every file, so files written with
+// different types stay readable.
+type ChecksumType = base.ChecksumType
+
+const (
+	// ChecksumCRC32C is the Castagnoli CRC32, hardware accelerated on amd64 and
+	// arm64. It is the default.
+	ChecksumCRC32C = base.ChecksumCRC32C
+	// ChecksumXXHash64Low32 stores the lower 32 bits of the xxHash64 of the
+	// data, with seed 0. It is fast on any CPU.
+	ChecksumXXHash64Low32 = base.ChecksumXXHash64Low32
+)
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	leveldb "github.com/quangh33/Go-LevelDB"
+	"io"
+	"log"
+	"os"
+	"unicode/utf8"
+)
+
+// Dumps hold one key/value pair per line, in key order. Values are base64
+// encoded. Keys are written as is, so dumps stay readable; JSON dumps fall
+// back to a base64 key_b64 field for keys that are not valid UTF-8.
+const (
+	dumpFormatJSON = "json"
+	dumpFormatCSV  = "csv"
+)
+
+// dumpRecord is one line of a JSON dump.
+type dumpRecord struct {
+	Key    string `json:"key,omitempty"`
+	KeyB64 string `json:"key_b64,omitempty"`
+	Value  string `json:"value"`
+}
+
+// runCommand runs the CLI subcommand name with its arguments.
+func runCommand(name string, args []string) error {
+	switch name {
+	case "export":
+		return exportCommand(args)
+	case "import":
+		return importCommand(args)
+	case "replay":
+		return replayCommand(args)
+	case "restore":
+		return restoreCommand(args)
+	case "force-unlock":
+		return forceUnlockCommand(args)
+	}
+	return fmt.Errorf("unknown command %q, want export, import, replay, restore or force-unlock", name)
+}
+
+// exportCommand streams the keyspace, or a range of it, to a dump.
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	format := fs.String("format", dumpFormatJSON, "dump format: json or csv")
+	start := fs.String("start", "", "first key to export")
+	end := fs.String("end", "", "export keys before this one; empty for no limit")
+	out := fs.String("o", "", "output file; standard output if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("export: -db is required")
+	}
+
+	db, err := leveldb.NewDB(*dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	var endKey []byte
+	if *end != "" {
+		endKey = []byte(*end)
+	}
+	n, err := exportDump(db, w, *format, []byte(*start), endKey)
+	if err != nil {
+		return fmt.Errorf("export failed after %d keys: %w", n, err)
+	}
+	log.Printf("Exported %d keys", n)
+	return nil
+}
+
+// importCommand loads a dump through ImportSorted.
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	format := fs.String("format", dumpFormatJSON, "dump format: json or csv")
+	in := fs.String("i", "", "input file; standard input if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("import: -db is required")
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	dump, err := newDumpReader(r, *format)
+	if err != nil {
+		return err
+	}
+	db, err := leveldb.NewDB(*dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.ImportSorted(dump); err != nil {
+		return err
+	}
+	log.Printf("Imported %d keys", dump.count)
+	return nil
+}
+
+// replayCommand replays a trace file against a database.
+func replayCommand(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory, usually a fresh one")
+	trace := fs.String("trace", "", "trace file recorded through Options.TraceFile")
+	speed := fs.Float64("speed", 1, "replay speed relative to the recording; 0 for as fast as possible")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || *trace == "" {
+		return fmt.Errorf("replay: -db and -trace are required")
+	}
+
+	file, err := os.Open(*trace)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	db, err := leveldb.NewDB(*dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	stats, err := leveldb.ReplayTrace(db, file, *speed)
+	if err != nil {
+		return err
+	}
+	log.Printf("Replayed %d writes and %d gets in %v", stats.Writes, stats.Gets, stats.Duration)
+	return nil
+}
+
+// restoreCommand rebuilds a database from a checkpoint and archived WALs.
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	checkpoint := fs.String("checkpoint", "", "checkpoint directory written by CreateCheckpoint")
+	archive := fs.String("archive", "", "WAL archive directory, see Options.WALArchiveDir")
+	out := fs.String("o", "", "directory to create for the restored database")
+	seq := fs.Uint64("seq", 0, "sequence number to restore to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *checkpoint == "" || *archive == "" || *out == "" {
+		return fmt.Errorf("restore: -checkpoint, -archive and -o are required")
+	}
+	reached, err := leveldb.RestoreToSequence(*checkpoint, *archive, *out, *seq)
+	if err != nil {
+		return err
+	}
+	if reached < *seq {
+		log.Printf("WARNING: The archive ends at sequence %d, before %d", reached, *seq)
+	}
+	return nil
+}
+
+// forceUnlockCommand removes a LOCK file left behind by a process that is gone.
+func forceUnlockCommand(args []string) error {
+	fs := flag.NewFlagSet("force-unlock", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("force-unlock: -db is required")
+	}
+	return leveldb.ForceUnlock(*dir)
+}
+
+// exportDump writes the keys in [start, end) to w and returns how many it
+// wrote. A nil end means no upper bound.
+func exportDump(db *leveldb.DB, w io.Writer, format string, start, end []byte) (int, error) {
+	bw := bufio.NewWriter(w)
+	var write func(key, value []byte) error
+	flush := bw.Flush
+	switch format {
+	case dumpFormatJSON:
+		enc := json.NewEncoder(bw)
+		write = func(key, value []byte) error {
+			rec := dumpRecord{Value: base64.StdEncoding.EncodeToString(value)}
+			if utf8.Valid(key) {
+				rec.Key = string(key)
+			} else {
+				rec.KeyB64 = base64.StdEncoding.EncodeToString(key)
+			}
+			return enc.Encode(rec)
+		}
+	case dumpFormatCSV:
+		cw := csv.NewWriter(bw)
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+		if err := cw.Write([]string{"key", "value"}); err != nil {
+			return 0, err
+		}
+		write = func(key, value []byte) error {
+			return cw.Write([]string{string(key), base64.StdEncoding.EncodeToString(value)})
+		}
+	default:
+		return 0, fmt.Errorf("unknown dump format %q", format)
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+	n := 0
+	for it.Seek(start); it.Valid(); it.Next() {
+		if end != nil && it.Key().UserKey >= string(end) {
+			break
+		}
+		if err := write([]byte(it.Key().UserKey), it.Value()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := it.Error(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+// dumpReader reads a dump as a KVReader.
+type dumpReader struct {
+	json   *json.Decoder
+	csv    *csv.Reader
+	count  int
+	header bool // Whether the CSV header has been read
+}
+
+func newDumpReader(r io.Reader, format string) (*dumpReader, error) {
+	switch format {
+	case dumpFormatJSON:
+		return &dumpReader{json: json.NewDecoder(bufio.NewReader(r))}, nil
+	case dumpFormatCSV:
+		cr := csv.NewReader(bufio.NewReader(r))
+		cr.FieldsPerRecord = 2
+		cr.ReuseRecord = true
+		return &dumpReader{csv: cr}, nil
+	}
+	return nil, fmt.Errorf("unknown dump format %q", format)
+}
+
+func (d *dumpReader) Next() ([]byte, []byte, error) {
+	var key []byte
+	var value string
+	if d.json != nil {
+		var rec dumpRecord
+		if err := d.json.Decode(&rec); err != nil {
+			return nil, nil, err // io.EOF after the last record
+		}
+		key = []byte(rec.Key)
+		if rec.KeyB64 != "" {
+			var err error
+			if key, err = base64.StdEncoding.DecodeString(rec.KeyB64); err != nil {
+				return nil, nil, fmt.Errorf("record %d: bad key_b64: %w", d.count+1, err)
+			}
+		}
+		value = rec.Value
+	} else {
+		if !d.header {
+			if _, err := d.csv.Read(); err != nil {
+				return nil, nil, err
+			}
+			d.header = true
+		}
+		rec, err := d.csv.Read()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, value = []byte(rec[0]), rec[1]
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("record %d: bad value: %w", d.count+1, err)
+	}
+	d.count++
+	return key, decoded, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	leveldb "github.com/quangh33/Go-LevelDB"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *leveldb.DB {
+	t.Helper()
+	db, err := leveldb.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestExportImportDump(t *testing.T) {
+	src := openTestDB(t)
+	keys := []string{"a", "b,quoted\"", "c", "d\xff", "e"}
+	for i, k := range keys {
+		if err := src.Put(leveldb.WriteOptions{}, []byte(k), []byte(fmt.Sprintf("v%d\n\x00", i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	for _, format := range []string{dumpFormatJSON, dumpFormatCSV} {
+		var dump bytes.Buffer
+		n, err := exportDump(src, &dump, format, []byte("b"), []byte("e"))
+		if err != nil || n != 3 {
+			t.Fatalf("%s: exported %d keys, err %v; want 3", format, n, err)
+		}
+
+		dst := openTestDB(t)
+		r, err := newDumpReader(&dump, format)
+		if err != nil {
+			t.Fatalf("%s: newDumpReader failed: %v", format, err)
+		}
+		if err := dst.ImportSorted(r); err != nil {
+			t.Fatalf("%s: ImportSorted failed: %v", format, err)
+		}
+		for i, k := range keys {
+			val, err := dst.Get([]byte(k))
+			if inRange := i >= 1 && i <= 3; (err == nil) != inRange || (err == nil && string(val) != fmt.Sprintf("v%d\n\x00", i)) {
+				t.Errorf("%s: Get(%q) = %q, %v", format, k, val, err)
+			}
+		}
+	}
+}
@@ -2,21 +2,29 @@ package main
 
 import (
 	"fmt"
+	leveldb "github.com/quangh33/Go-LevelDB"
 	"log"
 	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	dbDir := "mydb_iterator_test"
 	os.RemoveAll(dbDir)
 
-	db, err := NewDB(dbDir)
+	db, err := leveldb.NewDB(dbDir)
 	if err != nil {
 		log.Fatalf("Failed to create DB: %v", err)
 	}
 	defer db.Close()
 
-	wo := WriteOptions{Sync: true}
+	wo := leveldb.WriteOptions{Sync: true}
 
 	log.Println("--- Populating database with test data ---")
 	if err := db.Put(wo, []byte("apple"), []byte("red")); err != nil {
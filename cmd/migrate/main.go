@@ -0,0 +1,14 @@
+// Command migrate copies a goleveldb, Badger or bbolt store into a database,
+// bulk-loading it straight into SSTables, or a database into such a store.
+package main
+
+import (
+	"log"
+	"os"
+)
+
+func main() {
+	if err := migrateCommand(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	leveldb "github.com/quangh33/Go-LevelDB"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"log"
+)
+
+// Stores that migrate can read from and write to.
+const (
+	storeGoLevelDB = "goleveldb"
+	storeBadger    = "badger"
+	storeBbolt     = "bbolt"
+)
+
+// migrateWriteBatch is how many pairs are written to another store per batch
+// or transaction.
+const migrateWriteBatch = 10000
+
+// migrateCommand copies every key of a goleveldb, Badger or bbolt store into a
+// database, or, with -to, the other way around.
+func migrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	from := fs.String("from", "", "store to migrate from: goleveldb, badger or bbolt")
+	to := fs.String("to", "", "store to migrate to: goleveldb, badger or bbolt")
+	path := fs.String("store", "", "path of the other store: a directory, or the file of a bbolt store")
+	bucket := fs.String("bucket", "", "bbolt bucket to read or write")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || *path == "" || (*from == "") == (*to == "") {
+		return fmt.Errorf("migrate: -db, -store and one of -from or -to are required")
+	}
+	kind := *from + *to
+	if kind == storeBbolt && *bucket == "" {
+		return fmt.Errorf("migrate: -bucket is required for bbolt")
+	}
+
+	db, err := leveldb.NewDB(*dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var n int
+	if *from != "" {
+		n, err = migrateFrom(db, kind, *path, *bucket)
+	} else {
+		n, err = migrateTo(db, kind, *path, *bucket)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated %d keys", n)
+	return nil
+}
+
+// migrateFrom bulk-loads the store of the given kind at path into db and
+// returns how many keys it loaded. All three stores iterate in bytewise key
+// order, which is the order ImportSorted needs.
+func migrateFrom(db *leveldb.DB, kind, path, bucket string) (int, error) {
+	var r interface {
+		leveldb.KVReader
+		io.Closer
+	}
+	switch kind {
+	case storeGoLevelDB:
+		src, err := goleveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+		if err != nil {
+			return 0, fmt.Errorf("failed to open goleveldb store: %w", err)
+		}
+		r = &goLevelDBReader{db: src, it: src.NewIterator(nil, nil)}
+	case storeBadger:
+		src, err := badger.Open(badger.DefaultOptions(path).WithReadOnly(true).WithLogger(nil))
+		if err != nil {
+			return 0, fmt.Errorf("failed to open Badger store: %w", err)
+		}
+		txn := src.NewTransaction(false)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		it.Rewind()
+		r = &badgerReader{db: src, txn: txn, it: it}
+	case storeBbolt:
+		src, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+		if err != nil {
+			return 0, fmt.Errorf("failed to open bbolt store: %w", err)
+		}
+		tx, err := src.Begin(false)
+		if err != nil {
+			src.Close()
+			return 0, err
+		}
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			tx.Rollback()
+			src.Close()
+			return 0, fmt.Errorf("bbolt bucket %q not found", bucket)
+		}
+		r = &bboltReader{db: src, tx: tx, c: b.Cursor()}
+	default:
+		return 0, fmt.Errorf("unknown store %q, want goleveldb, badger or bbolt", kind)
+	}
+	defer r.Close()
+
+	counter := &countingReader{r: r}
+	if err := db.ImportSorted(counter); err != nil {
+		return 0, fmt.Errorf("migration failed after %d keys: %w", counter.n, err)
+	}
+	return counter.n, nil
+}
+
+// migrateTo copies every key of db into the store of the given kind at path,
+// creating it if needed, and returns how many keys it copied.
+func migrateTo(db *leveldb.DB, kind, path, bucket string) (int, error) {
+	var put func(key, value []byte) error
+	var flush, closeStore func() error
+	pending := 0
+	switch kind {
+	case storeGoLevelDB:
+		dst, err := goleveldb.OpenFile(path, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open goleveldb store: %w", err)
+		}
+		batch := new(goleveldb.Batch)
+		put = func(key, value []byte) error {
+			batch.Put(key, value)
+			return nil
+		}
+		flush = func() error {
+			err := dst.Write(batch, nil)
+			batch.Reset()
+			return err
+		}
+		closeStore = dst.Close
+	case storeBadger:
+		dst, err := badger.Open(badger.DefaultOptions(path).WithLogger(nil))
+		if err != nil {
+			return 0, fmt.Errorf("failed to open Badger store: %w", err)
+		}
+		wb := dst.NewWriteBatch()
+		put = func(key, value []byte) error {
+			// The write batch holds on to the slices until it is flushed.
+			return wb.Set(bytes.Clone(key), bytes.Clone(value))
+		}
+		flush = func() error { return nil } // The write batch commits as it fills up
+		closeStore = func() error {
+			if err := wb.Flush(); err != nil {
+				dst.Close()
+				return err
+			}
+			return dst.Close()
+		}
+	case storeBbolt:
+		dst, err := bolt.Open(path, 0600, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open bbolt store: %w", err)
+		}
+		var keys, values [][]byte
+		put = func(key, value []byte) error {
+			keys = append(keys, bytes.Clone(key))
+			values = append(values, bytes.Clone(value))
+			return nil
+		}
+		flush = func() error {
+			err := dst.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+				if err != nil {
+					return err
+				}
+				for i := range keys {
+					if err := b.Put(keys[i], values[i]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			keys, values = keys[:0], values[:0]
+			return err
+		}
+		closeStore = dst.Close
+	default:
+		return 0, fmt.Errorf("unknown store %q, want goleveldb, badger or bbolt", kind)
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+	n := 0
+	var err error
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if err = put([]byte(it.Key().UserKey), it.Value()); err != nil {
+			break
+		}
+		n++
+		if pending++; pending == migrateWriteBatch {
+			if err = flush(); err != nil {
+				break
+			}
+			pending = 0
+		}
+	}
+	if err == nil {
+		err = it.Error()
+	}
+	if err == nil && pending > 0 {
+		err = flush()
+	}
+	if cerr := closeStore(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("migration failed after %d keys: %w", n, err)
+	}
+	return n, nil
+}
+
+// countingReader counts the pairs read from r.
+type countingReader struct {
+	r leveldb.KVReader
+	n int
+}
+
+func (c *countingReader) Next() ([]byte, []byte, error) {
+	key, value, err := c.r.Next()
+	if err == nil {
+		c.n++
+	}
+	return key, value, err
+}
+
+// goLevelDBReader reads a goleveldb store as a KVReader.
+type goLevelDBReader struct {
+	db *goleveldb.DB
+	it iterator.Iterator
+}
+
+func (r *goLevelDBReader) Next() ([]byte, []byte, error) {
+	if !r.it.Next() {
+		if err := r.it.Error(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, io.EOF
+	}
+	return r.it.Key(), r.it.Value(), nil
+}
+
+func (r *goLevelDBReader) Close() error {
+	r.it.Release()
+	return r.db.Close()
+}
+
+// badgerReader reads the latest version of every key of a Badger store as a
+// KVReader.
+type badgerReader struct {
+	db  *badger.DB
+	txn *badger.Txn
+	it  *badger.Iterator
+}
+
+func (r *badgerReader) Next() ([]byte, []byte, error) {
+	if !r.it.Valid() {
+		return nil, nil, io.EOF
+	}
+	item := r.it.Item()
+	key := item.KeyCopy(nil)
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.it.Next()
+	return key, value, nil
+}
+
+func (r *badgerReader) Close() error {
+	r.it.Close()
+	r.txn.Discard()
+	return r.db.Close()
+}
+
+// bboltReader reads one bbolt bucket as a KVReader. Nested buckets are
+// skipped.
+type bboltReader struct {
+	db      *bolt.DB
+	tx      *bolt.Tx
+	c       *bolt.Cursor
+	started bool
+}
+
+func (r *bboltReader) Next() ([]byte, []byte, error) {
+	for {
+		var key, value []byte
+		if !r.started {
+			key, value = r.c.First()
+			r.started = true
+		} else {
+			key, value = r.c.Next()
+		}
+		if key == nil {
+			return nil, nil, io.EOF
+		}
+		if value != nil {
+			return key, value, nil
+		}
+	}
+}
+
+func (r *bboltReader) Close() error {
+	r.tx.Rollback()
+	return r.db.Close()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	leveldb "github.com/quangh33/Go-LevelDB"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+// migrateFixture is the data written to every source store: more keys than a
+// write batch, an empty value and a key that is not valid UTF-8.
+func migrateFixture() map[string]string {
+	data := map[string]string{"empty": "", "key\xff": "binary\x00value"}
+	for i := 0; i < migrateWriteBatch+10; i++ {
+		data[fmt.Sprintf("key-%016d", i)] = fmt.Sprintf("value-%d", i)
+	}
+	return data
+}
+
+func TestMigrate(t *testing.T) {
+	data := migrateFixture()
+	writeSource := map[string]func(path string) error{
+		storeGoLevelDB: func(path string) error {
+			src, err := goleveldb.OpenFile(path, nil)
+			if err != nil {
+				return err
+			}
+			batch := new(goleveldb.Batch)
+			for k, v := range data {
+				batch.Put([]byte(k), []byte(v))
+			}
+			batch.Put([]byte("deleted"), []byte("v"))
+			batch.Delete([]byte("deleted"))
+			if err := src.Write(batch, nil); err != nil {
+				return err
+			}
+			return src.Close()
+		},
+		storeBadger: func(path string) error {
+			src, err := badger.Open(badger.DefaultOptions(path).WithLogger(nil))
+			if err != nil {
+				return err
+			}
+			wb := src.NewWriteBatch()
+			for k := range data {
+				wb.Set([]byte(k), []byte("old"))
+			}
+			if err := wb.Flush(); err != nil {
+				return err
+			}
+			// Only the latest version of a key is migrated.
+			wb = src.NewWriteBatch()
+			for k, v := range data {
+				wb.Set([]byte(k), []byte(v))
+			}
+			if err := wb.Flush(); err != nil {
+				return err
+			}
+			return src.Close()
+		},
+		storeBbolt: func(path string) error {
+			src, err := bolt.Open(path, 0600, nil)
+			if err != nil {
+				return err
+			}
+			err = src.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("kv"))
+				if err != nil {
+					return err
+				}
+				if _, err := b.CreateBucket([]byte("nested")); err != nil {
+					return err
+				}
+				for k, v := range data {
+					if err := b.Put([]byte(k), []byte(v)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return src.Close()
+		},
+	}
+
+	for _, kind := range []string{storeGoLevelDB, storeBadger, storeBbolt} {
+		root := t.TempDir()
+		src, back := filepath.Join(root, "src"), filepath.Join(root, "back")
+		if kind == storeBbolt {
+			src, back = src+".db", back+".db"
+		}
+		if err := writeSource[kind](src); err != nil {
+			t.Fatalf("%s: failed to write source store: %v", kind, err)
+		}
+
+		dir := filepath.Join(root, "db")
+		if err := migrateCommand([]string{"-db", dir, "-from", kind, "-store", src, "-bucket", "kv"}); err != nil {
+			t.Fatalf("%s: migrate from failed: %v", kind, err)
+		}
+		db, err := leveldb.NewDB(dir)
+		if err != nil {
+			t.Fatalf("%s: failed to open migrated DB: %v", kind, err)
+		}
+		n, err := migrateTo(db, kind, back, "kv")
+		if err != nil || n != len(data) {
+			t.Fatalf("%s: migrate to copied %d keys, err %v; want %d", kind, n, err, len(data))
+		}
+		checkMigrated(t, kind, db, data)
+		db.Close()
+
+		// Migrating the copy back must yield the same data.
+		dir = filepath.Join(root, "db2")
+		if err := migrateCommand([]string{"-db", dir, "-from", kind, "-store", back, "-bucket", "kv"}); err != nil {
+			t.Fatalf("%s: migrate back failed: %v", kind, err)
+		}
+		db, err = leveldb.NewDB(dir)
+		if err != nil {
+			t.Fatalf("%s: failed to open DB: %v", kind, err)
+		}
+		checkMigrated(t, kind, db, data)
+		db.Close()
+	}
+
+	if err := migrateCommand([]string{"-db", t.TempDir(), "-from", storeBbolt, "-store", "x.db"}); err == nil {
+		t.Errorf("Expected bbolt without -bucket to fail")
+	}
+}
+
+// checkMigrated checks that db holds exactly the pairs of data.
+func checkMigrated(t *testing.T, kind string, db *leveldb.DB, data map[string]string) {
+	t.Helper()
+	got := 0
+	it := db.NewIterator()
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if v, ok := data[it.Key().UserKey]; !ok || v != string(it.Value()) {
+			t.Errorf("%s: migrated %q = %q", kind, it.Key().UserKey, it.Value())
+		}
+		got++
+	}
+	if got != len(data) {
+		t.Errorf("%s: migrated %d keys, want %d", kind, got, len(data))
+	}
+}
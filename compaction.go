@@ -1,211 +1,678 @@
-package main
+package leveldb
 
 import (
-	"bufio"
-	"bytes"
-	"container/heap"
-	"encoding/binary"
-	"encoding/gob"
+	"context"
 	"fmt"
-	"github.com/huandu/skiplist"
-	"io"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/sstable"
 	"log"
+	"math"
 	"os"
-	"sort"
+	"slices"
+	"strings"
 )
 
-type minHeap []*heapItem
-
-func (h minHeap) Len() int      { return len(h) }
-func (h minHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
-func (h *minHeap) Push(x any)   { *h = append(*h, x.(*heapItem)) }
-func (h *minHeap) Pop() any {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil
-	*h = old[0 : n-1]
-	return item
-}
-func (h minHeap) Less(i, j int) bool {
-	return NewInternalKeyComparator().Compare(h[i].key, h[j].key) < 0
+// MergeSSTables compacts multiple SSTables into a single new one.
+func MergeSSTables(paths []string, outputPath string, opts *Options) error {
+	return MergeSSTablesContext(context.Background(), paths, outputPath, opts)
 }
 
-type heapItem struct {
-	key      InternalKey
-	value    []byte
-	iterator *sstableIterator
-}
+// mergeSSTables merges paths into the tables of out and returns the paths
+// written. Tombstones are kept unless dropTombstones is set. Between entries,
+// the merge calls yield, if not nil, and once ctx is done, it removes its
+// output and returns ctx.Err(). Reads of paths[i] are counted in inputIO[i] if
+// inputIO is not nil.
+func mergeSSTables(ctx context.Context, paths []string, out *tableSplitter, dropTombstones bool, yield func(), inputIO []*base.FileIO) ([]string, error) {
+	// Inputs are read through their index in large sequential chunks,
+	// bypassing the block cache so compaction does not evict blocks of the live
+	// working set.
+	opts := out.opts
+	var iterators []Iterator
+	defer func() {
+		for _, it := range iterators {
+			it.Close()
+		}
+	}()
+	for i, path := range paths {
+		ro := sstable.ReaderOptions{}
+		if inputIO != nil {
+			ro.IO = inputIO[i]
+		}
+		reader, err := sstable.Open(opts.FS, path, ro)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		iterators = append(iterators, reader.NewCompactionIterator(int64(opts.CompactionReadaheadSize)))
+		reader.Unref() // The iterator keeps the reader open
+	}
+	input := base.NewMergingIterator(iterators)
+	input.SeekToFirst()
+	var history *timestampHistory
+	if opts.TimestampSize > 0 && opts.TimestampHorizon != nil {
+		history = &timestampHistory{horizon: opts.TimestampHorizon()}
+	}
 
-type sstableIterator struct {
-	file   *os.File
-	reader *bufio.Reader
-	key    InternalKey
-	value  []byte
-	err    error
-}
+	// Surviving entries stream straight from the merged inputs into the output
+	// tables. It's possible for a compaction to result in no keys if all keys
+	// were deleted. In this case, no SSTable is created.
+	var lastUserKey string
+	var hasLast bool
+	var chain *mergeChain // Versions of lastUserKey, if its newest is a delta
+	addChain := func() error {
+		for _, e := range chain.folded(dropTombstones) {
+			if err := out.add(e.key, e.value); err != nil {
+				return err
+			}
+		}
+		chain = nil
+		return nil
+	}
 
-// newSSTableFileIterator creates an iterator that streams from a file path.
-func newSSTableFileIterator(path string) (*sstableIterator, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+	for ; input.Valid(); input.Next() {
+		select {
+		case <-ctx.Done():
+			out.abort()
+			return nil, ctx.Err()
+		default:
+		}
+		if yield != nil {
+			yield()
+		}
+		key := input.Key()
+		if chain != nil {
+			if key.UserKey == lastUserKey {
+				chain.add(key, input.Value())
+				continue
+			}
+			if err := addChain(); err != nil {
+				out.abort()
+				return nil, err
+			}
+		}
+		if key.Type == OpTypeMerge && (!hasLast || key.UserKey != lastUserKey) {
+			chain = &mergeChain{}
+			chain.add(key, input.Value())
+			lastUserKey = key.UserKey
+			hasLast = true
+			continue
+		}
+		// Skip all older events
+		if !hasLast || key.UserKey != lastUserKey {
+			keep := key.Type != OpTypeDelete || !dropTombstones
+			if opts.TimestampSize > 0 {
+				// A tombstone with a timestamp also hides the older versions
+				// of its key, so only the history rules may drop it.
+				keep = history == nil || history.keep(key, dropTombstones)
+			}
+			if keep {
+				if err := out.add(key, input.Value()); err != nil {
+					out.abort()
+					return nil, err
+				}
+			}
+			lastUserKey = key.UserKey
+			hasLast = true
+		}
+	}
+	if err := input.Error(); err != nil {
+		out.abort()
+		return nil, fmt.Errorf("failed to read compaction input: %w", err)
+	}
+	if chain != nil {
+		if err := addChain(); err != nil {
+			out.abort()
+			return nil, err
+		}
 	}
-	return &sstableIterator{
-		file:   file,
-		reader: bufio.NewReader(file),
-	}, nil
+	return out.finish()
 }
 
-func (it *sstableIterator) Next() bool {
-	var keySize, valueSize uint32
-	if err := binary.Read(it.reader, binary.LittleEndian, &keySize); err != nil {
-		if err != io.EOF {
-			it.err = err
-		}
-		return false
+// maybeScheduleCompactionLocked starts a background compaction when there are
+// too many tables or one of them is dense with tombstones. With
+// Options.TimeWindow set, expired windows are dropped and compaction stays
+// within a window instead. The caller must hold db.mu.
+func (db *DB) maybeScheduleCompactionLocked() {
+	if db.compactionInProgress || db.compactionPaused > 0 || db.closed.Load() || db.opts.DisableAutoCompactions || db.readOnly.Load() != nil || db.noSpace.Load() != nil {
+		return
 	}
-	if err := binary.Read(it.reader, binary.LittleEndian, &valueSize); err != nil {
-		it.err = err
-		return false
+	var tables []int
+	priority := false
+	if db.opts.TimeWindow != nil {
+		db.dropExpiredWindowsLocked()
+		tables = db.pickWindowRunLocked()
+	} else if tables = db.pickDenseTombstonesLocked(); len(tables) > 0 {
+		// The dense table is merged with its neighbors.
+		priority = true
+	} else if db.sortedRunsLocked() >= SSTableCountThreshold {
+		if db.opts.CompactionPriority == CompactionPriorityMinOverlap {
+			tables = db.pickMinOverlapLocked()
+		} else {
+			tables = append(tables, db.current.tables...)
+		}
+	} else if db.seekCompactFile != 0 {
+		tables = db.pickSeekCompactionLocked()
+	} else if db.overQuotaLocked() && db.sortedRunsLocked() > 1 {
+		// Merging everything drops as much as compaction can.
+		tables = append(tables, db.current.tables...)
 	}
-	keyBytes := make([]byte, keySize)
-	if _, err := io.ReadFull(it.reader, keyBytes); err != nil {
-		it.err = err
-		return false
+	if len(tables) == 0 {
+		return
 	}
-	if err := gob.NewDecoder(bytes.NewReader(keyBytes)).Decode(&it.key); err != nil {
-		it.err = err
-		return false
+	// Tombstones may only be dropped if no older table could hold the deleted keys.
+	dropTombstones := tables[0] == db.current.tables[0]
+	if tables = db.trivialMovesLocked(tables, dropTombstones); len(tables) == 0 {
+		return
 	}
-	valueBytes := make([]byte, valueSize)
-	if _, err := io.ReadFull(it.reader, valueBytes); err != nil {
-		it.err = err
-		return false
+	if priority {
+		db.stats.priorityCompactions++
 	}
-	it.value = valueBytes
-	return true
+	db.compactionInProgress = true
+	db.wg.Add(1)
+	db.schedule(func() { db.compact(tables, dropTombstones) }, false)
 }
 
-// MergeSSTables compacts multiple SSTables into a single new one.
-func MergeSSTables(paths []string, outputPath string) error {
-	var iterators []*sstableIterator
-	for _, path := range paths {
-		it, err := newSSTableFileIterator(path)
+// sortedRunsLocked returns how many tables count towards the compaction
+// trigger. With Options.TargetFileSize set, that is the number of sorted runs:
+// stretches of adjacent tables whose key ranges do not overlap, such as the
+// tables cut from one compaction. Merging a single run would only cut it into
+// as many tables again. The caller must hold db.mu, at least for reading.
+func (db *DB) sortedRunsLocked() int {
+	tables := db.current.tables
+	if db.opts.TargetFileSize == 0 {
+		return len(tables)
+	}
+	runs := 0
+	var run [][2]string // Key ranges of the current run
+	for _, num := range tables {
+		reader, err := db.findTable(num)
 		if err != nil {
-			if os.IsNotExist(err) {
-				continue
+			return len(tables)
+		}
+		smallest, largest, err := reader.KeyRange()
+		reader.Unref()
+		if err != nil {
+			return len(tables)
+		}
+		overlaps := runs == 0
+		for _, r := range run {
+			if smallest <= r[1] && r[0] <= largest {
+				overlaps = true
+				break
 			}
-			return err
 		}
-		iterators = append(iterators, it)
+		if overlaps {
+			runs++
+			run = run[:0]
+		}
+		run = append(run, [2]string{smallest, largest})
 	}
+	return runs
+}
 
-	h := &minHeap{}
-	heap.Init(h)
+// chargeSeek records a lookup that read table num without finding the key,
+// and schedules the table for compaction once its allowed seeks run out.
+func (db *DB) chargeSeek(num int) {
+	db.fileMu.Lock()
+	allowed, ok := db.fileSeeks[num]
+	if !ok {
+		allowed = max(MinAllowedSeeks, int(fileSize(db.opts.FS, tablePath(db.dataDir, num))/BytesPerSeek))
+	}
+	allowed--
+	db.fileSeeks[num] = allowed
+	db.fileMu.Unlock()
+	if allowed != 0 {
+		return
+	}
 
-	for _, it := range iterators {
-		if it.Next() {
-			heap.Push(h, &heapItem{
-				key:      it.key,
-				value:    it.value,
-				iterator: it,
-			})
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.seekCompactFile = num
+	db.maybeScheduleCompactionLocked()
+}
+
+// pickSeekCompactionLocked merges the table that ran out of allowed seeks
+// with its older neighbor, so lookups that miss it no longer pay for a
+// separate probe. The caller must hold db.mu.
+func (db *DB) pickSeekCompactionLocked() []int {
+	num := db.seekCompactFile
+	db.seekCompactFile = 0
+	i := slices.Index(db.current.tables, num)
+	if i < 0 || len(db.current.tables) < 2 {
+		return nil
+	}
+	i = max(i, 1)
+	return []int{db.current.tables[i-1], db.current.tables[i]}
+}
+
+// pickMinOverlapLocked returns the adjacent pair of tables with the lowest
+// overlap ratio, see CompactionPriorityMinOverlap. The caller must hold db.mu.
+func (db *DB) pickMinOverlapLocked() []int {
+	tables := db.current.tables
+	best, bestScore := 0, math.Inf(1)
+	for i := 1; i < len(tables); i++ {
+		score, err := db.overlapRatio(tables[i-1], tables[i])
+		if err != nil {
+			log.Printf("ERROR: Failed to score SSTable %d for compaction: %v", tables[i], err)
+			continue
+		}
+		if score < bestScore {
+			best, bestScore = i, score
 		}
 	}
+	if best == 0 {
+		return nil
+	}
+	return []int{tables[best-1], tables[best]}
+}
 
-	list := skiplist.New(NewInternalKeyComparator())
-	var lastUserKey string
-	var itemCount uint
+// overlapRatio returns the bytes of the data blocks of table older that hold
+// keys in the key range of table newer, divided by the size of newer.
+func (db *DB) overlapRatio(older, newer int) (float64, error) {
+	newerReader, err := db.findTable(newer)
+	if err != nil {
+		return 0, err
+	}
+	smallest, largest, err := newerReader.KeyRange()
+	newerReader.Unref()
+	if err != nil {
+		return 0, err
+	}
+	olderReader, err := db.findTable(older)
+	if err != nil {
+		return 0, err
+	}
+	defer olderReader.Unref()
+	index, err := olderReader.Index()
+	if err != nil {
+		return 0, err
+	}
+	var overlap int64
+	for i, entry := range index {
+		// Block i holds the keys after the last key of block i-1.
+		if entry.LastKey.UserKey < smallest || (i > 0 && index[i-1].LastKey.UserKey >= largest) {
+			continue
+		}
+		overlap += int64(entry.Size)
+	}
+	size := max(fileSize(db.opts.FS, tablePath(db.dataDir, newer)), 1)
+	return float64(overlap) / float64(size), nil
+}
 
-	for h.Len() > 0 {
-		item := heap.Pop(h).(*heapItem)
-		// Skip all older events
-		if item.key.UserKey != lastUserKey {
-			if item.key.Type == OpTypePut {
-				list.Set(item.key, item.value)
-				itemCount++
+// trivialMovesLocked returns the tables that compaction has to rewrite. A
+// table whose key range overlaps no other input holds no older versions to
+// discard, so it keeps its place instead of being copied through the merge,
+// unless it has tombstones that could be dropped. When no input overlaps
+// another, all of them are merged, since that is the only way to lower the
+// table count. The caller must hold db.mu.
+func (db *DB) trivialMovesLocked(tables []int, dropTombstones bool) []int {
+	type tableRange struct {
+		smallest, largest string
+		deletions         uint64
+	}
+	ranges := make([]tableRange, len(tables))
+	for i, num := range tables {
+		reader, err := db.findTable(num)
+		if err != nil {
+			return tables // Let the compaction report the error
+		}
+		smallest, largest, err := reader.KeyRange()
+		ranges[i] = tableRange{smallest, largest, reader.Properties().NumDeletions}
+		reader.Unref()
+		if err != nil {
+			return tables
+		}
+	}
+	var merge, moved []int
+	for i, r := range ranges {
+		overlaps := dropTombstones && r.deletions > 0
+		for j, other := range ranges {
+			if j != i && r.smallest <= other.largest && other.smallest <= r.largest {
+				overlaps = true
+				break
 			}
-			lastUserKey = item.key.UserKey
 		}
-		if item.iterator.Next() {
-			heap.Push(h, &heapItem{
-				key:      item.iterator.key,
-				value:    item.iterator.value,
-				iterator: item.iterator,
-			})
+		if overlaps {
+			merge = append(merge, tables[i])
+		} else {
+			moved = append(moved, tables[i])
 		}
 	}
-	if list.Len() == 0 {
-		// It's possible for a compaction to result in no keys if all keys
-		// were deleted. In this case, we don't create an empty SSTable.
-		return nil
+	if len(merge) == 0 {
+		// Nothing overlaps, so only a full merge lowers the table count.
+		return tables
+	}
+	if len(moved) > 0 {
+		log.Printf("Trivial move of SSTables %v", moved)
+		db.stats.trivialMoves += len(moved)
 	}
+	return merge
+}
 
-	return WriteSSTable(outputPath, itemCount, list.Front())
+// pickDenseTombstonesLocked returns the oldest live table with more
+// tombstones and obsolete versions than Options.TombstoneCompactionRatio
+// allows, together with its neighbors, so the tombstones are dropped with the
+// values they shadow. The caller must hold db.mu.
+func (db *DB) pickDenseTombstonesLocked() []int {
+	tables := db.current.tables
+	for i, num := range tables {
+		if db.isDenseLocked(num) {
+			return append([]int(nil), tables[max(i-1, 0):min(i+2, len(tables))]...)
+		}
+	}
+	return nil
+}
+
+// isDenseLocked reports whether table num is marked for a priority
+// compaction, because tombstones and older versions of keys, which a
+// compaction drops, dominate it. The answer comes from the table properties
+// and is cached for the life of the table. The caller must hold db.mu.
+func (db *DB) isDenseLocked(num int) bool {
+	if dense, ok := db.denseTables[num]; ok {
+		return dense
+	}
+	reader, err := db.findTable(num)
+	if err != nil {
+		return false
+	}
+	props := reader.Properties()
+	reader.Unref()
+	garbage := props.NumDeletions + props.NumObsolete
+	dense := garbage >= MinTombstonesForCompaction &&
+		float64(garbage) > db.opts.TombstoneCompactionRatio*float64(props.NumEntries)
+	db.denseTables[num] = dense
+	return dense
 }
 
-func (db *DB) compact() {
+// compact merges tables into new tables of about Options.TargetFileSize bytes
+// that take their place. The tables must be adjacent in db.current.tables,
+// apart from tables whose key range overlaps none of them.
+func (db *DB) compact(tables []int, dropTombstones bool) {
 	defer db.wg.Done()
-	db.mu.Lock()
+	defer func() {
+		db.mu.Lock()
+		db.compactionInProgress = false
+		db.flushCond.Broadcast()
+		db.mu.Unlock()
+	}()
+	start := db.opts.Now()
 	log.Println("Starting compaction ...")
-	tablesToCompact := make([]int, len(db.activeSSTables))
-	copy(tablesToCompact, db.activeSSTables)
-	outputNum := db.nextFileNumber
-	db.nextFileNumber++
-
-	db.mu.Unlock()
 	var pathsToCompact []string
-	for _, num := range tablesToCompact {
-		pathsToCompact = append(pathsToCompact, fmt.Sprintf("%s/%05d.sst", db.dataDir, num))
+	var inputIO []*base.FileIO
+	var minTime, maxTime, bytesRead int64
+	for i, num := range tables {
+		pathsToCompact = append(pathsToCompact, tablePath(db.dataDir, num))
+		inputIO = append(inputIO, db.tableIO(num))
+		bytesRead += fileSize(db.opts.FS, pathsToCompact[i])
+		reader, err := db.findTable(num)
+		if err != nil {
+			log.Printf("ERROR: Compaction failed to open SSTable %d: %v", num, err)
+			db.quarantine(err)
+			return
+		}
+		props := reader.Properties()
+		reader.Unref()
+		if i == 0 || props.MinTimestamp < minTime {
+			minTime = props.MinTimestamp
+		}
+		maxTime = max(maxTime, props.MaxTimestamp)
 	}
 	log.Printf("paths to compact: %v", pathsToCompact)
-	newSSTablePath := fmt.Sprintf("%s/%05d.sst", db.dataDir, outputNum)
-	tmpPath := newSSTablePath + ".tmp"
+	var output []int
+	newOutput := func() string {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		output = append(output, db.nextFileNumber)
+		db.nextFileNumber++
+		return tablePath(db.dataDir, output[len(output)-1]) + ".tmp"
+	}
 
-	if err := MergeSSTables(pathsToCompact, tmpPath); err != nil {
-		log.Printf("ERROR: Compaction failed: %v", err)
-		return
+	out := &tableSplitter{opts: db.tableOptions(), newPath: newOutput, minTime: minTime, maxTime: maxTime}
+	if db.opts.MaxGrandparentOverlapBytes > 0 {
+		grandparents, err := db.grandparents(tables)
+		if err != nil {
+			log.Printf("ERROR: Compaction failed: %v", err)
+			return
+		}
+		out.grandparents = grandparents
 	}
 
-	if err := os.Rename(tmpPath, newSSTablePath); err != nil {
-		log.Printf("ERROR: Compaction failed during file rename: %v", err)
+	// The merge writes no table when every key was deleted.
+	tmpPaths, err := mergeSSTables(db.closeCtx, pathsToCompact, out, dropTombstones, db.yieldToFlushes, inputIO)
+	if err != nil {
+		if db.closeCtx.Err() != nil {
+			log.Println("Compaction cancelled by Close.")
+		} else {
+			log.Printf("ERROR: Compaction failed: %v", err)
+			db.quarantine(err)
+			db.setNoSpace(err)
+		}
 		return
 	}
+	if db.opts.VerifyCompactionOutput {
+		if err := verifyCompactionOutput(db.opts.FS, tmpPaths, out.entries); err != nil {
+			log.Printf("ERROR: Compaction output failed verification, keeping the input tables: %v", err)
+			for _, path := range tmpPaths {
+				db.opts.FS.Remove(path)
+			}
+			return
+		}
+	}
+	removeOutput := func() {
+		for _, num := range output {
+			db.opts.FS.Remove(tablePath(db.dataDir, num))
+		}
+	}
+	for _, tmpPath := range tmpPaths {
+		if err := db.opts.FS.Rename(tmpPath, strings.TrimSuffix(tmpPath, ".tmp")); err != nil {
+			log.Printf("ERROR: Compaction failed during file rename: %v", err)
+			db.setNoSpace(err)
+			for _, path := range tmpPaths {
+				db.opts.FS.Remove(path)
+			}
+			removeOutput()
+			return
+		}
+	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	newActiveTables := []int{outputNum}
+	if db.closed.Load() {
+		// The tables stay as they are; the output is an orphan for the next open.
+		removeOutput()
+		log.Println("Compaction cancelled by Close.")
+		return
+	}
 	isCompacted := make(map[int]bool)
-	for _, num := range tablesToCompact {
+	for _, num := range tables {
 		isCompacted[num] = true
 	}
 
-	// Check the *current* activeSSTables list for any new files.
-	for _, num := range db.activeSSTables {
+	// The outputs take the place of the compacted tables in the *current*
+	// Version, which may have gained newer tables in the meantime.
+	var newActiveTables []int
+	for _, num := range db.current.tables {
 		if !isCompacted[num] {
 			newActiveTables = append(newActiveTables, num)
+		} else if num == tables[0] {
+			newActiveTables = append(newActiveTables, output...)
 		}
 	}
 
-	db.activeSSTables = newActiveTables
-	sort.Ints(db.activeSSTables)
-
-	if err := db.saveState(); err != nil {
+	// Installing the new Version releases the old one; the compacted files are
+	// deleted as soon as no iterator or read still holds a Version listing them.
+	seqs := make(map[int]SeqRange, len(output))
+	for i, num := range output {
+		seqs[num] = out.seqs[i]
+	}
+	if err := db.installVersionLocked(newActiveTables, seqs); err != nil {
 		log.Printf("CRITICAL ERROR: Failed to save state after compaction: %v", err)
+		db.setNoSpace(err)
 		return
 	}
+	db.stats.compactions++
+	db.stats.compactionBytesRead += bytesRead
+	for _, num := range output {
+		// Tombstones that survived this compaction have to wait for the
+		// next one, so the output does not trigger another compaction.
+		db.denseTables[num] = false
+		size := fileSize(db.opts.FS, tablePath(db.dataDir, num))
+		db.stats.compactionBytesWritten += size
+		db.tableWritten(num, size, true)
+	}
+	db.stats.compactionTime += db.opts.Now().Sub(start)
 	log.Println("Compaction completed successfully.")
-	// Delete old SSTable files asynchronously
-	go func(pathsToDelete []string) {
-		db.wg.Add(1)
-		defer db.wg.Done()
-		log.Printf("Start deleting old sst files: %v", pathsToDelete)
-		for _, path := range pathsToDelete {
-			if err := os.Remove(path); err != nil {
-				log.Printf("ERROR: Failed to remove old SSTable %s after compaction: %v", path, err)
-			}
+}
+
+// grandparents returns the key ranges and sizes of the live tables that are
+// not in tables, ordered by largest key.
+func (db *DB) grandparents(tables []int) ([]tableSpan, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var spans []tableSpan
+	for _, num := range db.current.tables {
+		if slices.Contains(tables, num) {
+			continue
+		}
+		reader, err := db.findTable(num)
+		if err != nil {
+			return nil, err
+		}
+		smallest, largest, err := reader.KeyRange()
+		reader.Unref()
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, tableSpan{smallest, largest, fileSize(db.opts.FS, tablePath(db.dataDir, num))})
+	}
+	slices.SortFunc(spans, func(a, b tableSpan) int { return strings.Compare(a.largest, b.largest) })
+	return spans, nil
+}
+
+// CompactRange compacts every table holding keys in [start, end), together
+// with all older tables, and waits for it to finish. A nil start or end leaves
+// that side unbounded, so CompactRange(nil, nil) compacts the whole database.
+func (db *DB) CompactRange(start, end []byte) error {
+	db.mu.Lock()
+	for db.compactionInProgress {
+		db.waitForJobLocked()
+	}
+	if db.closed.Load() {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	if err := db.readOnlyErr(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	// Compacting from the oldest table keeps the inputs adjacent and lets
+	// tombstones be dropped.
+	last := -1
+	for i, num := range db.current.tables {
+		reader, err := db.findTable(num)
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		smallest, largest, err := reader.KeyRange()
+		reader.Unref()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		if (end == nil || smallest < string(end)) && largest >= string(start) {
+			last = i
+		}
+	}
+	if last < 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	tables := append([]int(nil), db.current.tables[:last+1]...)
+	db.compactionInProgress = true
+	db.wg.Add(1)
+	db.mu.Unlock()
+
+	db.compact(tables, true)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return ErrClosed // Close canceled the compaction
+	}
+	for _, num := range tables {
+		if slices.Contains(db.current.tables, num) {
+			return fmt.Errorf("compaction of tables %v failed", tables)
+		}
+	}
+	return nil
+}
+
+// PauseBackgroundWork stops new compactions from starting and waits for a
+// running one to finish. Flushes continue so that writes are not blocked. Each
+// call must be matched by a call to ContinueBackgroundWork.
+func (db *DB) PauseBackgroundWork() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.compactionPaused++
+	for db.compactionInProgress {
+		db.waitForJobLocked()
+	}
+}
+
+// ContinueBackgroundWork undoes one PauseBackgroundWork. When the last pause
+// is lifted, compaction triggers that fired in the meantime are re-evaluated.
+func (db *DB) ContinueBackgroundWork() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.compactionPaused == 0 {
+		return fmt.Errorf("background work is not paused")
+	}
+	db.compactionPaused--
+	if db.compactionPaused == 0 {
+		db.maybeScheduleCompactionLocked()
+	}
+	return nil
+}
+
+// DeleteFilesInRange removes every SSTable whose keys all fall in [start, end)
+// from the database without rewriting any data. A nil end means no upper
+// bound. Keys in the range that live in the memtable or in tables only partly
+// inside the range are kept, and dropping a table can expose older versions of
+// its keys stored in other tables; Delete them to remove them for good.
+func (db *DB) DeleteFilesInRange(start, end []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// A running compaction may be merging the tables that are about to go.
+	for db.compactionInProgress {
+		db.waitForJobLocked()
+	}
+	if db.closed.Load() {
+		return ErrClosed
+	}
+
+	var live, dropped []int
+	for _, num := range db.current.tables {
+		reader, err := db.findTable(num)
+		if err != nil {
+			return err
 		}
-		log.Printf("Successfully garbage collected %d old SSTables.", len(pathsToDelete))
-	}(pathsToCompact)
+		smallest, largest, err := reader.KeyRange()
+		reader.Unref()
+		if err != nil {
+			return err
+		}
+		if smallest >= string(start) && (end == nil || largest < string(end)) {
+			dropped = append(dropped, num)
+		} else {
+			live = append(live, num)
+		}
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+	log.Printf("Deleting SSTables %v in range [%q, %q)", dropped, start, end)
+	return db.installVersionLocked(live, nil)
 }
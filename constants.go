@@ -1,10 +1,83 @@
-package main
+package leveldb
+
+import (
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/sstable"
+	"time"
+)
 
 const (
-	// DataBlockSize groups key-value pairs into blocks of this size.
+	// DataBlockSize is the default size of the data blocks of an SSTable.
 	DataBlockSize         = 4096 // 4 KB
 	SSTableCountThreshold = 10
-	MemtableSizeThreshold = 4 * 1024 * 1024 // 4 MB
-	TableCacheSize        = 128             // Number of SSTable readers to keep in cache
-	BlockCacheSize        = 8 * 1024 * 1024 // 8MB block cache
+	MemtableSizeThreshold = 4 * 1024 * 1024   // 4 MB
+	TableCacheSize        = 128               // Number of SSTable readers to keep in cache by default
+	BlockCacheSize        = 8 * 1024 * 1024   // 8MB block cache
+	DefaultMetaCacheSize  = 2 * 1024 * 1024   // 2MB of the block cache for index and filter blocks
+	DefaultMaxKeySize     = 64 * 1024         // 64 KB
+	DefaultMaxValueSize   = 256 * 1024 * 1024 // 256 MB
+	DefaultFilterFPRate   = 0.01              // 1% bloom filter false positives
+
+	DefaultCompactionReadaheadSize     = 2 * 1024 * 1024   // 2 MB
+	DefaultPendingCompactionBytesLimit = 256 * 1024 * 1024 // 256 MB
+
+	// NumNonTableFiles is how much of MaxOpenFiles is set aside for the WAL, the
+	// LOCK and state files; the rest bounds the table cache.
+	NumNonTableFiles    = 10
+	DefaultMaxOpenFiles = TableCacheSize + NumNonTableFiles
+
+	// A table may absorb max(MinAllowedSeeks, size/BytesPerSeek) lookups that
+	// read it without finding the key before it is compacted, as in LevelDB.
+	MinAllowedSeeks = 100
+	BytesPerSeek    = 16 * 1024
+
+	// An iterator that crosses ReadaheadTrigger block boundaries in a row
+	// without seeking reads the next ReadaheadBlocks blocks in the background.
+	ReadaheadTrigger = sstable.ReadaheadTrigger
+	ReadaheadBlocks  = sstable.ReadaheadBlocks
+
+	// Flushes and compactions run on DefaultMaxBackgroundJobs goroutines, so
+	// a flush does not wait for a compaction.
+	DefaultMaxBackgroundJobs = 2
+
+	// GetAsync lookups are served by DefaultAsyncGetWorkers goroutines; at most
+	// AsyncGetQueueSize lookups wait for a worker before GetAsync blocks.
+	DefaultAsyncGetWorkers = 16
+	AsyncGetQueueSize      = 1024
+
+	// DefaultTxnLockTimeout is how long a write waits for a key locked by a
+	// pessimistic transaction.
+	DefaultTxnLockTimeout = time.Second
+
+	// With SyncFailureRetry, a failed fsync is retried DefaultSyncRetries
+	// times, waiting DefaultSyncRetryBackoff before the first retry.
+	DefaultSyncRetries      = 5
+	DefaultSyncRetryBackoff = 10 * time.Millisecond
+
+	// While the disk is full, a write retries the failed flush at most once
+	// per NoSpaceRetryInterval before failing with ErrNoSpace.
+	NoSpaceRetryInterval = time.Second
+
+	// BulkLoadTableSize is the size at which a bulk load starts a new SSTable.
+	BulkLoadTableSize = 64 * 1024 * 1024
+
+	// A memtable smaller than this is not flushed early by a WriteBufferManager,
+	// so a budget exceeded by other databases does not cause tiny tables.
+	MinWriteBufferFlushSize = 64 * 1024
+
+	// Bounds of the bits per key chosen by Options.AdaptiveFilter, and the
+	// lookups a filter must have answered before they are taken into account.
+	DefaultMinFilterBitsPerKey     = 2
+	DefaultMaxFilterBitsPerKey     = 20
+	DefaultAdaptiveFilterMinChecks = 1000
+
+	DefaultTombstoneCompactionRatio = 0.5
+	// A table needs at least this many tombstones and obsolete versions to
+	// trigger compaction on its own, so tiny tables do not cause a full
+	// compaction on every flush.
+	MinTombstonesForCompaction = 100
+
+	// maxEncodedSize is the hard limit on keys, values and whole batches imposed
+	// by the uint32 length fields of the WAL and data block formats.
+	maxEncodedSize = base.MaxEncodedSize
 )
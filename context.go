@@ -0,0 +1,145 @@
+package leveldb
+
+import (
+	"context"
+)
+
+// ctxMutex is a mutex whose Lock can be abandoned when a context is done.
+// The zero value is not usable; create it with newCtxMutex.
+type ctxMutex chan struct{}
+
+func newCtxMutex() ctxMutex {
+	return make(ctxMutex, 1)
+}
+
+func (m ctxMutex) Lock() {
+	m <- struct{}{}
+}
+
+// LockContext acquires the mutex or returns ctx.Err() if ctx is done first.
+func (m ctxMutex) LockContext(ctx context.Context) error {
+	select {
+	case m <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m ctxMutex) Unlock() {
+	<-m
+}
+
+// GetContext is like Get but stops searching SSTables and returns ctx.Err()
+// once ctx is done.
+func (db *DB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	return db.get(ctx, key, db.sequenceNum.Load())
+}
+
+// PutContext is like Put but gives up with ctx.Err() if ctx is done while the
+// write is still waiting behind other writers.
+func (db *DB) PutContext(ctx context.Context, wo WriteOptions, key, value []byte) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return db.WriteContext(ctx, wo, batch)
+}
+
+// DeleteContext is like Delete but gives up with ctx.Err() if ctx is done while
+// the write is still waiting behind other writers.
+func (db *DB) DeleteContext(ctx context.Context, wo WriteOptions, key []byte) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return db.WriteContext(ctx, wo, batch)
+}
+
+// WriteContext is like Write but gives up with ctx.Err() if ctx is done while
+// the batch is still waiting behind other writers. Once the batch has been
+// handed to the WAL it runs to completion: a returned error means that nothing
+// was written, unless it wraps ErrUnsynced, when the batch was applied but its
+// WAL sync failed.
+func (db *DB) WriteContext(ctx context.Context, wo WriteOptions, batch *WriteBatch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	if err := db.checkBatch(batch); err != nil {
+		return batch.finish(SeqRange{}, err)
+	}
+	return db.applyBatch(ctx, wo, batch, true)
+}
+
+// NewIteratorContext is like NewIterator but the iterator becomes invalid, with
+// Error returning ctx.Err(), as soon as ctx is done.
+func (db *DB) NewIteratorContext(ctx context.Context) Iterator {
+	return &contextIterator{Iterator: db.NewIterator(), ctx: ctx}
+}
+
+type contextIterator struct {
+	Iterator
+	ctx context.Context
+	err error
+}
+
+func (it *contextIterator) check() {
+	if it.err == nil {
+		it.err = it.ctx.Err()
+	}
+}
+
+func (it *contextIterator) Valid() bool {
+	return it.err == nil && it.Iterator.Valid()
+}
+
+func (it *contextIterator) Next() {
+	if it.check(); it.err == nil {
+		it.Iterator.Next()
+	}
+}
+
+func (it *contextIterator) Prev() {
+	if it.check(); it.err == nil {
+		it.Iterator.Prev()
+	}
+}
+
+func (it *contextIterator) SeekToFirst() {
+	if it.check(); it.err == nil {
+		it.Iterator.SeekToFirst()
+	}
+}
+
+func (it *contextIterator) Seek(key []byte) {
+	if it.check(); it.err == nil {
+		it.Iterator.Seek(key)
+	}
+}
+
+func (it *contextIterator) SeekForPrev(key []byte) {
+	if it.check(); it.err == nil {
+		it.Iterator.SeekForPrev(key)
+	}
+}
+
+func (it *contextIterator) SeekToLast() {
+	if it.check(); it.err == nil {
+		it.Iterator.SeekToLast()
+	}
+}
+
+func (it *contextIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.Iterator.Error()
+}
+
+// MergeSSTablesContext is like MergeSSTables but abandons the merge once ctx
+// is done, removes the partial output and returns ctx.Err().
+func MergeSSTablesContext(ctx context.Context, paths []string, outputPath string, opts *Options) error {
+	single := *sanitizeOptions(opts)
+	now := single.Now().UnixNano()
+	single.TargetFileSize = 0
+	single.MaxGrandparentOverlapBytes = 0
+	out := &tableSplitter{opts: &single, newPath: func() string { return outputPath }, minTime: now, maxTime: now}
+	_, err := mergeSSTables(ctx, paths, out, true, nil, nil)
+	return err
+}
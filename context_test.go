@@ -0,0 +1,119 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContextCancellation(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	db.Put(wo, []byte("a"), []byte("1"))
+
+	// A writer stuck behind another writer gives up when its deadline passes.
+	db.writeMu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := db.PutContext(ctx, wo, []byte("b"), []byte("2")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+	db.writeMu.Unlock()
+	if _, err := db.Get([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Abandoned write must not be applied")
+	}
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err := db.GetContext(canceled, []byte("missing")); !errors.Is(err, ErrNotFound) {
+		// Memtable lookups finish before any cancellation point.
+		t.Fatalf("Unexpected error for in-memory lookup: %v", err)
+	}
+	it := db.NewIteratorContext(canceled)
+	defer it.Close()
+	it.SeekToFirst()
+	if it.Valid() || !errors.Is(it.Error(), context.Canceled) {
+		t.Fatalf("Expected canceled iterator, valid=%v err=%v", it.Valid(), it.Error())
+	}
+}
+
+func TestMergeSSTablesContextCanceled(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		db.Put(WriteOptions{}, generateKey(i), []byte("v"))
+	}
+	db.Flush()
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.sst"))
+	db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := filepath.Join(t.TempDir(), "merged.sst")
+	if err := MergeSSTablesContext(ctx, paths, out, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected Canceled, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial output to be removed, stat: %v", err)
+	}
+}
+
+func TestCloseCancelsCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{MaxBackgroundJobs: 1, DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 100; i++ {
+			db.Put(WriteOptions{}, generateKey(i), []byte(fmt.Sprint(n)))
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	// Park the compaction in the middle of its merge: the only worker is busy,
+	// so the merge yields to a queued flush, which blocks.
+	hold, release, parked := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	db.schedule(func() { <-hold }, false)
+	db.schedule(func() {
+		close(parked)
+		<-release
+	}, true)
+	compacted := make(chan error, 1)
+	go func() { compacted <- db.CompactRange(nil, nil) }()
+	<-parked
+	closed := make(chan error, 1)
+	go func() { closed <- db.Close() }()
+	for db.closeCtx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	close(hold)
+
+	if err := <-compacted; !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected CompactRange to fail with ErrClosed, got %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("Canceled compaction left %v behind", tmp)
+	}
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if val, err := db.Get(generateKey(7)); err != nil || string(val) != "1" {
+		t.Errorf("Get = %q, %v after reopen; want the newest value", val, err)
+	}
+}
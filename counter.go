@@ -0,0 +1,127 @@
+package leveldb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A counter is a value of 8 bytes holding a little-endian int64. Increment
+// writes the delta as an OpTypeMerge entry instead of reading the counter, and
+// reads add up the deltas down to the newest put or delete of the key, which
+// count from their value and from zero. Compaction folds the deltas it sees
+// into one entry.
+
+var (
+	// ErrNotCounter is returned when the deltas of Increment apply to a value
+	// that is not a counter.
+	ErrNotCounter = errors.New("value is not a counter")
+	// ErrIndexedCounter is returned by Increment on a database with secondary
+	// indexes, which cannot extract values from deltas.
+	ErrIndexedCounter = errors.New("Increment is not supported with secondary indexes")
+)
+
+// Increment adds delta to the counter at key without reading it. A missing
+// key counts from zero.
+func (db *DB) Increment(wo WriteOptions, key []byte, delta int64) error {
+	batch := NewWriteBatch()
+	batch.Increment(key, delta)
+	return db.Write(wo, batch)
+}
+
+// Increment queues the addition of delta to the counter at key when the batch
+// is written.
+func (b *WriteBatch) Increment(key []byte, delta int64) {
+	b.entries = append(b.entries, batchEntry{op: OpTypeMerge, key: key, value: encodeCounter(delta)})
+}
+
+func encodeCounter(v int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(v))
+}
+
+func decodeCounter(val []byte) (int64, error) {
+	if len(val) != 8 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrNotCounter, len(val))
+	}
+	return int64(binary.LittleEndian.Uint64(val)), nil
+}
+
+// resolveMerge returns the counter of the entry ik, whose value is delta,
+// adding the deltas from ik down to the newest put or delete of its key.
+func (db *DB) resolveMerge(ik InternalKey, delta []byte) ([]byte, error) {
+	sum, err := decodeCounter(delta)
+	if err != nil {
+		return nil, err
+	}
+	// Increment is not allowed with timestamps, so the stored key is the
+	// user key.
+	key := []byte(ik.UserKey)
+	for ik.SeqNum > 0 {
+		var val []byte
+		var found bool
+		ik, val, found, err = db.getEntry(context.Background(), key, ik.SeqNum-1)
+		if err != nil {
+			return nil, err
+		}
+		if !found || ik.Type == OpTypeDelete {
+			break
+		}
+		if ik.Type == OpTypeBlobRef {
+			return nil, fmt.Errorf("%w: a blob", ErrNotCounter)
+		}
+		v, err := decodeCounter(val)
+		if err != nil {
+			return nil, err
+		}
+		sum += v
+		if ik.Type == OpTypePut {
+			break
+		}
+	}
+	return encodeCounter(sum), nil
+}
+
+// mergeChain folds the versions of a key in a compaction, newest first, that
+// start with a delta.
+type mergeChain struct {
+	entries  []mergeChainEntry
+	complete bool // A put or delete ended the chain
+}
+
+type mergeChainEntry struct {
+	key   InternalKey
+	value []byte
+}
+
+// add adds the next older version of the key, unless the chain is complete.
+func (c *mergeChain) add(key InternalKey, value []byte) {
+	if c.complete {
+		return
+	}
+	c.entries = append(c.entries, mergeChainEntry{key, append([]byte(nil), value...)})
+	c.complete = key.Type != OpTypeMerge
+}
+
+// folded returns the entries that replace the chain. If no older version of
+// the key can exist outside the compaction, final is set and the chain becomes
+// a put. A chain with a value that is not a counter is kept as it is, so the
+// reads report it.
+func (c *mergeChain) folded(final bool) []mergeChainEntry {
+	var sum int64
+	for _, e := range c.entries {
+		if e.key.Type == OpTypeDelete {
+			break
+		}
+		v, err := decodeCounter(e.value)
+		if err != nil || e.key.Type == OpTypeBlobRef {
+			return c.entries
+		}
+		sum += v
+	}
+	key := c.entries[0].key
+	if c.complete || final {
+		key.Type = OpTypePut
+	}
+	return []mergeChainEntry{{key, encodeCounter(sum)}}
+}
@@ -0,0 +1,88 @@
+package leveldb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func counterOf(t *testing.T, db *DB, key string) int64 {
+	t.Helper()
+	val, err := db.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("Get(%q) failed: %v", key, err)
+	}
+	if len(val) != 8 {
+		t.Fatalf("Get(%q) returned %d bytes, want a counter", key, len(val))
+	}
+	return int64(binary.LittleEndian.Uint64(val))
+}
+
+func TestIncrement(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	wo := WriteOptions{}
+	db.Put(wo, []byte("base"), encodeCounter(100))
+	db.Put(wo, []byte("text"), []byte("hello"))
+	for range 3 {
+		db.Increment(wo, []byte("base"), 5)
+		db.Increment(wo, []byte("new"), -2)
+	}
+	// Deltas in the memtable on top of a put in a table.
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.Increment(wo, []byte("base"), 1)
+	db.Delete(wo, []byte("new"))
+	db.Increment(wo, []byte("new"), 7)
+	db.Increment(wo, []byte("text"), 1)
+
+	check := func() {
+		t.Helper()
+		if got := counterOf(t, db, "base"); got != 116 {
+			t.Fatalf("Expected base=116, got %d", got)
+		}
+		if got := counterOf(t, db, "new"); got != 7 {
+			t.Fatalf("Expected a delete to reset the counter to 7, got %d", got)
+		}
+		if _, err := db.Get([]byte("text")); !errors.Is(err, ErrNotCounter) {
+			t.Fatalf("Expected ErrNotCounter, got %v", err)
+		}
+	}
+	check()
+	it := db.NewIterator()
+	if it.Seek([]byte("base")); !it.Valid() || int64(binary.LittleEndian.Uint64(it.Value())) != 116 {
+		t.Fatalf("Expected the iterator to add up the deltas")
+	}
+	it.Close()
+
+	// Replayed from the WAL.
+	db.Close()
+	if db, err = NewDB(dir); err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	check()
+
+	// Folded by compaction.
+	db.Increment(wo, []byte("base"), -16)
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if got := counterOf(t, db, "base"); got != 100 {
+		t.Fatalf("Expected base=100 after compaction, got %d", got)
+	}
+	if ik, _, _, _ := db.getEntry(context.Background(), []byte("base"), db.sequenceNum.Load()); ik.Type != OpTypePut {
+		t.Fatalf("Expected compaction to fold the deltas into a put, got type %d", ik.Type)
+	}
+	if _, err := db.Get([]byte("text")); !errors.Is(err, ErrNotCounter) {
+		t.Fatalf("Expected ErrNotCounter after compaction, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package leveldb
+
+import "math"
+
+// CountRange returns the number of live keys in [start, end); a nil end means
+// no upper bound. With exact set it iterates over the range. Otherwise the
+// keys of the memtables are counted exactly and the SSTables are estimated
+// from their properties and indexes, without reading data blocks: the live
+// entries of a table, net of tombstones and obsolete versions, are spread
+// evenly over its data blocks, and the blocks overlapping the range count. A
+// key in a memtable and in a table is counted twice, so the estimate can be
+// off in either direction.
+func (db *DB) CountRange(start, end []byte, exact bool) (uint64, error) {
+	if exact {
+		return db.countRangeExact(start, end)
+	}
+	if db.opts.TimestampSize > 0 {
+		start = escapeTimestampedKey(start)
+		if end != nil {
+			end = escapeTimestampedKey(end)
+		}
+	}
+
+	db.mu.RLock()
+	iters := []Iterator{db.mem.NewIterator()}
+	if db.immutableMem != nil {
+		iters = append(iters, db.immutableMem.NewIterator())
+	}
+	version := db.current
+	version.ref()
+	db.mu.RUnlock()
+	defer version.unref()
+
+	var n uint64
+	mems := NewMergingIterator(iters)
+	for mems.Seek(start); mems.Valid(); mems.Next() {
+		key := mems.Key().UserKey
+		if end != nil && key >= string(end) {
+			break
+		}
+		if !isReservedKey([]byte(key)) {
+			n++
+		}
+	}
+	mems.Close()
+
+	var tables float64
+	for _, num := range version.tables {
+		reader, err := db.findTable(num)
+		if err != nil {
+			return 0, err
+		}
+		estimate, err := estimateKeys(reader, string(start), end)
+		reader.Unref()
+		if err != nil {
+			return 0, err
+		}
+		tables += estimate
+	}
+	return n + uint64(math.Round(tables)), nil
+}
+
+// countRangeExact counts the keys in [start, end) with an iterator.
+func (db *DB) countRangeExact(start, end []byte) (uint64, error) {
+	it := db.NewIterator()
+	defer it.Close()
+	var n uint64
+	for it.Seek(start); it.Valid(); it.Next() {
+		if end != nil && it.Key().UserKey >= string(end) {
+			break
+		}
+		n++
+	}
+	return n, it.Error()
+}
+
+// estimateKeys estimates the live keys of the table in [start, end) from its
+// properties and index. Data blocks inside the range count fully, the blocks
+// at its edges half.
+func estimateKeys(r *SSTableReader, start string, end []byte) (float64, error) {
+	p := r.Properties()
+	live := int64(p.NumEntries) - int64(p.NumObsolete) - 2*int64(p.NumDeletions)
+	if live <= 0 || p.LargestKey < start || (end != nil && p.SmallestKey >= string(end)) {
+		return 0, nil
+	}
+	index, err := r.Index()
+	if err != nil || len(index) == 0 {
+		return 0, err
+	}
+	var blocks float64
+	lower := p.SmallestKey // Smallest key of block i
+	for _, entry := range index {
+		upper := entry.LastKey.UserKey
+		if end != nil && lower >= string(end) {
+			break
+		}
+		if upper >= start {
+			if lower >= start && (end == nil || upper < string(end)) {
+				blocks++
+			} else {
+				blocks += 0.5
+			}
+		}
+		lower = upper
+	}
+	return float64(live) * blocks / float64(len(index)), nil
+}
@@ -0,0 +1,43 @@
+package leveldb
+
+import "testing"
+
+func TestCountRange(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	for i := 0; i < 2000; i++ {
+		db.Put(wo, generateKey(i), generateValue(100))
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 2000; i < 2100; i++ {
+		db.Put(wo, generateKey(i), generateValue(100))
+	}
+	for i := 0; i < 2100; i += 10 {
+		db.Delete(wo, generateKey(i))
+	}
+
+	for _, r := range [][2]int{{0, 2100}, {500, 1500}, {1900, 2050}, {2010, 2020}} {
+		want := uint64(0)
+		for i := r[0]; i < r[1]; i++ {
+			if i%10 != 0 {
+				want++
+			}
+		}
+		start, end := generateKey(r[0]), generateKey(r[1])
+		got, err := db.CountRange(start, end, true)
+		if err != nil || got != want {
+			t.Errorf("Exact CountRange(%d, %d) = %d, %v; want %d", r[0], r[1], got, err, want)
+		}
+		// The tombstones in the memtable hide keys of the table, which the
+		// estimate counts.
+		estimate, err := db.CountRange(start, end, false)
+		if err != nil || float64(estimate) < 0.8*float64(want) || float64(estimate) > 1.3*float64(want) {
+			t.Errorf("Estimated CountRange(%d, %d) = %d, %v; want about %d", r[0], r[1], estimate, err, want)
+		}
+	}
+	if got, err := db.CountRange(generateKey(5000), nil, false); err != nil || got != 0 {
+		t.Errorf("CountRange past the last key = %d, %v", got, err)
+	}
+}
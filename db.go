@@ -1,16 +1,30 @@
-package main
+// Package leveldb is an embeddable key-value store built on a log-structured
+// merge tree, in the style of LevelDB. The command in cmd/goleveldb wraps it.
+package leveldb
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/gofrs/flock"
 	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/huandu/skiplist"
+	"github.com/quangh33/Go-LevelDB/cache"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/memtable"
+	"github.com/quangh33/Go-LevelDB/sstable"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"github.com/quangh33/Go-LevelDB/wal"
+	"io"
 	"log"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // WriteOptions control the behavior of a write operation.
@@ -18,31 +32,16 @@ type WriteOptions struct {
 	// If true, the write will be flushed from the operating system
 	// buffer cache before the write is considered complete.
 	Sync bool
-}
-
-type DBState struct {
-	NextFileNumber int   `json:"next_file_number"`
-	ActiveSSTables []int `json:"active_sstables"`
-}
-
-// saveState serializes the current DB state to a JSON file.
-func (db *DB) saveState() error {
-	state := DBState{
-		NextFileNumber: db.nextFileNumber,
-		ActiveSSTables: db.activeSSTables,
-	}
-
-	data, err := json.MarshalIndent(state, "", "  ")
-	if err != nil {
-		return err
-	}
 
-	statePath := filepath.Join(db.dataDir, "state.json")
-	return os.WriteFile(statePath, data, 0644)
+	// If true, the write skips the WAL and only goes to the memtable. Such
+	// writes are lost on a crash unless Flush is called first, which makes
+	// this suitable for bulk loads and data that can be recomputed.
+	DisableWAL bool
 }
 
 type DB struct {
 	mu           sync.RWMutex
+	writeMu      ctxMutex // Serializes writers so sequence numbers are applied in order
 	wal          *WAL
 	mem          *Memtable
 	immutableMem *Memtable      // hold the memtable data being flushed
@@ -50,76 +49,139 @@ type DB struct {
 
 	dataDir        string
 	nextFileNumber int
-	activeSSTables []int
+	current        *Version // Live SSTables, guarded by mu
+
+	fileMu   sync.Mutex
+	fileRefs map[int]int // Number of Versions listing each SSTable, guarded by fileMu
+	// Wasted seeks each SSTable may still absorb before it is compacted,
+	// guarded by fileMu. Tables get their budget on the first wasted seek.
+	fileSeeks map[int]int
 
 	// Global sequence number for all operations
 	sequenceNum atomic.Uint64
+	inserts     insertTracker // Batches being inserted outside writeMu
 
-	dbLock *flock.Flock
+	dbLock     io.Closer
+	sharedLock io.Closer // READERS lock of a database opened with OpenReadOnly
+	heldTables []int     // Obsolete tables kept for readers, guarded by fileMu
+
+	backupMu sync.Mutex
+	backups  map[*BackupSession]bool // Open backup sessions, guarded by backupMu
+	heldWALs []string                // Flushed WALs kept for backups, guarded by backupMu
 
 	compactionInProgress bool
+	compactionPaused     int // Outstanding PauseBackgroundWork calls, guarded by mu
+	seekCompactFile      int // Table that ran out of allowed seeks or 0, guarded by mu
+
+	closed      atomic.Bool             // Set by Close, after which writes fail with ErrClosed
+	readOnly    atomic.Pointer[error]   // Set by a failed fsync, wraps ErrReadOnly
+	noSpace     atomic.Pointer[noSpace] // Set while the disk is full
+	closeCtx    context.Context         // Canceled by Close to abandon running compactions
+	cancelClose context.CancelFunc
+
+	flushCond *sync.Cond // Signaled on mu when a background flush or compaction finishes
+	bgErr     error      // Error of the last failed flush, guarded by mu
+
+	failedFlush func() // Job of the flush that failed to write its tables, guarded by mu
+
+	liveTableBytes int64 // Size of the tables of current, guarded by mu
 
 	tableCache *lru.Cache[int, *SSTableReader]
-	blockCache *lru.Cache[string, []byte]
+	blockCache *cache.Cache // Data, index and filter blocks of all tables
+
+	indexes []*secondaryIndex // Secondary indexes, guarded by writeMu
+
+	asyncGets asyncGets      // Worker pool of GetAsync
+	jobs      backgroundJobs // Worker pool of flushes and compactions
+	bulk      *bulkLoad      // Bulk load in progress, guarded by writeMu
+	locks     keyLocks       // Key locks of pessimistic transactions
+	tracer    *tracer        // Records operations to Options.TraceFile, or nil
+
+	ioMu    sync.Mutex
+	ioStats map[string]*base.FileIO // Per-file I/O statistics by file name, guarded by ioMu
+
+	opts *Options
+
+	hasBlobs bool // Whether blob chunks may exist, guarded by mu
+	// denseTables caches whether a live table triggers a tombstone
+	// compaction, guarded by mu.
+	denseTables map[int]bool
+	quarantined map[int]bool           // Tables moved to lost/, guarded by mu
+	prepared    map[string]*WriteBatch // Prepared batches by id, guarded by writeMu
+
+	logNumber int // Rotated WALs numbered below this are flushed, guarded by mu
+
+	// The memtable size that triggers a flush, adapted between
+	// Options.MinMemtableSize and MaxMemtableSize.
+	memtableLimit   atomic.Int64
+	memtableStart   time.Time // When the current memtable started filling, guarded by mu
+	flushBacklogged bool      // Whether the memtable filled during the running flush, guarded by mu
+
+	pressureMu sync.Mutex
+	pressured  bool // Last state reported to Options.OnCompactionPressure, guarded by pressureMu
+
+	stats    dbStats       // Cumulative flush and compaction counters, guarded by mu
+	amp      ampCounters   // Bytes behind the amplification factors
+	ioTotals base.IOTotals // WAL writes, table reads and filter checks of all files
+
+	filterBits atomic.Int64 // Bits per key last chosen by Options.AdaptiveFilter
+
+	recoveryWarnings []RecoveryWarning // Found by Open, never modified
 }
 
-// NewDB creates or opens a database at the specified path.
-// It first replays all WALs to recover the state
+// NewDB creates or opens a database at the specified path with default options.
 func NewDB(dir string) (*DB, error) {
+	return Open(dir, nil)
+}
+
+// Open creates or opens a database at the specified path.
+// It first replays all WALs to recover the state
+func Open(dir string, opts *Options) (*DB, error) {
+	opts = sanitizeOptions(opts)
+	syncFS := &syncPolicyFS{FS: opts.FS, opts: opts}
+	opts.FS = syncFS
 	// First, replay WAL to recover the state
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	fs := opts.FS
+	if err := fs.MkdirAll(dir); err != nil {
 		return nil, err
 	}
 
 	lockPath := filepath.Join(dir, "LOCK")
-	dbLock := flock.New(lockPath)
-	locked, err := dbLock.TryLock()
+	dbLock, err := fs.Lock(lockPath)
+	if errors.Is(err, ErrDBLocked) {
+		return nil, lockedError(fs, dir)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to acquire database lock: %w", err)
 	}
-	if !locked {
-		return nil, fmt.Errorf("database is locked by another process")
-	}
+	writeLockOwner(fs, lockPath, opts.Now())
 
-	// tableCache caches the SSTableReader
-	tableCache, err := lru.NewWithEvict[int, *SSTableReader](TableCacheSize, func(key int, value *SSTableReader) {
-		// When a reader is evicted from the cache, close its file handle.
-		value.Close()
-	})
+	state, err := loadState(fs, dir)
 	if err != nil {
-		dbLock.Unlock()
-		return nil, fmt.Errorf("failed to create table cache: %w", err)
+		dbLock.Close()
+		return nil, err
 	}
-
-	// blockCache caches the actual data block of SSTable
-	blockCache, err := lru.New[string, []byte](BlockCacheSize / DataBlockSize)
-	if err != nil {
-		dbLock.Unlock()
-		return nil, fmt.Errorf("failed to create block cache: %w", err)
+	if err := checkStateFiles(fs, dir, &state); err != nil {
+		dbLock.Close()
+		return nil, err
 	}
-
-	statePath := filepath.Join(dir, "state.json")
-	var state DBState
-
-	data, err := os.ReadFile(statePath)
+	orphans, err := orphanTables(fs, dir, state)
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Println("State file not found, initializing with default state.")
-			state = DBState{NextFileNumber: 1, ActiveSSTables: []int{}}
-		} else {
-			dbLock.Unlock()
-			return nil, err
-		}
-	} else {
-		if err := json.Unmarshal(data, &state); err != nil {
-			dbLock.Unlock()
+		dbLock.Close()
+		return nil, err
+	}
+	if opts.ParanoidChecks {
+		if err := paranoidChecks(fs, dir, state); err != nil {
+			dbLock.Close()
 			return nil, err
 		}
-		log.Printf("Loaded state: NextFileNumber is %d, ActiveSSTables: %v", state.NextFileNumber, state.ActiveSSTables)
 	}
 
-	mem := NewMemtable()
-	var maxSeqNum uint64 = 0
+	mem := memtable.New(opts.MemtableShards)
+	maxSeqNum := state.LastSequence
+	for _, seqs := range state.TableSeqs {
+		maxSeqNum = max(maxSeqNum, seqs.Last)
+	}
 
 	// List all WAL files and sort them in order so that we replay in the order they were created.
 	// Imagine this situation:
@@ -129,74 +191,307 @@ func NewDB(dir string) (*DB, error) {
 	//   - a new db.wal is created
 	//   - the full memtable is moved to immutableMem
 	//   - lock is released
-	walFiles, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
-	sort.Strings(walFiles)
+	walFiles, _ := vfs.Glob(fs, dir, "wal-*.log")
 	activeWal := filepath.Join(dir, "db.wal")
 	walFiles = append(walFiles, activeWal)
+	if state.TimestampSize != opts.TimestampSize {
+		hasData := len(state.ActiveSSTables) > 0 || state.LastSequence > 0
+		for _, walPath := range walFiles {
+			hasData = hasData || fileSize(opts.FS, walPath) > int64(wal.HeaderSize)
+		}
+		if hasData {
+			dbLock.Close()
+			return nil, fmt.Errorf("database was written with a timestamp size of %d, not %d", state.TimestampSize, opts.TimestampSize)
+		}
+	}
+
+	// Recovered entries are applied in sequence order. Whenever the rebuilt
+	// memtable outgrows its initial size limit it is written out as an SSTable,
+	// so a crash under heavy write load does not reopen with a huge memtable.
+	tables := append([]int(nil), state.ActiveSSTables...)
+	recoveredSeqs := make(map[int]SeqRange)
+	nextFileNumber := state.NextFileNumber
+	flushRecovered := func() error {
+		data := mem.Sorted()
+		nums, seqs, err := writeMemtableSSTables(dir, data, opts, func() int {
+			nextFileNumber++
+			return nextFileNumber - 1
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write recovered SSTables: %w", err)
+		}
+		log.Printf("Recovery: flushed %d entries to SSTables %v", data.Len(), nums)
+		tables = append(tables, nums...)
+		maps.Copy(recoveredSeqs, seqs)
+		mem = memtable.New(opts.MemtableShards)
+		return nil
+	}
 
+	var sequences sequenceChecker
+	prepared := make(map[string]*WriteBatch)
 	for _, walPath := range walFiles {
-		if _, err := os.Stat(walPath); os.IsNotExist(err) {
+		if _, err := fs.Stat(walPath); os.IsNotExist(err) {
 			continue
 		}
-		recoveredData, lastSeq, err := Replay(walPath)
+		if num, ok := walFileNumber(walPath); ok && num < state.LogNumber {
+			// The flush of this log finished, only its deletion did not.
+			log.Printf("Recovery: removing already flushed WAL %s", walPath)
+			if err := retireWAL(walPath, opts); err != nil {
+				log.Printf("ERROR: Failed to delete flushed WAL %s: %v", walPath, err)
+			}
+			continue
+		}
+		recoveredData, lastSeq, ranges, err := replayWAL(fs, walPath, prepared)
 		if err != nil {
-			dbLock.Unlock()
+			dbLock.Close()
 			return nil, fmt.Errorf("failed to replay WAL %s: %w", walPath, err)
 		}
+		sequences.check(walPath, ranges)
+		if opts.ParanoidChecks {
+			if err := checkWALChain(sequences.warnings); err != nil {
+				dbLock.Close()
+				return nil, err
+			}
+		}
 		if lastSeq > maxSeqNum {
 			maxSeqNum = lastSeq
 		}
-		for key, value := range recoveredData {
-			mem.Put(key, value.Value)
+		keys := make([]InternalKey, 0, len(recoveredData))
+		for key := range recoveredData {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].SeqNum < keys[j].SeqNum })
+		for _, key := range keys {
+			mem.Put(key, recoveredData[key].Value)
+			if mem.ApproximateSize() > opts.initialMemtableSize() {
+				if err := flushRecovered(); err != nil {
+					dbLock.Close()
+					return nil, err
+				}
+			}
+		}
+	}
+	// Once part of the logs lives in SSTables, flush the rest as well so the
+	// replayed WALs can be deleted and are not applied a second time. The
+	// active log is renamed like a rotated one so that LogNumber covers it.
+	recoveredToTables := len(tables) > len(state.ActiveSSTables)
+	logNumber := state.LogNumber
+	if recoveredToTables {
+		if mem.Len() > 0 {
+			if err := flushRecovered(); err != nil {
+				dbLock.Close()
+				return nil, err
+			}
+		}
+		if _, err := fs.Stat(activeWal); err == nil {
+			rotated := rotatedWALPath(dir, nextFileNumber)
+			if err := fs.Rename(activeWal, rotated); err != nil {
+				dbLock.Close()
+				return nil, fmt.Errorf("failed to rename WAL: %w", err)
+			}
+			walFiles[len(walFiles)-1] = rotated
+			nextFileNumber++
 		}
+		logNumber = nextFileNumber
 	}
 	log.Printf("Recovery complete. Highest sequence number is %d", maxSeqNum)
 
-	wal, err := NewWAL(activeWal)
+	db, err := newDB(dir, opts)
 	if err != nil {
-		dbLock.Unlock()
+		dbLock.Close()
 		return nil, err
 	}
+	db.mem = mem
+	db.nextFileNumber = nextFileNumber
+	db.prepared = prepared
+	db.dbLock = dbLock
+	db.hasBlobs = state.HasBlobs
+	db.logNumber = logNumber
+	db.recoveryWarnings = sequences.warnings
+	syncFS.db.Store(db)
+	db.sequenceNum.Store(maxSeqNum)
+	legacySeqs := db.readTableSeqs(state.ActiveSSTables, maxSeqNum, state.TableSeqs)
+	db.current = db.newVersion(tables, state.TableSeqs, recoveredSeqs, legacySeqs)
+	db.liveTableBytes = db.tableBytes(tables)
+	if err := db.saveState(); err != nil && recoveredToTables {
+		dbLock.Close()
+		return nil, fmt.Errorf("failed to save state after recovery: %w", err)
+	}
+	if recoveredToTables {
+		for _, walPath := range walFiles {
+			if err := retireWAL(walPath, opts); err != nil && !os.IsNotExist(err) {
+				log.Printf("ERROR: Failed to delete recovered WAL %s: %v", walPath, err)
+			}
+		}
+	}
 
-	db := &DB{
-		wal:            wal,
-		mem:            mem,
-		dataDir:        dir,
-		nextFileNumber: state.NextFileNumber,
-		activeSSTables: state.ActiveSSTables,
-		dbLock:         dbLock,
-		tableCache:     tableCache,
-		blockCache:     blockCache,
+	if db.wal, err = db.openWAL(activeWal); err != nil {
+		dbLock.Close()
+		return nil, err
+	}
+	if recoveredToTables {
+		if err := relogPrepared(db.wal, prepared); err != nil {
+			db.wal.Close()
+			dbLock.Close()
+			return nil, fmt.Errorf("failed to log prepared transactions: %w", err)
+		}
+	}
+	if opts.TraceFile != "" {
+		if db.tracer, err = newTracer(opts.TraceFile); err != nil {
+			db.wal.Close()
+			dbLock.Close()
+			return nil, err
+		}
+	}
+	if wbm := opts.WriteBufferManager; wbm != nil {
+		wbm.register(db.blockCache)
+		wbm.reserve(int64(mem.ApproximateSize()))
+	}
+	// Orphaned tables go the way of obsolete ones, as readers may still use them.
+	db.heldTables = orphans
+	db.deleteObsoleteTables(nil)
+	if db.hasBlobs {
+		if err := db.collectOrphanBlobs(); err != nil {
+			log.Printf("ERROR: Failed to delete incomplete blobs: %v", err)
+		}
 	}
-	db.sequenceNum.Store(maxSeqNum)
-	db.saveState()
 
 	return db, nil
 }
 
-// findTable is a helper to get an SSTableReader, using the cache.
+// newDB returns a DB for dir with its caches set up, and no tables or
+// memtable yet.
+func newDB(dir string, opts *Options) (*DB, error) {
+	db := &DB{
+		writeMu:     newCtxMutex(),
+		dataDir:     dir,
+		fileRefs:    make(map[int]int),
+		fileSeeks:   make(map[int]int),
+		denseTables: make(map[int]bool),
+		quarantined: make(map[int]bool),
+		ioStats:     make(map[string]*base.FileIO),
+		opts:        opts,
+	}
+	// tableCache caches the SSTableReader, holding one open file per entry
+	tableCacheSize := max(opts.MaxOpenFiles-NumNonTableFiles, 1)
+	tableCache, err := lru.NewWithEvict[int, *SSTableReader](tableCacheSize, func(key int, value *SSTableReader) {
+		// When a reader is evicted from the cache, close its file handle once
+		// no iterator uses it anymore.
+		value.Unref()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table cache: %w", err)
+	}
+	db.tableCache = tableCache
+	// blockCache caches the data blocks of SSTables together with their
+	// decoded index and filter blocks, under one budget in bytes.
+	db.blockCache = cache.New(int64(opts.BlockCacheSize), int64(opts.MetaCacheSize))
+	db.memtableLimit.Store(int64(opts.initialMemtableSize()))
+	db.memtableStart = opts.Now()
+	db.flushCond = sync.NewCond(&db.mu)
+	db.inserts.cond = sync.NewCond(&db.inserts.mu)
+	db.locks.owners = make(map[string]*PessimisticTxn)
+	db.locks.released = make(chan struct{})
+	db.closeCtx, db.cancelClose = context.WithCancel(context.Background())
+	return db, nil
+}
+
+// writeMemtableSSTables writes the sorted contents of a memtable to tables of
+// about Options.TargetFileSize bytes in dir, numbered by newTable, and returns
+// their numbers with the sequence numbers each holds. The tables are written
+// and synced under a temporary name first, so a crash never leaves a truncated
+// table under its final name.
+func writeMemtableSSTables(dir string, data *skiplist.SkipList, opts *Options, newTable func() int) ([]int, map[int]SeqRange, error) {
+	var nums []int
+	out := &tableSplitter{opts: opts, newPath: func() string {
+		nums = append(nums, newTable())
+		return filepath.Join(dir, fmt.Sprintf("%05d.sst.tmp", nums[len(nums)-1]))
+	}}
+	for elem := data.Front(); elem != nil; elem = elem.Next() {
+		if err := out.add(elem.Key().(InternalKey), elem.Value.([]byte)); err != nil {
+			out.abort()
+			return nil, nil, err
+		}
+	}
+	tmpPaths, err := out.finish()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, tmpPath := range tmpPaths {
+		if err := opts.FS.Rename(tmpPath, strings.TrimSuffix(tmpPath, ".tmp")); err != nil {
+			for _, path := range tmpPaths[i:] {
+				opts.FS.Remove(path)
+			}
+			for _, path := range tmpPaths[:i] {
+				opts.FS.Remove(strings.TrimSuffix(path, ".tmp"))
+			}
+			return nil, nil, err
+		}
+	}
+	seqs := make(map[int]SeqRange, len(nums))
+	for i, num := range nums {
+		seqs[num] = out.seqs[i]
+	}
+	return nums, seqs, opts.FS.SyncDir(dir)
+}
+
+// tablePath returns the path of SSTable num in dir.
+func tablePath(dir string, num int) string {
+	return filepath.Join(dir, fmt.Sprintf("%05d.sst", num))
+}
+
+// rotatedWALPath returns the path that the active WAL is renamed to when it is
+// rotated, as the log of the memtable flushed to SSTable num.
+func rotatedWALPath(dir string, num int) string {
+	return filepath.Join(dir, fmt.Sprintf("wal-%05d.log", num))
+}
+
+// walFileNumber returns the number of a rotated WAL named wal-NNNNN.log. The
+// active db.wal has none.
+func walFileNumber(path string) (int, bool) {
+	var num int
+	if _, err := fmt.Sscanf(filepath.Base(path), "wal-%d.log", &num); err != nil {
+		return 0, false
+	}
+	return num, true
+}
+
+// findTable is a helper to get an SSTableReader, using the cache. The returned
+// reader carries a reference that the caller must release with unref, so it
+// stays open even if the cache evicts it in the meantime.
 func (db *DB) findTable(sstNum int) (*SSTableReader, error) {
-	if reader, ok := db.tableCache.Get(sstNum); ok {
+	if reader, ok := db.tableCache.Get(sstNum); ok && reader.TryRef() {
 		return reader, nil
 	}
 
 	// Cache miss: Open the file and create a new reader.
-	sstablePath := fmt.Sprintf("%s/%05d.sst", db.dataDir, sstNum)
-	reader, err := NewSSTableReader(sstablePath, db.blockCache)
+	sstablePath := tablePath(db.dataDir, sstNum)
+	reader, err := sstable.Open(db.opts.FS, sstablePath, sstable.ReaderOptions{Cache: db.blockCache, IO: db.tableIO(sstNum)})
 	if err != nil {
 		return nil, err
 	}
 
-	// Add the new reader to the cache.
-	db.tableCache.Add(sstNum, reader)
+	// Add the new reader to the cache, which owns the reader's initial reference.
+	// If another goroutine cached the same table first, use that reader instead.
+	if previous, ok, _ := db.tableCache.PeekOrAdd(sstNum, reader); ok && previous.TryRef() {
+		reader.Unref()
+		return previous, nil
+	}
+	reader.Ref()
 	return reader, nil
 }
 
 func (db *DB) flushMemtable() {
 	// Prevent other operations while we flush
 	log.Println("Memtable is full, starting flush...")
+	db.waitForInserts()
 	db.mu.Lock()
 	if db.immutableMem != nil {
+		// The memtable filled up before the previous flush finished.
+		if !db.flushBacklogged {
+			db.flushBacklogged = true
+			db.adjustMemtableLimitLocked(2, 1)
+		}
 		db.mu.Unlock()
 		return
 	}
@@ -204,213 +499,595 @@ func (db *DB) flushMemtable() {
 	// WAL rotation
 	sstNum := db.nextFileNumber
 	db.nextFileNumber++
-	walPath := db.wal.file.Name()
-	rotatedWalPath := fmt.Sprintf("%s/wal-%05d.log", db.dataDir, sstNum)
+	walPath := db.wal.Name()
+	rotatedWalPath := rotatedWALPath(db.dataDir, sstNum)
 	db.wal.Close()
-	if err := os.Rename(walPath, rotatedWalPath); err != nil {
+	if err := db.opts.FS.Rename(walPath, rotatedWalPath); err != nil {
 		log.Printf("CRITICAL ERROR: Failed to rename WAL: %v", err)
+		db.bgErr = fmt.Errorf("failed to rename WAL: %w", db.setNoSpace(err))
+		// The memtable stays, so writes go on to the same log.
+		if w, err := db.openWAL(walPath); err == nil {
+			db.wal = w
+		}
 		db.mu.Unlock()
 		return
 	}
+	db.renameFileIO(walPath, rotatedWalPath)
+	db.renameBackupFiles(walPath, rotatedWalPath)
 
-	newWal, err := NewWAL(walPath)
+	newWal, err := db.openWAL(walPath)
 	if err != nil {
 		log.Printf("CRITICAL ERROR: Failed to open new WAL: %v", err)
+		db.bgErr = fmt.Errorf("failed to open new WAL: %w", db.setNoSpace(err))
+		// The rotated log, which may have half a header in its place, goes
+		// back to being the active one.
+		if err := db.opts.FS.Rename(rotatedWalPath, walPath); err == nil {
+			db.renameFileIO(rotatedWalPath, walPath)
+			db.renameBackupFiles(rotatedWalPath, walPath)
+			if w, err := db.openWAL(walPath); err == nil {
+				db.wal = w
+			}
+		}
 		db.mu.Unlock()
 		return
 	}
 	db.wal = newWal
+	if err := relogPrepared(newWal, db.prepared); err != nil {
+		log.Printf("CRITICAL ERROR: Failed to log prepared transactions: %v", err)
+		db.bgErr = fmt.Errorf("failed to log prepared transactions: %w", db.setNoSpace(err))
+	}
 	db.immutableMem = db.mem
-	db.mem = NewMemtable()
+	db.mem = memtable.New(db.opts.MemtableShards)
+	fillTime := db.opts.Now().Sub(db.memtableStart)
+	db.memtableStart = db.opts.Now()
+	db.flushBacklogged = false
+	imm, walToDelete := db.immutableMem, rotatedWalPath
 	db.wg.Add(1)
 	db.mu.Unlock()
 
-	go func(imm *Memtable, walToDelete string, sstNum int) {
+	var flush func()
+	flush = func() {
 		log.Printf("Background flush: Starting to write SSTable %d...", sstNum)
 		defer db.wg.Done()
-		sstablePath := fmt.Sprintf("%s/%05d.sst", db.dataDir, sstNum)
-
-		itemCount := imm.data.Len()
-		if err := WriteSSTable(sstablePath, uint(itemCount), imm.data.Front()); err != nil {
+		start := db.opts.Now()
+		data := imm.Sorted()
+		// The first table takes the number of the rotated WAL.
+		next := sstNum
+		nums, seqs, err := writeMemtableSSTables(db.dataDir, data, db.tableOptions(), func() int {
+			if num := next; num >= 0 {
+				next = -1
+				return num
+			}
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			db.nextFileNumber++
+			return db.nextFileNumber - 1
+		})
+		if err != nil {
 			log.Printf("ERROR: Failed to write SSTable: %v", err)
+			db.mu.Lock()
+			db.bgErr = fmt.Errorf("failed to write SSTable %d: %w", sstNum, db.setNoSpace(err))
+			db.failedFlush = flush // The tables were removed, a retry writes them again
+			db.flushCond.Broadcast()
+			db.mu.Unlock()
 			return
 		}
 
-		log.Printf("Successfully flushed memtable to %s", sstablePath)
+		log.Printf("Successfully flushed memtable to SSTables %v", nums)
 
 		db.mu.Lock()
 		defer db.mu.Unlock()
+		defer db.flushCond.Broadcast()
+		db.stats.flushes++
+		for _, num := range nums {
+			size := fileSize(db.opts.FS, tablePath(db.dataDir, num))
+			db.stats.flushBytes += size
+			db.tableWritten(num, size, false)
+		}
+		db.stats.flushTime += db.opts.Now().Sub(start)
 		db.immutableMem = nil
-		db.activeSSTables = append(db.activeSSTables, sstNum)
-		sort.Ints(db.activeSSTables)
-		if err := db.saveState(); err != nil {
+		if wbm := db.opts.WriteBufferManager; wbm != nil {
+			wbm.free(int64(imm.ApproximateSize()))
+		}
+		// A full memtable flushed much faster than it was filled needs less room.
+		if !db.flushBacklogged && imm.ApproximateSize() >= int(db.memtableLimit.Load()) && 4*db.opts.Now().Sub(start) < fillTime {
+			db.adjustMemtableLimitLocked(1, 2)
+		}
+		// Tables are kept oldest first; the flushed memtable is newer than all.
+		tables := append(append([]int(nil), db.current.tables...), nums...)
+		prevLogNumber := db.logNumber
+		db.logNumber = sstNum + 1 // The rotated WAL is now redundant
+		if err := db.installVersionLocked(tables, seqs); err != nil {
+			db.logNumber = prevLogNumber
 			log.Printf("CRITICAL ERROR: Failed to save state file: %v", err)
+			db.bgErr = fmt.Errorf("failed to save state file: %w", db.setNoSpace(err))
 			return
 		}
+		db.bgErr = nil
 
 		log.Println("Truncating WAL file...")
-		if err := os.Remove(walToDelete); err != nil {
+		if db.holdWAL(walToDelete) {
+			log.Printf("Background flush: Keeping old WAL %s for a backup", walToDelete)
+		} else if err := retireWAL(walToDelete, db.opts); err != nil {
 			log.Printf("ERROR: Failed to delete rotated WAL %s: %v", walToDelete, err)
 		} else {
+			db.dropFileIO(walToDelete)
 			log.Printf("Background flush: Deleted old WAL %s", walToDelete)
 		}
 
-		if len(db.activeSSTables) >= SSTableCountThreshold && !db.compactionInProgress {
-			db.compactionInProgress = true
-			db.wg.Add(1)
-			go db.compact()
-		}
-	}(db.immutableMem, rotatedWalPath, sstNum)
+		db.maybeScheduleCompactionLocked()
+	}
+	db.schedule(flush, true)
+}
+
+// adjustMemtableLimitLocked scales the memtable size limit by num/den within
+// the configured range. The caller must hold db.mu.
+func (db *DB) adjustMemtableLimitLocked(num, den int64) {
+	limit := db.memtableLimit.Load() * num / den
+	limit = min(max(limit, int64(db.opts.MinMemtableSize)), int64(db.opts.MaxMemtableSize))
+	if old := db.memtableLimit.Swap(limit); old != limit {
+		log.Printf("Memtable size limit changed from %d to %d bytes", old, limit)
+	}
+}
+
+// Flush writes the current memtable to an SSTable and waits until it is
+// installed. Writes made with WriteOptions.DisableWAL are durable once Flush
+// returns.
+func (db *DB) Flush() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	return db.flushLocked()
+}
+
+// flushLocked flushes a non-empty memtable and waits for it to be installed.
+// The caller must hold db.writeMu.
+func (db *DB) flushLocked() error {
+	if err := db.readOnlyErr(); err != nil {
+		return err
+	}
+	if err := db.checkSpaceLocked(true); err != nil {
+		return err
+	}
+	db.waitForInserts()
+	if err := db.waitForFlush(); err != nil {
+		return err
+	}
+	db.mu.RLock()
+	empty := db.mem.Len() == 0
+	db.mu.RUnlock()
+	if empty {
+		return nil
+	}
+	db.flushMemtable()
+	return db.waitForFlush()
+}
+
+// waitForFlush blocks until no background memtable flush is in progress and
+// returns the error of the last flush, if it failed.
+func (db *DB) waitForFlush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for db.immutableMem != nil && db.bgErr == nil {
+		db.waitForJobLocked()
+	}
+	return db.bgErr
 }
 
 // Put adds or updates a key-value pair in the database.
 func (db *DB) Put(wo WriteOptions, key, value []byte) error {
-	seqNum := db.sequenceNum.Add(1)
-	internalKey := InternalKey{
-		UserKey: string(key),
-		SeqNum:  seqNum,
-		Type:    OpTypePut,
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return db.Write(wo, batch)
+}
+
+// Write applies all updates in the batch atomically. The batch is logged as a
+// single WAL record and its updates receive consecutive sequence numbers.
+func (db *DB) Write(wo WriteOptions, batch *WriteBatch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	if err := db.checkBatch(batch); err != nil {
+		return batch.finish(SeqRange{}, err)
+	}
+	return db.applyBatch(context.Background(), wo, batch, true)
+}
+
+// writeInternal applies a batch without the checks on user keys, so it may
+// also write to the reserved keyspaces. It waits for the keys of the batch
+// locked by pessimistic transactions, and runs the hooks of the batch.
+func (db *DB) writeInternal(wo WriteOptions, batch *WriteBatch) error {
+	return db.applyBatch(context.Background(), wo, batch, false)
+}
+
+// applyBatch writes the batch and runs its hooks, giving up with ctx.Err() if
+// ctx is done before the batch gets its turn. A traced batch is recorded under
+// db.writeMu once it is applied, so the trace lists writes in the order of
+// their sequence numbers and leaves out failed ones.
+func (db *DB) applyBatch(ctx context.Context, wo WriteOptions, batch *WriteBatch, trace bool) error {
+	if err := db.lockWriteMu(ctx, batch, nil); err != nil {
+		return batch.finish(SeqRange{}, err)
+	}
+	if err := ctx.Err(); err != nil {
+		db.writeMu.Unlock()
+		return batch.finish(SeqRange{}, err)
+	}
+	pending, err := db.writeLocked(wo, batch)
+	if err == nil && trace {
+		db.traceWrite(wo, batch)
+	}
+	db.writeMu.Unlock()
+	if err == nil {
+		err = pending.wait()
+	}
+	return batch.finish(pending.seqs, err)
+}
+
+// writeLocked applies the batch. The caller must hold db.writeMu. The returned
+// pendingWrite must be waited on, after releasing db.writeMu unless the caller
+// keeps holding it, before the write is acknowledged: it inserts the batch
+// into a sharded memtable and waits for a periodic WAL sync.
+func (db *DB) writeLocked(wo WriteOptions, batch *WriteBatch) (pendingWrite, error) {
+	if db.closed.Load() {
+		return pendingWrite{}, ErrClosed
+	}
+	if err := db.readOnlyErr(); err != nil {
+		return pendingWrite{}, err
+	}
+	if err := db.checkSpaceLocked(false); err != nil {
+		return pendingWrite{}, err
+	}
+	if err := db.checkQuota(batch); err != nil {
+		return pendingWrite{}, err
+	}
+	var userBytes int
+	for _, e := range batch.entries {
+		userBytes += len(e.key) + len(e.value)
+	}
+	db.amp.userBytesWritten.Add(int64(userBytes))
+	if db.bulk != nil {
+		first := db.sequenceNum.Load() + 1
+		seqs := SeqRange{first, first + uint64(batch.Len()) - 1}
+		return pendingWrite{seqs: seqs}, db.bulkWriteLocked(batch, first)
+	}
+	xid := batch.xid
+	batch = db.withTimestamps(batch)
+	batch, err := db.withBlobCleanup(batch)
+	if err != nil {
+		return pendingWrite{}, err
 	}
-	entry := &LogEntry{
-		Op:     OpPut,
-		Key:    key,
-		Value:  value,
-		SeqNum: seqNum,
+	if batch, err = db.withIndexUpdates(batch); err != nil {
+		return pendingWrite{}, err
 	}
+	batch.xid = xid // The rewritten batch commits the same prepared batch
 
 	db.mu.RLock()
 	wal := db.wal
-	memtable := db.mem
+	mem := db.mem
 	db.mu.RUnlock()
+	concurrent := mem.Shards() > 1
+	if concurrent && db.maybeFlushLocked(mem) {
+		// The batch goes into the new memtable instead.
+		db.mu.RLock()
+		wal = db.wal
+		mem = db.mem
+		db.mu.RUnlock()
+	}
+	firstSeq := db.sequenceNum.Load() + 1
+	if concurrent {
+		firstSeq = db.beginInsert(batch.Len())
+	}
+	lastSeq := firstSeq + uint64(batch.Len()) - 1
 
-	if err := wal.Write(entry, wo.Sync); err != nil {
-		return err
+	pending := pendingWrite{seqs: SeqRange{firstSeq, lastSeq}}
+	if !wo.DisableWAL {
+		if pending.sync, err = wal.Append(batch.logEntry(firstSeq), wo.Sync); err != nil {
+			// The record may be in the log all the same, if only its sync
+			// failed, so its sequence numbers are never handed out again.
+			if concurrent {
+				db.finishInsert(firstSeq, lastSeq)
+			} else {
+				db.sequenceNum.Store(lastSeq)
+			}
+			return pendingWrite{}, db.setNoSpace(err)
+		}
 	}
 
-	memtable.Put(internalKey, value)
+	if concurrent {
+		pending.insert = func() {
+			sizeBefore := mem.ApproximateSize()
+			insertBatch(mem, batch, firstSeq)
+			if wbm := db.opts.WriteBufferManager; wbm != nil {
+				wbm.reserve(int64(mem.ApproximateSize() - sizeBefore))
+			}
+			db.finishInsert(firstSeq, lastSeq)
+		}
+		return pending, nil
+	}
+	sizeBefore := mem.ApproximateSize()
+	insertBatch(mem, batch, firstSeq)
+	// Publish the new sequence number only once every update is in the memtable.
+	db.sequenceNum.Store(lastSeq)
+	if wbm := db.opts.WriteBufferManager; wbm != nil {
+		wbm.reserve(int64(mem.ApproximateSize() - sizeBefore))
+	}
+	db.maybeFlushLocked(mem)
+	return pending, nil
+}
 
-	if memtable.ApproximateSize() > MemtableSizeThreshold {
+// maybeFlushLocked starts a flush of mem if it is over its size limit or
+// the WriteBufferManager asks for one, and reports whether it did. The caller
+// must hold db.writeMu.
+func (db *DB) maybeFlushLocked(mem *Memtable) bool {
+	size := mem.ApproximateSize()
+	if wbm := db.opts.WriteBufferManager; wbm != nil {
+		if size >= MinWriteBufferFlushSize && wbm.shouldFlush() {
+			db.mu.RLock()
+			flushing := db.immutableMem != nil
+			db.mu.RUnlock()
+			if !flushing {
+				db.flushMemtable()
+				return true
+			}
+		}
+	}
+	if size > int(db.memtableLimit.Load()) {
 		db.flushMemtable()
+		return true
 	}
-	return nil
+	return false
 }
 
-// Get retrieves a value by key.
-func (db *DB) Get(key []byte) ([]byte, bool) {
+// Get retrieves a value by key. It returns ErrNotFound if the key does not
+// exist, and any error reading the SSTables otherwise.
+func (db *DB) Get(key []byte) ([]byte, error) {
+	return db.GetAt(db.sequenceNum.Load(), key)
+}
+
+// GetAt retrieves the value key had as of sequence number seq, ignoring every
+// write with a higher sequence number. Versions that compaction has already
+// discarded can no longer be observed.
+func (db *DB) GetAt(seq uint64, key []byte) ([]byte, error) {
+	return db.get(context.Background(), key, seq)
+}
+
+// get is Get for the context-aware and versioned variants.
+func (db *DB) get(ctx context.Context, key []byte, seq uint64) ([]byte, error) {
+	db.traceGet(key)
+	val, found, err := db.lookup(ctx, key, seq)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	db.amp.userBytesRead.Add(int64(len(key) + len(val)))
+	return val, nil
+}
+
+// lookup returns the value of key as of seq, resolving blob references. Unlike
+// Get it is neither traced nor counted as a user read, so the database can use
+// it for its own reads.
+func (db *DB) lookup(ctx context.Context, key []byte, seq uint64) ([]byte, bool, error) {
+	ik, val, found, err := db.getEntry(ctx, key, seq)
+	if err != nil || !found || ik.Type == OpTypeDelete {
+		return nil, false, err
+	}
+	val, err = db.resolveValue(seq, ik, val)
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// getEntry returns the newest entry for key with a sequence number at most seq,
+// including tombstones. The search is abandoned once ctx is done.
+func (db *DB) getEntry(ctx context.Context, key []byte, seq uint64) (InternalKey, []byte, bool, error) {
+	if size := db.opts.TimestampSize; size > 0 {
+		if len(key) < size {
+			return InternalKey{}, nil, false, ErrTimestampSize
+		}
+		key = encodeTimestampedKey(key, size)
+	}
 	db.mu.RLock()
 	mem := db.mem
 	imm := db.immutableMem
-	activeTables := db.activeSSTables
+	version := db.current
+	version.ref()
 	db.mu.RUnlock()
+	defer version.unref()
+	activeTables := version.tables
 
 	// 1. Check in active memtable
-	val, found := mem.Get(key)
-	if found {
-		if val == nil {
-			// Found a delete tombstone
-			return nil, false
-		}
-		return val, true
+	if ik, val, found := mem.GetEntry(key, seq); found {
+		return ik, val, true, nil
 	}
 
 	// 2. Check in immutable memtable
 	if imm != nil {
-		val, found = imm.Get(key)
-		if found {
-			if val == nil {
-				// Found a delete tombstone
-				return nil, false
-			}
-			return val, true
+		if ik, val, found := imm.GetEntry(key, seq); found {
+			return ik, val, true, nil
 		}
 	}
 
-	// 3. Search key in newest to oldest SSTables
+	// 3. Search key in newest to oldest SSTables, stopping at the first match
 	for i := len(activeTables) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return InternalKey{}, nil, false, err
+		}
 		sstNum := activeTables[i]
-		sstablePath := fmt.Sprintf("%s/%05d.sst", db.dataDir, sstNum)
-		//reader, err := NewSSTableReader(sstablePath)
+		if version.seqs[sstNum].First > seq {
+			continue // Every entry of the table is newer than the read
+		}
 		reader, err := db.findTable(sstNum)
 		if err != nil {
-			log.Printf("Error opening SSTable reader for %s: %v", sstablePath, err)
+			if db.quarantine(err) {
+				continue
+			}
+			return InternalKey{}, nil, false, fmt.Errorf("opening SSTable %05d: %w", sstNum, err)
+		}
+		if !reader.MayContainKeyRange(key) {
+			reader.Unref()
 			continue
 		}
-		val, found, err := reader.Get(key)
+		ik, val, found, err := reader.GetEntry(key, seq)
 		if err != nil {
-			log.Printf("Error reading SSTable %s: %v", sstablePath, err)
-			continue
+			reader.Unref()
+			if db.quarantine(err) {
+				continue
+			}
+			return InternalKey{}, nil, false, fmt.Errorf("reading SSTable %05d: %w", sstNum, err)
 		}
-
 		if found {
-			if val == nil {
-				return nil, false
-			}
-			return val, true
+			reader.Unref()
+			return ik, val, true, nil
 		}
+		if reader.MayContain(key) {
+			// The filter let the lookup through but the table did not have the key.
+			db.chargeSeek(sstNum)
+		}
+		reader.Unref()
 	}
 
-	return nil, false
+	return InternalKey{}, nil, false, nil
 }
 
 // Delete removes a key from the database.
 func (db *DB) Delete(wo WriteOptions, key []byte) error {
-	seqNum := db.sequenceNum.Add(1)
-	internalKey := InternalKey{UserKey: string(key), SeqNum: seqNum, Type: OpTypeDelete}
-	entry := &LogEntry{
-		Op:     OpDelete,
-		Key:    key,
-		SeqNum: seqNum,
-	}
-
-	db.mu.RLock()
-	wal := db.wal
-	memtable := db.mem
-	db.mu.RUnlock()
-
-	if err := wal.Write(entry, wo.Sync); err != nil {
-		return err
-	}
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return db.Write(wo, batch)
+}
 
-	memtable.Put(internalKey, nil)
-	if memtable.ApproximateSize() > MemtableSizeThreshold {
-		db.flushMemtable()
+// latestSeq returns the sequence number of the newest entry for key, including
+// tombstones, or 0 if the key has never been written.
+func (db *DB) latestSeq(key []byte) (uint64, error) {
+	ik, _, found, err := db.getEntry(context.Background(), key, math.MaxUint64)
+	if err != nil || !found {
+		return 0, err
 	}
-	return nil
+	return ik.SeqNum, nil
 }
 
+// Close waits for writes and flushes in progress and closes the database. A
+// running compaction is abandoned and its partial output removed, so Close
+// does not wait for it to finish.
 func (db *DB) Close() error {
+	// Taking writeMu waits for in-flight writes; later writes see closed.
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.closed.Swap(true) {
+		return ErrClosed
+	}
+	db.waitForInserts()
+	db.stopAsyncGets()
+	if db.bulk != nil {
+		db.abortBulkLoad(db.bulk)
+		db.bulk = nil
+	}
+
 	log.Println("Closing database, waiting for background work to finish...")
+	if db.opts.FlushOnClose && db.sharedLock == nil {
+		// No compaction is started for the new table, closed is already set.
+		if err := db.flushLocked(); err != nil {
+			log.Printf("ERROR: Failed to flush memtable on close, the WAL is kept: %v", err)
+		}
+	}
+	db.cancelClose()
+	if db.opts.ManualBackgroundJobs {
+		for db.RunBackgroundJob() {
+		}
+	}
 	db.wg.Wait()
+	db.stopBackgroundJobs()
 	log.Println("Background work finished.")
+
+	db.deleteObsoleteTables(nil)
+
+	db.mu.Lock()
+	var err error
+	if db.wal != nil {
+		err = db.wal.Close()
+	}
+	if wbm := db.opts.WriteBufferManager; wbm != nil {
+		wbm.free(int64(db.mem.ApproximateSize()))
+		if db.immutableMem != nil {
+			wbm.free(int64(db.immutableMem.ApproximateSize()))
+		}
+		wbm.unregister(db.blockCache)
+	}
+	db.mu.Unlock()
+	if db.tracer != nil {
+		if err := db.tracer.close(); err != nil {
+			log.Printf("ERROR: Failed to write trace file: %v", err)
+		}
+	}
+	db.tableCache.Purge()
+	// Only release the LOCK once nothing touches the directory anymore.
 	if db.dbLock != nil {
-		if err := db.dbLock.Unlock(); err != nil {
+		if err := db.dbLock.Close(); err != nil {
 			log.Printf("Warning: failed to unlock database: %v", err)
 		}
 	}
-	return db.wal.Close()
+	if db.sharedLock != nil {
+		db.sharedLock.Close()
+	}
+	return err
 }
 
-// NewIterator creates a new iterator over the database.
+// NewIterator creates a new iterator over the database. The iterator observes
+// a consistent view: writes made after it was created are not visible.
 func (db *DB) NewIterator() Iterator {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	return db.NewIteratorAt(db.sequenceNum.Load())
+}
 
-	// Collect iterators from all sources.
-	iters := make([]Iterator, 0)
+// NewIteratorAt creates an iterator that only observes entries with a sequence
+// number at most seq.
+func (db *DB) NewIteratorAt(seq uint64) Iterator {
+	return &userKeyIterator{Iterator: db.newMergingIterator(seq, nil), db: db, seq: seq}
+}
 
-	iters = append(iters, db.mem.NewIterator())
+// newMergingIterator merges all memtables and SSTables up to sequence number
+// seq, including the reserved index keyspace. If include is not nil, tables for
+// which it returns false are left out. Like Get, the iterator skips a table it
+// finds corrupt and quarantines; if any other table fails to open, the iterator
+// is never valid and its Error returns the failure.
+func (db *DB) newMergingIterator(seq uint64, include func(*SSTableReader) bool) Iterator {
+	db.mu.RLock()
+	// Collect iterators from all sources.
+	iters := []Iterator{db.mem.NewIterator()}
 	if db.immutableMem != nil {
 		iters = append(iters, db.immutableMem.NewIterator())
 	}
-	for i := len(db.activeSSTables) - 1; i >= 0; i-- {
-		sstNum := db.activeSSTables[i]
-		reader, err := db.findTable(sstNum)
-		if err != nil {
-			log.Printf("Error creating iterator for SSTable %d: %v", sstNum, err)
+	// Pin the current Version so compaction cannot delete the files being read.
+	version := db.current
+	version.ref()
+	db.mu.RUnlock()
+
+	var err error
+	for i := len(version.tables) - 1; i >= 0; i-- {
+		sstNum := version.tables[i]
+		if version.seqs[sstNum].First > seq {
 			continue
 		}
-		iters = append(iters, reader.NewIterator())
+		reader, ferr := db.findTable(sstNum)
+		if ferr != nil {
+			if db.quarantine(ferr) {
+				continue
+			}
+			err = fmt.Errorf("opening SSTable %05d: %w", sstNum, ferr)
+			break
+		}
+		if include == nil || include(reader) {
+			iters = append(iters, reader.NewIterator())
+		}
+		reader.Unref()
 	}
 
-	return NewMergingIterator(iters)
+	mi := newMergingIteratorAt(iters, seq)
+	mi.err = err
+	mi.cleanup = version.unref
+	return mi
 }
@@ -1,48 +1,51 @@
-package main
+package leveldb
 
 import (
-	"container/heap"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"math"
 )
 
-type Iterator interface {
-	Valid() bool
-	Key() InternalKey
-	Value() []byte
-	Next()
-	Close() error
-	Error() error
-	SeekToFirst()
-}
+// Iterator walks the entries of a database, a memtable or an SSTable in order.
+type Iterator = base.Iterator
 
-// mergingIterator combines multiple iterators into a single, sorted view.
+// mergingIterator combines multiple iterators into a single, sorted view. It
+// yields the newest visible version of every user key and hides tombstones.
 type mergingIterator struct {
-	h            minHeapIterator
+	raw          *base.MergingIterator
 	lastKey      InternalKey
 	currentValue []byte
 	isValid      bool
-	iters        []Iterator
+	// In forward direction raw is positioned just after the current entry, in
+	// reverse direction just before all versions of the current user key.
+	reverse bool
+	maxSeq  uint64 // Entries with a higher sequence number are invisible
+	err     error  // Failure to set up a source, which keeps the iterator invalid
+	cleanup func() // Called once when the iterator is closed
 }
 
 // NewMergingIterator creates a new merging iterator.
 func NewMergingIterator(iters []Iterator) Iterator {
+	return newMergingIteratorAt(iters, math.MaxUint64)
+}
+
+// newMergingIteratorAt creates a merging iterator that skips entries written
+// after sequence number maxSeq.
+func newMergingIteratorAt(iters []Iterator, maxSeq uint64) *mergingIterator {
 	mi := &mergingIterator{
-		iters: iters,
-		h:     make(minHeapIterator, 0, len(iters)),
+		raw:    base.NewMergingIterator(iters),
+		maxSeq: maxSeq,
 	}
 	return mi
 }
 
 func (mi *mergingIterator) findNextValid() {
-	for mi.h.Len() > 0 {
-		smallestItem := heap.Pop(&mi.h).(*heapIteratorItem)
-		currentKey := smallestItem.key
-		currentValue := smallestItem.value
-
-		smallestItem.iter.Next()
-		if smallestItem.iter.Valid() {
-			smallestItem.key = smallestItem.iter.Key()
-			smallestItem.value = smallestItem.iter.Value()
-			heap.Push(&mi.h, smallestItem)
+	for mi.raw.Valid() {
+		currentKey := mi.raw.Key()
+		currentValue := mi.raw.Value()
+		mi.raw.Next()
+
+		if currentKey.SeqNum > mi.maxSeq {
+			continue
 		}
 
 		if mi.isValid && mi.lastKey.UserKey == currentKey.UserKey {
@@ -64,8 +67,33 @@ func (mi *mergingIterator) findNextValid() {
 	mi.currentValue = nil
 }
 
+// findPrevValid moves to the previous user key with a visible, non-deleted
+// version. Walking backwards, the versions of a user key come oldest first, so
+// the last visible one seen is the newest.
+func (mi *mergingIterator) findPrevValid() {
+	for mi.raw.Valid() {
+		userKey := mi.raw.Key().UserKey
+		var found bool
+		for mi.raw.Valid() && mi.raw.Key().UserKey == userKey {
+			if key := mi.raw.Key(); key.SeqNum <= mi.maxSeq {
+				mi.lastKey = key
+				mi.currentValue = mi.raw.Value()
+				found = true
+			}
+			mi.raw.Prev()
+		}
+		if found && mi.lastKey.Type != OpTypeDelete {
+			mi.isValid = true
+			return
+		}
+	}
+
+	mi.isValid = false
+	mi.currentValue = nil
+}
+
 func (mi *mergingIterator) Valid() bool {
-	return mi.isValid
+	return mi.isValid && mi.err == nil
 }
 
 func (mi *mergingIterator) Key() InternalKey {
@@ -77,66 +105,75 @@ func (mi *mergingIterator) Value() []byte {
 }
 
 func (mi *mergingIterator) Next() {
+	if mi.reverse {
+		// Step over every version of the current user key.
+		if mi.raw.Valid() {
+			mi.raw.Next()
+		} else {
+			mi.raw.SeekToFirst()
+		}
+		for mi.raw.Valid() && mi.raw.Key().UserKey <= mi.lastKey.UserKey {
+			mi.raw.Next()
+		}
+		mi.reverse = false
+	}
 	mi.findNextValid()
 }
 
+func (mi *mergingIterator) Prev() {
+	if !mi.reverse {
+		// Step back over the versions of the current user key already consumed.
+		if mi.raw.Valid() {
+			mi.raw.Prev()
+		} else {
+			mi.raw.SeekToLast()
+		}
+		for mi.raw.Valid() && mi.raw.Key().UserKey >= mi.lastKey.UserKey {
+			mi.raw.Prev()
+		}
+		mi.reverse = true
+	}
+	mi.findPrevValid()
+}
+
 func (mi *mergingIterator) Close() error {
-	for _, item := range mi.h {
-		item.iter.Close()
+	mi.raw.Close()
+	mi.isValid = false
+	if mi.cleanup != nil {
+		mi.cleanup()
+		mi.cleanup = nil
 	}
 	return nil
 }
 
 func (mi *mergingIterator) Error() error {
-	for _, item := range mi.h {
-		if err := item.iter.Error(); err != nil {
-			return err
-		}
+	if mi.err != nil {
+		return mi.err
 	}
-	return nil
+	return mi.raw.Error()
 }
 
 func (mi *mergingIterator) SeekToFirst() {
-	mi.h = make(minHeapIterator, 0, len(mi.iters))
-	heap.Init(&mi.h)
-
-	for i, iter := range mi.iters {
-		iter.SeekToFirst()
-		if iter.Valid() {
-			heap.Push(&mi.h, &heapIteratorItem{
-				iter:  iter,
-				key:   iter.Key(),
-				value: iter.Value(),
-				idx:   i,
-			})
-		}
-	}
+	mi.raw.SeekToFirst()
+	mi.reverse = false
 	mi.isValid = false
-	mi.Next()
+	mi.findNextValid()
 }
 
-type heapIteratorItem struct {
-	iter  Iterator
-	key   InternalKey
-	value []byte
-	idx   int
+func (mi *mergingIterator) Seek(key []byte) {
+	mi.raw.Seek(key)
+	mi.reverse = false
+	mi.isValid = false
+	mi.findNextValid()
 }
 
-type minHeapIterator []*heapIteratorItem
-
-func (h minHeapIterator) Len() int { return len(h) }
-func (h minHeapIterator) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
-}
-func (h *minHeapIterator) Push(x any) { *h = append(*h, x.(*heapIteratorItem)) }
-func (h *minHeapIterator) Pop() any {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil
-	*h = old[0 : n-1]
-	return item
+func (mi *mergingIterator) SeekForPrev(key []byte) {
+	base.SeekForPrev(mi, key)
 }
-func (h minHeapIterator) Less(i, j int) bool {
-	return NewInternalKeyComparator().Compare(h[i].key, h[j].key) < 0
+
+func (mi *mergingIterator) SeekToLast() {
+	mi.raw.SeekToLast()
+	mi.reverse = true
+	mi.isValid = false
+	mi.findPrevValid()
 }
@@ -0,0 +1,2502 @@
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/cache"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/memtable"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"github.com/quangh33/Go-LevelDB/wal"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openTestDB opens a fresh database in a temporary directory.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestWriteBatchRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Put([]byte("b"), []byte("2"))
+	batch.Delete([]byte("a"))
+	if err := db.Write(WriteOptions{Sync: true}, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Get([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a to be deleted after recovery")
+	}
+	if val, err := db.Get([]byte("b")); err != nil || string(val) != "2" {
+		t.Errorf("Expected b=2 after recovery, got %q (%v)", val, err)
+	}
+	if seq := db.sequenceNum.Load(); seq != 3 {
+		t.Errorf("Expected sequence number 3 after recovery, got %d", seq)
+	}
+}
+
+func TestWriteBatchHooks(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	db.Put(wo, []byte("a"), []byte("1"))
+
+	var committed []SeqRange
+	var rolledBack []error
+	batch := NewWriteBatch()
+	batch.Put([]byte("b"), []byte("2"))
+	batch.Delete([]byte("a"))
+	batch.OnCommit(func(seqs SeqRange) { committed = append(committed, seqs) })
+	batch.OnRollback(func(err error) { rolledBack = append(rolledBack, err) })
+	if err := db.Write(wo, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if len(committed) != 1 || committed[0] != (SeqRange{2, 3}) || len(rolledBack) != 0 {
+		t.Fatalf("Expected one commit of sequence numbers 2-3, got %v and rollbacks %v", committed, rolledBack)
+	}
+
+	batch.Reset()
+	batch.Put([]byte("c"), []byte("3"))
+	if err := db.Write(wo, batch); err != nil || len(committed) != 1 {
+		t.Fatalf("Reset must drop the hooks, got %v and %d commits", err, len(committed))
+	}
+
+	batch.Reset()
+	batch.Put(make([]byte, DefaultMaxKeySize+1), nil)
+	batch.OnCommit(func(seqs SeqRange) { committed = append(committed, seqs) })
+	batch.OnRollback(func(err error) { rolledBack = append(rolledBack, err) })
+	err := db.Write(wo, batch)
+	if !errors.Is(err, ErrKeyTooLarge) || len(rolledBack) != 1 || rolledBack[0] != err || len(committed) != 1 {
+		t.Fatalf("Expected the failed write to roll back, got %v, rollbacks %v", err, rolledBack)
+	}
+}
+
+func TestWriteBatchHooksSyncFailure(t *testing.T) {
+	fs := &failSyncFS{MemFS: NewMemFS()}
+	db, err := Open("db", &Options{FS: fs, WALSyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	var committed []SeqRange
+	var rolledBack []error
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.OnCommit(func(seqs SeqRange) { committed = append(committed, seqs) })
+	batch.OnRollback(func(err error) { rolledBack = append(rolledBack, err) })
+	fs.failures.Store(1)
+	err = db.Write(WriteOptions{Sync: true}, batch)
+	if !errors.Is(err, ErrUnsynced) || !errors.Is(err, errInjected) {
+		t.Fatalf("Write with a failed WAL sync returned %v, want ErrUnsynced wrapping the fsync error", err)
+	}
+	if len(committed) != 1 || committed[0] != (SeqRange{1, 1}) || len(rolledBack) != 0 {
+		t.Fatalf("Expected the applied batch to commit, got commits %v and rollbacks %v", committed, rolledBack)
+	}
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get after the failed sync returned %q, %v", val, err)
+	}
+}
+
+func TestGetAtSequence(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	db.Put(wo, []byte("k"), []byte("v1"))
+	seqV1 := db.sequenceNum.Load()
+	db.Put(wo, []byte("k"), []byte("v2"))
+	db.Put(wo, []byte("other"), []byte("x"))
+	seqV2 := db.sequenceNum.Load()
+	db.Delete(wo, []byte("k"))
+
+	check := func(stage string) {
+		if val, err := db.GetAt(seqV1, []byte("k")); err != nil || string(val) != "v1" {
+			t.Errorf("%s: expected v1 at seq %d, got %q (%v)", stage, seqV1, val, err)
+		}
+		if val, err := db.GetAt(seqV2, []byte("k")); err != nil || string(val) != "v2" {
+			t.Errorf("%s: expected v2 at seq %d, got %q (%v)", stage, seqV2, val, err)
+		}
+		if _, err := db.Get([]byte("k")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected k to be deleted", stage)
+		}
+		it := db.NewIteratorAt(seqV1)
+		var keys []string
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			keys = append(keys, it.Key().UserKey+"="+string(it.Value()))
+		}
+		it.Close()
+		if len(keys) != 1 || keys[0] != "k=v1" {
+			t.Errorf("%s: expected [k=v1] at seq %d, got %v", stage, seqV1, keys)
+		}
+	}
+
+	check("memtable")
+	db.flushMemtable()
+	db.wg.Wait()
+	check("sstable")
+}
+
+func TestIteratorPinsCompactedTables(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	for i := 0; i < 3; i++ {
+		db.Put(wo, generateKey(i), []byte("v"))
+		db.flushMemtable()
+		db.wg.Wait()
+	}
+
+	it := db.NewIterator()
+	db.wg.Add(1)
+	db.compact(append([]int(nil), db.current.tables...), true)
+
+	// The compacted tables must stay readable until the iterator is closed.
+	count := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		count++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("Iterator failed after compaction: %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("Expected 3 keys, got %d", count)
+	}
+	if _, err := os.Stat(filepath.Join(db.dataDir, "00001.sst")); err != nil {
+		t.Fatalf("Expected compacted table to be pinned by the iterator: %v", err)
+	}
+	it.Close()
+	if _, err := os.Stat(filepath.Join(db.dataDir, "00001.sst")); !os.IsNotExist(err) {
+		t.Fatalf("Expected compacted table to be deleted after the iterator closed, got %v", err)
+	}
+}
+
+func TestIteratorIgnoresLaterWrites(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	db.Put(wo, []byte("a"), []byte("1"))
+	db.Put(wo, []byte("c"), []byte("3"))
+
+	it := db.NewIterator()
+	defer it.Close()
+	it.SeekToFirst()
+	db.Put(wo, []byte("b"), []byte("2"))
+	db.Put(wo, []byte("c"), []byte("changed"))
+
+	var got []string
+	for ; it.Valid(); it.Next() {
+		got = append(got, it.Key().UserKey+"="+string(it.Value()))
+	}
+	if len(got) != 2 || got[0] != "a=1" || got[1] != "c=3" {
+		t.Fatalf("Expected [a=1 c=3], got %v", got)
+	}
+}
+
+func TestDisableWALRequiresFlush(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	noWAL := WriteOptions{DisableWAL: true}
+	db.Put(noWAL, []byte("flushed"), []byte("1"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.Put(noWAL, []byte("lost"), []byte("2"))
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if val, err := db.Get([]byte("flushed")); err != nil || string(val) != "1" {
+		t.Errorf("Expected flushed write to survive reopen, got %q (%v)", val, err)
+	}
+	if _, err := db.Get([]byte("lost")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected unflushed DisableWAL write to be lost on reopen")
+	}
+}
+
+func TestPeriodicWALSync(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{WALSyncInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := db.Put(WriteOptions{Sync: true}, generateKey(w*10+i), []byte("v")); err != nil {
+					t.Errorf("Put failed: %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 80; i++ {
+		if _, err := db.Get(generateKey(i)); err != nil {
+			t.Fatalf("Missing key %d after reopen", i)
+		}
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MaxKeySize: 8, MaxValueSize: 16})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	wo := WriteOptions{}
+	if err := db.Put(wo, []byte("123456789"), nil); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("Expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := db.Put(wo, []byte("k"), make([]byte, 17)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected ErrValueTooLarge, got %v", err)
+	}
+	batch := NewWriteBatch()
+	batch.Put([]byte("ok"), []byte("v"))
+	batch.Delete([]byte("123456789"))
+	if err := db.Write(wo, batch); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("Expected ErrKeyTooLarge for batch, got %v", err)
+	}
+	if _, err := db.Get([]byte("ok")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rejected batch must not be partially applied")
+	}
+	if err := db.Put(wo, []byte("12345678"), make([]byte, 16)); err != nil {
+		t.Errorf("Put at the limits failed: %v", err)
+	}
+}
+
+func TestFilterPropertiesRecorded(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{FilterBitsPerKey: 16})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := db.Put(WriteOptions{}, []byte(k), []byte(k)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	r, err := db.findTable(db.current.tables[0])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	defer r.Unref()
+	props := r.Properties()
+	if props.NumEntries != 3 || props.FilterBitsPerKey != 16 {
+		t.Errorf("Unexpected properties %+v", props)
+	}
+	if props.FilterFPRate <= 0 || props.FilterFPRate >= DefaultFilterFPRate {
+		t.Errorf("Expected a false positive rate below the default, got %v", props.FilterFPRate)
+	}
+	if !props.FilterKeyHashes || !r.MayContain([]byte("b")) {
+		t.Errorf("Expected a filter over key hashes admitting b, got %+v", props)
+	}
+
+}
+
+func TestFilterPolicyNone(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{FilterPolicy: FilterPolicyNone})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.Close()
+
+	// Tables without a filter stay readable when the policy changes.
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	r, err := db.findTable(db.current.tables[0])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	defer r.Unref()
+	if r.Footer().FilterSize != 0 || r.Properties().FilterPolicy != FilterPolicyNone {
+		t.Errorf("Expected a table without a filter, got properties %+v", r.Properties())
+	}
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get(a) = %q, %v; want 1, nil", val, err)
+	}
+	if _, err := db.Get([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected b to be absent")
+	}
+}
+
+func TestDataBlockSize(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{DataBlockSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(WriteOptions{}, []byte(generateKey(i)), make([]byte, 100)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.Close()
+
+	// Tables keep the block size they were written with.
+	db, err = Open(dir, &Options{DataBlockSize: 1024})
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	r, err := db.findTable(db.current.tables[0])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	defer r.Unref()
+	index, err := r.Index()
+	if err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	if r.Properties().DataBlockSize != 64*1024 || len(index) != 3 {
+		t.Errorf("Expected 3 blocks of 64KB, got %d blocks, properties %+v", len(index), r.Properties())
+	}
+	for _, i := range []int{0, 500, 999} {
+		if _, err := db.Get([]byte(generateKey(i))); err != nil {
+			t.Errorf("Key %d not found", i)
+		}
+	}
+}
+
+func TestCuckooFilter(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{FilterPolicy: FilterPolicyCuckoo})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(WriteOptions{}, []byte(generateKey(i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	r, err := db.findTable(db.current.tables[0])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	defer r.Unref()
+	if props := r.Properties(); props.FilterPolicy != FilterPolicyCuckoo || props.FilterFPRate >= DefaultFilterFPRate {
+		t.Errorf("Unexpected filter properties %+v", props)
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if !r.MayContain([]byte(generateKey(i))) {
+			t.Fatalf("Filter rejected present key %d", i)
+		}
+		if r.MayContain([]byte(generateKey(i + 1000))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 5 {
+		t.Errorf("Expected almost no false positives, got %d of 1000", falsePositives)
+	}
+	if val, err := db.Get([]byte(generateKey(7))); err != nil || string(val) != "v" {
+		t.Errorf("Get = %q, %v; want v, nil", val, err)
+	}
+}
+
+func TestCompactionPressure(t *testing.T) {
+	type report struct {
+		over    bool
+		pending int64
+	}
+	reports := make(chan report, 10)
+	db, err := Open(t.TempDir(), &Options{
+		DisableAutoCompactions:      true,
+		PendingCompactionBytesLimit: 1,
+		OnCompactionPressure:        func(over bool, pending int64) { reports <- report{over, pending} },
+	})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 2; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	pending := db.EstimatePendingCompactionBytes()
+	if pending == 0 {
+		t.Fatalf("Expected pending compaction bytes with two tables")
+	}
+	if prop, _ := db.GetProperty("leveldb.estimate-pending-compaction-bytes"); prop != fmt.Sprint(pending) {
+		t.Errorf("Property = %s, want %d", prop, pending)
+	}
+	if r := <-reports; !r.over || r.pending != pending {
+		t.Errorf("Expected a report over the limit with %d bytes, got %+v", pending, r)
+	}
+
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if got := db.EstimatePendingCompactionBytes(); got != 0 {
+		t.Errorf("Expected no pending bytes after compaction, got %d", got)
+	}
+	if r := <-reports; r.over {
+		t.Errorf("Expected the pressure to be lifted, got %+v", r)
+	}
+}
+
+func TestFileIOStats(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if wal := db.FileIOStats()["db.wal"]; wal.Writes != 10 || wal.BytesWritten == 0 {
+		t.Errorf("WAL stats after 10 puts = %+v", wal)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	var table string
+	for name, s := range db.FileIOStats() {
+		if strings.HasSuffix(name, ".sst") {
+			table = name
+			if s.FlushBytesWritten == 0 || s.BytesWritten != s.FlushBytesWritten {
+				t.Errorf("Flushed table stats = %+v", s)
+			}
+		} else if strings.HasPrefix(name, "wal-") {
+			t.Errorf("Rotated WAL %s still listed after its flush", name)
+		}
+	}
+	if table == "" {
+		t.Fatalf("No stats for the flushed table")
+	}
+
+	db.Get(generateKey(1))
+	first := db.FileIOStats()[table]
+	if first.Reads == 0 || first.CacheMisses != first.Reads || first.BytesRead == 0 {
+		t.Errorf("Stats after a cold lookup = %+v", first)
+	}
+	db.Get(generateKey(2))
+	if second := db.FileIOStats()[table]; second.Reads != first.Reads {
+		t.Errorf("A cached lookup read from disk: %+v after %+v", second, first)
+	}
+	if report, _ := db.GetProperty("leveldb.file-io-stats"); !strings.Contains(report, table) {
+		t.Errorf("Report does not list %s:\n%s", table, report)
+	}
+}
+
+func TestFilterStats(t *testing.T) {
+	db, err := OpenInMemory(&Options{FilterFPRate: 0.2})
+	if err != nil {
+		t.Fatalf("OpenInMemory failed: %v", err)
+	}
+	defer db.Close()
+	const n = 2000
+	for i := 0; i < 2*n; i += 2 {
+		if err := db.Put(WriteOptions{}, []byte(fmt.Sprintf("k%05d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	for i := 0; i < 2*n; i += 2 {
+		if _, err := db.Get([]byte(fmt.Sprintf("k%05d", i))); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if stats := db.FilterStats(); stats.Checks != n || stats.Negatives != 0 || stats.FalsePositives != 0 {
+		t.Errorf("Stats after lookups of present keys = %+v", stats)
+	}
+	// Keys between the smallest and largest of the table reach its filter.
+	for i := 1; i < 2*n-1; i += 2 {
+		db.Get([]byte(fmt.Sprintf("k%05d", i)))
+	}
+	stats := db.FilterStats()
+	if stats.Checks != 2*n-1 || stats.Negatives+stats.FalsePositives != n-1 {
+		t.Errorf("Stats after lookups of absent keys = %+v", stats)
+	}
+	if rate := stats.FalsePositiveRate(); rate < 0.1 || rate > 0.3 {
+		t.Errorf("Measured false positive rate %.3f, configured 0.2", rate)
+	}
+	table := fmt.Sprintf("%05d.sst", db.current.tables[0])
+	if s := db.FileIOStats()[table]; s.FilterChecks != stats.Checks || s.FilterFalsePositives != stats.FalsePositives {
+		t.Errorf("Table stats %+v do not match the totals %+v", s, stats)
+	}
+	if report, _ := db.GetProperty("leveldb.stats"); !strings.Contains(report, fmt.Sprintf("%d false positives", stats.FalsePositives)) {
+		t.Errorf("Report does not list the filter stats:\n%s", report)
+	}
+}
+
+func TestTombstoneDensityTriggersCompaction(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 2*MinTombstonesForCompaction; i++ {
+		if err := db.Put(WriteOptions{}, []byte(fmt.Sprintf("key%04d", i)), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < 2*MinTombstonesForCompaction; i++ {
+		if err := db.Delete(WriteOptions{}, []byte(fmt.Sprintf("key%04d", i))); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// Two tables are far below SSTableCountThreshold, so only the tombstone
+	// ratio can have triggered the compaction that removes them.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		db.mu.RLock()
+		tables := len(db.current.tables)
+		db.mu.RUnlock()
+		if tables == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected compaction to drop all tables, %d remain", tables)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := db.Get([]byte("key0000")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleted key to stay deleted after compaction")
+	}
+}
+
+func TestTombstoneCompactionPicksNeighbors(t *testing.T) {
+	db := openTestDB(t)
+	write := func(prefix string, del bool) {
+		t.Helper()
+		for i := 0; i < 2*MinTombstonesForCompaction; i++ {
+			key := []byte(fmt.Sprintf("%s%04d", prefix, i))
+			var err error
+			if del {
+				err = db.Delete(WriteOptions{}, key)
+			} else {
+				err = db.Put(WriteOptions{}, key, []byte("v"))
+			}
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	wait := func() (int, int) {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		for db.compactionInProgress {
+			db.flushCond.Wait()
+		}
+		return len(db.current.tables), db.stats.compactions
+	}
+	write("a", false)
+	write("b", false)
+	write("b", true) // Dense, but a table older than its neighbor remains
+	if tables, compactions := wait(); tables != 2 || compactions != 1 {
+		t.Fatalf("Expected the dense table to merge with its neighbor only, have %d tables after %d compactions", tables, compactions)
+	}
+	// The output keeps its tombstones, which must not trigger again.
+	write("c", false)
+	if tables, compactions := wait(); tables != 3 || compactions != 1 {
+		t.Errorf("Expected no further compaction, have %d tables after %d compactions", tables, compactions)
+	}
+	if _, err := db.Get([]byte("b0000")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleted key to stay deleted after compaction")
+	}
+}
+
+func TestObsoleteVersionsTriggerCompaction(t *testing.T) {
+	db := openTestDB(t)
+	// Every key is overwritten many times within one memtable.
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			if err := db.Put(WriteOptions{}, generateKey(i), []byte(fmt.Sprint(round))); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	db.mu.Lock()
+	for db.compactionInProgress {
+		db.flushCond.Wait()
+	}
+	tables := append([]int(nil), db.current.tables...)
+	priority := db.stats.priorityCompactions
+	db.mu.Unlock()
+	if priority != 1 || len(tables) != 1 {
+		t.Fatalf("Expected one priority compaction into one table, got %d compactions and tables %v", priority, tables)
+	}
+	reader, err := db.findTable(tables[0])
+	if err != nil {
+		t.Fatalf("Failed to open table: %v", err)
+	}
+	props := reader.Properties()
+	reader.Unref()
+	if props.NumEntries != 10 || props.NumObsolete != 0 {
+		t.Errorf("Compacted table has %d entries, %d obsolete; want 10, 0", props.NumEntries, props.NumObsolete)
+	}
+	if val, err := db.Get(generateKey(3)); err != nil || string(val) != "19" {
+		t.Errorf("Get = %q, %v; want the newest version", val, err)
+	}
+	if report, _ := db.GetProperty("leveldb.stats"); !strings.Contains(report, "Priority compactions: 1 ") {
+		t.Errorf("Stats report misses the priority compaction:\n%s", report)
+	}
+}
+
+func TestMaxBackgroundJobs(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MaxBackgroundJobs: 1})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	// With one worker, jobs run one at a time, flushes go first and a running
+	// job yields to them.
+	release := make(chan struct{})
+	var order []string
+	var mu sync.Mutex
+	job := func(name string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+	db.schedule(func() {
+		<-release
+		db.yieldToFlushes()
+		job("yielded")()
+	}, false)
+	db.schedule(job("compaction"), false)
+	db.schedule(job("flush"), true)
+	close(release)
+
+	// Real flushes and compactions still complete on the single worker.
+	for i := 0; i < 2*SSTableCountThreshold; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	for i := 0; i < 2*SSTableCountThreshold; i++ {
+		if _, err := db.Get(generateKey(i)); err != nil {
+			t.Errorf("Key %d not found", i)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(order) != "[flush yielded compaction]" {
+		t.Errorf("Jobs ran in order %v, want the flush first", order)
+	}
+}
+
+func TestMinOverlapCompactionPriority(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{CompactionPriority: CompactionPriorityMinOverlap})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	// Every table spans the same key range, except the newest, which only
+	// holds keys after all others.
+	for n := 0; n < SSTableCountThreshold; n++ {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("m-%03d", i*10+n)
+			if n == SSTableCountThreshold-1 {
+				key = fmt.Sprintf("z-%03d", i)
+			}
+			if err := db.Put(WriteOptions{}, []byte(key), []byte("v")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	db.mu.Lock()
+	for db.compactionInProgress {
+		db.flushCond.Wait()
+	}
+	tables := append([]int(nil), db.current.tables...)
+	db.mu.Unlock()
+	if len(tables) != SSTableCountThreshold-1 {
+		t.Fatalf("Expected one pair of tables to be merged, have %d tables", len(tables))
+	}
+	reader, err := db.findTable(tables[len(tables)-1])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	defer reader.Unref()
+	if props := reader.Properties(); props.NumEntries != 20 || props.LargestKey != "z-009" {
+		t.Errorf("Merged table holds %d entries up to %q, want the two newest tables", props.NumEntries, props.LargestKey)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := db.Get([]byte(fmt.Sprintf("m-%03d", i))); (err == nil) != (i%10 < SSTableCountThreshold-1) {
+			t.Errorf("Key m-%03d = %v", i, err)
+		}
+	}
+}
+
+func TestTrivialMove(t *testing.T) {
+	db := openTestDB(t)
+	// Only the oldest and the newest table overlap; the tables in between
+	// hold disjoint key ranges.
+	for n := 0; n < SSTableCountThreshold; n++ {
+		for i := 0; i < 10; i++ {
+			key, value := fmt.Sprintf("m%d-%03d", n, i), "v"
+			if n == 0 || n == SSTableCountThreshold-1 {
+				key, value = fmt.Sprintf("a-%03d", i), fmt.Sprint(n)
+			}
+			if err := db.Put(WriteOptions{}, []byte(key), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	db.mu.Lock()
+	for db.compactionInProgress {
+		db.flushCond.Wait()
+	}
+	tables := len(db.current.tables)
+	moves := db.stats.trivialMoves
+	db.mu.Unlock()
+	if tables != SSTableCountThreshold-1 {
+		t.Errorf("Expected the overlapping pair to be merged, have %d tables", tables)
+	}
+	if moves != SSTableCountThreshold-2 {
+		t.Errorf("Expected %d trivial moves, got %d", SSTableCountThreshold-2, moves)
+	}
+	for i := 0; i < 10; i++ {
+		if val, err := db.Get([]byte(fmt.Sprintf("a-%03d", i))); err != nil || string(val) != fmt.Sprint(SSTableCountThreshold-1) {
+			t.Errorf("Key a-%03d = %q, %v, want the newest value", i, val, err)
+		}
+		for n := 1; n < SSTableCountThreshold-1; n++ {
+			if _, err := db.Get([]byte(fmt.Sprintf("m%d-%03d", n, i))); err != nil {
+				t.Errorf("Key m%d-%03d not found after trivial move", n, i)
+			}
+		}
+	}
+}
+
+func TestTargetFileSize(t *testing.T) {
+	const target = 4 << 10
+	db, err := Open(t.TempDir(), &Options{TargetFileSize: target})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	value := generateValue(100)
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	checkTables := func(when string) {
+		t.Helper()
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		tables := db.current.tables
+		if len(tables) < SSTableCountThreshold {
+			t.Fatalf("%s: expected the output to be cut into many tables, have %d", when, len(tables))
+		}
+		var lastLargest string
+		for i, num := range tables {
+			if size := fileSize(db.opts.FS, tablePath(db.dataDir, num)); size > 2*target {
+				t.Errorf("%s: table %d has %d bytes, target %d", when, num, size, target)
+			}
+			reader, err := db.findTable(num)
+			if err != nil {
+				t.Fatalf("%s: failed to open table %d: %v", when, num, err)
+			}
+			smallest, largest, _ := reader.KeyRange()
+			reader.Unref()
+			if i > 0 && smallest <= lastLargest {
+				t.Errorf("%s: table %d starts at %q, before the end of the previous one", when, num, smallest)
+			}
+			lastLargest = largest
+		}
+		// One sorted run does not trigger another compaction.
+		if runs := db.sortedRunsLocked(); runs != 1 {
+			t.Errorf("%s: have %d sorted runs, want 1", when, runs)
+		}
+		db.maybeScheduleCompactionLocked()
+		if db.compactionInProgress {
+			t.Errorf("%s: a single sorted run was scheduled for compaction", when)
+		}
+	}
+	checkTables("after flush")
+
+	for i := 0; i < 1000; i += 2 {
+		if err := db.Delete(WriteOptions{}, generateKey(i)); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	checkTables("after compaction")
+	for i := 0; i < 1000; i++ {
+		if _, err := db.Get(generateKey(i)); (err == nil) != (i%2 == 1) {
+			t.Errorf("Key %d = %v after compaction", i, err)
+		}
+	}
+}
+
+func TestGrandparentOverlapCutsOutput(t *testing.T) {
+	dir := t.TempDir()
+	opts := sanitizeOptions(&Options{MaxGrandparentOverlapBytes: 150})
+	var grandparents []tableSpan
+	for g := 0; g < 10; g++ {
+		grandparents = append(grandparents, tableSpan{fmt.Sprintf("k%d0", g), fmt.Sprintf("k%d9", g), 100})
+	}
+	n := 0
+	out := &tableSplitter{opts: opts, grandparents: grandparents, newPath: func() string {
+		n++
+		return filepath.Join(dir, fmt.Sprintf("%05d.sst", n))
+	}}
+	for i := 0; i < 100; i++ {
+		if err := out.add(InternalKey{UserKey: fmt.Sprintf("k%02d", i), SeqNum: 1, Type: OpTypePut}, []byte("v")); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	paths, err := out.finish()
+	if err != nil {
+		t.Fatalf("finish failed: %v", err)
+	}
+	// A table is cut once it has passed two grandparents of 100 bytes.
+	if len(paths) != 5 {
+		t.Fatalf("Expected 5 tables, got %d", len(paths))
+	}
+	for i, path := range paths {
+		reader, err := NewSSTableReader(path, nil)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", path, err)
+		}
+		smallest, largest, err := reader.KeyRange()
+		reader.Unref()
+		if want := fmt.Sprintf("k%d0", 2*i); err != nil || smallest != want || largest != fmt.Sprintf("k%d9", 2*i+1) {
+			t.Errorf("Table %d covers %q to %q, err %v; want it to start at %q", i, smallest, largest, err, want)
+		}
+	}
+}
+
+func TestDeleteFilesInRange(t *testing.T) {
+	db := openTestDB(t)
+	for _, keys := range [][]string{{"a1", "a2"}, {"b1", "b2"}, {"b3", "c1"}} {
+		for _, k := range keys {
+			if err := db.Put(WriteOptions{}, []byte(k), []byte(k)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	if err := db.DeleteFilesInRange([]byte("b"), []byte("c")); err != nil {
+		t.Fatalf("DeleteFilesInRange failed: %v", err)
+	}
+	if len(db.current.tables) != 2 {
+		t.Fatalf("Expected 2 tables to remain, got %v", db.current.tables)
+	}
+	for key, want := range map[string]bool{"a1": true, "b1": false, "b2": false, "b3": true, "c1": true} {
+		if _, err := db.Get([]byte(key)); (err == nil) != want {
+			t.Errorf("Get(%s) = %v, want %v", key, err, want)
+		}
+	}
+	db.Close()
+	if err := db.DeleteFilesInRange(nil, nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestCloseWaitsForFlushAndRejectsWrites(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	db.flushMemtable()
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("b"), []byte("2")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from Put after Close, got %v", err)
+	}
+	if err := db.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from a second Close, got %v", err)
+	}
+
+	// The background flush finished before the LOCK was released.
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if len(db.current.tables) != 1 {
+		t.Errorf("Expected the flushed table to be installed, got %v", db.current.tables)
+	}
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get(a) = %q, %v; want 1, nil", val, err)
+	}
+}
+
+func TestPauseBackgroundWork(t *testing.T) {
+	db := openTestDB(t)
+	db.PauseBackgroundWork()
+	for i := 0; i < SSTableCountThreshold; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	db.mu.RLock()
+	tables, running := len(db.current.tables), db.compactionInProgress
+	db.mu.RUnlock()
+	if tables != SSTableCountThreshold || running {
+		t.Fatalf("Expected no compaction while paused, have %d tables, running %v", tables, running)
+	}
+
+	if err := db.ContinueBackgroundWork(); err != nil {
+		t.Fatalf("ContinueBackgroundWork failed: %v", err)
+	}
+	db.PauseBackgroundWork() // Waits for the compaction scheduled on resume
+	if len(db.current.tables) != 1 {
+		t.Errorf("Expected resume to compact into 1 table, have %v", db.current.tables)
+	}
+	if err := db.ContinueBackgroundWork(); err != nil {
+		t.Fatalf("ContinueBackgroundWork failed: %v", err)
+	}
+	if err := db.ContinueBackgroundWork(); err == nil {
+		t.Errorf("Expected an error when resuming without a pause")
+	}
+}
+
+func TestCompactRangeWithAutoCompactionsDisabled(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < SSTableCountThreshold+2; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if n := len(db.current.tables); n != SSTableCountThreshold+2 {
+		t.Fatalf("Expected no automatic compaction, have %d tables", n)
+	}
+
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if n := len(db.current.tables); n != 1 {
+		t.Errorf("Expected 1 table after CompactRange, have %d", n)
+	}
+	for i := 0; i < SSTableCountThreshold+2; i++ {
+		if _, err := db.Get(generateKey(i)); err != nil {
+			t.Errorf("Key %d missing after CompactRange", i)
+		}
+	}
+}
+
+func TestCompactionOutputManyKeys(t *testing.T) {
+	const targetSize = 64 * 1024
+	db, err := Open(t.TempDir(), &Options{DisableAutoCompactions: true, TargetFileSize: targetSize})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	wo := WriteOptions{}
+	want := make(map[string]string)
+	for pass := 0; pass < 3; pass++ {
+		for i := pass; i < 20000; i += pass + 1 {
+			key := string(generateKey(i))
+			if pass > 0 && i%5 == 0 {
+				db.Delete(wo, []byte(key))
+				delete(want, key)
+				continue
+			}
+			value := fmt.Sprintf("value-%d-%d-%s", pass, i, bytes.Repeat([]byte("x"), i%100))
+			db.Put(wo, []byte(key), []byte(value))
+			want[key] = value
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+
+	iter := db.NewIterator()
+	n := 0
+	var prev string
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		key := iter.Key().UserKey
+		if key <= prev {
+			t.Fatalf("Key %q after %q", key, prev)
+		}
+		if string(iter.Value()) != want[key] {
+			t.Fatalf("Key %q = %q, want %q", key, iter.Value(), want[key])
+		}
+		prev = key
+		n++
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatalf("Iterator failed: %v", err)
+	}
+	iter.Close()
+	if n != len(want) {
+		t.Fatalf("Iterated %d keys, want %d", n, len(want))
+	}
+
+	// Tables are cut once they reach the target size, between user keys.
+	live, err := db.GetLiveFiles(false)
+	if err != nil {
+		t.Fatalf("GetLiveFiles failed: %v", err)
+	}
+	defer live.Release()
+	tables := live.Tables
+	sort.Slice(tables, func(i, j int) bool { return tables[i].SmallestKey < tables[j].SmallestKey })
+	if len(tables) < 10 {
+		t.Fatalf("Compaction wrote %d tables, want the output cut at %d bytes", len(tables), targetSize)
+	}
+	for i, table := range tables {
+		if i < len(tables)-1 && (table.Size < targetSize || table.Size > 2*targetSize) {
+			t.Errorf("Table %d has %d bytes, want about %d", table.FileNum, table.Size, targetSize)
+		}
+		if i > 0 && tables[i-1].LargestKey >= table.SmallestKey {
+			t.Errorf("Table %d starts at %q, before the end %q of the previous table", table.FileNum, table.SmallestKey, tables[i-1].LargestKey)
+		}
+	}
+}
+
+func TestMaxOpenFilesEvictsReaders(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MaxOpenFiles: NumNonTableFiles + 1, DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 3; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	// Every read reopens a table evicted by the previous one.
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 3; i++ {
+			if _, err := db.Get(generateKey(i)); err != nil {
+				t.Fatalf("Key %d missing", i)
+			}
+			if n := db.tableCache.Len(); n > 1 {
+				t.Fatalf("Expected at most 1 cached reader, have %d", n)
+			}
+		}
+	}
+}
+
+func TestFlushOnClose(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{FlushOnClose: true})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "db.wal")); err != nil || info.Size() != int64(wal.HeaderSize) {
+		t.Errorf("Expected a WAL without records after Close, got %v, %v", info, err)
+	}
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if len(db.current.tables) != 1 {
+		t.Errorf("Expected the memtable to be flushed on close, tables %v", db.current.tables)
+	}
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get(a) = %q, %v; want 1, nil", val, err)
+	}
+}
+
+func TestOpenChecksStateFiles(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	db.Put(WriteOptions{}, []byte("a"), []byte("1"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	num := db.current.tables[0]
+	db.mu.Lock()
+	state := db.stateLocked()
+	db.mu.Unlock()
+	db.Close()
+
+	// A state that lost track of the file numbers in use is repaired.
+	state.NextFileNumber = 1
+	if err := writeState(OSFS{}, dir, state); err != nil {
+		t.Fatalf("writeState failed: %v", err)
+	}
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	if db.nextFileNumber <= num {
+		t.Errorf("Next file number %d would reuse SSTable %d", db.nextFileNumber, num)
+	}
+	db.Close()
+
+	// A missing table fails the open instead of the first read.
+	path := filepath.Join(dir, fmt.Sprintf("%05d.sst", num))
+	if err := os.Rename(path, path+".bak"); err != nil {
+		t.Fatalf("Failed to move table: %v", err)
+	}
+	if _, err := NewDB(dir); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("Expected a missing SSTable error, got %v", err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+	if _, err := NewDB(dir); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("Expected a truncated SSTable error, got %v", err)
+	}
+}
+
+// crashRenameFS is a MemFS that, while crashing is set, fails to rename or
+// remove temporary tables, as if the process died right after writing them.
+type crashRenameFS struct {
+	*MemFS
+	crashing atomic.Bool
+}
+
+func (fs *crashRenameFS) Rename(oldname, newname string) error {
+	if fs.crashing.Load() && strings.HasSuffix(oldname, ".sst.tmp") {
+		return errInjected
+	}
+	return fs.MemFS.Rename(oldname, newname)
+}
+
+func (fs *crashRenameFS) Remove(name string) error {
+	if fs.crashing.Load() && strings.HasSuffix(name, ".sst.tmp") {
+		return errInjected
+	}
+	return fs.MemFS.Remove(name)
+}
+
+func TestOpenRemovesOrphanTables(t *testing.T) {
+	fs := &crashRenameFS{MemFS: NewMemFS()}
+	db, err := Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	db.Put(WriteOptions{}, []byte("a"), []byte("1"))
+	fs.crashing.Store(true)
+	if err := db.Flush(); !errors.Is(err, errInjected) {
+		t.Fatalf("Flush with a failed rename returned %v", err)
+	}
+	db.Close()
+	fs.crashing.Store(false)
+	tmpPaths, _ := vfs.Glob(fs, "db", "*.sst.tmp")
+	if len(tmpPaths) != 1 {
+		t.Fatalf("Expected the failed flush to leave a temporary table, found %v", tmpPaths)
+	}
+	// A table written by a compaction whose state was never saved.
+	orphan := tablePath("db", 50)
+	if err := writeFile(fs, orphan, []byte("orphaned table")); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+
+	db, err = Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer db.Close()
+	if _, err := fs.Stat(tmpPaths[0]); !os.IsNotExist(err) {
+		t.Errorf("Temporary table %s survived the open: %v", tmpPaths[0], err)
+	}
+	if _, err := fs.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Unlisted table %s survived the open: %v", orphan, err)
+	}
+	if _, err := fs.Stat(strings.TrimSuffix(tmpPaths[0], ".tmp")); !os.IsNotExist(err) {
+		t.Errorf("The table of the failed flush exists: %v", err)
+	}
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get after reopen returned %q, %v", val, err)
+	}
+}
+
+func TestCorruptStateFallsBackToPrevious(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	// Save again, so the previous state lists the flushed table too.
+	db.mu.Lock()
+	err = db.saveState()
+	db.mu.Unlock()
+	if err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+	db.Close()
+
+	// Flip a digit so the JSON stays valid but the checksum no longer matches.
+	statePath := filepath.Join(dir, stateFile)
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	corrupt := strings.Replace(string(data), `"next_file_number": 2`, `"next_file_number": 7`, 1)
+	if corrupt == string(data) {
+		t.Fatalf("Unexpected state file contents:\n%s", data)
+	}
+	if err := os.WriteFile(statePath, []byte(corrupt), 0644); err != nil {
+		t.Fatalf("Failed to corrupt state: %v", err)
+	}
+	if _, err := readState(OSFS{}, statePath); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("Expected the checksum mismatch to be detected, got %v", err)
+	}
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Expected to open with the previous state, got %v", err)
+	}
+	defer db.Close()
+	if db.nextFileNumber == 7 {
+		t.Errorf("Expected the corrupt state to be ignored")
+	}
+
+	// A state with a format but a zeroed checksum is not trusted.
+	state, err := readState(OSFS{}, statePath)
+	if err != nil {
+		t.Fatalf("readState failed: %v", err)
+	}
+	state.CRC = 0
+	data, _ = json.Marshal(state)
+	os.WriteFile(statePath+".bad", data, 0644)
+	if _, err := readState(OSFS{}, statePath+".bad"); err == nil {
+		t.Errorf("Expected a state without checksum to be rejected")
+	}
+}
+
+func TestStalePreviousStateIsNotUsed(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	// The previous state lists the inputs, which the compaction deletes.
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	db.Close()
+	if prev, err := readState(OSFS{}, filepath.Join(dir, prevStateFile)); err != nil || len(prev.ActiveSSTables) != 2 {
+		t.Fatalf("Expected the previous state to list both inputs, got %v, %v", prev.ActiveSSTables, err)
+	}
+	os.WriteFile(filepath.Join(dir, stateFile), []byte("{"), 0644)
+
+	if _, err := NewDB(dir); err == nil {
+		t.Fatalf("Expected Open to fail rather than use a state listing deleted tables")
+	}
+}
+
+func TestGetLiveFilesPinsTables(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 2; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := db.Put(WriteOptions{}, generateKey(2), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	lf, err := db.GetLiveFiles(true)
+	if err != nil {
+		t.Fatalf("GetLiveFiles failed: %v", err)
+	}
+	if len(lf.Tables) != 3 || lf.Tables[0].Size == 0 || lf.Tables[2].SmallestKey != string(generateKey(2)) {
+		t.Fatalf("Unexpected live tables %+v", lf.Tables)
+	}
+	if err := db.Put(WriteOptions{}, generateKey(3), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	var state DBState
+	if err := json.Unmarshal(lf.State, &state); err != nil || len(state.ActiveSSTables) != 3 || state.LastSequence != lf.LastSequence {
+		t.Errorf("Unexpected state %s for the live files: %v", lf.State, err)
+	}
+	if w := lf.WALFiles[len(lf.WALFiles)-1]; w.Name != "db.wal" || w.Size != int64(wal.HeaderSize) {
+		t.Errorf("Expected the flushed WAL to be empty in the snapshot, got %+v", w)
+	}
+
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	for _, table := range lf.Tables {
+		if _, err := os.Stat(filepath.Join(lf.Dir, table.Name)); err != nil {
+			t.Errorf("Expected %s to stay until Release: %v", table.Name, err)
+		}
+	}
+	lf.Release()
+	if _, err := os.Stat(filepath.Join(lf.Dir, lf.Tables[0].Name)); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be deleted after Release, got %v", lf.Tables[0].Name, err)
+	}
+}
+
+func TestGetAtSkipsNewerTables(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	for _, key := range []string{"a", "b"} {
+		db.Put(WriteOptions{}, []byte(key), []byte("v"))
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	newer := db.current.tables[1]
+	if seqs := db.current.seqs[newer]; seqs != (SeqRange{2, 2}) {
+		t.Fatalf("Flushed table has sequence numbers %+v, want 2 to 2", seqs)
+	}
+	db.tableCache.Purge()
+	if _, err := db.GetAt(1, []byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAt(1, b) returned %v, want ErrNotFound", err)
+	}
+	if db.tableCache.Contains(newer) {
+		t.Errorf("GetAt opened table %d, whose entries are all newer than the read", newer)
+	}
+	if val, err := db.Get([]byte("b")); err != nil || string(val) != "v" {
+		t.Errorf("Get(b) = %q, %v", val, err)
+	}
+}
+
+func TestTableSeqs(t *testing.T) {
+	fs := NewMemFS()
+	opts := &Options{FS: fs, DisableAutoCompactions: true}
+	db, err := Open("db", opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i%15), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if i%10 == 9 {
+			if err := db.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+		}
+	}
+	lf, err := db.GetLiveFiles(false)
+	if err != nil {
+		t.Fatalf("GetLiveFiles failed: %v", err)
+	}
+	lf.Release()
+	for i, table := range lf.Tables {
+		first, last := uint64(10*i+1), uint64(10*i+10)
+		if table.SmallestSeq != first || table.LargestSeq != last {
+			t.Errorf("Table %d has sequence numbers %d to %d, want %d to %d", i, table.SmallestSeq, table.LargestSeq, first, last)
+		}
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	num := db.current.tables[0]
+	r, err := db.findTable(num)
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	props := r.Properties()
+	r.Unref()
+	// The older versions of the first five keys are gone.
+	if props.SmallestSeq != 6 || props.LargestSeq != 20 {
+		t.Errorf("Compacted table has sequence numbers %d to %d, want 6 to 20", props.SmallestSeq, props.LargestSeq)
+	}
+	db.Close()
+
+	state, err := loadState(fs, "db")
+	if err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	if seqs := state.TableSeqs[num]; seqs != (SeqRange{6, 20}) {
+		t.Errorf("State records %+v for table %d", seqs, num)
+	}
+
+	// A state without the ranges gets them from the tables, and a state that
+	// lost track of the last sequence number resumes after them.
+	state.TableSeqs = nil
+	state.LastSequence = 0
+	if err := writeState(fs, "db", state); err != nil {
+		t.Fatalf("writeState failed: %v", err)
+	}
+	db, err = Open("db", opts)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if seqs := db.current.seqs[num]; seqs != (SeqRange{6, 20}) {
+		t.Errorf("Reopened database has %+v for table %d", seqs, num)
+	}
+	db.Close()
+	state, _ = loadState(fs, "db")
+	state.LastSequence = 0
+	writeState(fs, "db", state)
+	db, err = Open("db", opts)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer db.Close()
+	if seq := db.sequenceNum.Load(); seq != 20 {
+		t.Errorf("Reopened database resumes after sequence number %d, want 20", seq)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("b"), []byte("2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	it := db.NewIterator()
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+
+	usage, err := db.DiskUsage()
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if usage.LiveTables == 0 || usage.WAL == 0 || usage.ObsoleteTables == 0 {
+		t.Errorf("Expected live, obsolete and WAL bytes, got %+v", usage)
+	}
+	if usage.Total < usage.LiveTables+usage.ObsoleteTables+usage.WAL {
+		t.Errorf("Total %d is less than its parts %+v", usage.Total, usage)
+	}
+
+	it.Close() // Releases the compacted table
+	if usage, err = db.DiskUsage(); err != nil || usage.ObsoleteTables != 0 {
+		t.Errorf("Expected no obsolete tables after the iterator closed, got %+v, %v", usage, err)
+	}
+}
+
+func TestEstimateNumKeys(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 100; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Delete(WriteOptions{}, generateKey(i)); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	if n := db.EstimateNumKeys(); n != 90 {
+		t.Errorf("EstimateNumKeys() = %d, want 90", n)
+	}
+}
+
+func TestStatsProperty(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 2; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+
+	report, ok := db.GetProperty("leveldb.stats")
+	if !ok {
+		t.Fatalf("Expected leveldb.stats to be supported")
+	}
+	for _, want := range []string{"Flushes: 2,", "Compactions: 1,", "Read amplification: 1"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected %q in stats report:\n%s", want, report)
+		}
+	}
+	if _, ok := db.GetProperty("leveldb.unknown"); ok {
+		t.Errorf("Expected unknown property to be unsupported")
+	}
+}
+
+func TestAmplification(t *testing.T) {
+	db := openTestDB(t)
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 50; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	amp := db.Amplification()
+	userBytes := int64(50 * (len(generateKey(0)) + len(value)))
+	if amp.UserBytesWritten != userBytes {
+		t.Errorf("UserBytesWritten = %d, want %d", amp.UserBytesWritten, userBytes)
+	}
+	// Every byte goes to the WAL and to a table, plus framing.
+	if waf := amp.WriteAmplification(); waf < 2 {
+		t.Errorf("WriteAmplification() = %.2f, want at least 2 (%+v)", waf, amp)
+	}
+
+	db.Get(generateKey(1))
+	amp = db.Amplification()
+	if amp.UserBytesRead != int64(len(generateKey(1))+len(value)) || amp.DiskBytesRead == 0 {
+		t.Errorf("After a cold lookup: %+v", amp)
+	}
+	if raf := amp.ReadAmplification(); raf <= 1 {
+		t.Errorf("ReadAmplification() = %.2f, want above 1 for a cold lookup", raf)
+	}
+	db.Get(generateKey(2))
+	if cached := db.Amplification(); cached.DiskBytesRead != amp.DiskBytesRead {
+		t.Errorf("A cached lookup read %d bytes from disk", cached.DiskBytesRead-amp.DiskBytesRead)
+	}
+}
+
+func TestMetaCacheSurvivesScans(t *testing.T) {
+	// A scan through the data-block cache leaves the metadata cache alone.
+	db := openTestDB(t)
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), make([]byte, 1024)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	it := db.NewIterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+	}
+	it.Close()
+	if _, ok := db.blockCache.Get(fmt.Sprintf("%d:index", db.current.tables[0])); !ok {
+		t.Errorf("Expected the index block to stay cached after a scan")
+	}
+}
+
+func TestReaderLoadsMetadataLazily(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	r, err := db.findTable(db.current.tables[0])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	defer r.Unref()
+	if db.blockCache.MetaUsage() != 0 {
+		t.Fatalf("Expected opening a table not to load its index or filter, cached %d bytes", db.blockCache.MetaUsage())
+	}
+	if _, err := db.Get([]byte("a")); err != nil {
+		t.Fatalf("Expected a to be found")
+	}
+	if db.blockCache.MetaUsage() == 0 {
+		t.Errorf("Expected the first read to cache the index and filter")
+	}
+}
+
+func TestSeekCompaction(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{FilterPolicy: FilterPolicyNone})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for _, keys := range [][]string{{"a", "c"}, {"b", "d"}} {
+		for _, key := range keys {
+			if err := db.Put(WriteOptions{}, []byte(key), []byte("v")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	// Without a filter, every lookup of c probes the overlapping newer table in vain.
+	for i := 0; i < MinAllowedSeeks; i++ {
+		if _, err := db.Get([]byte("c")); err != nil {
+			t.Fatalf("Expected c to be found")
+		}
+	}
+	db.PauseBackgroundWork() // Waits for the seek compaction
+	defer db.ContinueBackgroundWork()
+	if len(db.current.tables) != 1 {
+		t.Errorf("Expected the tables to be merged after %d wasted seeks, have %v", MinAllowedSeeks, db.current.tables)
+	}
+}
+
+func TestGetSkipsTablesOutsideKeyRange(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{FilterPolicy: FilterPolicyNone})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for _, keys := range [][]string{{"a", "c"}, {"x", "z"}} {
+		for _, key := range keys {
+			if err := db.Put(WriteOptions{}, []byte(key), []byte("v")); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	// Without a filter, only the key range keeps Get from reading the newer table.
+	if _, err := db.Get([]byte("a")); err != nil {
+		t.Fatalf("Expected a to be found")
+	}
+	if _, err := db.Get([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected b to be absent")
+	}
+	db.fileMu.Lock()
+	defer db.fileMu.Unlock()
+	if seeks, ok := db.fileSeeks[db.current.tables[1]]; ok {
+		t.Errorf("Expected no probe of the table holding x..z, it has %d seeks left", seeks)
+	}
+}
+
+func TestShardedMemtable(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableShards: 4})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	batch := NewWriteBatch()
+	for i := 0; i < 2*memtable.ParallelInsertThreshold; i++ {
+		batch.Put(generateKey(i), []byte("v1"))
+	}
+	batch.Put(generateKey(0), []byte("v2")) // Later entries in a batch win
+	if err := db.Write(WriteOptions{}, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	check := func() {
+		t.Helper()
+		count := 0
+		it := db.NewIterator()
+		var last string
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			if key := it.Key().UserKey; key <= last {
+				t.Fatalf("Keys out of order: %q after %q", key, last)
+			}
+			last = it.Key().UserKey
+			count++
+		}
+		it.Close()
+		if count != 2*memtable.ParallelInsertThreshold {
+			t.Errorf("Expected %d keys, got %d", 2*memtable.ParallelInsertThreshold, count)
+		}
+		if val, _ := db.Get(generateKey(0)); string(val) != "v2" {
+			t.Errorf("Get(key0) = %q, want v2", val)
+		}
+	}
+	check()
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	check()
+}
+
+func TestShardedMemtableConcurrentWriters(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableShards: 4, MinMemtableSize: 32 << 10, MaxMemtableSize: 32 << 10})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	const writers, perWriter = 8, 300
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Go(func() {
+			for i := w * perWriter; i < (w+1)*perWriter; i++ {
+				if err := db.Put(WriteOptions{}, generateKey(i), generateValue(100)); err != nil {
+					t.Errorf("Put failed: %v", err)
+					return
+				}
+				// A writer reads its own write as soon as Put returns.
+				if _, err := db.Get(generateKey(i)); err != nil {
+					t.Errorf("Key %d not found right after Put", i)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if seq := db.sequenceNum.Load(); seq != writers*perWriter {
+		t.Errorf("Sequence number = %d, want %d", seq, writers*perWriter)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < writers*perWriter; i++ {
+		if _, err := db.Get(generateKey(i)); err != nil {
+			t.Errorf("Key %d lost", i)
+		}
+	}
+}
+
+func TestChecksumTypes(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, &Options{Checksum: ChecksumXXHash64Low32})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("flushed"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("logged"), []byte("2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	db.Close()
+
+	// Files are verified with the type they were written with.
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	for _, k := range []string{"flushed", "logged"} {
+		if _, err := db.Get([]byte(k)); err != nil {
+			t.Errorf("Key %s not found after reopen", k)
+		}
+	}
+	r, err := db.findTable(db.current.tables[0])
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	if got := r.Properties().Checksum; got != ChecksumXXHash64Low32 {
+		t.Errorf("Table checksum = %v, want xxhash64-low32", got)
+	}
+	path := tablePath(dir, db.current.tables[0])
+	r.Unref()
+	db.Close()
+
+	// A flipped bit in a data block is detected.
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read table: %v", err)
+	}
+	raw[10] ^= 0x01
+	corruptPath := filepath.Join(t.TempDir(), "corrupt.sst")
+	if err := os.WriteFile(corruptPath, raw, 0644); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+	reader, err := NewSSTableReader(corruptPath, nil)
+	if err != nil {
+		t.Fatalf("NewSSTableReader failed: %v", err)
+	}
+	defer reader.Close()
+	_, _, err = reader.Get([]byte("flushed"))
+	var ce *CorruptionError
+	if !errors.As(err, &ce) || ce.File != corruptPath || ce.Offset != 0 {
+		t.Errorf("Expected a corrupt block at offset 0 of %s, got %v", corruptPath, err)
+	}
+}
+
+func TestReplayLegacyWAL(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "db.wal")
+	w, err := NewWAL(walPath, 0, base.ChecksumLegacy)
+	if err != nil {
+		t.Fatalf("NewWAL failed: %v", err)
+	}
+	if err := w.Write(&LogEntry{Op: OpPut, Key: []byte("a"), Value: []byte("1"), SeqNum: 1}, false); err != nil {
+		t.Fatalf("WAL write failed: %v", err)
+	}
+	w.Close()
+
+	// WALs written before the header existed start with the first record.
+	raw, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	if err := os.WriteFile(walPath, raw[wal.HeaderSize:], 0644); err != nil {
+		t.Fatalf("Failed to write WAL: %v", err)
+	}
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get(a) = %q, %v; want 1, nil", val, err)
+	}
+}
+
+func TestParallelWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	n := 3*replayChunkSize + 7
+	for i := 0; i < n; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	db.Close()
+
+	walPath := filepath.Join(dir, "db.wal")
+	data, lastSeq, err := Replay(walPath)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(data) != n || lastSeq != uint64(n) {
+		t.Fatalf("Replayed %d entries up to sequence %d, want %d", len(data), lastSeq, n)
+	}
+
+	// Corrupt one byte in the middle of the log.
+	raw, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	raw[len(raw)/2] ^= 0xFF
+	if err := os.WriteFile(walPath, raw, 0644); err != nil {
+		t.Fatalf("Failed to write WAL: %v", err)
+	}
+	if _, _, err := Replay(walPath); err == nil {
+		t.Errorf("Expected corruption to be detected")
+	}
+}
+
+func TestRecoverySequenceWarnings(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, seqs ...uint64) {
+		wal, err := NewWAL(filepath.Join(dir, name), 0, ChecksumCRC32C)
+		if err != nil {
+			t.Fatalf("NewWAL failed: %v", err)
+		}
+		for _, seq := range seqs {
+			entry := &LogEntry{Op: OpPut, Key: generateKey(int(seq)), Value: []byte("v"), SeqNum: seq}
+			if err := wal.Write(entry, false); err != nil {
+				t.Fatalf("WAL write failed: %v", err)
+			}
+		}
+		wal.Close()
+	}
+	write("wal-00001.log", 1, 2, 3)
+	write("db.wal", 6, 5, 7) // Sequence numbers 4 and 5 were lost, 5 then reappears
+
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	want := []RecoveryWarning{
+		{Kind: RecoverySequenceGap, WAL: "db.wal", Expected: 4, Got: 6},
+		{Kind: RecoveryDuplicateSequence, WAL: "db.wal", Expected: 7, Got: 5},
+	}
+	if got := db.RecoveryWarnings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RecoveryWarnings() = %v, want %v", got, want)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 8; i < 10; i++ {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if got := db.RecoveryWarnings(); len(got) != 0 {
+		t.Errorf("Expected no warnings for contiguous WALs, got %v", got)
+	}
+}
+
+func TestRecoveryFlushesLargeWAL(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := NewWAL(filepath.Join(dir, "db.wal"), 0, ChecksumCRC32C)
+	if err != nil {
+		t.Fatalf("NewWAL failed: %v", err)
+	}
+	value := bytes.Repeat([]byte("v"), 64*1024)
+	n := 2*MemtableSizeThreshold/len(value) + 10
+	for i := 0; i < n; i++ {
+		entry := &LogEntry{Op: OpPut, Key: generateKey(i), Value: value, SeqNum: uint64(i + 1)}
+		if err := wal.Write(entry, false); err != nil {
+			t.Fatalf("WAL write failed: %v", err)
+		}
+	}
+	wal.Close()
+
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if got := len(db.current.tables); got < 2 {
+		t.Errorf("Recovery produced %d SSTables, want at least 2", got)
+	}
+	if size := db.mem.ApproximateSize(); size != 0 {
+		t.Errorf("Memtable holds %d bytes after recovery, want 0", size)
+	}
+	for _, i := range []int{0, n / 2, n - 1} {
+		if got, err := db.Get(generateKey(i)); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("Get(%d) = %d bytes, %v", i, len(got), err)
+		}
+	}
+	tables := len(db.current.tables)
+	db.Close()
+
+	// The replayed log must not be applied again.
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if got := len(db.current.tables); got != tables {
+		t.Errorf("Reopen has %d SSTables, want %d", got, tables)
+	}
+}
+
+func TestRecoverySkipsFlushedWAL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	if err := db.Put(WriteOptions{}, []byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	db.Close()
+	stale, err := os.ReadFile(filepath.Join(dir, "db.wal"))
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	sstNum := db.current.tables[len(db.current.tables)-1]
+	db.Close()
+
+	// Pretend the flush crashed before deleting its rotated WAL.
+	walPath := filepath.Join(dir, fmt.Sprintf("wal-%05d.log", sstNum))
+	if err := os.WriteFile(walPath, stale, 0644); err != nil {
+		t.Fatalf("Failed to restore WAL: %v", err)
+	}
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if n := db.mem.Len(); n != 0 {
+		t.Errorf("Flushed WAL was replayed into the memtable (%d entries)", n)
+	}
+	if _, err := os.Stat(walPath); !os.IsNotExist(err) {
+		t.Errorf("Flushed WAL %s was not removed", walPath)
+	}
+	if got, err := db.Get([]byte("key")); err != nil || string(got) != "value" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+}
+
+func TestIteratorPrev(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MemtableShards: 4})
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+	model := make(map[string]string)
+	put := func(i int, v string) {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte(v)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		model[string(generateKey(i))] = v
+	}
+	// Spread versions and tombstones over two tables and the memtable.
+	for i := 0; i < 500; i++ {
+		put(i, "old")
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < 500; i += 3 {
+		put(i, "new")
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < 500; i += 5 {
+		if err := db.Delete(WriteOptions{}, generateKey(i)); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		delete(model, string(generateKey(i)))
+	}
+	put(7, "newest")
+
+	keys := make([]string, 0, len(model))
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	it := db.NewIterator()
+	defer it.Close()
+	i := len(keys) - 1
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		if i < 0 || it.Key().UserKey != keys[i] || string(it.Value()) != model[keys[i]] {
+			t.Fatalf("At %d got %q=%q", i, it.Key().UserKey, it.Value())
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("Reverse scan stopped with %d keys left", i+1)
+	}
+
+	// Change direction in the middle of the key space.
+	it.SeekToFirst()
+	for j := 0; j < 100; j++ {
+		it.Next()
+	}
+	if it.Key().UserKey != keys[100] {
+		t.Fatalf("Forward position %q, want %q", it.Key().UserKey, keys[100])
+	}
+	it.Prev()
+	it.Prev()
+	if it.Key().UserKey != keys[98] {
+		t.Errorf("After Prev got %q, want %q", it.Key().UserKey, keys[98])
+	}
+	it.Next()
+	if it.Key().UserKey != keys[99] || string(it.Value()) != model[keys[99]] {
+		t.Errorf("After Next got %q=%q, want %q", it.Key().UserKey, it.Value(), keys[99])
+	}
+}
+
+func TestIteratorSeek(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 300; i += 2 {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.Delete(WriteOptions{}, generateKey(100)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+	tests := []struct {
+		seek int
+		want int // -1 if the iterator must be exhausted
+	}{
+		{0, 0},
+		{51, 52},
+		{100, 102}, // Deleted
+		{298, 298},
+		{299, -1},
+	}
+	for _, tt := range tests {
+		it.Seek(generateKey(tt.seek))
+		if tt.want < 0 {
+			if it.Valid() {
+				t.Errorf("Seek(%d) landed on %q, want end", tt.seek, it.Key().UserKey)
+			}
+			continue
+		}
+		if !it.Valid() || it.Key().UserKey != string(generateKey(tt.want)) {
+			t.Errorf("Seek(%d) did not land on %d", tt.seek, tt.want)
+		}
+	}
+
+	// Stepping back from 102 skips the deleted 100.
+	it.Seek(generateKey(101))
+	it.Prev()
+	if !it.Valid() || it.Key().UserKey != string(generateKey(98)) {
+		t.Errorf("Prev after Seek landed on the wrong key")
+	}
+}
+
+func TestIteratorSeekForPrev(t *testing.T) {
+	db := openTestDB(t)
+	for i := 2; i < 300; i += 2 {
+		if err := db.Put(WriteOptions{}, generateKey(i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if i == 150 {
+			if err := db.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+		}
+	}
+	if err := db.Delete(WriteOptions{}, generateKey(100)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+	tests := []struct {
+		seek int
+		want int // -1 if the iterator must be exhausted
+	}{
+		{0, -1},
+		{2, 2},
+		{51, 50},
+		{101, 98}, // 100 is deleted
+		{152, 152},
+		{153, 152},
+		{999, 298},
+	}
+	for _, tt := range tests {
+		it.SeekForPrev(generateKey(tt.seek))
+		if tt.want < 0 {
+			if it.Valid() {
+				t.Errorf("SeekForPrev(%d) landed on %q, want end", tt.seek, it.Key().UserKey)
+			}
+			continue
+		}
+		if !it.Valid() || it.Key().UserKey != string(generateKey(tt.want)) {
+			t.Errorf("SeekForPrev(%d) did not land on %d", tt.seek, tt.want)
+		}
+	}
+
+	// Iteration continues forward from the floor entry.
+	it.SeekForPrev(generateKey(99))
+	it.Next()
+	if !it.Valid() || it.Key().UserKey != string(generateKey(102)) {
+		t.Errorf("Next after SeekForPrev landed on the wrong key")
+	}
+
+	// A prefix iterator stays within its prefix.
+	pit := db.NewPrefixIterator(generateKey(1)[:len(generateKey(1))-1])
+	defer pit.Close()
+	pit.SeekForPrev(generateKey(999))
+	if !pit.Valid() || pit.Key().UserKey != string(generateKey(8)) {
+		t.Errorf("Prefix SeekForPrev did not land on the last key of the prefix")
+	}
+}
+
+func TestMergeSSTablesReadsOnlyDataBlocks(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, from, to int, seq uint64) string {
+		path := filepath.Join(dir, name)
+		w, err := NewSSTableWriter(path, nil)
+		if err != nil {
+			t.Fatalf("NewSSTableWriter failed: %v", err)
+		}
+		for i := from; i < to; i++ {
+			key := InternalKey{UserKey: string(generateKey(i)), SeqNum: seq, Type: OpTypePut}
+			if err := w.Add(key, []byte(fmt.Sprint(seq))); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		if err := w.Finish(); err != nil {
+			t.Fatalf("Finish failed: %v", err)
+		}
+		return path
+	}
+	older := write("00001.sst", 0, 1000, 1)
+	newer := write("00002.sst", 500, 1500, 2)
+	out := filepath.Join(dir, "00003.sst")
+	if err := MergeSSTables([]string{older, newer}, out, nil); err != nil {
+		t.Fatalf("MergeSSTables failed: %v", err)
+	}
+	r, err := NewSSTableReader(out, cache.New(BlockCacheSize, DefaultMetaCacheSize))
+	if err != nil {
+		t.Fatalf("NewSSTableReader failed: %v", err)
+	}
+	defer r.Close()
+	if n := r.Properties().NumEntries; n != 1500 {
+		t.Errorf("Merged table has %d entries, want 1500", n)
+	}
+	if v, _, _ := r.Get(generateKey(700)); string(v) != "2" {
+		t.Errorf("Get(700) = %q, want the newer version", v)
+	}
+
+	// A corrupt data block is an error rather than the end of the input.
+	raw, err := os.ReadFile(older)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	binary.LittleEndian.PutUint32(raw[0:4], 1<<30)
+	if err := os.WriteFile(older, raw, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := MergeSSTables([]string{older, newer}, filepath.Join(dir, "00004.sst"), nil); err == nil {
+		t.Errorf("Expected an error for a corrupt input")
+	}
+}
+
+func TestAdaptiveMemtableLimit(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{MinMemtableSize: 1 << 20, MaxMemtableSize: 16 << 20})
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+	if got := db.memtableLimit.Load(); got != MemtableSizeThreshold {
+		t.Fatalf("Initial limit = %d, want %d", got, MemtableSizeThreshold)
+	}
+
+	// A memtable that fills during a running flush grows the limit once.
+	db.mu.Lock()
+	db.immutableMem = NewMemtable()
+	db.mu.Unlock()
+	db.flushMemtable()
+	db.flushMemtable()
+	if got := db.memtableLimit.Load(); got != 2*MemtableSizeThreshold {
+		t.Errorf("Limit after backlog = %d, want %d", got, 2*MemtableSizeThreshold)
+	}
+	db.mu.Lock()
+	db.immutableMem = nil
+	for i := 0; i < 5; i++ {
+		db.adjustMemtableLimitLocked(1, 2)
+	}
+	db.mu.Unlock()
+	if got := db.memtableLimit.Load(); got != 1<<20 {
+		t.Errorf("Limit = %d, want the minimum %d", got, 1<<20)
+	}
+}
+
+func TestWriteBufferManager(t *testing.T) {
+	wbm := NewWriteBufferManager(256*1024, false)
+	open := func() *DB {
+		db, err := Open(t.TempDir(), &Options{WriteBufferManager: wbm})
+		if err != nil {
+			t.Fatalf("Failed to create DB: %v", err)
+		}
+		return db
+	}
+	db1, db2 := open(), open()
+	value := bytes.Repeat([]byte("v"), 10*1024)
+	for i := 0; i < 20; i++ {
+		if err := db1.Put(WriteOptions{}, generateKey(i), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if n := len(db1.current.tables); n != 0 {
+		t.Fatalf("db1 flushed below the budget")
+	}
+	// db2 pushes the shared usage over the budget and flushes its memtable.
+	for i := 0; i < 10; i++ {
+		if err := db2.Put(WriteOptions{}, generateKey(i), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db2.waitForFlush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db2.mu.RLock()
+	tables := len(db2.current.tables)
+	db2.mu.RUnlock()
+	if tables == 0 {
+		t.Errorf("db2 did not flush when the shared budget was exceeded")
+	}
+	if usage := wbm.MemoryUsage(); usage > wbm.BufferSize() {
+		t.Errorf("Usage %d still above the budget after the flush", usage)
+	}
+
+	db1.Close()
+	db2.Close()
+	if usage := wbm.MemoryUsage(); usage != 0 {
+		t.Errorf("Usage after Close = %d, want 0", usage)
+	}
+}
+
+func TestWriteBufferManagerChargedCache(t *testing.T) {
+	const budget = 1 << 20
+	wbm := NewWriteBufferManager(budget, true)
+	db, err := Open(t.TempDir(), &Options{WriteBufferManager: wbm, BlockCacheSize: budget})
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+	value := bytes.Repeat([]byte("v"), 1024)
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := db.Put(WriteOptions{}, generateKey(i), value); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+	}
+	tables := func() int {
+		if err := db.waitForFlush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return len(db.current.tables)
+	}
+	put(0, 2048)
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := 0; i < 2048; i++ {
+		db.Get(generateKey(i))
+	}
+	if usage := db.blockCache.Usage(); usage < budget*9/10 {
+		t.Fatalf("Block cache holds %d bytes, want it nearly full", usage)
+	}
+	flushed := tables()
+
+	// The full cache leaves the memtables a quarter of the budget.
+	put(10000, 10128)
+	if n := tables(); n != flushed {
+		t.Fatalf("Flushed a %d byte memtable under a full cache", db.mem.ApproximateSize())
+	}
+	put(10128, 10512)
+	if n := tables(); n == flushed {
+		t.Errorf("Did not flush once the memtables exceeded a quarter of the budget")
+	}
+}
@@ -0,0 +1,186 @@
+package leveldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A dump written by Dump is a logical backup of the keyspace:
+//
+//	header  = "GLDBDUMP" | version (1 byte) | sequence number (8 bytes)
+//	pair    = 'p' | key length (uvarint) | value length (uvarint) | key | value | CRC32C
+//	trailer = 'e' | number of pairs (8 bytes) | CRC32C
+//
+// Integers are little-endian. The pairs are in key order, and the CRC32C of a
+// pair or trailer covers all of its bytes before the CRC. The trailer marks a
+// complete dump, so a truncated one is never loaded.
+const (
+	dumpMagic   = "GLDBDUMP"
+	dumpVersion = 1
+	dumpPair    = 'p'
+	dumpEnd     = 'e'
+)
+
+// DumpOptions select what Dump writes.
+type DumpOptions struct {
+	// Start and End bound the keys written to [Start, End). A nil End means no
+	// upper bound.
+	Start, End []byte
+	// Seq is the sequence number the dump observes, see GetAt. Zero dumps the
+	// database as of the call.
+	Seq uint64
+}
+
+// Dump streams the live keys to w in the dump format above. Every key is read
+// at one sequence number, so writes made during the dump are not in it, and
+// compactions cannot remove the tables it reads.
+func (db *DB) Dump(w io.Writer, opts DumpOptions) error {
+	seq := opts.Seq
+	if seq == 0 {
+		seq = db.sequenceNum.Load()
+	}
+	bw := bufio.NewWriter(w)
+	header := binary.LittleEndian.AppendUint64(append([]byte(dumpMagic), dumpVersion), seq)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	it := db.NewIteratorAt(seq)
+	defer it.Close()
+	var frame []byte
+	var n uint64
+	for it.Seek(opts.Start); it.Valid(); it.Next() {
+		key := it.Key().UserKey
+		if opts.End != nil && key >= string(opts.End) {
+			break
+		}
+		value := it.Value()
+		frame = append(frame[:0], dumpPair)
+		frame = binary.AppendUvarint(frame, uint64(len(key)))
+		frame = binary.AppendUvarint(frame, uint64(len(value)))
+		frame = append(append(frame, key...), value...)
+		frame = binary.LittleEndian.AppendUint32(frame, ChecksumCRC32C.Sum(frame))
+		if _, err := bw.Write(frame); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	frame = binary.LittleEndian.AppendUint64(append(frame[:0], dumpEnd), n)
+	frame = binary.LittleEndian.AppendUint32(frame, ChecksumCRC32C.Sum(frame))
+	if _, err := bw.Write(frame); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Load restores a dump written by Dump through ImportSorted, so the dump is
+// loaded completely or not at all. Keys of the dump replace those already in
+// the database; other keys are kept.
+func (db *DB) Load(r io.Reader) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(dumpMagic)+1+8)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(dumpMagic)]) != dumpMagic {
+		return fmt.Errorf("%w: missing header", ErrBadDump)
+	}
+	if v := header[len(dumpMagic)]; v != dumpVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrBadDump, v)
+	}
+	return db.ImportSorted(&dumpFrameReader{r: br})
+}
+
+// dumpFrameReader reads the pairs of a dump as a KVReader, verifying each
+// frame, and reports io.EOF only after a valid trailer.
+type dumpFrameReader struct {
+	r     *bufio.Reader
+	frame []byte
+	n     uint64
+}
+
+func (d *dumpFrameReader) Next() ([]byte, []byte, error) {
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return nil, nil, d.truncated(err)
+	}
+	d.frame = append(d.frame[:0], kind)
+	switch kind {
+	case dumpPair:
+		keyLen, err := d.uvarint()
+		if err != nil {
+			return nil, nil, err
+		}
+		valueLen, err := d.uvarint()
+		if err != nil {
+			return nil, nil, err
+		}
+		if keyLen > maxEncodedSize || valueLen > maxEncodedSize {
+			return nil, nil, fmt.Errorf("%w: pair %d claims %d bytes", ErrBadDump, d.n+1, keyLen+valueLen)
+		}
+		if err := d.read(int(keyLen + valueLen)); err != nil {
+			return nil, nil, err
+		}
+		body := d.frame[len(d.frame)-int(keyLen+valueLen):]
+		if err := d.verify(); err != nil {
+			return nil, nil, err
+		}
+		d.n++
+		return body[:keyLen], body[keyLen:], nil
+	case dumpEnd:
+		if err := d.read(8); err != nil {
+			return nil, nil, err
+		}
+		count := binary.LittleEndian.Uint64(d.frame[1:])
+		if err := d.verify(); err != nil {
+			return nil, nil, err
+		}
+		if count != d.n {
+			return nil, nil, fmt.Errorf("%w: trailer counts %d pairs, read %d", ErrBadDump, count, d.n)
+		}
+		return nil, nil, io.EOF
+	}
+	return nil, nil, fmt.Errorf("%w: unknown frame %q after pair %d", ErrBadDump, kind, d.n)
+}
+
+// uvarint reads a uvarint into the frame.
+func (d *dumpFrameReader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return 0, d.truncated(err)
+	}
+	d.frame = binary.AppendUvarint(d.frame, v)
+	return v, nil
+}
+
+// read appends the next n bytes to the frame.
+func (d *dumpFrameReader) read(n int) error {
+	start := len(d.frame)
+	d.frame = append(d.frame, make([]byte, n)...)
+	if _, err := io.ReadFull(d.r, d.frame[start:]); err != nil {
+		return d.truncated(err)
+	}
+	return nil
+}
+
+// verify reads the CRC of the frame and checks it.
+func (d *dumpFrameReader) verify() error {
+	var crc [4]byte
+	if _, err := io.ReadFull(d.r, crc[:]); err != nil {
+		return d.truncated(err)
+	}
+	if binary.LittleEndian.Uint32(crc[:]) != ChecksumCRC32C.Sum(d.frame) {
+		return fmt.Errorf("%w: checksum mismatch after pair %d", ErrBadDump, d.n)
+	}
+	return nil
+}
+
+// truncated turns the end of the input before the trailer into ErrBadDump.
+func (d *dumpFrameReader) truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: truncated after pair %d", ErrBadDump, d.n)
+	}
+	return err
+}
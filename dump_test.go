@@ -0,0 +1,74 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDumpAndLoad(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	for i := 0; i < 300; i++ {
+		db.Put(wo, generateKey(i), []byte(fmt.Sprint(i)))
+		if i == 150 {
+			db.Flush()
+		}
+	}
+	db.Delete(wo, generateKey(7))
+	seq := db.sequenceNum.Load()
+	db.Put(wo, generateKey(8), []byte("after the dump"))
+
+	var buf bytes.Buffer
+	if err := db.Dump(&buf, DumpOptions{Seq: seq}); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	dump := buf.Bytes()
+
+	restored := openTestDB(t)
+	if err := restored.Load(bytes.NewReader(dump)); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		val, err := restored.Get(generateKey(i))
+		if i == 7 {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Deleted key %d = %q, %v", i, val, err)
+			}
+			continue
+		}
+		if err != nil || string(val) != fmt.Sprint(i) {
+			t.Errorf("Key %d = %q, %v; want the value as of the dump", i, val, err)
+		}
+	}
+
+	// A truncated or damaged dump loads nothing.
+	for name, bad := range map[string][]byte{
+		"truncated": dump[:len(dump)-3],
+		"damaged":   append(append([]byte(nil), dump[:40]...), append([]byte{dump[40] ^ 0xFF}, dump[41:]...)...),
+	} {
+		empty := openTestDB(t)
+		if err := empty.Load(bytes.NewReader(bad)); !errors.Is(err, ErrBadDump) {
+			t.Errorf("Load of a %s dump = %v, want ErrBadDump", name, err)
+		}
+		if _, err := empty.Get(generateKey(0)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load of a %s dump wrote keys", name)
+		}
+	}
+
+	// A range dump holds only the keys in the range.
+	buf.Reset()
+	if err := db.Dump(&buf, DumpOptions{Start: generateKey(10), End: generateKey(20)}); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	ranged := openTestDB(t)
+	if err := ranged.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		if _, err := ranged.Get(generateKey(i)); (err == nil) != (i >= 10 && i < 20) {
+			t.Errorf("Key %d after a range dump: %v", i, err)
+		}
+	}
+}
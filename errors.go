@@ -0,0 +1,57 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for a key that does not exist or was deleted.
+	ErrNotFound = base.ErrNotFound
+	// ErrClosed is returned by every operation on a closed database.
+	ErrClosed = errors.New("database is closed")
+	// ErrDBLocked is returned by Open when another process has the database
+	// open.
+	ErrDBLocked = vfs.ErrLocked
+	// ErrReadOnly is returned by writes, flushes and compactions of a
+	// database that a failed fsync switched to read-only.
+	ErrReadOnly = errors.New("database is read-only")
+	// ErrNoSpace is returned by writes while the disk is full. Reads keep
+	// working, and writes resume once the work that failed succeeds again.
+	ErrNoSpace = errors.New("out of disk space")
+	// ErrQuotaExceeded is returned by writes that would grow a database past
+	// Options.MaxTotalSize.
+	ErrQuotaExceeded = errors.New("database size quota exceeded")
+	// ErrCorruption matches every *CorruptionError with errors.Is.
+	ErrCorruption = base.ErrCorruption
+	// ErrBadDump is returned by Load for input that is not a complete dump.
+	ErrBadDump = errors.New("bad dump")
+	// ErrUnsynced is returned, wrapping the fsync error, by a write that was
+	// applied and is visible but whose WAL sync failed, so a crash may lose it.
+	ErrUnsynced = errors.New("write applied but not synced")
+)
+
+// CorruptionError reports damaged data in a file: a checksum mismatch, or a
+// record, block or table that does not decode.
+type CorruptionError = base.CorruptionError
+
+// LockedError is returned by Open when another process holds the database
+// open. It matches ErrDBLocked with errors.Is.
+type LockedError struct {
+	Dir   string
+	Owner *LockOwner // Recorded in the LOCK file, nil if it names no owner
+}
+
+func (e *LockedError) Error() string {
+	if e.Owner == nil {
+		return fmt.Sprintf("%v: %s", ErrDBLocked, e.Dir)
+	}
+	return fmt.Sprintf("%v: %s is held by pid %d on %s since %s", ErrDBLocked, e.Dir, e.Owner.PID, e.Owner.Host, e.Owner.Opened.Format(time.RFC3339))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrDBLocked
+}
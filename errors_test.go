@@ -0,0 +1,118 @@
+package leveldb
+
+import (
+	"errors"
+	"github.com/quangh33/Go-LevelDB/typedkv"
+	"github.com/quangh33/Go-LevelDB/wal"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBLocked(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+	if _, err := NewDB(dir); !errors.Is(err, ErrDBLocked) {
+		t.Errorf("Expected ErrDBLocked, got %v", err)
+	}
+}
+
+func TestTombstoneNotFound(t *testing.T) {
+	db := openTestDB(t)
+	db.Put(WriteOptions{}, []byte("a"), []byte("1"))
+	db.Delete(WriteOptions{}, []byte("a"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.mu.RLock()
+	reader, err := db.findTable(db.current.tables[len(db.current.tables)-1])
+	db.mu.RUnlock()
+	if err != nil {
+		t.Fatalf("findTable failed: %v", err)
+	}
+	defer reader.Unref()
+	if _, found, err := reader.Get([]byte("a")); !found || !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted key = found %v, %v, want found with ErrNotFound", found, err)
+	}
+}
+
+func TestTypedStoreNotFound(t *testing.T) {
+	db := openTestDB(t)
+	users := typedkv.New(db.TypedBackend(WriteOptions{}), typedkv.Uint64Key{}, typedkv.JSON[string]{})
+	if err := users.Put(1, "ada"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if name, err := users.Get(1); err != nil || name != "ada" {
+		t.Errorf("Get(1) = %q, %v", name, err)
+	}
+	if _, err := users.Get(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestWALCorruptionOffset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.wal")
+	w, err := NewWAL(path, 0, ChecksumCRC32C)
+	if err != nil {
+		t.Fatalf("NewWAL failed: %v", err)
+	}
+	for seq := uint64(1); seq <= 3; seq++ {
+		entry := &LogEntry{Op: OpPut, Key: generateKey(int(seq)), Value: []byte("v"), SeqNum: seq}
+		if err := w.Write(entry, false); err != nil {
+			t.Fatalf("WAL write failed: %v", err)
+		}
+	}
+	w.Close()
+
+	// Flip a byte in the value of the second record.
+	recordSize := 4 + 8 + 4 + 4 + 1 + len(generateKey(1)) + 1
+	second := int64(wal.HeaderSize + recordSize)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	raw[second+int64(recordSize)-1] ^= 0xFF
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatalf("Failed to write WAL: %v", err)
+	}
+
+	_, _, err = Replay(path)
+	var ce *CorruptionError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrCorruption) {
+		t.Fatalf("Expected a CorruptionError, got %v", err)
+	}
+	if ce.File != path || ce.Offset != second {
+		t.Errorf("Corruption at %s offset %d, want %s offset %d", ce.File, ce.Offset, path, second)
+	}
+}
+
+func TestGetReportsReadErrors(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	db.Put(WriteOptions{}, []byte("a"), []byte("1"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	path := tablePath(db.dataDir, db.current.tables[0])
+	db.Close()
+
+	// Tables are opened on first use, so the removal shows up in the read.
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove table: %v", err)
+	}
+	if _, err := db.Get([]byte("a")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get with a missing table = %v, want os.ErrNotExist", err)
+	}
+}
@@ -1,15 +1,31 @@
-module Go-LevelDB
+module github.com/quangh33/Go-LevelDB
 
 go 1.25.1
 
 require (
 	github.com/bits-and-blooms/bloom/v3 v3.7.0
+	github.com/dgraph-io/badger/v4 v4.9.6
+	github.com/gofrs/flock v0.13.0
+	github.com/hashicorp/golang-lru/v2 v2.0.7
 	github.com/huandu/skiplist v1.2.1
+	github.com/syndtr/goleveldb v1.0.0
+	go.etcd.io/bbolt v1.4.3
+	google.golang.org/protobuf v1.36.9
 )
 
 require (
 	github.com/bits-and-blooms/bitset v1.24.1 // indirect
-	github.com/gofrs/flock v0.13.0 // indirect
-	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
-	golang.org/x/sys v0.37.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgraph-io/ristretto/v2 v2.2.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/go-logr/logr v1.4.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/google/flatbuffers v25.2.10+incompatible // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
+	go.opentelemetry.io/otel v1.41.0 // indirect
+	go.opentelemetry.io/otel/metric v1.41.0 // indirect
+	go.opentelemetry.io/otel/trace v1.41.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
 )
@@ -0,0 +1,331 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// indexKeyPrefix marks the reserved keyspace that holds secondary index entries.
+// Keys in this keyspace are hidden from DB iterators and cannot be written directly.
+const indexKeyPrefix = "\x00__index__\x00"
+
+var ErrReservedKey = errors.New("key uses the reserved index keyspace")
+
+// ErrIndexedBlob is returned by PutReader on a database with secondary
+// indexes, which cannot extract index values from a streamed value.
+var ErrIndexedBlob = errors.New("PutReader is not supported with secondary indexes")
+
+// IndexExtractor derives the indexed values of a primary record. A record may
+// produce zero, one or several index values.
+type IndexExtractor func(key, value []byte) [][]byte
+
+type secondaryIndex struct {
+	name    string
+	extract IndexExtractor
+}
+
+// CreateIndex declares a secondary index maintained atomically with every write.
+// Existing records are indexed before CreateIndex returns. Indexes are not
+// persisted, so the application declares them again after every open.
+func (db *DB) CreateIndex(name string, extract IndexExtractor) error {
+	if name == "" || strings.IndexByte(name, 0) >= 0 {
+		return fmt.Errorf("invalid index name %q", name)
+	}
+	if db.opts.TimestampSize > 0 {
+		return fmt.Errorf("secondary indexes are not supported with timestamps")
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	for _, idx := range db.indexes {
+		if idx.name == name {
+			return fmt.Errorf("index %q already exists", name)
+		}
+	}
+	idx := &secondaryIndex{name: name, extract: extract}
+	db.waitForInserts()
+
+	// Backfill the index from the records that are already stored. Entries are
+	// re-put if the index existed in a previous session, which is harmless.
+	backfill := NewWriteBatch()
+	it := db.NewIterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		key := []byte(it.Key().UserKey)
+		for _, v := range extract(key, it.Value()) {
+			backfill.Put(idx.entryKey(v, key), nil)
+		}
+	}
+	err := it.Error()
+	it.Close()
+	if err != nil {
+		return err
+	}
+	if backfill.Len() > 0 {
+		pending, err := db.writeLocked(WriteOptions{Sync: true}, backfill)
+		if err == nil {
+			err = pending.wait()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	db.indexes = append(db.indexes, idx)
+	return nil
+}
+
+// valuePrefix returns the key prefix shared by all entries for one indexed value.
+// Zero bytes in the value are escaped as 0x00 0xFF and the value is terminated
+// by 0x00 0x01, which keeps entries ordered by value and unambiguous to split.
+func (idx *secondaryIndex) valuePrefix(value []byte) []byte {
+	buf := make([]byte, 0, len(indexKeyPrefix)+len(idx.name)+1+len(value)+2)
+	buf = append(buf, indexKeyPrefix...)
+	buf = append(buf, idx.name...)
+	buf = append(buf, 0x00)
+	for _, b := range value {
+		if b == 0x00 {
+			buf = append(buf, 0x00, 0xFF)
+		} else {
+			buf = append(buf, b)
+		}
+	}
+	return append(buf, 0x00, 0x01)
+}
+
+func (idx *secondaryIndex) entryKey(value, primaryKey []byte) []byte {
+	return append(idx.valuePrefix(value), primaryKey...)
+}
+
+// withIndexUpdates returns a batch that contains the original updates plus the
+// index entries they add and remove. The caller must hold db.writeMu so that the
+// previous values read here cannot change before the batch is applied. It fails
+// if a previous value cannot be read, since its index entries would go stale.
+func (db *DB) withIndexUpdates(batch *WriteBatch) (*WriteBatch, error) {
+	if len(db.indexes) == 0 {
+		return batch, nil
+	}
+	db.waitForInserts()
+
+	// Earlier updates in the same batch shadow what is stored in the DB.
+	pending := make(map[string]batchEntry)
+	expanded := NewWriteBatch()
+	seq := db.sequenceNum.Load()
+	for _, e := range batch.entries {
+		if isReservedKey(e.key) {
+			// Index entries written by the DB itself are not indexed again.
+			expanded.entries = append(expanded.entries, e)
+			continue
+		}
+		if e.op == OpTypeBlobRef {
+			return nil, ErrIndexedBlob
+		}
+		if e.op == OpTypeMerge {
+			return nil, ErrIndexedCounter
+		}
+		var oldValue []byte
+		var oldFound bool
+		if prev, ok := pending[string(e.key)]; ok {
+			oldValue, oldFound = prev.value, prev.op == OpTypePut
+		} else {
+			// Blob values written before the index was created are resolved,
+			// just as the backfill saw them.
+			var err error
+			if oldValue, oldFound, err = db.lookup(context.Background(), e.key, seq); err != nil {
+				return nil, fmt.Errorf("reading previous value of %q for indexing: %w", e.key, err)
+			}
+		}
+
+		for _, idx := range db.indexes {
+			if oldFound {
+				for _, v := range idx.extract(e.key, oldValue) {
+					expanded.Delete(idx.entryKey(v, e.key))
+				}
+			}
+			if e.op == OpTypePut {
+				for _, v := range idx.extract(e.key, e.value) {
+					expanded.Put(idx.entryKey(v, e.key), nil)
+				}
+			}
+		}
+		expanded.entries = append(expanded.entries, e)
+		pending[string(e.key)] = e
+	}
+	return expanded, nil
+}
+
+// isReservedKey reports whether key belongs to a keyspace managed by the DB
+// itself: secondary index entries, blob chunks and pending blob markers.
+func isReservedKey(key []byte) bool {
+	return strings.HasPrefix(string(key), indexKeyPrefix) || strings.HasPrefix(string(key), blobKeyPrefix) ||
+		strings.HasPrefix(string(key), blobPendingPrefix)
+}
+
+// hasReservedKey reports whether any update in the batch targets the index keyspace.
+func (b *WriteBatch) hasReservedKey() bool {
+	for _, e := range b.entries {
+		if isReservedKey(e.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexIterator walks the primary keys whose records produced a given index value.
+type IndexIterator struct {
+	iter   Iterator
+	prefix []byte
+	valid  bool
+}
+
+// NewIndexIterator returns an iterator over the primary keys indexed under value.
+// The iterator is positioned at the first match.
+func (db *DB) NewIndexIterator(name string, value []byte) (*IndexIterator, error) {
+	db.writeMu.Lock()
+	var idx *secondaryIndex
+	for _, i := range db.indexes {
+		if i.name == name {
+			idx = i
+		}
+	}
+	db.writeMu.Unlock()
+	if idx == nil {
+		return nil, fmt.Errorf("index %q does not exist", name)
+	}
+
+	it := &IndexIterator{
+		iter:   db.newMergingIterator(db.sequenceNum.Load(), nil),
+		prefix: idx.valuePrefix(value),
+	}
+	it.iter.Seek(it.prefix)
+	it.check()
+	return it, nil
+}
+
+func (it *IndexIterator) check() {
+	it.valid = it.iter.Valid() && strings.HasPrefix(it.iter.Key().UserKey, string(it.prefix))
+}
+
+func (it *IndexIterator) Valid() bool {
+	return it.valid
+}
+
+// PrimaryKey returns the key of the primary record at the current position.
+func (it *IndexIterator) PrimaryKey() []byte {
+	return []byte(it.iter.Key().UserKey[len(it.prefix):])
+}
+
+func (it *IndexIterator) Next() {
+	it.iter.Next()
+	it.check()
+}
+
+func (it *IndexIterator) Close() error {
+	it.valid = false
+	return it.iter.Close()
+}
+
+func (it *IndexIterator) Error() error {
+	return it.iter.Error()
+}
+
+// userKeyIterator hides the reserved keyspaces from DB iterators and resolves
+// blob references to their full values.
+type userKeyIterator struct {
+	Iterator
+	db  *DB
+	seq uint64
+	err error
+}
+
+// Key returns the current key as the application wrote it.
+func (it *userKeyIterator) Key() InternalKey {
+	key := it.Iterator.Key()
+	key.UserKey = it.db.userKeyOf(key.UserKey)
+	return key
+}
+
+func (it *userKeyIterator) Value() []byte {
+	val, err := it.db.resolveValue(it.seq, it.Iterator.Key(), it.Iterator.Value())
+	if err != nil && it.err == nil {
+		it.err = err
+	}
+	it.db.amp.userBytesRead.Add(int64(len(it.Iterator.Key().UserKey) + len(val)))
+	return val
+}
+
+// Error returns the first error of the iteration. A corrupt SSTable is
+// quarantined, so later iterators and reads skip it.
+func (it *userKeyIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	err := it.Iterator.Error()
+	if err != nil {
+		it.db.quarantine(err)
+	}
+	return err
+}
+
+func (it *userKeyIterator) skipReserved() {
+	for it.Iterator.Valid() && isReservedKey([]byte(it.Iterator.Key().UserKey)) {
+		it.Iterator.Next()
+	}
+}
+
+func (it *userKeyIterator) Next() {
+	it.Iterator.Next()
+	it.skipReserved()
+}
+
+func (it *userKeyIterator) SeekToFirst() {
+	it.Iterator.SeekToFirst()
+	it.skipReserved()
+}
+
+// Seek moves to the first key at or after key. With Options.TimestampSize,
+// key has no timestamp and the iterator moves to its newest version.
+func (it *userKeyIterator) Seek(key []byte) {
+	if it.db.opts.TimestampSize > 0 {
+		key = escapeTimestampedKey(key)
+	}
+	it.Iterator.Seek(key)
+	it.skipReserved()
+}
+
+// SeekForPrev moves to the last key at or before key. With
+// Options.TimestampSize, key has no timestamp and the iterator moves to the
+// oldest version of the last key at or before it.
+func (it *userKeyIterator) SeekForPrev(key []byte) {
+	it.Seek(key)
+	if !it.Valid() {
+		if it.Error() == nil {
+			it.SeekToLast()
+		}
+		return
+	}
+	current := it.Key().UserKey
+	if size := it.db.opts.TimestampSize; size > 0 && len(current) >= size {
+		current = current[:len(current)-size]
+	}
+	if current > string(key) {
+		it.Prev()
+	}
+}
+
+func (it *userKeyIterator) skipReservedBackward() {
+	for it.Iterator.Valid() && isReservedKey([]byte(it.Iterator.Key().UserKey)) {
+		it.Iterator.Prev()
+	}
+}
+
+func (it *userKeyIterator) Prev() {
+	it.Iterator.Prev()
+	it.skipReservedBackward()
+}
+
+func (it *userKeyIterator) SeekToLast() {
+	it.Iterator.SeekToLast()
+	it.skipReservedBackward()
+}
@@ -0,0 +1,100 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func collectIndex(t *testing.T, db *DB, name, value string) []string {
+	t.Helper()
+	it, err := db.NewIndexIterator(name, []byte(value))
+	if err != nil {
+		t.Fatalf("NewIndexIterator failed: %v", err)
+	}
+	defer it.Close()
+	var keys []string
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.PrimaryKey()))
+	}
+	return keys
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	// Values look like "<color>:<name>" and are indexed by color.
+	byColor := func(key, value []byte) [][]byte {
+		color, _, ok := bytes.Cut(value, []byte(":"))
+		if !ok {
+			return nil
+		}
+		return [][]byte{color}
+	}
+
+	db.Put(wo, []byte("apple"), []byte("red:apple"))
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	db.Put(wo, []byte("cherry"), []byte("red:cherry"))
+	db.Put(wo, []byte("banana"), []byte("yellow:banana"))
+
+	if got := collectIndex(t, db, "color", "red"); len(got) != 2 || got[0] != "apple" || got[1] != "cherry" {
+		t.Fatalf("Expected [apple cherry], got %v", got)
+	}
+
+	// Updating and deleting records must remove their stale index entries.
+	db.Put(wo, []byte("apple"), []byte("green:apple"))
+	db.Delete(wo, []byte("cherry"))
+	if got := collectIndex(t, db, "color", "red"); len(got) != 0 {
+		t.Fatalf("Expected no red records, got %v", got)
+	}
+	if got := collectIndex(t, db, "color", "green"); len(got) != 1 || got[0] != "apple" {
+		t.Fatalf("Expected [apple], got %v", got)
+	}
+
+	// The index keyspace is hidden from regular iteration.
+	count := 0
+	it := db.NewIterator()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		count++
+	}
+	it.Close()
+	if count != 2 {
+		t.Fatalf("Expected 2 user keys, got %d", count)
+	}
+
+	if err := db.Put(wo, []byte(indexKeyPrefix+"x"), nil); !errors.Is(err, ErrReservedKey) {
+		t.Fatalf("Expected ErrReservedKey, got %v", err)
+	}
+}
+
+func TestSecondaryIndexWithBlobs(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	value := []byte("blue:sky")
+	if err := db.PutReader(wo, []byte("sky"), bytes.NewReader(value), int64(len(value))); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	byColor := func(key, value []byte) [][]byte {
+		color, _, _ := bytes.Cut(value, []byte(":"))
+		return [][]byte{color}
+	}
+	if err := db.CreateIndex("color", byColor); err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if got := collectIndex(t, db, "color", "blue"); len(got) != 1 || got[0] != "sky" {
+		t.Fatalf("Expected the blob to be indexed, got %v", got)
+	}
+
+	// Overwriting the blob removes the entry of its old value.
+	if err := db.Put(wo, []byte("sky"), []byte("grey:sky")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if got := collectIndex(t, db, "color", "blue"); len(got) != 0 {
+		t.Fatalf("Expected no blue records, got %v", got)
+	}
+	if err := db.PutReader(wo, []byte("sea"), bytes.NewReader(value), int64(len(value))); !errors.Is(err, ErrIndexedBlob) {
+		t.Fatalf("Expected ErrIndexedBlob, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package leveldb
+
+import (
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/wal"
+	"sync"
+)
+
+// With a sharded memtable, writers insert their batches outside db.writeMu, so
+// batches of concurrent writers go into the shards in parallel. Only sequence
+// numbers and WAL appends stay serialized. A batch becomes visible once it and
+// every batch with lower sequence numbers are in the memtable.
+
+// insertTracker orders the publication of concurrently inserted batches.
+type insertTracker struct {
+	mu        sync.Mutex
+	cond      *sync.Cond // Broadcast on mu whenever sequence numbers are published
+	allocated uint64     // Highest sequence number handed out
+	inFlight  int        // Batches allocated but not yet published
+	finished  map[uint64]uint64
+}
+
+// pendingWrite is the part of a write that runs after db.writeMu is released.
+type pendingWrite struct {
+	sync   wal.PendingSync
+	insert func() // Inserts and publishes the batch, for a sharded memtable
+	seqs   SeqRange
+}
+
+// wait finishes the write: it inserts the batch if that is still to be done,
+// then waits for the WAL sync. A failed sync is wrapped in ErrUnsynced, as the
+// batch is applied by then.
+func (p pendingWrite) wait() error {
+	if p.insert != nil {
+		p.insert()
+	}
+	if err := p.sync.Wait(); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsynced, err)
+	}
+	return nil
+}
+
+// beginInsert allocates n sequence numbers for a batch that is inserted outside
+// db.writeMu. The caller must hold db.writeMu and later call finishInsert.
+func (db *DB) beginInsert(n int) uint64 {
+	t := &db.inserts
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	first := max(t.allocated, db.sequenceNum.Load()) + 1
+	t.allocated = first + uint64(n) - 1
+	t.inFlight++
+	return first
+}
+
+// finishInsert marks the batch with sequence numbers first to last as inserted
+// and waits until it is visible, so a writer reads its own writes.
+func (db *DB) finishInsert(first, last uint64) {
+	t := &db.inserts
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished == nil {
+		t.finished = make(map[uint64]uint64)
+	}
+	t.finished[first] = last
+	for {
+		next := db.sequenceNum.Load() + 1
+		end, ok := t.finished[next]
+		if !ok {
+			break
+		}
+		delete(t.finished, next)
+		db.sequenceNum.Store(end)
+		t.inFlight--
+		t.cond.Broadcast()
+	}
+	for db.sequenceNum.Load() < last {
+		t.cond.Wait()
+	}
+}
+
+// waitForInserts blocks until every batch inserted outside db.writeMu is
+// visible. The caller must hold db.writeMu, so no new batch starts; it is
+// needed before reading the latest values or switching the memtable.
+func (db *DB) waitForInserts() {
+	t := &db.inserts
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.inFlight > 0 {
+		t.cond.Wait()
+	}
+}
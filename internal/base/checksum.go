@@ -0,0 +1,118 @@
+package base
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ChecksumType selects the function that checksums WAL records and SSTable
+// data blocks. The type is stored in every file, so files written with
+// different types stay readable.
+type ChecksumType byte
+
+const (
+	// ChecksumLegacy is the IEEE CRC32 of WALs written before the checksum type
+	// was recorded. Tables of that age have no block checksums.
+	ChecksumLegacy ChecksumType = iota
+	// ChecksumCRC32C is the Castagnoli CRC32, hardware accelerated on amd64 and
+	// arm64. It is the default.
+	ChecksumCRC32C
+	// ChecksumXXHash64Low32 stores the lower 32 bits of the xxHash64 of the
+	// data, with seed 0. It is fast on any CPU.
+	ChecksumXXHash64Low32
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Sum returns the checksum of data.
+func (t ChecksumType) Sum(data []byte) uint32 {
+	switch t {
+	case ChecksumCRC32C:
+		return crc32.Checksum(data, crc32cTable)
+	case ChecksumXXHash64Low32:
+		return uint32(XXHash64(data))
+	}
+	return crc32.ChecksumIEEE(data)
+}
+
+// Valid reports whether t is a known checksum type.
+func (t ChecksumType) Valid() bool {
+	return t <= ChecksumXXHash64Low32
+}
+
+func (t ChecksumType) String() string {
+	switch t {
+	case ChecksumLegacy:
+		return "crc32"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash64Low32:
+		return "xxhash64-low32"
+	}
+	return fmt.Sprintf("ChecksumType(%d)", byte(t))
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// XXHash64 returns the xxHash64 of b with seed 0.
+func XXHash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		p1, p2 := xxPrime1, xxPrime2
+		v1, v2, v3, v4 := p1+p2, p2, uint64(0), -p1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
@@ -0,0 +1,27 @@
+package base
+
+import "testing"
+
+func TestXXHash64(t *testing.T) {
+	// Published xxHash64 test vectors, and inputs that go through every
+	// stripe and tail path of the algorithm.
+	seq := func(n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		return string(b)
+	}
+	for input, want := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+		seq(77):  0x93f85c1b6280ead3,
+		seq(100): 0x6ac1e58032166597,
+	} {
+		if got := XXHash64([]byte(input)); got != want {
+			t.Errorf("XXHash64(%q) = %x, want %x", input, got, want)
+		}
+	}
+}
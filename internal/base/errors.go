@@ -0,0 +1,33 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned for a key that does not exist or was deleted.
+	ErrNotFound = errors.New("key not found")
+	// ErrCorruption matches every *CorruptionError with errors.Is.
+	ErrCorruption = errors.New("data corruption")
+)
+
+// CorruptionError reports damaged data in a file: a checksum mismatch, or a
+// record, block or table that does not decode.
+type CorruptionError struct {
+	File   string
+	Offset int64 // Start of the damaged record or block
+	Err    error // What is wrong with it
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("data corruption in %s at offset %d: %v", e.File, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruption
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
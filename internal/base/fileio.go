@@ -0,0 +1,84 @@
+package base
+
+import "sync/atomic"
+
+// FileIO counts the I/O done on one SSTable or WAL. A nil *FileIO counts
+// nothing, for readers and WALs outside a database.
+type FileIO struct {
+	Reads, BytesRead, CacheMisses, CompactionBytesRead              atomic.Int64
+	Writes, BytesWritten, FlushBytesWritten, CompactionBytesWritten atomic.Int64
+	Filter                                                          FilterCounters
+
+	Totals *IOTotals // Database-wide totals the I/O also counts towards, if set
+}
+
+// IOTotals are the database-wide totals of the I/O counted by FileIOs.
+type IOTotals struct {
+	WALBytesWritten atomic.Int64
+	DiskBytesRead   atomic.Int64 // Table reads on behalf of lookups and iterators
+	Filter          FilterCounters
+}
+
+// FilterCounters count the lookups answered by table filters.
+type FilterCounters struct {
+	Checks, Negatives, FalsePositives atomic.Int64
+}
+
+func (c *FilterCounters) add(negative, falsePositive bool) {
+	c.Checks.Add(1)
+	if negative {
+		c.Negatives.Add(1)
+	}
+	if falsePositive {
+		c.FalsePositives.Add(1)
+	}
+}
+
+// Read counts a read of n bytes on behalf of a lookup or an iterator.
+func (c *FileIO) Read(n int, cacheMiss bool) {
+	if c == nil {
+		return
+	}
+	c.Reads.Add(1)
+	c.BytesRead.Add(int64(n))
+	if cacheMiss {
+		c.CacheMisses.Add(1)
+	}
+	if c.Totals != nil {
+		c.Totals.DiskBytesRead.Add(int64(n))
+	}
+}
+
+// CompactionRead counts a read of n bytes by a compaction.
+func (c *FileIO) CompactionRead(n int) {
+	if c != nil {
+		c.Reads.Add(1)
+		c.BytesRead.Add(int64(n))
+		c.CompactionBytesRead.Add(int64(n))
+	}
+}
+
+// Write counts a WAL record of n bytes.
+func (c *FileIO) Write(n int) {
+	if c == nil {
+		return
+	}
+	c.Writes.Add(1)
+	c.BytesWritten.Add(int64(n))
+	if c.Totals != nil {
+		c.Totals.WALBytesWritten.Add(int64(n))
+	}
+}
+
+// FilterChecked counts a lookup the filter of the table answered: negative if
+// it ruled the key out, falsePositive if it let through a key the table does
+// not hold.
+func (c *FileIO) FilterChecked(negative, falsePositive bool) {
+	if c == nil {
+		return
+	}
+	c.Filter.add(negative, falsePositive)
+	if c.Totals != nil {
+		c.Totals.Filter.add(negative, falsePositive)
+	}
+}
@@ -0,0 +1,32 @@
+package base
+
+type Iterator interface {
+	Valid() bool
+	Key() InternalKey
+	Value() []byte
+	Next()
+	Prev()
+	Close() error
+	Error() error
+	SeekToFirst()
+	SeekToLast()
+	// Seek moves to the first entry whose user key is at or after key.
+	Seek(key []byte)
+	// SeekForPrev moves to the last entry whose user key is at or before key.
+	SeekForPrev(key []byte)
+}
+
+// SeekForPrev is Iterator.SeekForPrev for iterators that compare user keys as
+// stored.
+func SeekForPrev(it Iterator, key []byte) {
+	it.Seek(key)
+	if !it.Valid() {
+		if it.Error() == nil {
+			it.SeekToLast()
+		}
+		return
+	}
+	if it.Key().UserKey > string(key) {
+		it.Prev()
+	}
+}
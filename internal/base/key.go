@@ -0,0 +1,59 @@
+// Package base holds the types that the packages of a database share: internal
+// keys, iterators, checksums, corruption errors and I/O counters.
+package base
+
+// OpType defines the operation type for an entry.
+type OpType = byte
+
+const (
+	OpTypePut    OpType = 0
+	OpTypeDelete OpType = 1
+	// OpTypeBlobRef marks a value written with PutReader. The stored value is a
+	// reference to chunks kept in the reserved blob keyspace.
+	OpTypeBlobRef OpType = 3
+	// OpTypeMerge marks a delta written with Increment.
+	OpTypeMerge OpType = 4
+)
+
+// InternalKey combines the user key with metadata for versioning.
+type InternalKey struct {
+	UserKey string
+	SeqNum  uint64
+	Type    OpType
+}
+
+// InternalKeyComparator orders InternalKeys in skiplists and tables.
+type InternalKeyComparator struct{}
+
+// Compare sorts by UserKey ascending, then by SeqNum descending.
+func (c InternalKeyComparator) Compare(k1, k2 interface{}) int {
+	ik1 := k1.(InternalKey)
+	ik2 := k2.(InternalKey)
+
+	// First, compare by user key.
+	if ik1.UserKey > ik2.UserKey {
+		return 1
+	}
+	if ik1.UserKey < ik2.UserKey {
+		return -1
+	}
+
+	// If user keys are the same, the one with the HIGHER sequence number is considered "smaller"
+	// so that it comes first in an iteration.
+	if ik1.SeqNum > ik2.SeqNum {
+		return -1
+	}
+	if ik1.SeqNum < ik2.SeqNum {
+		return 1
+	}
+	return 0
+}
+
+// Not used
+func (c InternalKeyComparator) CalcScore(key interface{}) float64 {
+	return 0
+}
+
+// MaxEncodedSize is the hard limit on keys, values and whole batches imposed
+// by the uint32 length fields of the WAL and data block formats.
+const MaxEncodedSize = 1<<32 - 1 - 1024
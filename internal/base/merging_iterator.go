@@ -0,0 +1,196 @@
+package base
+
+import "container/heap"
+
+// MergingIterator merges iterators into one sorted stream. It returns every
+// entry, including older versions and tombstones. Changing direction
+// repositions every child around the current key, so the children must support
+// Prev and SeekToLast.
+type MergingIterator struct {
+	iters   []Iterator
+	h       minHeapIterator
+	rh      maxHeapIterator
+	reverse bool
+}
+
+// NewMergingIterator returns an iterator over the entries of iters. It takes
+// ownership of them and closes them when it is closed.
+func NewMergingIterator(iters []Iterator) *MergingIterator {
+	return &MergingIterator{iters: iters}
+}
+
+func (it *MergingIterator) top() *heapIteratorItem {
+	if it.reverse {
+		return it.rh.minHeapIterator[0]
+	}
+	return it.h[0]
+}
+
+func (it *MergingIterator) Valid() bool {
+	if it.reverse {
+		return len(it.rh.minHeapIterator) > 0
+	}
+	return len(it.h) > 0
+}
+
+func (it *MergingIterator) Key() InternalKey {
+	return it.top().key
+}
+
+func (it *MergingIterator) Value() []byte {
+	return it.top().value
+}
+
+func (it *MergingIterator) Next() {
+	if it.reverse {
+		// Move every child to its first entry after the current key.
+		key := it.Key()
+		cmp := InternalKeyComparator{}
+		for _, iter := range it.iters {
+			if iter.Valid() {
+				iter.Next()
+			} else {
+				iter.SeekToFirst()
+			}
+			for iter.Valid() && cmp.Compare(iter.Key(), key) <= 0 {
+				iter.Next()
+			}
+		}
+		it.rebuild(false)
+		return
+	}
+	top := it.h[0]
+	top.iter.Next()
+	if top.iter.Valid() {
+		top.key, top.value = top.iter.Key(), top.iter.Value()
+		heap.Fix(&it.h, 0)
+	} else {
+		heap.Pop(&it.h)
+	}
+}
+
+func (it *MergingIterator) Prev() {
+	if !it.reverse {
+		// Move every child to its last entry before the current key.
+		key := it.Key()
+		cmp := InternalKeyComparator{}
+		for _, iter := range it.iters {
+			if iter.Valid() {
+				iter.Prev()
+			} else {
+				iter.SeekToLast()
+			}
+			for iter.Valid() && cmp.Compare(iter.Key(), key) >= 0 {
+				iter.Prev()
+			}
+		}
+		it.rebuild(true)
+		return
+	}
+	top := it.rh.minHeapIterator[0]
+	top.iter.Prev()
+	if top.iter.Valid() {
+		top.key, top.value = top.iter.Key(), top.iter.Value()
+		heap.Fix(&it.rh, 0)
+	} else {
+		heap.Pop(&it.rh)
+	}
+}
+
+func (it *MergingIterator) Close() error {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+	it.h = nil
+	it.rh.minHeapIterator = nil
+	return nil
+}
+
+func (it *MergingIterator) Error() error {
+	for _, iter := range it.iters {
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *MergingIterator) SeekToFirst() {
+	for _, iter := range it.iters {
+		iter.SeekToFirst()
+	}
+	it.rebuild(false)
+}
+
+func (it *MergingIterator) SeekToLast() {
+	for _, iter := range it.iters {
+		iter.SeekToLast()
+	}
+	it.rebuild(true)
+}
+
+func (it *MergingIterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.rebuild(false)
+}
+
+func (it *MergingIterator) SeekForPrev(key []byte) {
+	SeekForPrev(it, key)
+}
+
+// rebuild fills the heap for the given direction from the valid children.
+func (it *MergingIterator) rebuild(reverse bool) {
+	items := make(minHeapIterator, 0, len(it.iters))
+	for i, iter := range it.iters {
+		if iter.Valid() {
+			items = append(items, &heapIteratorItem{iter: iter, key: iter.Key(), value: iter.Value(), idx: i})
+		}
+	}
+	it.reverse = reverse
+	if reverse {
+		it.h = nil
+		it.rh = maxHeapIterator{items}
+		heap.Init(&it.rh)
+	} else {
+		it.rh = maxHeapIterator{}
+		it.h = items
+		heap.Init(&it.h)
+	}
+}
+
+type heapIteratorItem struct {
+	iter  Iterator
+	key   InternalKey
+	value []byte
+	idx   int
+}
+
+type minHeapIterator []*heapIteratorItem
+
+func (h minHeapIterator) Len() int { return len(h) }
+func (h minHeapIterator) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+func (h *minHeapIterator) Push(x any) { *h = append(*h, x.(*heapIteratorItem)) }
+func (h *minHeapIterator) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[0 : n-1]
+	return item
+}
+func (h minHeapIterator) Less(i, j int) bool {
+	return InternalKeyComparator{}.Compare(h[i].key, h[j].key) < 0
+}
+
+// maxHeapIterator orders the items largest key first, for reverse iteration.
+type maxHeapIterator struct {
+	minHeapIterator
+}
+
+func (h maxHeapIterator) Less(i, j int) bool {
+	return InternalKeyComparator{}.Compare(h.minHeapIterator[i].key, h.minHeapIterator[j].key) > 0
+}
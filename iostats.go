@@ -0,0 +1,135 @@
+package leveldb
+
+import (
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileIOStats counts the I/O done on one SSTable or WAL since the database was
+// opened.
+type FileIOStats struct {
+	Reads       int64 // Reads from disk
+	BytesRead   int64
+	CacheMisses int64 // Data, index and filter blocks not found in the block cache
+	// Bytes read from disk by compactions, included in BytesRead.
+	CompactionBytesRead int64
+
+	Writes       int64 // WAL records appended
+	BytesWritten int64
+	// Bytes of a table written by a flush or a compaction, included in
+	// BytesWritten.
+	FlushBytesWritten      int64
+	CompactionBytesWritten int64
+
+	// Lookups answered by the filter of a table: FilterNegatives ruled the
+	// key out, FilterFalsePositives let it through to a table without it.
+	FilterChecks         int64
+	FilterNegatives      int64
+	FilterFalsePositives int64
+}
+
+// fileIOStats snapshots the live counters of a file.
+func fileIOStats(c *base.FileIO) FileIOStats {
+	return FileIOStats{
+		Reads:                  c.Reads.Load(),
+		BytesRead:              c.BytesRead.Load(),
+		CacheMisses:            c.CacheMisses.Load(),
+		CompactionBytesRead:    c.CompactionBytesRead.Load(),
+		Writes:                 c.Writes.Load(),
+		BytesWritten:           c.BytesWritten.Load(),
+		FlushBytesWritten:      c.FlushBytesWritten.Load(),
+		CompactionBytesWritten: c.CompactionBytesWritten.Load(),
+		FilterChecks:           c.Filter.Checks.Load(),
+		FilterNegatives:        c.Filter.Negatives.Load(),
+		FilterFalsePositives:   c.Filter.FalsePositives.Load(),
+	}
+}
+
+// fileIO returns the counters of the file named name in the data directory,
+// creating them on first use.
+func (db *DB) fileIO(name string) *base.FileIO {
+	db.ioMu.Lock()
+	defer db.ioMu.Unlock()
+	c, ok := db.ioStats[name]
+	if !ok {
+		c = &base.FileIO{Totals: &db.ioTotals}
+		db.ioStats[name] = c
+	}
+	return c
+}
+
+func (db *DB) tableIO(num int) *base.FileIO {
+	return db.fileIO(fmt.Sprintf("%05d.sst", num))
+}
+
+// tableWritten counts a table written in full by a flush or a compaction.
+func (db *DB) tableWritten(num int, size int64, compaction bool) {
+	c := db.tableIO(num)
+	c.BytesWritten.Add(size)
+	if compaction {
+		c.CompactionBytesWritten.Add(size)
+	} else {
+		c.FlushBytesWritten.Add(size)
+	}
+}
+
+// renameFileIO moves the counters of a renamed file, such as a rotated WAL.
+func (db *DB) renameFileIO(oldPath, newPath string) {
+	db.ioMu.Lock()
+	defer db.ioMu.Unlock()
+	if c, ok := db.ioStats[filepath.Base(oldPath)]; ok {
+		delete(db.ioStats, filepath.Base(oldPath))
+		db.ioStats[filepath.Base(newPath)] = c
+	}
+}
+
+// dropFileIO forgets the counters of a deleted file.
+func (db *DB) dropFileIO(path string) {
+	db.ioMu.Lock()
+	defer db.ioMu.Unlock()
+	delete(db.ioStats, filepath.Base(path))
+}
+
+// FileIOStats returns the I/O counters of every file that still exists, keyed
+// by file name, such as "00012.sst" or "db.wal". Counters of a file are
+// dropped once it is deleted. Files with many reads or cache misses point to
+// hot key ranges.
+func (db *DB) FileIOStats() map[string]FileIOStats {
+	db.ioMu.Lock()
+	defer db.ioMu.Unlock()
+	stats := make(map[string]FileIOStats, len(db.ioStats))
+	for name, c := range db.ioStats {
+		stats[name] = fileIOStats(c)
+	}
+	return stats
+}
+
+// fileIOReport formats FileIOStats as a table, busiest files first.
+func (db *DB) fileIOReport() string {
+	stats := db.FileIOStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := stats[names[i]], stats[names[j]]
+		if a.BytesRead+a.BytesWritten != b.BytesRead+b.BytesWritten {
+			return a.BytesRead+a.BytesWritten > b.BytesRead+b.BytesWritten
+		}
+		return names[i] < names[j]
+	})
+	const mb = 1024 * 1024
+	var b strings.Builder
+	fmt.Fprintf(&b, "File              Reads  Read(MB) Misses Compact(MB)   Writes Write(MB) Flush(MB) Compact(MB)\n")
+	fmt.Fprintf(&b, "-------------------------------------------------------------------------------------------\n")
+	for _, name := range names {
+		s := stats[name]
+		fmt.Fprintf(&b, "%-14s %8d %9.1f %6d %11.1f %8d %9.1f %9.1f %11.1f\n", name, s.Reads, float64(s.BytesRead)/mb,
+			s.CacheMisses, float64(s.CompactionBytesRead)/mb, s.Writes, float64(s.BytesWritten)/mb,
+			float64(s.FlushBytesWritten)/mb, float64(s.CompactionBytesWritten)/mb)
+	}
+	return b.String()
+}
@@ -0,0 +1,193 @@
+package leveldb
+
+import (
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LiveFiles lists the files that make up the database at one point in time.
+// The listed SSTables are not deleted before Release is called, even if a
+// compaction replaces them, so they can be copied safely. The state file and
+// the active WAL keep changing, so a backup writes State as StateFile and
+// copies only the first Size bytes of each WAL.
+type LiveFiles struct {
+	Dir          string
+	StateFile    string // Names are relative to Dir
+	State        []byte // Contents of StateFile describing exactly Tables
+	WALFiles     []LiveWAL
+	Tables       []LiveTable // Oldest first
+	LastSequence uint64
+
+	version *Version
+}
+
+// LiveWAL describes one WAL at the time of GetLiveFiles. Records appended
+// later are not part of the snapshot.
+type LiveWAL struct {
+	Name string
+	Size int64
+}
+
+// LiveTable describes one live SSTable.
+type LiveTable struct {
+	FileNum     int
+	Name        string
+	Size        int64
+	SmallestKey string
+	LargestKey  string
+	SmallestSeq uint64
+	LargestSeq  uint64
+}
+
+// GetLiveFiles returns the current set of live files. With flush set, the
+// memtable is flushed first so the SSTables hold every write and the WAL is
+// empty. Without it, a rotated WAL may be deleted by its flush while it is
+// being copied. The caller must call Release on the result.
+func (db *DB) GetLiveFiles(flush bool) (*LiveFiles, error) {
+	return db.liveFiles(flush, nil)
+}
+
+// liveFiles is GetLiveFiles that also calls pin, if set, with the files while
+// no WAL can be rotated.
+func (db *DB) liveFiles(flush bool, pin func(*LiveFiles)) (*LiveFiles, error) {
+	if flush {
+		if err := db.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Holding off writers and flushes lines up the WAL sizes, the state and
+	// the sequence number.
+	db.writeMu.Lock()
+	db.waitForInserts()
+	db.mu.RLock()
+	version := db.current
+	version.ref()
+	lf := &LiveFiles{
+		Dir:          db.dataDir,
+		StateFile:    stateFile,
+		LastSequence: db.sequenceNum.Load(),
+		version:      version,
+	}
+	state, err := encodeState(db.stateLocked())
+	lf.State = state
+	if err == nil {
+		lf.WALFiles, err = db.liveWALsLocked()
+	}
+	if err == nil && pin != nil {
+		pin(lf)
+	}
+	db.mu.RUnlock()
+	db.writeMu.Unlock()
+	if err != nil {
+		lf.Release()
+		return nil, err
+	}
+	for _, num := range version.tables {
+		name := fmt.Sprintf("%05d.sst", num)
+		info, err := db.opts.FS.Stat(filepath.Join(db.dataDir, name))
+		if err != nil {
+			lf.Release()
+			return nil, err
+		}
+		reader, err := db.findTable(num)
+		if err != nil {
+			lf.Release()
+			return nil, err
+		}
+		smallest, largest, err := reader.KeyRange()
+		reader.Unref()
+		if err != nil {
+			lf.Release()
+			return nil, err
+		}
+		lf.Tables = append(lf.Tables, LiveTable{
+			FileNum:     num,
+			Name:        name,
+			Size:        info.Size(),
+			SmallestKey: smallest,
+			LargestKey:  largest,
+			SmallestSeq: version.seqs[num].First,
+			LargestSeq:  version.seqs[num].Last,
+		})
+	}
+	return lf, nil
+}
+
+// liveWALsLocked returns the rotated WALs and the active one with their
+// current sizes. The caller must hold db.mu.
+func (db *DB) liveWALsLocked() ([]LiveWAL, error) {
+	paths, err := vfs.Glob(db.opts.FS, db.dataDir, "wal-*.log")
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, filepath.Join(db.dataDir, "db.wal"))
+	wals := make([]LiveWAL, 0, len(paths))
+	for _, path := range paths {
+		info, err := db.opts.FS.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		wals = append(wals, LiveWAL{Name: filepath.Base(path), Size: info.Size()})
+	}
+	return wals, nil
+}
+
+// Release allows the listed SSTables to be deleted again.
+func (lf *LiveFiles) Release() {
+	if lf.version != nil {
+		lf.version.unref()
+		lf.version = nil
+	}
+}
+
+// DiskUsageStats breaks down the bytes a database occupies on disk.
+type DiskUsageStats struct {
+	LiveTables     int64 // SSTables of the current Version
+	ObsoleteTables int64 // SSTables still held by readers or not yet deleted
+	WAL            int64 // Active and rotated WAL files
+	Total          int64 // Every file in the database directory
+}
+
+// DiskUsage reports how much disk space the database uses. A growing
+// ObsoleteTables means long-running iterators keep compacted files alive.
+func (db *DB) DiskUsage() (DiskUsageStats, error) {
+	db.mu.RLock()
+	live := make(map[string]bool, len(db.current.tables))
+	for _, num := range db.current.tables {
+		live[fmt.Sprintf("%05d.sst", num)] = true
+	}
+	// Read the directory under mu so no table moves between live and obsolete.
+	names, err := db.opts.FS.List(db.dataDir)
+	db.mu.RUnlock()
+	if err != nil {
+		return DiskUsageStats{}, err
+	}
+
+	var usage DiskUsageStats
+	for _, name := range names {
+		info, err := db.opts.FS.Stat(filepath.Join(db.dataDir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // Deleted since the directory was read
+			}
+			return DiskUsageStats{}, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		switch {
+		case live[name]:
+			usage.LiveTables += info.Size()
+		case strings.HasSuffix(name, ".sst") || strings.HasSuffix(name, ".sst.tmp"):
+			usage.ObsoleteTables += info.Size()
+		case name == "db.wal" || strings.HasPrefix(name, "wal-"):
+			usage.WAL += info.Size()
+		}
+		usage.Total += info.Size()
+	}
+	return usage, nil
+}
@@ -0,0 +1,103 @@
+package leveldb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockOwner is the process that holds a database open, as written to its LOCK
+// file by Open.
+type LockOwner struct {
+	PID    int       `json:"pid"`
+	Host   string    `json:"host"`
+	Opened time.Time `json:"opened"`
+}
+
+// writeLockOwner records the current process as the owner of the lock at
+// path. It is only diagnostic, so failures are logged: Windows, for one, does
+// not let anyone write a locked file.
+func writeLockOwner(fs FS, path string, now time.Time) {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(LockOwner{PID: os.Getpid(), Host: host, Opened: now})
+	if err == nil {
+		err = writeFile(fs, path, data)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to record the owner of %s: %v", path, err)
+	}
+}
+
+func writeFile(fs FS, path string, data []byte) error {
+	file, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readLockOwner returns the owner recorded in the lock at path.
+func readLockOwner(fs FS, path string) (*LockOwner, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	var owner LockOwner
+	if err := json.Unmarshal(data, &owner); err != nil || owner.PID == 0 {
+		return nil, fmt.Errorf("%s names no owner", path)
+	}
+	return &owner, nil
+}
+
+// lockedError describes the lock of the database in dir held by another
+// process.
+func lockedError(fs FS, dir string) error {
+	owner, _ := readLockOwner(fs, filepath.Join(dir, "LOCK"))
+	return &LockedError{Dir: dir, Owner: owner}
+}
+
+// ForceUnlock removes the LOCK file of the database in dir that a process
+// left locked although it is gone, as can happen on network filesystems. It
+// refuses unless the LOCK names an owner on this host that no longer runs,
+// and does nothing if no one holds the lock.
+func ForceUnlock(dir string) error {
+	return forceUnlock(OSFS{}, dir)
+}
+
+func forceUnlock(fs FS, dir string) error {
+	path := filepath.Join(dir, "LOCK")
+	lock, err := fs.Lock(path)
+	if err == nil {
+		return lock.Close()
+	}
+	if !errors.Is(err, ErrDBLocked) {
+		return err
+	}
+	owner, err := readLockOwner(fs, path)
+	if err != nil {
+		return fmt.Errorf("cannot tell whether the owner of the lock is gone: %w", err)
+	}
+	host, _ := os.Hostname()
+	if owner.Host != host {
+		return fmt.Errorf("lock is held by pid %d on %s, which cannot be checked from %s", owner.PID, owner.Host, host)
+	}
+	if processAlive(owner.PID) {
+		return fmt.Errorf("lock is held by pid %d, which is still running", owner.PID)
+	}
+	log.Printf("Removing %s, left by pid %d since %s", path, owner.PID, owner.Opened.Format(time.RFC3339))
+	return fs.Remove(path)
+}
@@ -0,0 +1,64 @@
+package leveldb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLockOwner(t *testing.T) {
+	fs := NewMemFS()
+	opened := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	db, err := Open("db", &Options{FS: fs, Now: func() time.Time { return opened }})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	_, err = Open("db", &Options{FS: fs})
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrDBLocked) {
+		t.Fatalf("Second Open returned %v, want a LockedError", err)
+	}
+	host, _ := os.Hostname()
+	if o := locked.Owner; o == nil || o.PID != os.Getpid() || o.Host != host || !o.Opened.Equal(opened) {
+		t.Errorf("LockedError names owner %+v, want pid %d on %s", o, os.Getpid(), host)
+	}
+	if err := forceUnlock(fs, "db"); err == nil {
+		t.Errorf("forceUnlock removed the lock of a running process")
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows does not remove a locked file")
+	}
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// A pid above the Linux limit of 2^22 names no process.
+	host, _ := os.Hostname()
+	gone := `{"pid": 1073741824, "host": "` + host + `", "opened": "2024-05-01T12:00:00Z"}`
+	if err := os.WriteFile(filepath.Join(dir, "LOCK"), []byte(gone), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := ForceUnlock(dir); err != nil {
+		t.Fatalf("ForceUnlock of a lock left by a gone process failed: %v", err)
+	}
+	other, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open after ForceUnlock failed: %v", err)
+	}
+	other.Close()
+
+	if err := ForceUnlock(t.TempDir()); err != nil {
+		t.Errorf("ForceUnlock of an unlocked database failed: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package leveldb
+
+// OpenInMemory opens a new, empty database that keeps all of its files,
+// SSTables and state included, in a MemFS of its own. Everything but
+// durability works as with Open: flushes, compactions and iterators behave
+// the same. The data is gone once the database is closed. opts.FS is ignored.
+func OpenInMemory(opts *Options) (*DB, error) {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.FS = NewMemFS()
+	return Open("db", &o)
+}
@@ -0,0 +1,92 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOpenInMemory(t *testing.T) {
+	db, err := OpenInMemory(&Options{MaxMemtableSize: 16 * 1024})
+	if err != nil {
+		t.Fatalf("OpenInMemory failed: %v", err)
+	}
+	defer db.Close()
+
+	wo := WriteOptions{}
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if err := db.Put(wo, generateKey(i), generateValue(100)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	for i := 0; i < n; i += 2 {
+		db.Delete(wo, generateKey(i))
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if len(db.current.tables) == 0 {
+		t.Fatalf("Expected SSTables in memory")
+	}
+
+	it := db.NewIterator()
+	count := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if want := string(generateKey(2*count + 1)); it.Key().UserKey != want {
+			t.Fatalf("Iterator at %q, want %q", it.Key().UserKey, want)
+		}
+		count++
+	}
+	it.Close()
+	if count != n/2 {
+		t.Errorf("Iterated over %d keys, want %d", count, n/2)
+	}
+	if _, err := db.Get(generateKey(0)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted key returned %v", err)
+	}
+	usage, err := db.DiskUsage()
+	if err != nil || usage.LiveTables == 0 {
+		t.Errorf("DiskUsage returned %+v, %v", usage, err)
+	}
+}
+
+func TestMemFSReopen(t *testing.T) {
+	fs := NewMemFS()
+	opts := &Options{FS: fs}
+	db, err := Open("data/db", opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := Open("data/db", opts); !errors.Is(err, ErrDBLocked) {
+		t.Errorf("Second Open returned %v, want ErrDBLocked", err)
+	}
+	wo := WriteOptions{Sync: true}
+	for i := 0; i < 10; i++ {
+		db.Put(wo, []byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i)))
+		if i == 4 {
+			db.Flush()
+		}
+	}
+	if err := db.CreateCheckpoint("data/checkpoint"); err != nil {
+		t.Fatalf("CreateCheckpoint failed: %v", err)
+	}
+	db.Close()
+
+	for _, dir := range []string{"data/db", "data/checkpoint"} {
+		db, err := Open(dir, opts)
+		if err != nil {
+			t.Fatalf("Reopen of %s failed: %v", dir, err)
+		}
+		for i := 0; i < 10; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("k%d", i)))
+			if err != nil || string(val) != fmt.Sprintf("v%d", i) {
+				t.Errorf("Get k%d in %s returned %q, %v", i, dir, val, err)
+			}
+		}
+		db.Close()
+	}
+}
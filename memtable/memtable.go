@@ -0,0 +1,249 @@
+// Package memtable holds the recent writes of a database in memory, sorted by
+// internal key, until they are flushed to an SSTable.
+package memtable
+
+import (
+	"github.com/huandu/skiplist"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelInsertThreshold is the smallest batch whose entries are inserted into
+// the shards of a sharded memtable concurrently.
+const ParallelInsertThreshold = 64
+
+// Memtable holds recent writes in memory. It is split into shards by user key
+// hash, each with its own skiplist and lock, so large batches can be inserted
+// in parallel. All versions of a user key live in the same shard.
+type Memtable struct {
+	shards    []*shard
+	size      atomic.Int64 // Approximate size in bytes
+	deletions atomic.Int64 // Number of tombstones
+}
+
+type shard struct {
+	mu   sync.RWMutex
+	data *skiplist.SkipList
+}
+
+// New creates a memtable with n shards.
+func New(n int) *Memtable {
+	m := &Memtable{shards: make([]*shard, max(n, 1))}
+	for i := range m.shards {
+		m.shards[i] = &shard{data: skiplist.New(base.InternalKeyComparator{})}
+	}
+	return m
+}
+
+func (m *Memtable) shard(userKey string) *shard {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(userKey))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+func (m *Memtable) Put(key base.InternalKey, value []byte) {
+	s := m.shard(key.UserKey)
+	s.mu.Lock()
+	s.data.Set(key, value)
+	s.mu.Unlock()
+	m.size.Add(int64(len(key.UserKey) + len(value)))
+	if key.Type == base.OpTypeDelete {
+		m.deletions.Add(1)
+	}
+}
+
+// Shards returns the number of shards of the memtable.
+func (m *Memtable) Shards() int {
+	return len(m.shards)
+}
+
+// PutBatch inserts n entries with consecutive sequence numbers starting at
+// firstSeq, entry returning the i-th of them. Large batches are spread over the
+// shards in parallel.
+func (m *Memtable) PutBatch(n int, firstSeq uint64, entry func(i int) (key []byte, op base.OpType, value []byte)) {
+	put := func(i int) {
+		key, op, value := entry(i)
+		internalKey := base.InternalKey{
+			UserKey: string(key),
+			SeqNum:  firstSeq + uint64(i),
+			Type:    op,
+		}
+		if op == base.OpTypeDelete {
+			m.Put(internalKey, nil)
+		} else {
+			m.Put(internalKey, value)
+		}
+	}
+	if len(m.shards) == 1 || n < ParallelInsertThreshold {
+		for i := range n {
+			put(i)
+		}
+		return
+	}
+
+	perShard := make(map[*shard][]int)
+	for i := range n {
+		key, _, _ := entry(i)
+		s := m.shard(string(key))
+		perShard[s] = append(perShard[s], i)
+	}
+	var wg sync.WaitGroup
+	for _, indexes := range perShard {
+		wg.Go(func() {
+			for _, i := range indexes {
+				put(i)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func (m *Memtable) Get(key []byte) ([]byte, bool) {
+	foundKey, value, ok := m.GetEntry(key, math.MaxUint64)
+	if !ok {
+		return nil, false // Not found
+	}
+	if foundKey.Type == base.OpTypeDelete {
+		return nil, true // Found a tombstone
+	}
+	return value, true
+}
+
+// GetEntry returns the newest entry for the user key with a sequence number
+// at most seq, including tombstones.
+func (m *Memtable) GetEntry(key []byte, seq uint64) (base.InternalKey, []byte, bool) {
+	s := m.shard(string(key))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	searchKey := base.InternalKey{
+		UserKey: string(key),
+		SeqNum:  seq,
+		Type:    base.OpTypePut,
+	}
+	elem := s.data.Find(searchKey)
+	if elem == nil {
+		return base.InternalKey{}, nil, false
+	}
+	foundKey := elem.Key().(base.InternalKey)
+	if foundKey.UserKey != string(key) {
+		return base.InternalKey{}, nil, false // Not a match
+	}
+	return foundKey, elem.Value.([]byte), true
+}
+
+func (m *Memtable) ApproximateSize() int {
+	return int(m.size.Load())
+}
+
+// Len returns the number of entries in the memtable.
+func (m *Memtable) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.RLock()
+		n += s.data.Len()
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Counts returns the number of entries and of tombstones among them.
+func (m *Memtable) Counts() (int, int) {
+	return m.Len(), int(m.deletions.Load())
+}
+
+// Sorted returns every entry in one skiplist, merging the shards. It must only
+// be called once the memtable no longer receives writes.
+func (m *Memtable) Sorted() *skiplist.SkipList {
+	if len(m.shards) == 1 {
+		return m.shards[0].data
+	}
+	list := skiplist.New(base.InternalKeyComparator{})
+	for _, s := range m.shards {
+		for elem := s.data.Front(); elem != nil; elem = elem.Next() {
+			list.Set(elem.Key(), elem.Value)
+		}
+	}
+	return list
+}
+
+// NewIterator returns an iterator over the memtable's contents.
+func (m *Memtable) NewIterator() base.Iterator {
+	if len(m.shards) == 1 {
+		return &iterator{shard: m.shards[0]}
+	}
+	iters := make([]base.Iterator, len(m.shards))
+	for i, s := range m.shards {
+		iters[i] = &iterator{shard: s}
+	}
+	return base.NewMergingIterator(iters)
+}
+
+// iterator walks the live skiplist of one shard, so it takes the
+// shard's read lock while moving between elements. Entries inserted after the
+// iterator was created may be encountered; the merging iterator hides them by
+// sequence number.
+type iterator struct {
+	shard   *shard
+	current *skiplist.Element
+}
+
+func (it *iterator) Valid() bool {
+	return it.current != nil
+}
+
+func (it *iterator) Key() base.InternalKey {
+	return it.current.Key().(base.InternalKey)
+}
+
+func (it *iterator) Value() []byte {
+	return it.current.Value.([]byte)
+}
+
+func (it *iterator) Next() {
+	it.shard.mu.RLock()
+	defer it.shard.mu.RUnlock()
+	it.current = it.current.Next()
+}
+
+func (it *iterator) Prev() {
+	it.shard.mu.RLock()
+	defer it.shard.mu.RUnlock()
+	it.current = it.current.Prev()
+}
+
+func (it *iterator) Close() error {
+	it.current = nil
+	return nil
+}
+
+func (it *iterator) Error() error {
+	return nil
+}
+
+func (it *iterator) SeekToFirst() {
+	it.shard.mu.RLock()
+	defer it.shard.mu.RUnlock()
+	it.current = it.shard.data.Front()
+}
+
+func (it *iterator) Seek(key []byte) {
+	it.shard.mu.RLock()
+	defer it.shard.mu.RUnlock()
+	it.current = it.shard.data.Find(base.InternalKey{UserKey: string(key), SeqNum: math.MaxUint64, Type: base.OpTypePut})
+}
+
+func (it *iterator) SeekForPrev(key []byte) {
+	base.SeekForPrev(it, key)
+}
+
+func (it *iterator) SeekToLast() {
+	it.shard.mu.RLock()
+	defer it.shard.mu.RUnlock()
+	it.current = it.shard.data.Back()
+}
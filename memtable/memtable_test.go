@@ -0,0 +1,56 @@
+package memtable
+
+import (
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"testing"
+)
+
+func TestPutBatchSharded(t *testing.T) {
+	const n = 4 * ParallelInsertThreshold
+	m := New(4)
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i%(n/2))) }
+	m.PutBatch(n, 100, func(i int) ([]byte, base.OpType, []byte) {
+		if i%7 == 0 {
+			return key(i), base.OpTypeDelete, nil
+		}
+		return key(i), base.OpTypePut, []byte(fmt.Sprint(i))
+	})
+	if m.Len() != n {
+		t.Fatalf("Len = %d, want %d", m.Len(), n)
+	}
+
+	// Every user key was written twice, the second write wins.
+	for i := n / 2; i < n; i++ {
+		ik, value, ok := m.GetEntry(key(i), 100+uint64(n))
+		if !ok || ik.SeqNum != 100+uint64(i) {
+			t.Fatalf("GetEntry(%s) = %+v (found %v), want seq %d", key(i), ik, ok, 100+i)
+		}
+		if i%7 != 0 && string(value) != fmt.Sprint(i) {
+			t.Errorf("GetEntry(%s) = %q, want %q", key(i), value, fmt.Sprint(i))
+		}
+	}
+	// Older versions stay visible at older sequence numbers.
+	if ik, _, ok := m.GetEntry(key(1), 100+uint64(n/2)); !ok || ik.SeqNum != 101 {
+		t.Errorf("GetEntry at an old seq = %+v (found %v), want seq 101", ik, ok)
+	}
+
+	it := m.NewIterator()
+	defer it.Close()
+	count := 0
+	var last base.InternalKey
+	cmp := base.InternalKeyComparator{}
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if count > 0 && cmp.Compare(last, it.Key()) >= 0 {
+			t.Fatalf("Iterator returned %+v after %+v", it.Key(), last)
+		}
+		last = it.Key()
+		count++
+	}
+	if count != n {
+		t.Errorf("Iterator returned %d entries, want %d", count, n)
+	}
+	if sorted := m.Sorted(); sorted.Len() != n {
+		t.Errorf("Sorted holds %d entries, want %d", sorted.Len(), n)
+	}
+}
@@ -0,0 +1,82 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+)
+
+// noSpace records that a write to disk failed for lack of space.
+type noSpace struct {
+	err error     // Wraps ErrNoSpace and the error of the failed write
+	at  time.Time // When it failed, by Options.Now
+}
+
+// setNoSpace stops writes if err reports a full disk, and returns err,
+// wrapped in ErrNoSpace if so.
+func (db *DB) setNoSpace(err error) error {
+	if !errors.Is(err, syscall.ENOSPC) || errors.Is(err, ErrNoSpace) {
+		return err
+	}
+	err = fmt.Errorf("%w, writes are stopped: %w", ErrNoSpace, err)
+	if db.noSpace.Swap(&noSpace{err: err, at: db.opts.Now()}) == nil {
+		log.Printf("CRITICAL ERROR: %v", err)
+	}
+	return err
+}
+
+// checkSpaceLocked returns the error writes fail with while the disk is full.
+// Once NoSpaceRetryInterval has passed since the last failure, or right away
+// if force is set, it first retries the failed work and returns nil if that
+// succeeds. The caller must hold db.writeMu.
+func (db *DB) checkSpaceLocked(force bool) error {
+	ns := db.noSpace.Load()
+	if ns == nil {
+		return nil
+	}
+	if !force && db.opts.Now().Sub(ns.at) < NoSpaceRetryInterval {
+		return ns.err
+	}
+	err := db.resumeLocked()
+	if err == nil {
+		return nil
+	}
+	if err = db.setNoSpace(err); !errors.Is(err, ErrNoSpace) {
+		// Writes stay stopped, the next retry waits as long again.
+		db.noSpace.Store(&noSpace{err: ns.err, at: db.opts.Now()})
+	}
+	return err
+}
+
+// resumeLocked retries the work that failed for lack of space: the flush of
+// the immutable memtable, if it failed, then a flush of the memtable onto a
+// new WAL, as the old one may end in a partial record. The caller must hold
+// db.writeMu.
+func (db *DB) resumeLocked() error {
+	db.waitForInserts()
+	db.mu.Lock()
+	// The error of a failed state save is stale, the next flush saves the
+	// state again.
+	db.bgErr = nil
+	if job := db.failedFlush; job != nil {
+		db.failedFlush = nil
+		db.wg.Add(1)
+		db.schedule(job, true)
+	}
+	db.mu.Unlock()
+	if err := db.waitForFlush(); err != nil {
+		return err
+	}
+	db.flushMemtable()
+	if err := db.waitForFlush(); err != nil {
+		return err
+	}
+	db.noSpace.Store(nil)
+	log.Println("Disk space is available again, writes resume")
+	db.mu.Lock()
+	db.maybeScheduleCompactionLocked()
+	db.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,166 @@
+package leveldb
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fullFS is a MemFS whose writes to files with a name ending in suffix fail
+// with ENOSPC while full is set.
+type fullFS struct {
+	*MemFS
+	suffix string
+	full   atomic.Bool
+}
+
+func (fs *fullFS) Create(name string) (File, error) {
+	f, err := fs.MemFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fullFile{File: f, fs: fs}, nil
+}
+
+func (fs *fullFS) Append(name string) (File, error) {
+	f, err := fs.MemFS.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fullFile{File: f, fs: fs}, nil
+}
+
+type fullFile struct {
+	File
+	fs *fullFS
+}
+
+func (f *fullFile) Write(p []byte) (int, error) {
+	if f.fs.full.Load() && strings.HasSuffix(f.Name(), f.fs.suffix) {
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	return f.File.Write(p)
+}
+
+// manualClock is a clock that only moves when told to.
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestNoSpaceWAL(t *testing.T) {
+	fs := &fullFS{MemFS: NewMemFS()}
+	clock := &manualClock{now: time.Unix(0, 0)}
+	opts := &Options{FS: fs, Now: clock.Now}
+	db, err := Open("db", opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	wo := WriteOptions{Sync: true}
+	db.Put(wo, []byte("a"), []byte("1"))
+
+	fs.full.Store(true)
+	err = db.Put(wo, []byte("b"), []byte("2"))
+	if !errors.Is(err, ErrNoSpace) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Put on a full disk returned %v, want ErrNoSpace wrapping ENOSPC", err)
+	}
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get on a full disk returned %q, %v", val, err)
+	}
+
+	fs.full.Store(false)
+	if err := db.Put(wo, []byte("c"), []byte("3")); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Put before NoSpaceRetryInterval passed returned %v, want ErrNoSpace", err)
+	}
+	clock.advance(NoSpaceRetryInterval)
+	if err := db.Put(wo, []byte("c"), []byte("3")); err != nil {
+		t.Fatalf("Put once space was freed failed: %v", err)
+	}
+	db.Close()
+
+	db, err = Open("db", opts)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer db.Close()
+	for key, want := range map[string]string{"a": "1", "c": "3"} {
+		if val, err := db.Get([]byte(key)); err != nil || string(val) != want {
+			t.Errorf("Get %s after reopen returned %q, %v", key, val, err)
+		}
+	}
+	if _, err := db.Get([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of the failed write returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestNoSpaceFlush(t *testing.T) {
+	// Either the new WAL or the table of the flush cannot be written.
+	for _, suffix := range []string{"", ".sst.tmp"} {
+		t.Run("suffix="+suffix, func(t *testing.T) {
+			testNoSpaceFlush(t, suffix)
+		})
+	}
+}
+
+func testNoSpaceFlush(t *testing.T, suffix string) {
+	fs := &fullFS{MemFS: NewMemFS(), suffix: suffix}
+	db, err := Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	wo := WriteOptions{}
+	for i := 0; i < 100; i++ {
+		db.Put(wo, generateKey(i), generateValue(100))
+	}
+
+	fs.full.Store(true)
+	if err := db.Flush(); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("Flush on a full disk returned %v, want ErrNoSpace", err)
+	}
+	if err := db.Put(wo, generateKey(100), generateValue(100)); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Put after a failed flush returned %v, want ErrNoSpace", err)
+	}
+	names, _ := fs.List("db")
+	for _, name := range names {
+		if strings.HasSuffix(name, ".sst") || strings.HasSuffix(name, ".tmp") {
+			t.Errorf("Failed flush left %s behind", name)
+		}
+	}
+	if val, err := db.Get(generateKey(5)); err != nil || len(val) != 100 {
+		t.Errorf("Get after a failed flush returned %d bytes, %v", len(val), err)
+	}
+
+	fs.full.Store(false)
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush once space was freed failed: %v", err)
+	}
+	if len(db.current.tables) == 0 {
+		t.Errorf("Retried flush installed no table")
+	}
+	if err := db.Put(wo, generateKey(100), generateValue(100)); err != nil {
+		t.Errorf("Put after the retried flush failed: %v", err)
+	}
+	for i := 0; i <= 100; i++ {
+		if _, err := db.Get(generateKey(i)); err != nil {
+			t.Fatalf("Get %d after the retried flush failed: %v", i, err)
+		}
+	}
+}
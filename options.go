@@ -0,0 +1,442 @@
+package leveldb
+
+import (
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/sstable"
+	"time"
+)
+
+// FilterPolicy selects the kind of filter built for each SSTable.
+type FilterPolicy = sstable.FilterPolicy
+
+const (
+	// FilterPolicyBloom builds a bloom filter over the user keys of each table
+	// so point lookups can skip tables that do not contain the key.
+	FilterPolicyBloom = sstable.FilterPolicyBloom
+	// FilterPolicyNone builds no filter. It saves CPU and space for workloads
+	// that only scan ranges; every Get has to search each table's index.
+	FilterPolicyNone = sstable.FilterPolicyNone
+	// FilterPolicyCuckoo builds a cuckoo filter of 16-bit key fingerprints. At
+	// a similar size to the default bloom filter it has a far lower false
+	// positive rate (about 0.01%), so fewer Gets for absent keys read a block.
+	FilterPolicyCuckoo = sstable.FilterPolicyCuckoo
+)
+
+// CompactionPriority selects what a compaction triggered by the table count
+// merges.
+type CompactionPriority string
+
+const (
+	// CompactionPriorityAll merges every table into one. It is the default.
+	CompactionPriorityAll CompactionPriority = "all"
+	// CompactionPriorityMinOverlap merges only the adjacent pair of tables
+	// with the lowest overlap ratio: the bytes of the older table that fall
+	// in the newer table's key range, divided by the newer table's size.
+	// Each compaction then rewrites two tables instead of all of them, and
+	// tables with disjoint key ranges, such as those of sequential inserts,
+	// are combined before the ones whose keys interleave.
+	CompactionPriorityMinOverlap CompactionPriority = "min-overlap"
+)
+
+// SyncFailurePolicy selects what a database does when an fsync fails. The
+// data written since the last successful fsync may then be lost without a
+// later fsync reporting it again, so the database cannot simply carry on.
+type SyncFailurePolicy string
+
+const (
+	// SyncFailureReadOnly fails the operation and switches the database to
+	// read-only: writes, flushes and compactions fail with ErrReadOnly, reads
+	// keep working. Reopening the database recovers what reached the disk. It
+	// is the default.
+	SyncFailureReadOnly SyncFailurePolicy = "read-only"
+	// SyncFailurePanic panics, so the process restarts and recovers from the
+	// WAL instead of serving data the disk may not have.
+	SyncFailurePanic SyncFailurePolicy = "panic"
+	// SyncFailureRetry retries the fsync SyncRetries times, with a backoff
+	// starting at SyncRetryBackoff and doubling, and switches to read-only if
+	// it keeps failing. Only use it for storage whose errors are transient,
+	// such as network filesystems: Linux drops the dirty pages of a failed
+	// fsync, so a retry on a local disk may succeed although data was lost.
+	SyncFailureRetry SyncFailurePolicy = "retry"
+)
+
+// Options control the behavior of a database. A nil *Options, or any zero
+// field, selects the default.
+type Options struct {
+	// WALSyncInterval, if positive, makes a background goroutine fsync the WAL
+	// on this interval. Writes without Sync are acknowledged once buffered, and
+	// writes with Sync wait for the next background fsync instead of issuing
+	// their own, so concurrent synchronous writers share one fsync. Zero fsyncs
+	// on every Sync write.
+	WALSyncInterval time.Duration
+
+	// MaxKeySize and MaxValueSize bound the size of a single key and value.
+	// Larger writes fail with ErrKeyTooLarge or ErrValueTooLarge. Defaults are
+	// DefaultMaxKeySize and DefaultMaxValueSize; values above what the on-disk
+	// formats can encode are lowered to that limit.
+	MaxKeySize   int
+	MaxValueSize int
+
+	// MemtableShards splits the memtable into this many shards by key hash,
+	// each with its own lock. With more than one shard, concurrent writers
+	// insert their batches in parallel after their WAL append, and large
+	// batches are spread over the shards. The shards are merged into one
+	// sorted SSTable on flush. Default is 1.
+	MemtableShards int
+
+	// MinMemtableSize and MaxMemtableSize bound the memtable size that triggers
+	// a flush. The limit starts at MemtableSizeThreshold, clamped to the range,
+	// doubles whenever the memtable fills up before the previous flush has
+	// finished and halves when flushes run far faster than the memtable fills.
+	// Both default to MemtableSizeThreshold, which keeps the limit fixed.
+	MinMemtableSize int
+	MaxMemtableSize int
+
+	// WriteBufferManager, if set, bounds the memtable memory of every database
+	// sharing it. A write that finds the budget exceeded flushes its database's
+	// memtable early.
+	WriteBufferManager *WriteBufferManager
+
+	// MaxBackgroundJobs is the number of goroutines that run flushes and
+	// compactions, which caps the CPU and disk bandwidth background work takes.
+	// At most one flush and one compaction are in progress at a time. Flushes
+	// take priority: with every worker busy, a running compaction pauses to
+	// run a queued flush. Default is DefaultMaxBackgroundJobs.
+	MaxBackgroundJobs int
+
+	// AsyncGetWorkers is the number of goroutines serving GetAsync lookups.
+	// Default is DefaultAsyncGetWorkers.
+	AsyncGetWorkers int
+
+	// TxnLockTimeout is how long a transaction or write waits for a key locked
+	// by a pessimistic transaction before failing with ErrTxnLockTimeout.
+	// Default is DefaultTxnLockTimeout.
+	TxnLockTimeout time.Duration
+
+	// BulkLoadTableSize is the size at which BeginBulkLoad and ImportSorted
+	// start a new SSTable. Default is BulkLoadTableSize.
+	BulkLoadTableSize int
+
+	// TargetFileSize is the size at which flushes and compactions cut their
+	// output into a new SSTable, so that later compactions can pick smaller
+	// pieces. A table only ends between two keys, so it can grow past the
+	// size by one key's versions. Zero, the default, writes a single table.
+	TargetFileSize int
+
+	// MaxGrandparentOverlapBytes also cuts compaction output into a new
+	// SSTable once it overlaps this many bytes of the tables the compaction
+	// leaves alone, which play the role of LevelDB's grandparent level, so a
+	// later compaction of the output stays cheap. Default is ten times
+	// TargetFileSize; without a TargetFileSize, output is not cut this way.
+	MaxGrandparentOverlapBytes int64
+
+	// OnCompactionPressure, if set, is called in the background with the
+	// result of EstimatePendingCompactionBytes whenever it rises above or
+	// falls back to PendingCompactionBytesLimit, so applications can shed load
+	// until compaction catches up. Calls are not concurrent. The limit defaults
+	// to DefaultPendingCompactionBytesLimit.
+	OnCompactionPressure        func(over bool, pendingBytes int64)
+	PendingCompactionBytesLimit int64
+
+	// OnCorruption, if set, is called after a read or compaction found an
+	// SSTable corrupt and the database moved it to the lost directory. The
+	// keys of the table are gone from then on. It is called on the goroutine
+	// that hit the corruption, without locks held.
+	OnCorruption func(err *CorruptionError)
+
+	// MaxOpenFiles limits the file descriptors the database keeps open. When the
+	// table cache is full, the least recently used SSTable is closed and
+	// reopened on its next read. Default is DefaultMaxOpenFiles.
+	MaxOpenFiles int
+
+	// DataBlockSize is the size at which the entries of an SSTable are cut into
+	// a new data block. Larger blocks suit scans and large values, smaller ones
+	// make point lookups read less. Each table records its block size, and
+	// tables written with any size stay readable. Default is DataBlockSize.
+	DataBlockSize int
+
+	// CompactionReadaheadSize is how many bytes compaction reads from an input
+	// table at once, rather than one data block per read, which matters on
+	// network and other high-latency storage. Default is
+	// DefaultCompactionReadaheadSize.
+	CompactionReadaheadSize int
+
+	// Checksum selects the checksum of new WAL records and SSTable data blocks.
+	// Default is ChecksumCRC32C.
+	Checksum ChecksumType
+
+	// BlockCacheSize is the budget in bytes for cached data blocks and decoded
+	// index and filter blocks together. Default is BlockCacheSize.
+	BlockCacheSize int
+	// MetaCacheSize is the share of BlockCacheSize in which index and filter
+	// blocks are never evicted by data blocks, so large scans cannot push out
+	// what point reads depend on. Default is DefaultMetaCacheSize.
+	MetaCacheSize int
+
+	// FilterPolicy selects the per-table filter. Default is FilterPolicyBloom.
+	FilterPolicy FilterPolicy
+	// FilterFPRate is the target false positive rate of the bloom filter built
+	// for each new SSTable. Lower rates cost more memory and disk per key but
+	// waste fewer block reads on absent keys. Default is DefaultFilterFPRate.
+	FilterFPRate float64
+	// FilterBitsPerKey, if positive, sizes bloom filters by bits per key
+	// instead of by FilterFPRate.
+	FilterBitsPerKey int
+	// AdaptiveFilter, if set, chooses the bits per key of the bloom filters of
+	// new tables from the lookups the filters of the live tables answered,
+	// overriding FilterBitsPerKey.
+	AdaptiveFilter *AdaptiveFilterOptions
+	// PrefixExtractor, if set, also adds the prefix of every key to the bloom
+	// filters, so NewPrefixIterator can skip tables without the prefix. The
+	// extractor's name is recorded per table; tables built with another
+	// extractor are always scanned.
+	PrefixExtractor PrefixExtractor
+
+	// CompactionPriority selects the tables a compaction triggered by the
+	// table count merges. Default is CompactionPriorityAll.
+	CompactionPriority CompactionPriority
+
+	// TombstoneCompactionRatio schedules a compaction of a table in which
+	// more than this fraction of entries are deletions or older versions of a
+	// key in the same table, and its neighbors, as soon as it is installed,
+	// regardless of the table count. Compaction outputs do not trigger it
+	// again. Default is DefaultTombstoneCompactionRatio; 1 or more disables the
+	// trigger.
+	TombstoneCompactionRatio float64
+
+	// TraceFile, if set, is a file that every Write and Get is appended to as
+	// a TraceRecord, for ReplayTrace to reproduce the workload later.
+	TraceFile string
+
+	// WALArchiveDir, if set, is where WALs go once their memtable is flushed,
+	// instead of being deleted. Together with a checkpoint, archived WALs let
+	// RestoreToSequence rebuild the database as of any later write. Archived
+	// files are never deleted by the database.
+	WALArchiveDir string
+
+	// FlushOnClose makes Close write the memtable to an SSTable, so the next
+	// Open has no WAL to replay.
+	FlushOnClose bool
+
+	// DisableAutoCompactions turns off every automatic compaction trigger, for
+	// example during a bulk load. CompactRange still works.
+	DisableAutoCompactions bool
+
+	// TimeWindow, if set, replaces the default compaction with time-windowed
+	// compaction for time-series data.
+	TimeWindow *TimeWindowOptions
+
+	// TimestampSize, if positive, makes the last TimestampSize bytes of every
+	// key a timestamp, compared as an unsigned big-endian number. Keys then
+	// order by the part before the timestamp and, within it, newest timestamp
+	// first, and GetAtTimestamp reads a key as of a timestamp. A database keeps
+	// the size it was created with. Timestamps cannot be combined with
+	// secondary indexes, blobs or bulk loads.
+	TimestampSize int
+
+	// TimestampHorizon, if set with TimestampSize, returns the oldest timestamp
+	// reads still ask for. Compaction keeps, for every key, the versions at or
+	// after it and the newest version before it, and drops older history.
+	TimestampHorizon func() []byte
+
+	// MaxTotalSize, if positive, bounds the bytes of the live SSTables and
+	// memtables of the database. A write that would grow it past the quota
+	// fails with ErrQuotaExceeded and starts a compaction of all tables, which
+	// drops overwritten values and deleted keys. Writes of deletes only are
+	// always accepted, so that space can be freed. Blob files and obsolete
+	// tables still held by iterators are not counted.
+	MaxTotalSize int64
+
+	// FS is the filesystem the database keeps its files in. Default is OSFS.
+	FS FS
+
+	// Now, if set, replaces time.Now as the clock of the database, which
+	// times flushes for the memtable size limit and dates SSTables for
+	// time-windowed compaction. Lock timeouts and the WAL sync interval keep
+	// using the system clock.
+	Now func() time.Time
+
+	// ManualBackgroundJobs starts no background goroutines, for deterministic
+	// tests: flushes and compactions wait in a queue until RunBackgroundJob
+	// runs them. A call that has to wait for a queued job, such as Flush or
+	// Close, runs the queue itself.
+	ManualBackgroundJobs bool
+
+	// SyncFailurePolicy selects what happens when an fsync of the WAL, an
+	// SSTable, the state file or the database directory fails. Default is
+	// SyncFailureReadOnly. SyncRetries and SyncRetryBackoff configure
+	// SyncFailureRetry; defaults are DefaultSyncRetries and
+	// DefaultSyncRetryBackoff.
+	SyncFailurePolicy SyncFailurePolicy
+	SyncRetries       int
+	SyncRetryBackoff  time.Duration
+
+	// ParanoidChecks makes Open verify the footer and index of every live
+	// SSTable against the size of its file, and refuse to open when a table
+	// does not check out or when the WALs reuse sequence numbers, which
+	// catches partial restores and tampering early. Open then reads the
+	// metadata of every table.
+	ParanoidChecks bool
+
+	// VerifyCompactionOutput makes a compaction read back the tables it wrote
+	// before installing them: every block must pass its checksum, the keys
+	// must be ordered across all of them, and they must hold as many entries
+	// as the merge wrote. On a mismatch the output is discarded and the input
+	// tables stay live. It costs a read of the output.
+	VerifyCompactionOutput bool
+}
+
+// TimeWindowOptions configure time-windowed compaction. Every table belongs to
+// the window containing its newest timestamp, and compaction only merges
+// adjacent tables of the same window. Once a window has passed, its tables are
+// no longer rewritten, and after Retention they are dropped without merging.
+type TimeWindowOptions struct {
+	// Window is the width of each window. Default is one hour.
+	Window time.Duration
+	// Timestamp, if set, extracts the timestamp embedded in a key. Otherwise a
+	// table's time range is when its data was flushed.
+	Timestamp func(key []byte) (time.Time, bool)
+	// Retention, if positive, drops whole windows that ended more than
+	// Retention ago, including any keys and tombstones in them.
+	Retention time.Duration
+	// MinTables is the number of adjacent tables in one window that triggers
+	// a compaction of them. Default is 4.
+	MinTables int
+}
+
+// AdaptiveFilterOptions configure the sizing of bloom filters from their
+// measured effectiveness, see FilterStats. FilterFPRate is then the rate of
+// false positives per lookup that reaches a table: tables mostly asked for
+// keys they hold get smaller filters, and filters that let through more keys
+// than predicted get larger ones.
+type AdaptiveFilterOptions struct {
+	// MinBitsPerKey and MaxBitsPerKey bound the bits per key. Defaults are
+	// DefaultMinFilterBitsPerKey and DefaultMaxFilterBitsPerKey.
+	MinBitsPerKey int
+	MaxBitsPerKey int
+	// MinChecks is the number of lookups a table's filter must have answered
+	// before its statistics count. Default is DefaultAdaptiveFilterMinChecks.
+	MinChecks int64
+}
+
+// initialMemtableSize is the memtable size limit a database starts with.
+func (o *Options) initialMemtableSize() int {
+	return min(max(MemtableSizeThreshold, o.MinMemtableSize), o.MaxMemtableSize)
+}
+
+// sanitizeOptions returns a copy of opts with defaults filled in.
+func sanitizeOptions(opts *Options) *Options {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxKeySize <= 0 {
+		o.MaxKeySize = DefaultMaxKeySize
+	}
+	if o.MaxValueSize <= 0 {
+		o.MaxValueSize = DefaultMaxValueSize
+	}
+	if o.MemtableShards <= 0 {
+		o.MemtableShards = 1
+	}
+	if o.MinMemtableSize <= 0 {
+		o.MinMemtableSize = MemtableSizeThreshold
+		if o.MaxMemtableSize > 0 {
+			o.MinMemtableSize = min(o.MinMemtableSize, o.MaxMemtableSize)
+		}
+	}
+	if o.MaxMemtableSize < o.MinMemtableSize {
+		o.MaxMemtableSize = max(o.MinMemtableSize, MemtableSizeThreshold)
+	}
+	if o.MaxBackgroundJobs <= 0 {
+		o.MaxBackgroundJobs = DefaultMaxBackgroundJobs
+	}
+	if o.AsyncGetWorkers <= 0 {
+		o.AsyncGetWorkers = DefaultAsyncGetWorkers
+	}
+	if o.TxnLockTimeout <= 0 {
+		o.TxnLockTimeout = DefaultTxnLockTimeout
+	}
+	if o.BulkLoadTableSize <= 0 {
+		o.BulkLoadTableSize = BulkLoadTableSize
+	}
+	if o.MaxGrandparentOverlapBytes <= 0 {
+		o.MaxGrandparentOverlapBytes = 10 * int64(o.TargetFileSize)
+	}
+	if o.PendingCompactionBytesLimit <= 0 {
+		o.PendingCompactionBytesLimit = DefaultPendingCompactionBytesLimit
+	}
+	if o.MaxOpenFiles <= 0 {
+		o.MaxOpenFiles = DefaultMaxOpenFiles
+	}
+	if o.DataBlockSize <= 0 {
+		o.DataBlockSize = DataBlockSize
+	}
+	if o.CompactionReadaheadSize <= 0 {
+		o.CompactionReadaheadSize = DefaultCompactionReadaheadSize
+	}
+	if o.Checksum == base.ChecksumLegacy || !o.Checksum.Valid() {
+		o.Checksum = ChecksumCRC32C
+	}
+	if o.BlockCacheSize <= 0 {
+		o.BlockCacheSize = BlockCacheSize
+	}
+	if o.MetaCacheSize <= 0 {
+		o.MetaCacheSize = DefaultMetaCacheSize
+	}
+	o.MetaCacheSize = min(o.MetaCacheSize, o.BlockCacheSize)
+	if o.CompactionPriority == "" {
+		o.CompactionPriority = CompactionPriorityAll
+	}
+	if o.FilterPolicy == "" {
+		o.FilterPolicy = FilterPolicyBloom
+	}
+	if o.FilterFPRate <= 0 || o.FilterFPRate >= 1 {
+		o.FilterFPRate = DefaultFilterFPRate
+	}
+	if o.TombstoneCompactionRatio <= 0 {
+		o.TombstoneCompactionRatio = DefaultTombstoneCompactionRatio
+	}
+	if o.TimeWindow != nil {
+		tw := *o.TimeWindow
+		if tw.Window <= 0 {
+			tw.Window = time.Hour
+		}
+		if tw.MinTables < 2 {
+			tw.MinTables = 4
+		}
+		o.TimeWindow = &tw
+	}
+	if o.AdaptiveFilter != nil {
+		af := *o.AdaptiveFilter
+		if af.MinBitsPerKey <= 0 {
+			af.MinBitsPerKey = DefaultMinFilterBitsPerKey
+		}
+		if af.MaxBitsPerKey <= 0 {
+			af.MaxBitsPerKey = DefaultMaxFilterBitsPerKey
+		}
+		af.MaxBitsPerKey = max(af.MaxBitsPerKey, af.MinBitsPerKey)
+		if af.MinChecks <= 0 {
+			af.MinChecks = DefaultAdaptiveFilterMinChecks
+		}
+		o.AdaptiveFilter = &af
+	}
+	if o.FS == nil {
+		o.FS = OSFS{}
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	if o.SyncFailurePolicy == "" {
+		o.SyncFailurePolicy = SyncFailureReadOnly
+	}
+	if o.SyncRetries <= 0 {
+		o.SyncRetries = DefaultSyncRetries
+	}
+	if o.SyncRetryBackoff <= 0 {
+		o.SyncRetryBackoff = DefaultSyncRetryBackoff
+	}
+	o.MaxKeySize = min(o.MaxKeySize, maxEncodedSize)
+	o.MaxValueSize = min(o.MaxValueSize, maxEncodedSize)
+	return &o
+}
@@ -0,0 +1,80 @@
+package leveldb
+
+import (
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/sstable"
+)
+
+// checkWALChain fails on a WAL record that reuses sequence numbers, for
+// Options.ParanoidChecks. Gaps are left as warnings, since writes without the
+// WAL leave them on purpose.
+func checkWALChain(warnings []RecoveryWarning) error {
+	for _, w := range warnings {
+		if w.Kind == RecoveryDuplicateSequence {
+			return fmt.Errorf("paranoid checks: %v", w)
+		}
+	}
+	return nil
+}
+
+// paranoidChecks verifies every live SSTable of state in dir with
+// sstable.Verify, for Options.ParanoidChecks.
+func paranoidChecks(fs FS, dir string, state DBState) error {
+	for _, num := range state.ActiveSSTables {
+		if err := sstable.Verify(fs, tablePath(dir, num)); err != nil {
+			return fmt.Errorf("paranoid checks: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyCompactionOutput reads back the tables at paths, written in order by
+// a compaction, for Options.VerifyCompactionOutput. Every block must pass its
+// checksum, the keys must be strictly ordered within and across the tables,
+// with no user key split between two of them, and the tables must hold
+// entries entries in all, as many as each records in its properties.
+func verifyCompactionOutput(fs FS, paths []string, entries uint64) error {
+	var cmp base.InternalKeyComparator
+	var last InternalKey
+	var total uint64
+	for i, path := range paths {
+		if err := sstable.Verify(fs, path); err != nil {
+			return err
+		}
+		if err := func() error {
+			r, err := sstable.Open(fs, path, sstable.ReaderOptions{})
+			if err != nil {
+				return err
+			}
+			it := r.NewIterator()
+			r.Unref() // The iterator keeps the reader open
+			defer it.Close()
+			var n uint64
+			for it.SeekToFirst(); it.Valid(); it.Next() {
+				key := it.Key()
+				if total+n > 0 {
+					if c := cmp.Compare(last, key); c >= 0 || (n == 0 && last.UserKey == key.UserKey) {
+						return fmt.Errorf("%s: key %q (seq %d) does not follow %q (seq %d)", path, key.UserKey, key.SeqNum, last.UserKey, last.SeqNum)
+					}
+				}
+				last = key
+				n++
+			}
+			if err := it.Error(); err != nil {
+				return err
+			}
+			if props := r.Properties(); n != props.NumEntries {
+				return fmt.Errorf("%s: read %d entries, the table records %d", path, n, props.NumEntries)
+			}
+			total += n
+			return nil
+		}(); err != nil {
+			return fmt.Errorf("output table %d: %w", i, err)
+		}
+	}
+	if total != entries {
+		return fmt.Errorf("output holds %d entries, the merge wrote %d", total, entries)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParanoidChecks(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{ParanoidChecks: true}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		db.Put(WriteOptions{}, generateKey(i), generateValue(100))
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	path := tablePath(dir, db.current.tables[0])
+	db.Close()
+
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Paranoid checks failed on a healthy database: %v", err)
+	}
+	db.Close()
+
+	// A footer that moves the filter start into the data blocks. Reads only
+	// notice once they load the filter, the paranoid checks on open.
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read table: %v", err)
+	}
+	size := int(binary.LittleEndian.Uint32(raw[len(raw)-4:]))
+	var footer Footer
+	if err := gob.NewDecoder(bytes.NewReader(raw[len(raw)-4-size : len(raw)-4])).Decode(&footer); err != nil {
+		t.Fatalf("Failed to decode footer: %v", err)
+	}
+	footer.FilterOffset--
+	footer.FilterSize++
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(footer); err != nil {
+		t.Fatalf("Failed to encode footer: %v", err)
+	}
+	raw = binary.LittleEndian.AppendUint32(append(raw[:len(raw)-4-size], buf.Bytes()...), uint32(buf.Len()))
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+	if _, err := Open(dir, opts); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("Expected paranoid checks to reject the table, got %v", err)
+	}
+	db, err = Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open without paranoid checks failed: %v", err)
+	}
+	db.Close()
+}
+
+func TestParanoidChecksWALChain(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, seqs ...uint64) {
+		wal, err := NewWAL(filepath.Join(dir, name), 0, ChecksumCRC32C)
+		if err != nil {
+			t.Fatalf("NewWAL failed: %v", err)
+		}
+		for _, seq := range seqs {
+			entry := &LogEntry{Op: OpPut, Key: generateKey(int(seq)), Value: []byte("v"), SeqNum: seq}
+			if err := wal.Write(entry, false); err != nil {
+				t.Fatalf("WAL write failed: %v", err)
+			}
+		}
+		wal.Close()
+	}
+	write("wal-00001.log", 1, 2, 3)
+	write("db.wal", 2, 3) // A WAL of another database, or restored twice
+
+	if _, err := Open(dir, &Options{ParanoidChecks: true}); err == nil {
+		t.Fatalf("Expected paranoid checks to reject reused sequence numbers")
+	}
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open without paranoid checks failed: %v", err)
+	}
+	db.Close()
+}
+
+// flipFS is a MemFS that flips a bit of the first write to every file with a
+// name ending in .sst.tmp while flip is set.
+type flipFS struct {
+	*MemFS
+	flip atomic.Bool
+}
+
+func (fs *flipFS) Create(name string) (File, error) {
+	f, err := fs.MemFS.Create(name)
+	if err != nil || !fs.flip.Load() || !strings.HasSuffix(name, ".sst.tmp") {
+		return f, err
+	}
+	return &flipFile{File: f}, nil
+}
+
+type flipFile struct {
+	File
+	written bool
+}
+
+func (f *flipFile) Write(p []byte) (int, error) {
+	if !f.written && len(p) > 0 {
+		f.written = true
+		p = bytes.Clone(p)
+		p[len(p)/2] ^= 1
+	}
+	return f.File.Write(p)
+}
+
+func TestVerifyCompactionOutput(t *testing.T) {
+	fs := &flipFS{MemFS: NewMemFS()}
+	db, err := Open("db", &Options{FS: fs, VerifyCompactionOutput: true, DisableAutoCompactions: true})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 200; i++ {
+		db.Put(WriteOptions{}, generateKey(i), generateValue(100))
+		if i == 99 {
+			db.Flush()
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	tables := slices.Clone(db.current.tables)
+	paths := []string{tablePath("db", tables[0]), tablePath("db", tables[1])}
+	if err := verifyCompactionOutput(fs, paths, 200); err != nil {
+		t.Errorf("Verification of ordered tables failed: %v", err)
+	}
+	if err := verifyCompactionOutput(fs, paths, 199); err == nil {
+		t.Errorf("Verification passed with the wrong number of entries")
+	}
+	if err := verifyCompactionOutput(fs, []string{paths[1], paths[0]}, 200); err == nil {
+		t.Errorf("Verification passed with tables out of order")
+	}
+
+	// A bit flipped on its way to the disk keeps the input tables live.
+	fs.flip.Store(true)
+	if err := db.CompactRange(nil, nil); err == nil {
+		t.Fatalf("CompactRange installed a corrupted table")
+	}
+	if !slices.Equal(db.current.tables, tables) {
+		t.Errorf("Tables are %v after a failed verification, want %v", db.current.tables, tables)
+	}
+	if names, _ := fs.List("db"); slices.ContainsFunc(names, func(name string) bool { return strings.HasSuffix(name, ".tmp") }) {
+		t.Errorf("Output of the failed compaction was left behind: %v", names)
+	}
+	for i := 0; i < 200; i++ {
+		if val, err := db.Get(generateKey(i)); err != nil || len(val) != 100 {
+			t.Fatalf("Get %d returned %d bytes, %v", i, len(val), err)
+		}
+	}
+
+	fs.flip.Store(false)
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if len(db.current.tables) != 1 {
+		t.Errorf("Got %d tables after compaction, want 1", len(db.current.tables))
+	}
+}
@@ -0,0 +1,226 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTxnLockTimeout is returned when a key stays locked by a pessimistic
+// transaction for longer than Options.TxnLockTimeout.
+var ErrTxnLockTimeout = errors.New("timed out waiting for a transaction lock")
+
+// keyLocks are the key locks of pessimistic transactions. Locks are taken
+// and checked under db.writeMu, so a write never slips between a lock and
+// the read that follows it.
+type keyLocks struct {
+	mu       sync.Mutex
+	owners   map[string]*PessimisticTxn
+	released chan struct{} // Closed and replaced whenever locks are released
+	held     atomic.Int32  // len(owners), read by writers without mu
+}
+
+// waitChan returns a channel that is closed once locks are released, if a
+// key of batch is locked by a transaction other than owner, and nil otherwise.
+func (l *keyLocks) waitChan(batch *WriteBatch, owner *PessimisticTxn) <-chan struct{} {
+	if l.held.Load() == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range batch.entries {
+		if o := l.owners[string(e.key)]; o != nil && o != owner {
+			return l.released
+		}
+	}
+	return nil
+}
+
+// releaseAll drops every lock of owner and wakes the writers waiting for one.
+func (l *keyLocks) releaseAll(owner *PessimisticTxn, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if l.owners[key] == owner {
+			delete(l.owners, key)
+			l.held.Add(-1)
+		}
+	}
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// awaitRelease waits for ch, giving up at deadline or when ctx is done.
+func awaitRelease(ctx context.Context, ch <-chan struct{}, deadline time.Time) error {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return ErrTxnLockTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lockWriteMu acquires db.writeMu once no key of batch is locked by a
+// pessimistic transaction other than owner.
+func (db *DB) lockWriteMu(ctx context.Context, batch *WriteBatch, owner *PessimisticTxn) error {
+	deadline := time.Now().Add(db.opts.TxnLockTimeout)
+	for {
+		if err := db.writeMu.LockContext(ctx); err != nil {
+			return err
+		}
+		ch := db.locks.waitChan(batch, owner)
+		if ch == nil {
+			return nil
+		}
+		db.writeMu.Unlock()
+		if err := awaitRelease(ctx, ch, deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// PessimisticTxn locks every key it writes or reads with GetForUpdate until
+// it commits or rolls back. Other transactions and plain writes of a locked
+// key wait for the lock, up to Options.TxnLockTimeout, so Commit never fails
+// with a conflict.
+type PessimisticTxn struct {
+	db     *DB
+	locked []string
+	writes map[string]batchEntry // Latest buffered write for each key, for read-your-writes
+	batch  *WriteBatch
+	done   bool
+}
+
+// BeginPessimistic starts a new pessimistic transaction.
+func (db *DB) BeginPessimistic() *PessimisticTxn {
+	return &PessimisticTxn{db: db, writes: make(map[string]batchEntry), batch: NewWriteBatch()}
+}
+
+// lock takes the lock on key, waiting up to Options.TxnLockTimeout for
+// another transaction to release it.
+func (t *PessimisticTxn) lock(key []byte) error {
+	db, l := t.db, &t.db.locks
+	deadline := time.Now().Add(db.opts.TxnLockTimeout)
+	for {
+		db.writeMu.Lock()
+		l.mu.Lock()
+		owner := l.owners[string(key)]
+		if owner == nil {
+			l.owners[string(key)] = t
+			l.held.Add(1)
+			t.locked = append(t.locked, string(key))
+		}
+		ch := l.released
+		l.mu.Unlock()
+		if owner == nil || owner == t {
+			// Writes acknowledged before the lock must be visible to the
+			// reads under it.
+			db.waitForInserts()
+			db.writeMu.Unlock()
+			return nil
+		}
+		db.writeMu.Unlock()
+		if err := awaitRelease(context.Background(), ch, deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// Get reads a key, observing the transaction's own uncommitted writes first,
+// without locking it.
+func (t *PessimisticTxn) Get(key []byte) ([]byte, bool, error) {
+	if t.done {
+		return nil, false, ErrTxnDone
+	}
+	if e, ok := t.writes[string(key)]; ok {
+		if e.op == OpTypeDelete {
+			return nil, false, nil
+		}
+		return e.value, true, nil
+	}
+	val, err := t.db.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	return val, err == nil, err
+}
+
+// GetForUpdate locks key and then reads it, so no other writer can change
+// the value before the transaction commits.
+func (t *PessimisticTxn) GetForUpdate(key []byte) ([]byte, bool, error) {
+	if t.done {
+		return nil, false, ErrTxnDone
+	}
+	if err := t.lock(key); err != nil {
+		return nil, false, err
+	}
+	return t.Get(key)
+}
+
+// Put locks key and buffers a key-value pair to be written on Commit.
+func (t *PessimisticTxn) Put(key, value []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := t.lock(key); err != nil {
+		return err
+	}
+	t.batch.Put(key, value)
+	t.writes[string(key)] = batchEntry{op: OpTypePut, key: key, value: value}
+	return nil
+}
+
+// Delete locks key and buffers its removal to be applied on Commit.
+func (t *PessimisticTxn) Delete(key []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := t.lock(key); err != nil {
+		return err
+	}
+	t.batch.Delete(key)
+	t.writes[string(key)] = batchEntry{op: OpTypeDelete, key: key}
+	return nil
+}
+
+// Commit applies the buffered writes atomically and releases the locks.
+func (t *PessimisticTxn) Commit(wo WriteOptions) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	defer t.db.locks.releaseAll(t, t.locked)
+	if t.batch.Len() == 0 {
+		return nil
+	}
+	if err := t.db.checkBatch(t.batch); err != nil {
+		return err
+	}
+	if err := t.db.lockWriteMu(context.Background(), t.batch, t); err != nil {
+		return err
+	}
+	pending, err := t.db.writeLocked(wo, t.batch)
+	t.db.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return pending.wait()
+}
+
+// Rollback discards the buffered writes and releases the locks.
+func (t *PessimisticTxn) Rollback() {
+	if !t.done {
+		t.db.locks.releaseAll(t, t.locked)
+	}
+	t.done = true
+	t.batch.Reset()
+}
@@ -0,0 +1,86 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/sstable"
+)
+
+// PrefixExtractor maps a key to the prefix that range scans are bounded by.
+type PrefixExtractor = sstable.PrefixExtractor
+
+type fixedPrefix int
+
+// FixedPrefix returns an extractor that uses the first n bytes of a key as its
+// prefix. Keys shorter than n bytes have no prefix.
+func FixedPrefix(n int) PrefixExtractor {
+	return fixedPrefix(n)
+}
+
+func (n fixedPrefix) Name() string {
+	return fmt.Sprintf("fixed:%d", int(n))
+}
+
+func (n fixedPrefix) Prefix(key []byte) []byte {
+	if len(key) < int(n) {
+		return nil
+	}
+	return key[:n]
+}
+
+// NewPrefixIterator returns an iterator over the keys that start with prefix,
+// positioned at the first of them. When Options.PrefixExtractor is set and
+// extracts a prefix from prefix itself, SSTables whose filter does not contain
+// that prefix are not read at all.
+func (db *DB) NewPrefixIterator(prefix []byte) Iterator {
+	seq := db.sequenceNum.Load()
+	var include func(*SSTableReader) bool
+	if extractor := db.opts.PrefixExtractor; extractor != nil {
+		if p := extractor.Prefix(prefix); p != nil {
+			include = func(r *SSTableReader) bool { return r.MayContainPrefix(extractor, p) }
+		}
+	}
+	it := &prefixIterator{
+		Iterator: &userKeyIterator{Iterator: db.newMergingIterator(seq, include), db: db, seq: seq},
+		prefix:   prefix,
+	}
+	it.SeekToFirst()
+	return it
+}
+
+// prefixIterator restricts an iterator to the keys starting with prefix.
+type prefixIterator struct {
+	Iterator
+	prefix []byte
+}
+
+func (it *prefixIterator) Valid() bool {
+	return it.Iterator.Valid() && bytes.HasPrefix([]byte(it.Iterator.Key().UserKey), it.prefix)
+}
+
+func (it *prefixIterator) SeekToFirst() {
+	it.Iterator.Seek(it.prefix)
+}
+
+// Seek moves to the first key at or after key, but not before the prefix.
+func (it *prefixIterator) Seek(key []byte) {
+	if bytes.Compare(key, it.prefix) < 0 {
+		key = it.prefix
+	}
+	it.Iterator.Seek(key)
+}
+
+// SeekForPrev moves to the last key at or before key, which is invalid if no
+// key of the prefix is.
+func (it *prefixIterator) SeekForPrev(key []byte) {
+	base.SeekForPrev(it, key)
+}
+
+func (it *prefixIterator) SeekToLast() {
+	it.Iterator.SeekToLast()
+	for it.Iterator.Valid() && !bytes.HasPrefix([]byte(it.Iterator.Key().UserKey), it.prefix) &&
+		bytes.Compare([]byte(it.Iterator.Key().UserKey), it.prefix) > 0 {
+		it.Iterator.Prev()
+	}
+}
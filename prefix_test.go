@@ -0,0 +1,48 @@
+package leveldb
+
+import "testing"
+
+func TestPrefixIteratorSkipsTables(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{PrefixExtractor: FixedPrefix(4)})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	for _, keys := range [][]string{{"aaaa1", "aaaa2"}, {"bbbb1", "bbbb2", "bbbb3"}} {
+		for _, k := range keys {
+			if err := db.Put(WriteOptions{}, []byte(k), []byte(k)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	var tablesWithPrefix int
+	for _, num := range db.current.tables {
+		r, err := db.findTable(num)
+		if err != nil {
+			t.Fatalf("findTable failed: %v", err)
+		}
+		if r.MayContainPrefix(db.opts.PrefixExtractor, []byte("bbbb")) {
+			tablesWithPrefix++
+		}
+		r.Unref()
+	}
+	if tablesWithPrefix != 1 {
+		t.Errorf("Expected the prefix filter to match 1 table, got %d", tablesWithPrefix)
+	}
+
+	var got []string
+	it := db.NewPrefixIterator([]byte("bbbb"))
+	for ; it.Valid(); it.Next() {
+		got = append(got, it.Key().UserKey)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(got) != 3 || got[0] != "bbbb1" || got[2] != "bbbb3" {
+		t.Errorf("Unexpected prefix scan result %v", got)
+	}
+}
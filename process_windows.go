@@ -0,0 +1,14 @@
+package leveldb
+
+import "os"
+
+// processAlive reports whether a process with this pid runs on this host.
+// FindProcess fails on Windows for a process that does not exist.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
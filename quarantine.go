@@ -0,0 +1,70 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// lostDir is the directory in the data directory that quarantined SSTables
+// are moved to.
+const lostDir = "lost"
+
+// quarantine takes the SSTable that a *CorruptionError in err names out of
+// the database and keeps it under lost/ for inspection, so that reads and
+// compactions of the other tables carry on. The keys the table held are lost,
+// and older versions of them in older tables become visible again. It
+// reports whether the table is out of the database, so the caller can skip
+// it.
+func (db *DB) quarantine(err error) bool {
+	var ce *CorruptionError
+	if !errors.As(err, &ce) || filepath.Clean(filepath.Dir(ce.File)) != filepath.Clean(db.dataDir) {
+		return false
+	}
+	var num int
+	if _, serr := fmt.Sscanf(filepath.Base(ce.File), "%05d.sst", &num); serr != nil {
+		return false
+	}
+
+	db.mu.Lock()
+	if db.closed.Load() {
+		db.mu.Unlock()
+		return false
+	}
+	if db.quarantined[num] {
+		db.mu.Unlock()
+		return true
+	}
+	i := slices.Index(db.current.tables, num)
+	if i < 0 {
+		db.mu.Unlock()
+		return false
+	}
+	// The link keeps the file once the Version release deletes its name.
+	lost := filepath.Join(db.dataDir, lostDir)
+	if err := db.opts.FS.MkdirAll(lost); err != nil {
+		db.mu.Unlock()
+		log.Printf("ERROR: Failed to quarantine SSTable %s: %v", ce.File, err)
+		return false
+	}
+	if err := db.opts.FS.Link(ce.File, filepath.Join(lost, filepath.Base(ce.File))); err != nil && !os.IsExist(err) {
+		db.mu.Unlock()
+		log.Printf("ERROR: Failed to quarantine SSTable %s: %v", ce.File, err)
+		return false
+	}
+	db.quarantined[num] = true
+	db.stats.quarantined++
+	if err := db.installVersionLocked(slices.Delete(slices.Clone(db.current.tables), i, i+1), nil); err != nil {
+		log.Printf("CRITICAL ERROR: Failed to save state after quarantining SSTable %s: %v", ce.File, err)
+	}
+	db.mu.Unlock()
+
+	log.Printf("WARNING: Quarantined SSTable %s in %s: %v", ce.File, lost, ce)
+	if db.opts.OnCorruption != nil {
+		db.opts.OnCorruption(ce)
+	}
+	return true
+}
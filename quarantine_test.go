@@ -0,0 +1,158 @@
+package leveldb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCorruptTableQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	var corrupt []*CorruptionError
+	opts := &Options{OnCorruption: func(err *CorruptionError) { corrupt = append(corrupt, err) }}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	wo := WriteOptions{}
+	db.Put(wo, []byte("a"), []byte("old"))
+	db.Put(wo, []byte("b"), []byte("1"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.Put(wo, []byte("a"), []byte("new"))
+	db.Put(wo, []byte("c"), []byte("2"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	newest := db.current.tables[len(db.current.tables)-1]
+	path := tablePath(db.dataDir, newest)
+	db.Close()
+
+	// Flip a bit in the data block of the newest table.
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read table: %v", err)
+	}
+	raw[10] ^= 0x01
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	// The lookup skips the corrupt table and finds the older version.
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "old" {
+		t.Errorf("Get(a) = %q, %v; want the version of the older table", val, err)
+	}
+	if len(corrupt) != 1 || corrupt[0].File != path {
+		t.Fatalf("OnCorruption calls = %v, want one for %s", corrupt, path)
+	}
+	if val, err := db.Get([]byte("b")); err != nil || string(val) != "1" {
+		t.Errorf("Get(b) = %q, %v; want 1, nil", val, err)
+	}
+	if _, err := db.Get([]byte("c")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(c) = %v, want the key lost with its table", err)
+	}
+	if slices.Contains(db.current.tables, newest) {
+		t.Errorf("Corrupt table %d is still live", newest)
+	}
+	if _, err := os.Stat(filepath.Join(dir, lostDir, filepath.Base(path))); err != nil {
+		t.Errorf("Corrupt table not kept in %s: %v", lostDir, err)
+	}
+	db.Close()
+
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "old" || len(corrupt) != 1 {
+		t.Errorf("Get(a) after reopen = %q, %v with %d corruption reports", val, err, len(corrupt))
+	}
+}
+
+// failOpenFS is a MemFS that fails to open SSTables while failing is set.
+type failOpenFS struct {
+	*MemFS
+	failing atomic.Bool
+}
+
+func (fs *failOpenFS) Open(name string) (File, error) {
+	if fs.failing.Load() && strings.HasSuffix(name, ".sst") {
+		return nil, errInjected
+	}
+	return fs.MemFS.Open(name)
+}
+
+func TestIteratorTableErrors(t *testing.T) {
+	fs := &failOpenFS{MemFS: NewMemFS()}
+	db, err := Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	wo := WriteOptions{}
+	for _, key := range []string{"a", "b"} {
+		db.Put(wo, []byte(key), []byte("1"))
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	newest := db.current.tables[len(db.current.tables)-1]
+	db.Close()
+
+	// A table that cannot be opened fails the iterator.
+	db, err = Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	fs.failing.Store(true)
+	iter := db.NewIterator()
+	iter.SeekToFirst()
+	if iter.Valid() || !errors.Is(iter.Error(), errInjected) {
+		t.Errorf("Iterator over an unreadable table: valid %v, error %v", iter.Valid(), iter.Error())
+	}
+	iter.Close()
+	fs.failing.Store(false)
+	db.Close()
+
+	// A corrupt table is quarantined and skipped.
+	path := tablePath("db", newest)
+	f, err := fs.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open table: %v", err)
+	}
+	raw, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Failed to read table: %v", err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	if err := writeFile(fs, path, raw); err != nil {
+		t.Fatalf("Failed to write table: %v", err)
+	}
+	db, err = Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	iter = db.NewIterator()
+	defer iter.Close()
+	var keys []string
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key().UserKey)
+	}
+	if iter.Error() != nil || !slices.Equal(keys, []string{"a"}) {
+		t.Errorf("Iterator over a corrupt table returned %v, %v; want only the other table", keys, iter.Error())
+	}
+	if slices.Contains(db.current.tables, newest) {
+		t.Errorf("Corrupt table %d is still live", newest)
+	}
+}
@@ -0,0 +1,56 @@
+package leveldb
+
+import "fmt"
+
+// checkQuota returns an error wrapping ErrQuotaExceeded if batch would grow
+// the database past Options.MaxTotalSize, and starts a compaction that may
+// bring it back under. Batches of deletes always pass, so that space can be
+// freed.
+func (db *DB) checkQuota(batch *WriteBatch) error {
+	if db.opts.MaxTotalSize <= 0 {
+		return nil
+	}
+	var size int64
+	for _, e := range batch.entries {
+		if e.op != OpTypeDelete {
+			size += int64(len(e.key) + len(e.value))
+		}
+	}
+	if size == 0 {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	used := db.totalSizeLocked()
+	if used+size <= db.opts.MaxTotalSize {
+		return nil
+	}
+	db.maybeScheduleCompactionLocked()
+	return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, used, db.opts.MaxTotalSize)
+}
+
+// totalSizeLocked returns the bytes counted against Options.MaxTotalSize: the
+// live SSTables and the memtables, whose entries are in the WALs. The caller
+// must hold db.mu, at least for reading.
+func (db *DB) totalSizeLocked() int64 {
+	used := db.liveTableBytes + int64(db.mem.ApproximateSize())
+	if db.immutableMem != nil {
+		used += int64(db.immutableMem.ApproximateSize())
+	}
+	return used
+}
+
+// overQuotaLocked reports whether the database is at or past
+// Options.MaxTotalSize. The caller must hold db.mu, at least for reading.
+func (db *DB) overQuotaLocked() bool {
+	return db.opts.MaxTotalSize > 0 && db.totalSizeLocked() >= db.opts.MaxTotalSize
+}
+
+// tableBytes returns the size of the files of tables.
+func (db *DB) tableBytes(tables []int) int64 {
+	var size int64
+	for _, num := range tables {
+		size += fileSize(db.opts.FS, tablePath(db.dataDir, num))
+	}
+	return size
+}
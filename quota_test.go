@@ -0,0 +1,55 @@
+package leveldb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMaxTotalSize(t *testing.T) {
+	const quota = 64 * 1024
+	db, err := OpenInMemory(&Options{MaxTotalSize: quota, MaxMemtableSize: 16 * 1024})
+	if err != nil {
+		t.Fatalf("OpenInMemory failed: %v", err)
+	}
+	defer db.Close()
+	wo := WriteOptions{}
+
+	n := 0
+	for ; n < 1000; n++ {
+		err := db.Put(wo, generateKey(n), generateValue(1000))
+		if errors.Is(err, ErrQuotaExceeded) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if n == 1000 || n < 32 {
+		t.Fatalf("Quota of %d bytes rejected Put %d", quota, n)
+	}
+	usage, err := db.DiskUsage()
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if usage.LiveTables > quota {
+		t.Errorf("Live tables use %d bytes, over the quota of %d", usage.LiveTables, quota)
+	}
+	if _, err := db.Get(generateKey(0)); err != nil {
+		t.Errorf("Get over the quota failed: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		if err := db.Delete(wo, generateKey(i)); err != nil {
+			t.Fatalf("Delete over the quota failed: %v", err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if err := db.Put(wo, generateKey(n), generateValue(1000)); err != nil {
+		t.Errorf("Put after freeing space failed: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/memtable"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// readersLock is the lock that readers opened with OpenReadOnly share. The
+// writer only deletes obsolete tables while it can take it exclusively.
+const readersLock = "READERS"
+
+// OpenReadOnly opens the database in dir for reading, next to the process
+// that may have it open with Open. The view is built from the state file and
+// the WALs without changing anything on disk, and stays as it is until
+// Refresh. Writes, flushes and compactions fail with ErrReadOnly.
+//
+// The reader holds a shared lock on the READERS file of the database for as
+// long as it is open. The writer keeps the tables it no longer needs while
+// any reader holds it, so that the view of every reader stays readable, and
+// deletes them once the readers are gone.
+func OpenReadOnly(dir string, opts *Options) (*DB, error) {
+	opts = sanitizeOptions(opts)
+	fs := opts.FS
+	if _, err := fs.Stat(dir); err != nil {
+		return nil, err
+	}
+	// The writer only takes the lock for as long as it deletes files.
+	var shared io.Closer
+	var err error
+	for attempt := 0; ; attempt++ {
+		shared, err = fs.LockShared(filepath.Join(dir, readersLock))
+		if !errors.Is(err, ErrDBLocked) || attempt == readViewAttempts {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire shared lock: %w", err)
+	}
+
+	db, err := newDB(dir, opts)
+	if err != nil {
+		shared.Close()
+		return nil, err
+	}
+	db.sharedLock = shared
+	db.prepared = make(map[string]*WriteBatch)
+	readOnly := fmt.Errorf("%w: opened with OpenReadOnly", ErrReadOnly)
+	db.readOnly.Store(&readOnly)
+	db.mem = memtable.New(opts.MemtableShards)
+	db.current = db.newVersion(nil, nil)
+	if err := db.Refresh(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Refresh brings the view of a database opened with OpenReadOnly up to what
+// its writer has logged or flushed since. Iterators keep the view they
+// started with.
+func (db *DB) Refresh() error {
+	if db.sharedLock == nil {
+		return errors.New("Refresh needs a database opened with OpenReadOnly")
+	}
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	view, err := readView(db.opts.FS, db.dataDir, db.opts)
+	if err != nil {
+		return err
+	}
+	legacySeqs := db.readTableSeqs(view.state.ActiveSSTables, view.lastSeq, view.state.TableSeqs)
+	db.mu.Lock()
+	old := db.current
+	db.sequenceNum.Store(view.lastSeq)
+	db.current = db.newVersion(view.state.ActiveSSTables, view.state.TableSeqs, legacySeqs)
+	db.mem = view.mem
+	db.hasBlobs = view.state.HasBlobs
+	db.mu.Unlock()
+	old.unref()
+	return nil
+}
+
+// readViewAttempts bounds how often a reader tries again to open or refresh
+// while the writer changes the files it reads.
+const readViewAttempts = 100
+
+// dbView is what a reader sees of a database.
+type dbView struct {
+	state   DBState
+	mem     *Memtable // Contents of the WALs
+	lastSeq uint64
+}
+
+// readView reads the view of the database in dir. The writer may rotate,
+// flush and delete WALs meanwhile, and the active WAL may end in a record
+// being written, so a view is only taken once the state file and the list of
+// WALs read the same before and after replaying the WALs.
+func readView(fs FS, dir string, opts *Options) (*dbView, error) {
+	var err error
+	for attempt := range readViewAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		var view *dbView
+		var stable bool
+		if view, stable, err = tryReadView(fs, dir, opts); err == nil && stable {
+			return view, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("the database kept changing")
+	}
+	return nil, fmt.Errorf("failed to read a consistent view of %s: %w", dir, err)
+}
+
+// tryReadView reads the view of the database in dir once, and reports whether
+// the writer left the state and the WALs alone meanwhile.
+func tryReadView(fs FS, dir string, opts *Options) (*dbView, bool, error) {
+	state, err := readViewState(fs, dir)
+	if err != nil {
+		return nil, false, err
+	}
+	if state.TimestampSize != opts.TimestampSize && (len(state.ActiveSSTables) > 0 || state.LastSequence > 0) {
+		return nil, false, fmt.Errorf("database was written with a timestamp size of %d, not %d", state.TimestampSize, opts.TimestampSize)
+	}
+	walFiles, err := vfs.Glob(fs, dir, "wal-*.log")
+	if err != nil {
+		return nil, false, err
+	}
+	walFiles = append(walFiles, filepath.Join(dir, "db.wal"))
+
+	view := &dbView{state: state, mem: memtable.New(opts.MemtableShards), lastSeq: state.LastSequence}
+	prepared := make(map[string]*WriteBatch)
+	for _, walPath := range walFiles {
+		if num, ok := walFileNumber(walPath); ok && num < state.LogNumber {
+			continue
+		}
+		data, lastSeq, _, err := replayWAL(fs, walPath, prepared)
+		if err != nil {
+			// The writer may be appending the last record, try again.
+			return nil, false, err
+		}
+		view.lastSeq = max(view.lastSeq, lastSeq)
+		for key, value := range data {
+			view.mem.Put(key, value.Value)
+		}
+	}
+
+	after, err := readViewState(fs, dir)
+	if err != nil {
+		return nil, false, err
+	}
+	walsAfter, err := vfs.Glob(fs, dir, "wal-*.log")
+	if err != nil {
+		return nil, false, err
+	}
+	stable := sameState(state, after) && slices.Equal(walFiles[:len(walFiles)-1], walsAfter)
+	return view, stable, nil
+}
+
+// readViewState reads the state file of the database in dir, as a reader.
+func readViewState(fs FS, dir string) (DBState, error) {
+	state, err := readState(fs, filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return DBState{NextFileNumber: 1}, nil
+	}
+	return state, err
+}
+
+func sameState(a, b DBState) bool {
+	return a.LastSequence == b.LastSequence && a.LogNumber == b.LogNumber && a.NextFileNumber == b.NextFileNumber &&
+		slices.Equal(a.ActiveSSTables, b.ActiveSSTables)
+}
+
+// deleteObsoleteTables deletes the files of tables that no Version lists
+// anymore. While readers opened with OpenReadOnly hold the shared lock, their
+// views may still list them, so they are kept until a later call finds the
+// lock free. A reader deletes nothing.
+func (db *DB) deleteObsoleteTables(nums []int) {
+	if db.sharedLock != nil {
+		return
+	}
+	db.fileMu.Lock()
+	nums = append(db.heldTables, nums...)
+	db.heldTables = nil
+	db.fileMu.Unlock()
+	if len(nums) == 0 {
+		return
+	}
+	lock, err := db.opts.FS.Lock(filepath.Join(db.dataDir, readersLock))
+	if err != nil {
+		if !errors.Is(err, ErrDBLocked) {
+			log.Printf("ERROR: Failed to check for readers before deleting SSTables %v: %v", nums, err)
+		}
+		db.fileMu.Lock()
+		db.heldTables = append(db.heldTables, nums...)
+		db.fileMu.Unlock()
+		return
+	}
+	defer lock.Close()
+	for _, num := range nums {
+		path := tablePath(db.dataDir, num)
+		if err := db.opts.FS.Remove(path); err != nil {
+			log.Printf("ERROR: Failed to remove obsolete SSTable %s: %v", path, err)
+		} else {
+			db.dropFileIO(path)
+			log.Printf("Deleted obsolete SSTable %s", path)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package leveldb
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	fs := NewMemFS()
+	writer, err := Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	wo := WriteOptions{}
+	for i := 0; i < 100; i++ {
+		writer.Put(wo, generateKey(i), generateValue(100))
+		if i == 49 {
+			writer.Flush()
+		}
+	}
+
+	reader, err := OpenReadOnly("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := reader.Get(generateKey(i)); err != nil {
+			t.Fatalf("Get %d from the reader failed: %v", i, err)
+		}
+	}
+	if err := reader.Put(wo, []byte("x"), []byte("1")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put to the reader returned %v, want ErrReadOnly", err)
+	}
+	if err := reader.Flush(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Flush of the reader returned %v, want ErrReadOnly", err)
+	}
+
+	writer.Put(wo, []byte("new"), []byte("1"))
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := writer.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	if _, err := reader.Get([]byte("new")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Reader saw a write before Refresh: %v", err)
+	}
+	// The tables of the view of the reader outlive the compaction.
+	it := reader.NewIterator()
+	count := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		count++
+	}
+	if err := it.Error(); err != nil || count != 100 {
+		t.Errorf("Reader iterated over %d keys, err %v; want 100", count, err)
+	}
+	it.Close()
+
+	if err := reader.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if val, err := reader.Get([]byte("new")); err != nil || string(val) != "1" {
+		t.Errorf("Get after Refresh returned %q, %v", val, err)
+	}
+	reader.Close()
+
+	writer.Close()
+	names, _ := fs.List("db")
+	tables := 0
+	for _, name := range names {
+		if strings.HasSuffix(name, ".sst") {
+			tables++
+		}
+	}
+	if tables != 1 {
+		t.Errorf("Database holds %d tables after the reader closed, want 1: %v", tables, names)
+	}
+}
+
+func TestOpenReadOnlyOS(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer writer.Close()
+	writer.Put(WriteOptions{Sync: true}, []byte("a"), []byte("1"))
+
+	for range 2 {
+		reader, err := OpenReadOnly(dir, nil)
+		if err != nil {
+			t.Fatalf("OpenReadOnly failed: %v", err)
+		}
+		defer reader.Close()
+		if val, err := reader.Get([]byte("a")); err != nil || string(val) != "1" {
+			t.Errorf("Get from the reader returned %q, %v", val, err)
+		}
+	}
+}
+
+// TestReadOnlyRefreshWhileWriting refreshes a reader while the writer keeps
+// rotating, flushing and compacting. Keys are written in order, so every view
+// must hold a prefix of them.
+func TestReadOnlyRefreshWhileWriting(t *testing.T) {
+	fs := NewMemFS()
+	opts := &Options{FS: fs, MaxMemtableSize: 8 * 1024}
+	writer, err := Open("db", opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer writer.Close()
+	reader, err := OpenReadOnly("db", opts)
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	defer reader.Close()
+
+	const n = 3000
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for i := 0; i < n; i++ {
+			if err := writer.Put(WriteOptions{}, generateKey(i), generateValue(100)); err != nil {
+				t.Errorf("Put failed: %v", err)
+				return
+			}
+		}
+	})
+	for done := false; !done; {
+		done = writer.sequenceNum.Load() >= n
+		if err := reader.Refresh(); err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+		it := reader.NewIterator()
+		count := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			if want := string(generateKey(count)); it.Key().UserKey != want {
+				t.Fatalf("View holds %s after %d keys, want %s", it.Key().UserKey, count, want)
+			}
+			count++
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("Iterator failed: %v", err)
+		}
+		it.Close()
+		if done && count != n {
+			t.Errorf("Last view holds %d keys, want %d", count, n)
+		}
+	}
+	wg.Wait()
+}
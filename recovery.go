@@ -0,0 +1,59 @@
+package leveldb
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// Kinds of RecoveryWarning.
+const (
+	// RecoverySequenceGap means sequence numbers are missing between two
+	// records. Writes made with WriteOptions.DisableWAL leave such gaps on
+	// purpose; otherwise writes were lost or a WAL is missing.
+	RecoverySequenceGap = "gap"
+	// RecoveryDuplicateSequence means a record reuses sequence numbers of an
+	// earlier one, which points to WALs of different databases mixed up.
+	RecoveryDuplicateSequence = "duplicate"
+)
+
+// RecoveryWarning reports an inconsistency in the WALs replayed by Open. The
+// database still opens; the warnings are available from RecoveryWarnings.
+type RecoveryWarning struct {
+	Kind     string // RecoverySequenceGap or RecoveryDuplicateSequence
+	WAL      string // File name of the WAL holding the record
+	Expected uint64 // Sequence number the record should start at
+	Got      uint64 // Sequence number it starts at
+}
+
+func (w RecoveryWarning) String() string {
+	return fmt.Sprintf("sequence %s in %s: expected %d, got %d", w.Kind, w.WAL, w.Expected, w.Got)
+}
+
+// RecoveryWarnings returns the warnings of the recovery done by Open.
+func (db *DB) RecoveryWarnings() []RecoveryWarning {
+	return append([]RecoveryWarning(nil), db.recoveryWarnings...)
+}
+
+// sequenceChecker verifies that the records of the replayed WALs, taken in
+// log order, continue each other's sequence numbers. The start of the first
+// WAL is not checked: the logs before it were flushed and deleted.
+type sequenceChecker struct {
+	next     uint64 // Expected start of the next record, 0 before the first
+	warnings []RecoveryWarning
+}
+
+func (c *sequenceChecker) check(walPath string, ranges []seqRange) {
+	for _, r := range ranges {
+		if c.next != 0 && r.first != c.next {
+			kind := RecoverySequenceGap
+			if r.first < c.next {
+				kind = RecoveryDuplicateSequence
+			}
+			w := RecoveryWarning{Kind: kind, WAL: filepath.Base(walPath), Expected: c.next, Got: r.first}
+			log.Printf("WARNING: Recovery: %v", w)
+			c.warnings = append(c.warnings, w)
+		}
+		c.next = max(c.next, r.last+1)
+	}
+}
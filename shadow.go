@@ -0,0 +1,156 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// ShadowStore is the secondary store of a Shadow. A *DB is one, so another
+// database directory can serve as the shadow; other stores apply a batch by
+// walking it with WriteBatch.Each. Get returns ErrNotFound for a missing key.
+type ShadowStore interface {
+	Write(wo WriteOptions, batch *WriteBatch) error
+	Get(key []byte) ([]byte, error)
+}
+
+// ShadowOptions configure a Shadow.
+type ShadowOptions struct {
+	// CompareReads makes Get also read the secondary store and report the
+	// keys whose values differ.
+	CompareReads bool
+
+	// OnWriteError is called with a batch the secondary store failed to
+	// write. Default logs a warning.
+	OnWriteError func(batch *WriteBatch, err error)
+
+	// OnMismatch is called with a key read with different values, nil for a
+	// missing one. Default logs a warning.
+	OnMismatch func(key, primary, secondary []byte)
+}
+
+// ShadowStats counts the operations of a Shadow.
+type ShadowStats struct {
+	Writes      uint64 // Batches written to the primary
+	WriteErrors uint64 // Of those, batches the secondary failed to write
+	Reads       uint64 // Reads compared
+	Mismatches  uint64 // Of those, reads with different values
+	ReadErrors  uint64 // Of those, reads the secondary failed
+}
+
+// Shadow mirrors the writes to a database onto a secondary store, and with
+// ShadowOptions.CompareReads checks that both return the same values, so
+// production traffic can be tried on a new store before moving onto it. The
+// database stays the source of truth: a write goes to the secondary only once
+// the database took it, and the secondary failing never fails a call. Writes
+// are applied to both stores in the same order, so they serialize, and a
+// compared read waits for them. Only the writes made through the Shadow are
+// mirrored.
+type Shadow struct {
+	db        *DB
+	secondary ShadowStore
+	opts      ShadowOptions
+	mu        sync.RWMutex // Held for writing by writes, for reading by compared reads
+
+	writes, writeErrors, reads, mismatches, readErrors atomic.Uint64
+}
+
+// NewShadow returns a Shadow that mirrors the writes to db onto secondary.
+func NewShadow(db *DB, secondary ShadowStore, opts *ShadowOptions) *Shadow {
+	s := &Shadow{db: db, secondary: secondary}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.OnWriteError == nil {
+		s.opts.OnWriteError = func(batch *WriteBatch, err error) {
+			log.Printf("Warning: Shadow store failed a write of %d updates: %v", batch.Len(), err)
+		}
+	}
+	if s.opts.OnMismatch == nil {
+		s.opts.OnMismatch = func(key, primary, secondary []byte) {
+			log.Printf("Warning: Shadow store returned %d bytes for key %q, the database %d bytes", len(secondary), key, len(primary))
+		}
+	}
+	return s
+}
+
+// DB returns the primary database.
+func (s *Shadow) DB() *DB {
+	return s.db
+}
+
+// Put stores key in the database and the secondary store.
+func (s *Shadow) Put(wo WriteOptions, key, value []byte) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return s.Write(wo, batch)
+}
+
+// Delete removes key from the database and the secondary store.
+func (s *Shadow) Delete(wo WriteOptions, key []byte) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return s.Write(wo, batch)
+}
+
+// Write applies batch to the database and then to the secondary store, and
+// returns the error of the database.
+func (s *Shadow) Write(wo WriteOptions, batch *WriteBatch) error {
+	s.mu.Lock()
+	err := s.db.Write(wo, batch)
+	var shadowErr error
+	if err == nil {
+		shadowErr = s.secondary.Write(wo, batch)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.writes.Add(1)
+	if shadowErr != nil {
+		s.writeErrors.Add(1)
+		s.opts.OnWriteError(batch, shadowErr)
+	}
+	return nil
+}
+
+// Get returns the value of key in the database, comparing it with the
+// secondary store if ShadowOptions.CompareReads is set.
+func (s *Shadow) Get(key []byte) ([]byte, error) {
+	if !s.opts.CompareReads {
+		return s.db.Get(key)
+	}
+	s.mu.RLock()
+	val, err := s.db.Get(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		s.mu.RUnlock()
+		return nil, err
+	}
+	shadowVal, shadowErr := s.secondary.Get(key)
+	s.mu.RUnlock()
+
+	s.reads.Add(1)
+	if shadowErr != nil && !errors.Is(shadowErr, ErrNotFound) {
+		s.readErrors.Add(1)
+		log.Printf("Warning: Shadow store failed a read of key %q: %v", key, shadowErr)
+		return val, err
+	}
+	if (err == nil) != (shadowErr == nil) || !bytes.Equal(val, shadowVal) {
+		s.mismatches.Add(1)
+		s.opts.OnMismatch(key, val, shadowVal)
+	}
+	return val, err
+}
+
+// Stats returns the counters of the Shadow.
+func (s *Shadow) Stats() ShadowStats {
+	return ShadowStats{
+		Writes:      s.writes.Load(),
+		WriteErrors: s.writeErrors.Load(),
+		Reads:       s.reads.Load(),
+		Mismatches:  s.mismatches.Load(),
+		ReadErrors:  s.readErrors.Load(),
+	}
+}
@@ -0,0 +1,121 @@
+package leveldb
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+)
+
+// mapStore is a ShadowStore over a map, which fails writes once failWrites is
+// set.
+type mapStore struct {
+	mu         sync.Mutex
+	data       map[string][]byte
+	failWrites bool
+}
+
+func (m *mapStore) Write(wo WriteOptions, batch *WriteBatch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failWrites {
+		return errInjected
+	}
+	batch.Each(func(op OpType, key, value []byte) {
+		if op == OpTypeDelete {
+			delete(m.data, string(key))
+		} else {
+			m.data[string(key)] = value
+		}
+	})
+	return nil
+}
+
+func (m *mapStore) Get(key []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	val, ok := m.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return val, nil
+}
+
+func TestShadow(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	db, err := OpenInMemory(nil)
+	if err != nil {
+		t.Fatalf("OpenInMemory failed: %v", err)
+	}
+	defer db.Close()
+	secondary, err := OpenInMemory(nil)
+	if err != nil {
+		t.Fatalf("OpenInMemory failed: %v", err)
+	}
+	defer secondary.Close()
+	store := &mapStore{data: make(map[string][]byte)}
+
+	var mismatched []string
+	opts := &ShadowOptions{CompareReads: true, OnMismatch: func(key, primary, secondary []byte) {
+		mismatched = append(mismatched, string(key))
+	}}
+	shadows := []*Shadow{NewShadow(db, store, opts), NewShadow(db, secondary, opts)}
+	wo := WriteOptions{}
+	for i := 0; i < 100; i++ {
+		s := shadows[i%2]
+		if err := s.Put(wo, generateKey(i), generateValue(10)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	batch := NewWriteBatch()
+	batch.Delete(generateKey(0))
+	batch.Put(generateKey(1), []byte("new"))
+	for _, s := range shadows {
+		if err := s.Write(wo, batch); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	for _, s := range shadows {
+		if _, err := s.Get(generateKey(0)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get of a deleted key returned %v", err)
+		}
+		if val, err := s.Get(generateKey(1)); err != nil || string(val) != "new" {
+			t.Errorf("Get returned %q, %v", val, err)
+		}
+	}
+	if len(mismatched) != 0 {
+		t.Errorf("Mismatches on keys written through the Shadow: %q", mismatched)
+	}
+
+	// Every other key only reached one of the stores.
+	for i := 2; i < 100; i++ {
+		shadows[0].Get(generateKey(i))
+	}
+	if len(mismatched) != 49 {
+		t.Errorf("Got %d mismatches, want 49", len(mismatched))
+	}
+
+	// A failing secondary does not fail writes.
+	var failed int
+	shadow := NewShadow(db, store, &ShadowOptions{OnWriteError: func(batch *WriteBatch, err error) {
+		if errors.Is(err, errInjected) {
+			failed++
+		}
+	}})
+	store.failWrites = true
+	if err := shadow.Put(wo, []byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed with a failing secondary: %v", err)
+	}
+	if val, err := db.Get([]byte("k")); err != nil || string(val) != "v" {
+		t.Errorf("Get returned %q, %v", val, err)
+	}
+	if stats := shadow.Stats(); failed != 1 || stats.Writes != 1 || stats.WriteErrors != 1 {
+		t.Errorf("Got %d failed writes and stats %+v", failed, stats)
+	}
+	if stats := shadows[0].Stats(); stats.Reads != 100 || stats.Mismatches != 49 || stats.Writes != 51 {
+		t.Errorf("Stats are %+v", stats)
+	}
+}
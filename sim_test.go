@@ -0,0 +1,330 @@
+package leveldb
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"io"
+	"log"
+	"maps"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	simSeed   = flag.Int64("sim.seed", 1, "seed of TestSimulation")
+	simCycles = flag.Int("sim.cycles", 100, "open, write and crash cycles of TestSimulation")
+)
+
+var errCrashed = errors.New("simulated crash")
+
+// simFS is a strict MemFS whose mutating operations fail at random with
+// errInjected, and all of them fail with errCrashed once the machine crashed,
+// which happens after opsLeft of them if it is not negative. The database runs
+// single-threaded, so the faults only depend on the seed of rng.
+type simFS struct {
+	*MemFS
+	mu        sync.Mutex
+	rng       *rand.Rand
+	faultRate float64
+	opsLeft   int
+	dead      bool
+}
+
+func newSimFS(mem *MemFS, rng *rand.Rand, faultRate float64) *simFS {
+	return &simFS{MemFS: mem, rng: rng, faultRate: faultRate, opsLeft: -1}
+}
+
+// step accounts for one mutating operation and returns the error it fails
+// with, if any.
+func (fs *simFS) step() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.opsLeft == 0 {
+		fs.dead = true
+	}
+	if fs.dead {
+		return errCrashed
+	}
+	if fs.opsLeft > 0 {
+		fs.opsLeft--
+	}
+	if fs.rng.Float64() < fs.faultRate {
+		return errInjected
+	}
+	return nil
+}
+
+// crash stops the machine: every later mutating operation fails.
+func (fs *simFS) crash() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.dead = true
+}
+
+// restart returns the filesystem the machine finds after crashing: the
+// contents a file had at its last Sync, under the names its directory had at
+// the last SyncDir.
+func (fs *simFS) restart() *simFS {
+	fs.crash()
+	return newSimFS(fs.MemFS.CrashClone(), fs.rng, fs.faultRate)
+}
+
+func (fs *simFS) Create(name string) (File, error) {
+	if err := fs.step(); err != nil {
+		return nil, err
+	}
+	f, err := fs.MemFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &simFile{File: f, fs: fs}, nil
+}
+
+func (fs *simFS) Append(name string) (File, error) {
+	if err := fs.step(); err != nil {
+		return nil, err
+	}
+	f, err := fs.MemFS.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return &simFile{File: f, fs: fs}, nil
+}
+
+func (fs *simFS) Rename(oldname, newname string) error {
+	if err := fs.step(); err != nil {
+		return err
+	}
+	return fs.MemFS.Rename(oldname, newname)
+}
+
+func (fs *simFS) Remove(name string) error {
+	if err := fs.step(); err != nil {
+		return err
+	}
+	return fs.MemFS.Remove(name)
+}
+
+func (fs *simFS) Link(oldname, newname string) error {
+	if err := fs.step(); err != nil {
+		return err
+	}
+	return fs.MemFS.Link(oldname, newname)
+}
+
+func (fs *simFS) SyncDir(dir string) error {
+	if err := fs.step(); err != nil {
+		return err
+	}
+	return fs.MemFS.SyncDir(dir)
+}
+
+// simFile is a file of a simFS open for writing.
+type simFile struct {
+	File
+	fs *simFS
+}
+
+func (f *simFile) Write(p []byte) (int, error) {
+	if err := f.fs.step(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *simFile) Sync() error {
+	if err := f.fs.step(); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+// simClock is a clock that advances by a millisecond on every reading.
+type simClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *simClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(time.Millisecond)
+	return c.now
+}
+
+// simModel tracks, for every key, the values a read may return: the value of
+// the last acknowledged write, and those of later writes that failed and may
+// or may not have reached the disk. An empty value stands for a deleted key.
+type simModel map[string]map[string]bool
+
+func (m simModel) write(key, value string, acked bool) {
+	if acked {
+		m[key] = map[string]bool{value: true}
+		return
+	}
+	if m[key] == nil {
+		m[key] = map[string]bool{"": true}
+	}
+	m[key][value] = true
+}
+
+// check reads every key of the model from db. A key is then settled to the
+// value read, which recovery found on disk.
+func (m simModel) check(db *DB, settle bool) error {
+	// Keys are read in order: reads use up the seeks allowed per table, which
+	// schedule compactions.
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		values := m[key]
+		val, err := db.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			val, err = nil, nil
+		}
+		if err != nil {
+			return fmt.Errorf("Get %s failed: %w", key, err)
+		}
+		if !values[string(val)] {
+			return fmt.Errorf("Get %s returned %.20q, want one of %.20q", key, val, slices.Sorted(maps.Keys(values)))
+		}
+		if settle {
+			m[key] = map[string]bool{string(val): true}
+		}
+	}
+	if !settle {
+		return nil
+	}
+	it := db.NewIterator()
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if values := m[it.Key().UserKey]; !values[string(it.Value())] {
+			return fmt.Errorf("Iterator returned %s = %.20q, which was never written", it.Key().UserKey, it.Value())
+		}
+	}
+	return it.Error()
+}
+
+// isSimFault reports whether err comes from the simulated disk.
+func isSimFault(err error) bool {
+	return errors.Is(err, errInjected) || errors.Is(err, errCrashed)
+}
+
+// runSimulation runs cycles of opening the database on a simFS, applying
+// random writes, flushes, compactions and background jobs, and crashing,
+// all drawn from seed. After every crash, the reopened database must pass
+// its paranoid checks and hold every acknowledged write. It returns a trace
+// of the outcomes, which only depends on the seed.
+func runSimulation(t *testing.T, seed int64, cycles int) string {
+	rng := rand.New(rand.NewSource(seed))
+	fs := newSimFS(vfs.NewStrictMemFS(), rng, 0.005)
+	clock := &simClock{now: time.Unix(0, 0)}
+	model := make(simModel)
+	var trace strings.Builder
+	fail := func(cycle int, format string, args ...any) {
+		t.Helper()
+		t.Fatalf("seed %d, cycle %d: %s", seed, cycle, fmt.Sprintf(format, args...))
+	}
+
+	written := 0
+	for cycle := 0; cycle < cycles; cycle++ {
+		opts := &Options{
+			FS:                     fs,
+			Now:                    clock.Now,
+			ManualBackgroundJobs:   true,
+			MaxMemtableSize:        4096,
+			TargetFileSize:         2048,
+			ParanoidChecks:         true,
+			SyncRetryBackoff:       time.Nanosecond,
+			VerifyCompactionOutput: rng.Intn(2) == 0,
+		}
+		// A failed fsync switches the database to read-only, unless a retry
+		// gets past it.
+		if rng.Intn(2) == 0 {
+			opts.SyncFailurePolicy = SyncFailureRetry
+		}
+		db, err := Open("db", opts)
+		fmt.Fprintf(&trace, "open %v\n", err)
+		if isSimFault(err) {
+			fs = fs.restart()
+			continue
+		}
+		if err != nil {
+			fail(cycle, "Open failed: %v", err)
+		}
+		if err := model.check(db, true); err != nil {
+			fail(cycle, "after recovery: %v", err)
+		}
+
+		if rng.Intn(2) == 0 {
+			fs.mu.Lock()
+			fs.opsLeft = rng.Intn(200)
+			fs.mu.Unlock()
+		}
+		for op, n := 0, rng.Intn(80); op < n; op++ {
+			var err error
+			switch r := rng.Intn(100); {
+			case r < 70:
+				batch := NewWriteBatch()
+				var keys, values []string
+				for range 1 + rng.Intn(4) {
+					key := fmt.Sprintf("k%02d", rng.Intn(64))
+					value := ""
+					if rng.Intn(5) == 0 {
+						batch.Delete([]byte(key))
+					} else {
+						written++
+						value = fmt.Sprintf("v%d-%s", written, strings.Repeat("x", rng.Intn(200)))
+						batch.Put([]byte(key), []byte(value))
+					}
+					keys, values = append(keys, key), append(values, value)
+				}
+				// A failed write is not acknowledged, whatever the error.
+				err = db.Write(WriteOptions{Sync: true}, batch)
+				for i, key := range keys {
+					model.write(key, values[i], err == nil)
+				}
+			case r < 82:
+				db.RunBackgroundJob()
+			case r < 87:
+				err = db.Flush()
+			case r < 90:
+				err = db.CompactRange(nil, nil)
+			default:
+				if err := model.check(db, false); err != nil {
+					fail(cycle, "%v", err)
+				}
+			}
+			fmt.Fprintf(&trace, "%d %v\n", op, err)
+		}
+
+		if rng.Intn(4) == 0 {
+			err = db.Close()
+			fs = fs.restart()
+		} else {
+			fs.crash()
+			err = db.Close()
+			fs = fs.restart()
+		}
+		fmt.Fprintf(&trace, "close %v\n", err)
+	}
+	return trace.String()
+}
+
+func TestSimulation(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	cycles := *simCycles
+	if testing.Short() {
+		cycles /= 10
+	}
+	runSimulation(t, *simSeed, cycles)
+
+	if a, b := runSimulation(t, *simSeed, 20), runSimulation(t, *simSeed, 20); a != b {
+		t.Errorf("Two simulations with seed %d diverged", *simSeed)
+	}
+}
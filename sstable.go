@@ -1,461 +1,161 @@
-package main
+package leveldb
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
-	"encoding/gob"
-	"fmt"
-	"github.com/bits-and-blooms/bloom/v3"
-	lru "github.com/hashicorp/golang-lru/v2"
-	"github.com/huandu/skiplist"
-	"io"
-	"math"
-	"os"
-	"path/filepath"
-	"sort"
-	"strconv"
+	"github.com/quangh33/Go-LevelDB/cache"
+	"github.com/quangh33/Go-LevelDB/sstable"
 )
 
 // IndexEntry stores the last key of a data block and its location in SSTable file
-type IndexEntry struct {
-	LastKey InternalKey
-	Offset  int64
-	Size    int
-}
-
-// Footer stores the location of the index and filter block
-type Footer struct {
-	IndexOffset  int64
-	IndexSize    int
-	FilterOffset int64
-	FilterSize   int
-}
-
-type SSTableReader struct {
-	file       *os.File
-	index      []IndexEntry
-	filter     *bloom.BloomFilter
-	cmp        internalKeyComparable
-	blockCache *lru.Cache[string, []byte]
-	fileNum    int
-}
-
-func WriteSSTable(path string, itemCount uint, it *skiplist.Element) error {
-	file, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
-	var indexEntries []IndexEntry
-	var currentOffset int64 = 0
-	filter := bloom.NewWithEstimates(itemCount, 0.01)
-	blockBuffer := new(bytes.Buffer)
-	var lastKeyInBlock InternalKey
-
-	for ; it != nil; it = it.Next() {
-		internalKey := it.Key().(InternalKey)
-		value := it.Value.([]byte)
-		filter.Add([]byte(internalKey.UserKey))
-
-		if blockBuffer.Len() > DataBlockSize {
-			// Write data block to SSTable file
-			blockBytes := blockBuffer.Bytes()
-			n, err := writer.Write(blockBytes)
-			if err != nil {
-				return err
-			}
-			indexEntries = append(indexEntries, IndexEntry{
-				LastKey: lastKeyInBlock,
-				Offset:  currentOffset,
-				Size:    n,
-			})
-			currentOffset += int64(n)
-			blockBuffer.Reset()
-		}
-		keyBuf := new(bytes.Buffer)
-		if err := gob.NewEncoder(keyBuf).Encode(internalKey); err != nil {
+type IndexEntry = sstable.IndexEntry
+
+// Footer stores the location of the index, filter and properties block
+type Footer = sstable.Footer
+
+// TableProperties records how an SSTable was built and what it contains.
+type TableProperties = sstable.Properties
+
+// SSTableReader reads an SSTable. See sstable.Reader.
+type SSTableReader = sstable.Reader
+
+// SSTableWriter builds an SSTable. See sstable.Writer.
+type SSTableWriter = sstable.Writer
+
+// NewSSTableReader opens an SSTable and reads its footer and properties. The
+// index and filter blocks are loaded into the cache on first use. A reader
+// without a cache reads every block from disk and keeps only its index.
+func NewSSTableReader(path string, cache *cache.Cache) (*SSTableReader, error) {
+	return sstable.Open(OSFS{}, path, sstable.ReaderOptions{Cache: cache})
+}
+
+// NewSSTableWriter creates the file at path and returns a writer for it. The
+// table is only complete once Finish returns; call Abort to give up on it.
+func NewSSTableWriter(path string, opts *Options) (*SSTableWriter, error) {
+	opts = sanitizeOptions(opts)
+	return sstable.NewWriter(opts.FS, path, writerOptions(opts))
+}
+
+// writerOptions returns the table options of opts, which must be sanitized.
+// The table's time range is the current time.
+func writerOptions(opts *Options) sstable.WriterOptions {
+	now := opts.Now().UnixNano()
+	w := sstable.WriterOptions{
+		DataBlockSize:    opts.DataBlockSize,
+		Checksum:         opts.Checksum,
+		FilterPolicy:     opts.FilterPolicy,
+		FilterBitsPerKey: opts.FilterBitsPerKey,
+		FilterFPRate:     opts.FilterFPRate,
+		PrefixExtractor:  opts.PrefixExtractor,
+		MinTime:          now,
+		MaxTime:          now,
+	}
+	if opts.TimeWindow != nil {
+		w.Timestamp = opts.TimeWindow.Timestamp
+	}
+	return w
+}
+
+// tableSplitter writes a stream of entries in increasing internal key order
+// into one or more SSTables. Once a table reaches Options.TargetFileSize, or
+// overlaps more than Options.MaxGrandparentOverlapBytes of grandparents, the
+// next user key starts a new one, so the versions of a key stay together.
+// Tables are only created once they get an entry.
+type tableSplitter struct {
+	opts             *Options
+	newPath          func() string // Path of the next table
+	minTime, maxTime int64         // Time range recorded for every table
+	w                *SSTableWriter
+	lastKey          string
+	paths            []string
+	seqs             []SeqRange // Sequence numbers of the entries of each table
+	entries          uint64     // Entries added to all tables
+
+	// grandparents are the live tables outside the compaction, by largest
+	// key. overlap counts the bytes of those the current table overlaps.
+	grandparents []tableSpan
+	next         int // First grandparent whose largest key was not passed
+	overlap      int64
+}
+
+// tableSpan is the key range and size of a table.
+type tableSpan struct {
+	smallest, largest string
+	size              int64
+}
+
+func (s *tableSplitter) add(key InternalKey, value []byte) error {
+	if (s.w == nil || key.UserKey != s.lastKey) && s.shouldCut(key.UserKey) {
+		w := s.w
+		s.w = nil
+		if err := w.Finish(); err != nil {
 			return err
 		}
-		keyBytes := keyBuf.Bytes()
-		binary.Write(blockBuffer, binary.LittleEndian, uint32(len(keyBytes)))
-		binary.Write(blockBuffer, binary.LittleEndian, uint32(len(value)))
-		blockBuffer.Write(keyBytes)
-		blockBuffer.Write(value)
-		lastKeyInBlock = internalKey
 	}
-
-	if blockBuffer.Len() > 0 {
-		blockBytes := blockBuffer.Bytes()
-		n, err := writer.Write(blockBytes)
+	if s.w == nil {
+		path := s.newPath()
+		opts := sanitizeOptions(s.opts)
+		wo := writerOptions(opts)
+		wo.MinTime, wo.MaxTime = s.minTime, s.maxTime
+		w, err := sstable.NewWriter(opts.FS, path, wo)
 		if err != nil {
 			return err
 		}
-		indexEntries = append(indexEntries, IndexEntry{
-			LastKey: lastKeyInBlock,
-			Offset:  currentOffset,
-			Size:    n,
-		})
-		currentOffset += int64(n)
-	}
-
-	// Write the Filter Block
-	filterOffset := currentOffset
-	filterSize, err := filter.WriteTo(writer)
-	if err != nil {
-		return err
-	}
-
-	// Write the Index Block
-	indexOffset := currentOffset + filterSize
-	if err := writer.Flush(); err != nil {
-		return err
-	}
-	indexBuf := new(bytes.Buffer)
-	if err := gob.NewEncoder(indexBuf).Encode(indexEntries); err != nil {
-		return err
-	}
-	indexBytes := indexBuf.Bytes()
-	if _, err := writer.Write(indexBytes); err != nil {
-		return err
-	}
-	indexSize := len(indexBytes)
-
-	// Write the Footer
-	footer := Footer{
-		IndexOffset:  indexOffset,
-		IndexSize:    indexSize,
-		FilterOffset: filterOffset,
-		FilterSize:   int(filterSize),
-	}
-
-	footerBuffer := new(bytes.Buffer)
-	if err := gob.NewEncoder(footerBuffer).Encode(footer); err != nil {
-		return err
-	}
-	footerBytes := footerBuffer.Bytes()
-	if _, err := writer.Write(footerBytes); err != nil {
-		return err
-	}
-	if err := binary.Write(writer, binary.LittleEndian, int32(len(footerBytes))); err != nil {
-		return err
-	}
-
-	if err := writer.Flush(); err != nil {
-		return err
-	}
-	return file.Sync()
-}
-
-func NewSSTableReader(path string, blockCache *lru.Cache[string, []byte]) (*SSTableReader, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	stat, err := file.Stat()
-	if err != nil {
-		return nil, err
-	}
-	// Read the footerSize
-	fileSize := stat.Size()
-	footerSizeBuf := make([]byte, 4)
-	if _, err := file.ReadAt(footerSizeBuf, fileSize-4); err != nil {
-		return nil, fmt.Errorf("failed to read footer size: %w", err)
-	}
-	footerSize := binary.LittleEndian.Uint32(footerSizeBuf)
-	// Read the footer
-	footerOffset := fileSize - 4 - int64(footerSize)
-	footerBuf := make([]byte, footerSize)
-	if _, err := file.ReadAt(footerBuf, footerOffset); err != nil {
-		return nil, fmt.Errorf("failed to read footer: %w", err)
-	}
-	var footer Footer
-	if err := gob.NewDecoder(bytes.NewReader(footerBuf)).Decode(&footer); err != nil {
-		return nil, fmt.Errorf("failed to decode footer: %w", err)
-	}
-	// Read the Filter block
-	filterBuf := make([]byte, footer.FilterSize)
-	if _, err := file.ReadAt(filterBuf, footer.FilterOffset); err != nil {
-		return nil, fmt.Errorf("failed to read filter block: %w", err)
-	}
-	var filter = &bloom.BloomFilter{}
-	if _, err := filter.ReadFrom(bytes.NewReader(filterBuf)); err != nil {
-		return nil, fmt.Errorf("failed to read from filter buffer: %w", err)
-	}
-	// Read the Index block
-	indexBuf := make([]byte, footer.IndexSize)
-	if _, err := file.ReadAt(indexBuf, footer.IndexOffset); err != nil {
-		return nil, fmt.Errorf("failed to read index block: %w", err)
-	}
-	var index []IndexEntry
-	if err := gob.NewDecoder(bytes.NewReader(indexBuf)).Decode(&index); err != nil {
-		return nil, fmt.Errorf("failed to decode index: %w", err)
-	}
-
-	base := filepath.Base(path)
-	ext := filepath.Ext(base)
-	numStr := base[:len(base)-len(ext)]
-	fileNum, _ := strconv.Atoi(numStr)
-
-	return &SSTableReader{
-		file:       file,
-		index:      index,
-		filter:     filter,
-		cmp:        internalKeyComparable{},
-		blockCache: blockCache,
-		fileNum:    fileNum,
-	}, nil
-}
-
-// getBlock reads a data block from disk or retrieves it from the cache.
-func (r *SSTableReader) getBlock(entry IndexEntry) ([]byte, error) {
-	cacheKey := fmt.Sprintf("%d:%d", r.fileNum, entry.Offset)
-
-	if blockData, ok := r.blockCache.Get(cacheKey); ok {
-		return blockData, nil
-	}
-	// Cache miss: Read the block from disk.
-	blockData := make([]byte, entry.Size)
-	_, err := r.file.ReadAt(blockData, entry.Offset)
-	if err != nil {
-		return nil, err
-	}
-
-	// Add the newly read block to the cache.
-	r.blockCache.Add(cacheKey, blockData)
-	return blockData, nil
-}
-
-func (r *SSTableReader) Get(userKey []byte) ([]byte, bool, error) {
-	if !r.filter.Test(userKey) {
-		return nil, false, nil
-	}
-
-	searchKey := InternalKey{
-		UserKey: string(userKey),
-		SeqNum:  math.MaxUint64,
-		Type:    OpTypePut,
-	}
-
-	// Find the Data block that contains this searchKey
-	blockIndex := sort.Search(len(r.index), func(i int) bool {
-		return r.cmp.Compare(r.index[i].LastKey, searchKey) >= 0
-	})
-
-	if blockIndex >= len(r.index) {
-		return nil, false, nil
-	}
-
-	entry := r.index[blockIndex]
-	blockData, err := r.getBlock(entry)
-	if err != nil {
-		return nil, false, err
-	}
-
-	reader := bytes.NewReader(blockData)
-
-	for {
-		var keySize, valueSize uint32
-		if err := binary.Read(reader, binary.LittleEndian, &keySize); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, false, err
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &valueSize); err != nil {
-			return nil, false, err
+		s.w = w
+		s.paths = append(s.paths, path)
+		s.seqs = append(s.seqs, SeqRange{key.SeqNum, key.SeqNum})
+	}
+	seqs := &s.seqs[len(s.seqs)-1]
+	seqs.First = min(seqs.First, key.SeqNum)
+	seqs.Last = max(seqs.Last, key.SeqNum)
+	s.lastKey = key.UserKey
+	s.entries++
+	return s.w.Add(key, value)
+}
+
+// shouldCut reports whether the current table ends before the user key key,
+// which is the first entry of its key.
+func (s *tableSplitter) shouldCut(key string) bool {
+	// Grandparents passed since the current table began are overlapped by it
+	// and would all be rewritten by a later compaction of the table.
+	for s.next < len(s.grandparents) && key > s.grandparents[s.next].largest {
+		if s.w != nil {
+			s.overlap += s.grandparents[s.next].size
 		}
-
-		keyBytes := make([]byte, keySize)
-		if _, err := io.ReadFull(reader, keyBytes); err != nil {
-			return nil, false, err
-		}
-
-		var ik InternalKey
-		if err := gob.NewDecoder(bytes.NewReader(keyBytes)).Decode(&ik); err != nil {
-			// Corrupted key, skip this entry
-			reader.Seek(int64(valueSize), io.SeekCurrent)
-			continue
-		}
-
-		if ik.UserKey == string(userKey) {
-			// Found the latest version of our user key.
-			if ik.Type == OpTypeDelete {
-				return nil, true, fmt.Errorf("key not found (deleted)")
-			}
-			valueBuf := make([]byte, valueSize)
-			if _, err := io.ReadFull(reader, valueBuf); err != nil {
-				return nil, false, err
-			}
-			return valueBuf, true, nil
-		}
-
-		// Key didn't match, so skip over the value to get to the next entry.
-		if _, err := reader.Seek(int64(valueSize), io.SeekCurrent); err != nil {
-			return nil, false, err
-		}
-	}
-
-	return nil, false, nil
-}
-
-// Close closes the underlying file of the reader.
-func (r *SSTableReader) Close() error {
-	return r.file.Close()
-}
-
-// sstableBlockIterator iterates over a single data block in memory.
-type sstableBlockIterator struct {
-	reader *bytes.Reader
-	key    InternalKey
-	value  []byte
-	valid  bool
-	err    error
-}
-
-func newBlockIterator(data []byte) *sstableBlockIterator {
-	return &sstableBlockIterator{
-		reader: bytes.NewReader(data),
-	}
-}
-
-func (it *sstableBlockIterator) Valid() bool {
-	return it.valid
-}
-
-func (it *sstableBlockIterator) Key() InternalKey {
-	return it.key
-}
-
-func (it *sstableBlockIterator) Value() []byte {
-	return it.value
-}
-
-func (it *sstableBlockIterator) Next() {
-	it.readNext()
-}
-
-func (it *sstableBlockIterator) SeekToFirst() {
-	it.reader.Seek(0, io.SeekStart)
-	it.readNext()
-}
-
-func (it *sstableBlockIterator) Error() error { return it.err }
-
-func (it *sstableBlockIterator) Close() error { return nil }
-
-func (it *sstableBlockIterator) readNext() {
-	if it.reader.Len() == 0 {
-		it.valid = false
-		return
-	}
-
-	var keySize, valueSize uint32
-	if err := binary.Read(it.reader, binary.LittleEndian, &keySize); err != nil {
-		if err != io.EOF {
-			it.err = err
-		}
-		it.valid = false
-		return
+		s.next++
 	}
-	if err := binary.Read(it.reader, binary.LittleEndian, &valueSize); err != nil {
-		it.err = err
-		it.valid = false
-		return
+	if s.w == nil {
+		return false
 	}
-
-	keyBytes := make([]byte, keySize)
-	if _, err := io.ReadFull(it.reader, keyBytes); err != nil {
-		it.err = err
-		it.valid = false
-		return
+	if s.opts.MaxGrandparentOverlapBytes > 0 && s.overlap > s.opts.MaxGrandparentOverlapBytes {
+		s.overlap = 0
+		return true
 	}
-
-	var ik InternalKey
-	if err := gob.NewDecoder(bytes.NewReader(keyBytes)).Decode(&ik); err != nil {
-		it.err = err
-		it.valid = false
-		return
-	}
-	it.key = ik
-
-	valueBytes := make([]byte, valueSize)
-	if _, err := io.ReadFull(it.reader, valueBytes); err != nil {
-		it.err = err
-		it.valid = false
-		return
-	}
-	it.value = valueBytes
-	it.valid = true
-}
-
-// NewIterator creates a new iterator over the SSTable.
-func (r *SSTableReader) NewIterator() Iterator {
-	return &sstableFileIterator{
-		reader: r,
+	if s.opts.TargetFileSize > 0 && s.w.EstimatedSize() >= int64(s.opts.TargetFileSize) {
+		s.overlap = 0
+		return true
 	}
+	return false
 }
 
-// sstableFileIterator implements the Iterator interface for an entire SSTable.
-type sstableFileIterator struct {
-	reader     *SSTableReader
-	blockIter  *sstableBlockIterator
-	blockIndex int
-	err        error
-}
-
-func (it *sstableFileIterator) Valid() bool {
-	return it.blockIter != nil && it.blockIter.Valid()
-}
-
-func (it *sstableFileIterator) Key() InternalKey {
-	return it.blockIter.Key()
-}
-
-func (it *sstableFileIterator) Value() []byte {
-	return it.blockIter.Value()
-}
-
-func (it *sstableFileIterator) Next() {
-	if it.blockIter == nil {
-		return
-	}
-	it.blockIter.Next()
-	if !it.blockIter.Valid() {
-		it.blockIndex++
-		it.loadBlock()
+// finish finishes the last table and returns the paths of all tables written.
+func (s *tableSplitter) finish() ([]string, error) {
+	if s.w != nil {
+		w := s.w
+		s.w = nil
+		if err := w.Finish(); err != nil {
+			s.abort()
+			return nil, err
+		}
 	}
+	return s.paths, nil
 }
 
-func (it *sstableFileIterator) Close() error {
-	it.blockIter = nil
-	return nil
-}
-
-func (it *sstableFileIterator) Error() error {
-	return it.err
-}
-
-func (it *sstableFileIterator) SeekToFirst() {
-	it.blockIndex = 0
-	it.loadBlock()
-}
-
-func (it *sstableFileIterator) loadBlock() {
-	if it.blockIndex >= len(it.reader.index) {
-		it.blockIter = nil
-		return
+// abort removes every table written so far.
+func (s *tableSplitter) abort() {
+	if s.w != nil {
+		s.w.Abort()
+		s.w = nil
 	}
-	entry := it.reader.index[it.blockIndex]
-
-	blockData, err := it.reader.getBlock(entry)
-	if err != nil {
-		it.err = err
-		it.blockIter = nil
-		return
+	for _, path := range s.paths {
+		s.opts.FS.Remove(path)
 	}
-	it.blockIter = newBlockIterator(blockData)
-	it.blockIter.SeekToFirst()
 }
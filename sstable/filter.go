@@ -0,0 +1,270 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/bits-and-blooms/bloom/v3"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// tableFilter is the decoded filter block of an SSTable.
+type tableFilter interface {
+	// Test reports whether key may have been added to the filter.
+	Test(key []byte) bool
+}
+
+// filterHash returns the 64-bit hash of a key that filters are built from, so
+// a table writer keeps 8 bytes per key for its filter instead of the key.
+func filterHash(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+// writeFilter builds the filter for the keys with the given filterHash values
+// under opts.FilterPolicy and writes it to w. It returns the size of the block
+// and the filter properties. Nothing is written under FilterPolicyNone.
+func writeFilter(w io.Writer, hashes []uint64, opts *WriterOptions) (int64, Properties, error) {
+	switch opts.FilterPolicy {
+	case FilterPolicyNone:
+		return 0, Properties{FilterPolicy: FilterPolicyNone}, nil
+	case FilterPolicyCuckoo:
+		props := Properties{FilterPolicy: FilterPolicyCuckoo, FilterFPRate: cuckooFPRate}
+		filter := buildCuckooFilter(hashes)
+		n, err := filter.WriteTo(w)
+		return n, props, err
+	}
+	filter, props := newBloomFilter(uint(len(hashes)), opts)
+	props.FilterKeyHashes = true
+	var buf [8]byte
+	for _, h := range hashes {
+		binary.LittleEndian.PutUint64(buf[:], h)
+		filter.Add(buf[:])
+	}
+	n, err := filter.WriteTo(w)
+	return n, props, err
+}
+
+// newBloomFilter sizes a filter for n keys from the options, preferring
+// FilterBitsPerKey over FilterFPRate when both are set. It returns a nil filter
+// under FilterPolicyNone.
+func newBloomFilter(n uint, opts *WriterOptions) (*bloom.BloomFilter, Properties) {
+	if opts.FilterPolicy == FilterPolicyNone {
+		return nil, Properties{FilterPolicy: FilterPolicyNone}
+	}
+	props := Properties{FilterPolicy: FilterPolicyBloom, FilterBitsPerKey: opts.FilterBitsPerKey, FilterFPRate: opts.FilterFPRate}
+	if opts.FilterBitsPerKey > 0 {
+		m := max(n, 1) * uint(opts.FilterBitsPerKey)
+		k := max(uint(math.Round(float64(opts.FilterBitsPerKey)*math.Ln2)), 1)
+		props.FilterFPRate = math.Pow(1-math.Exp(-float64(k)/float64(opts.FilterBitsPerKey)), float64(k))
+		return bloom.New(m, k), props
+	}
+	return bloom.NewWithEstimates(n, opts.FilterFPRate), props
+}
+
+// hashedBloomFilter is a bloom filter over the filterHash values of the keys.
+type hashedBloomFilter struct {
+	*bloom.BloomFilter
+}
+
+func (f hashedBloomFilter) Test(key []byte) bool {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], filterHash(key))
+	return f.BloomFilter.Test(buf[:])
+}
+
+// decodeFilter decodes a filter block written under the policy of props and
+// returns it with the memory it holds. Tables from before properties existed
+// have bloom filters over the keys themselves.
+func decodeFilter(props Properties, data []byte) (tableFilter, int64, error) {
+	policy := props.FilterPolicy
+	if policy == FilterPolicyCuckoo {
+		filter, err := decodeCuckooFilter(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		return filter, int64(len(filter.buckets))*cuckooBucketSize*2 + 64, nil
+	}
+	filter := &bloom.BloomFilter{}
+	if _, err := filter.ReadFrom(bytes.NewReader(data)); err != nil {
+		return nil, 0, err
+	}
+	// Charge the filter's bit array plus its fixed overhead.
+	charge := int64(filter.Cap()/8) + 64
+	if props.FilterKeyHashes {
+		return hashedBloomFilter{filter}, charge, nil
+	}
+	return filter, charge, nil
+}
+
+const (
+	cuckooBucketSize = 4
+	cuckooMaxKicks   = 500
+	// With 16-bit fingerprints and two buckets of four slots, a lookup compares
+	// against at most 8 fingerprints.
+	cuckooFPRate = 2.0 * cuckooBucketSize / (1 << 16)
+)
+
+// cuckooFilter stores a 16-bit fingerprint of every key in one of two
+// candidate buckets. At the same space it has a lower false positive rate than
+// a bloom filter, and keys can be removed again.
+type cuckooFilter struct {
+	buckets [][cuckooBucketSize]uint16 // Zero marks an empty slot
+	mask    uint32
+	rng     *rand.Rand
+}
+
+// newCuckooFilter returns a filter sized for n keys at a 95% load factor.
+func newCuckooFilter(n int) *cuckooFilter {
+	numBuckets := uint32(1)
+	if want := (n*100/95 + cuckooBucketSize - 1) / cuckooBucketSize; want > 1 {
+		numBuckets = 1 << bits.Len32(uint32(want-1))
+	}
+	return &cuckooFilter{
+		buckets: make([][cuckooBucketSize]uint16, numBuckets),
+		mask:    numBuckets - 1,
+		rng:     rand.New(rand.NewSource(1)),
+	}
+}
+
+// buildCuckooFilter returns a filter holding the keys with the given
+// filterHash values, growing it until every insertion succeeds.
+func buildCuckooFilter(hashes []uint64) *cuckooFilter {
+	for n := len(hashes); ; n *= 2 {
+		filter := newCuckooFilter(n)
+		ok := true
+		for _, h := range hashes {
+			if ok = filter.insertHash(h); !ok {
+				break
+			}
+		}
+		if ok {
+			return filter
+		}
+	}
+}
+
+func (f *cuckooFilter) indexAndFingerprint(sum uint64) (uint32, uint16) {
+	// Mix the hash so the fingerprint bits depend on every byte of the key.
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	fp := uint16(sum >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	return uint32(sum) & f.mask, fp
+}
+
+func (f *cuckooFilter) altIndex(i uint32, fp uint16) uint32 {
+	return (i ^ (uint32(fp) * 0x5bd1e995)) & f.mask
+}
+
+func (f *cuckooFilter) insertInto(i uint32, fp uint16) bool {
+	for slot, v := range f.buckets[i] {
+		if v == 0 {
+			f.buckets[i][slot] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// Insert adds key. It fails once the filter is too full to place it.
+func (f *cuckooFilter) Insert(key []byte) bool {
+	return f.insertHash(filterHash(key))
+}
+
+// insertHash adds the key with filterHash value h.
+func (f *cuckooFilter) insertHash(h uint64) bool {
+	i1, fp := f.indexAndFingerprint(h)
+	i2 := f.altIndex(i1, fp)
+	if f.insertInto(i1, fp) || f.insertInto(i2, fp) {
+		return true
+	}
+	// Evict fingerprints to their alternate buckets to make room.
+	i := i1
+	if f.rng.Intn(2) == 1 {
+		i = i2
+	}
+	for range cuckooMaxKicks {
+		slot := f.rng.Intn(cuckooBucketSize)
+		fp, f.buckets[i][slot] = f.buckets[i][slot], fp
+		i = f.altIndex(i, fp)
+		if f.insertInto(i, fp) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *cuckooFilter) Test(key []byte) bool {
+	i1, fp := f.indexAndFingerprint(filterHash(key))
+	i2 := f.altIndex(i1, fp)
+	for _, i := range []uint32{i1, i2} {
+		for _, v := range f.buckets[i] {
+			if v == fp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Delete removes one copy of key. Only keys known to have been inserted may be
+// deleted, or another key sharing the fingerprint disappears instead.
+func (f *cuckooFilter) Delete(key []byte) bool {
+	i1, fp := f.indexAndFingerprint(filterHash(key))
+	for _, i := range []uint32{i1, f.altIndex(i1, fp)} {
+		for slot, v := range f.buckets[i] {
+			if v == fp {
+				f.buckets[i][slot] = 0
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteTo encodes the filter as [NumBuckets (4 bytes)][Fingerprints].
+func (f *cuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, 4+len(f.buckets)*cuckooBucketSize*2)
+	binary.LittleEndian.PutUint32(buf, uint32(len(f.buckets)))
+	off := 4
+	for _, bucket := range f.buckets {
+		for _, v := range bucket {
+			binary.LittleEndian.PutUint16(buf[off:], v)
+			off += 2
+		}
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+func decodeCuckooFilter(data []byte) (*cuckooFilter, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("cuckoo filter too short")
+	}
+	numBuckets := binary.LittleEndian.Uint32(data)
+	if numBuckets == 0 || numBuckets&(numBuckets-1) != 0 || len(data) != 4+int(numBuckets)*cuckooBucketSize*2 {
+		return nil, fmt.Errorf("corrupt cuckoo filter")
+	}
+	f := &cuckooFilter{
+		buckets: make([][cuckooBucketSize]uint16, numBuckets),
+		mask:    numBuckets - 1,
+		rng:     rand.New(rand.NewSource(1)),
+	}
+	off := 4
+	for i := range f.buckets {
+		for slot := range f.buckets[i] {
+			f.buckets[i][slot] = binary.LittleEndian.Uint16(data[off:])
+			off += 2
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,26 @@
+package sstable
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLegacyBloomFilter(t *testing.T) {
+	// Filters of older tables hold the keys themselves.
+	legacy, _ := newBloomFilter(1, &WriterOptions{FilterFPRate: 0.01})
+	legacy.Add([]byte("a"))
+	var buf bytes.Buffer
+	legacy.WriteTo(&buf)
+	filter, _, err := decodeFilter(Properties{FilterPolicy: FilterPolicyBloom}, buf.Bytes())
+	if err != nil || !filter.Test([]byte("a")) {
+		t.Errorf("Legacy bloom filter does not admit its key: %v", err)
+	}
+}
+
+func TestCuckooFilterDelete(t *testing.T) {
+	// Deleting a key removes only its fingerprint.
+	filter := buildCuckooFilter([]uint64{filterHash([]byte("a")), filterHash([]byte("b"))})
+	if !filter.Delete([]byte("a")) || filter.Test([]byte("a")) || !filter.Test([]byte("b")) {
+		t.Errorf("Delete did not remove exactly the deleted key")
+	}
+}
@@ -0,0 +1,71 @@
+// Package sstable writes and reads SSTables: immutable files of entries sorted
+// by internal key, split into checksummed data blocks and followed by a
+// filter, an index and a properties block.
+package sstable
+
+import (
+	"github.com/quangh33/Go-LevelDB/cache"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"time"
+)
+
+// FilterPolicy selects the kind of filter built for each SSTable.
+type FilterPolicy string
+
+const (
+	// FilterPolicyBloom builds a bloom filter over the user keys of each table
+	// so point lookups can skip tables that do not contain the key.
+	FilterPolicyBloom FilterPolicy = "bloom"
+	// FilterPolicyNone builds no filter. It saves CPU and space for workloads
+	// that only scan ranges; every Get has to search each table's index.
+	FilterPolicyNone FilterPolicy = "none"
+	// FilterPolicyCuckoo builds a cuckoo filter of 16-bit key fingerprints. At
+	// a similar size to the default bloom filter it has a far lower false
+	// positive rate (about 0.01%), so fewer Gets for absent keys read a block.
+	FilterPolicyCuckoo FilterPolicy = "cuckoo"
+)
+
+// PrefixExtractor maps a key to the prefix that range scans are bounded by.
+type PrefixExtractor interface {
+	// Name identifies the extractor. It is stored with every table, so changing
+	// how prefixes are computed requires a new name.
+	Name() string
+	// Prefix returns the prefix of key, or nil if the key has none.
+	Prefix(key []byte) []byte
+}
+
+const (
+	// An iterator that crosses ReadaheadTrigger block boundaries in a row
+	// without seeking reads the next ReadaheadBlocks blocks in the background.
+	ReadaheadTrigger = 2
+	ReadaheadBlocks  = 2
+)
+
+// WriterOptions configure a table written with NewWriter.
+type WriterOptions struct {
+	DataBlockSize int // Target size of the data blocks
+	// Checksum checksums every data block. base.ChecksumLegacy writes none.
+	Checksum base.ChecksumType
+
+	// FilterPolicy selects the filter of the table. FilterBitsPerKey, if
+	// positive, sizes a bloom filter instead of FilterFPRate.
+	FilterPolicy     FilterPolicy
+	FilterBitsPerKey int
+	FilterFPRate     float64
+	// PrefixExtractor, if set, also adds the prefix of every key to the filter.
+	PrefixExtractor PrefixExtractor
+
+	// MinTime and MaxTime are the time range recorded for the table in Unix
+	// nanoseconds, unless Timestamp extracts a timestamp from its keys.
+	MinTime, MaxTime int64
+	Timestamp        func(key []byte) (time.Time, bool)
+}
+
+// ReaderOptions configure a table opened with Open.
+type ReaderOptions struct {
+	// Cache, if set, holds the data blocks and decoded index and filter blocks
+	// of the table. A reader without a cache reads every block from disk and
+	// keeps only its index.
+	Cache *cache.Cache
+	IO    *base.FileIO // Per-file I/O statistics, nil outside a database
+}
@@ -0,0 +1,846 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/cache"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// IndexEntry stores the last key of a data block and its location in SSTable file
+type IndexEntry struct {
+	LastKey base.InternalKey
+	Offset  int64
+	Size    int
+}
+
+// Footer stores the location of the index, filter and properties block
+type Footer struct {
+	IndexOffset      int64
+	IndexSize        int
+	FilterOffset     int64
+	FilterSize       int
+	PropertiesOffset int64
+	PropertiesSize   int // Zero for tables written before properties existed
+}
+
+// Properties records how an SSTable was built and what it contains.
+type Properties struct {
+	NumEntries       uint64
+	NumDeletions     uint64            // Tombstones among NumEntries
+	NumObsolete      uint64            // Older versions of a key among NumEntries
+	DataBlockSize    int               // Target size of the data blocks; zero if unknown
+	Checksum         base.ChecksumType // Of each data block; base.ChecksumLegacy for none
+	FilterPolicy     FilterPolicy
+	FilterBitsPerKey int     // Set if the filter was sized by bits per key
+	FilterFPRate     float64 // Target false positive rate of the filter
+	PrefixExtractor  string  // Name of the extractor whose prefixes are in the filter
+	FilterKeyHashes  bool    // Whether the bloom filter holds filterHash values, not keys
+	SmallestKey      string  // Smallest user key in the table
+	LargestKey       string  // Largest user key in the table
+	SmallestSeq      uint64  // Smallest sequence number in the table
+	LargestSeq       uint64  // Largest sequence number in the table; zero if unknown
+	MinTimestamp     int64   // Time range of the data in Unix nanoseconds, from key
+	MaxTimestamp     int64   // timestamps if configured, else from when it was written
+}
+
+// Reader reads the entries of an SSTable. It is safe for concurrent use.
+type Reader struct {
+	file    vfs.File
+	footer  Footer
+	cmp     base.InternalKeyComparator
+	cache   *cache.Cache // Data blocks and decoded index and filter blocks
+	index   []IndexEntry // The index of a reader without a cache
+	fileNum int
+	props   Properties
+	refs    atomic.Int32 // The file is closed when the last reference is released
+	io      *base.FileIO // Per-file I/O statistics, nil outside a database
+}
+
+// Open opens the SSTable at path on fs and reads its footer and properties.
+// The index and filter blocks are loaded into the cache on first use. The
+// reader holds one reference, released with Unref.
+func Open(fs vfs.FS, path string, opts ReaderOptions) (_ *Reader, err error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+		}
+	}()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Read the footerSize
+	fileSize := stat.Size()
+	footerSizeBuf := make([]byte, 4)
+	if _, err := file.ReadAt(footerSizeBuf, fileSize-4); err != nil {
+		return nil, fmt.Errorf("failed to read footer size: %w", err)
+	}
+	footerSize := binary.LittleEndian.Uint32(footerSizeBuf)
+	// Read the footer
+	footerOffset := fileSize - 4 - int64(footerSize)
+	if footerOffset < 0 {
+		return nil, &base.CorruptionError{File: path, Offset: fileSize - 4, Err: fmt.Errorf("footer size %d exceeds the file", footerSize)}
+	}
+	footerBuf := make([]byte, footerSize)
+	if _, err := file.ReadAt(footerBuf, footerOffset); err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	var footer Footer
+	if err := gob.NewDecoder(bytes.NewReader(footerBuf)).Decode(&footer); err != nil {
+		return nil, &base.CorruptionError{File: path, Offset: footerOffset, Err: fmt.Errorf("failed to decode footer: %w", err)}
+	}
+	// Read the Properties block
+	var props Properties
+	if footer.PropertiesSize > 0 {
+		propsBuf := make([]byte, footer.PropertiesSize)
+		if _, err := file.ReadAt(propsBuf, footer.PropertiesOffset); err != nil {
+			return nil, fmt.Errorf("failed to read properties block: %w", err)
+		}
+		if err := gob.NewDecoder(bytes.NewReader(propsBuf)).Decode(&props); err != nil {
+			return nil, &base.CorruptionError{File: path, Offset: footer.PropertiesOffset, Err: fmt.Errorf("failed to decode properties: %w", err)}
+		}
+	}
+
+	name := filepath.Base(path)
+	numStr := name[:len(name)-len(filepath.Ext(name))]
+	fileNum, _ := strconv.Atoi(numStr)
+
+	reader := &Reader{
+		file:    file,
+		footer:  footer,
+		cmp:     base.InternalKeyComparator{},
+		cache:   opts.Cache,
+		fileNum: fileNum,
+		props:   props,
+		io:      opts.IO,
+	}
+	reader.refs.Store(1)
+	return reader, nil
+}
+
+// loadFilter returns the table's filter from the metadata cache, reading it
+// from disk on a miss. It returns nil if the table has no filter.
+func (r *Reader) loadFilter() (tableFilter, error) {
+	if r.footer.FilterSize == 0 {
+		return nil, nil // Written under FilterPolicyNone
+	}
+	cacheKey := fmt.Sprintf("%d:filter", r.fileNum)
+	if r.cache != nil {
+		if filter, ok := r.cache.Get(cacheKey); ok {
+			return filter.(tableFilter), nil
+		}
+	}
+	filterBuf := make([]byte, r.footer.FilterSize)
+	if _, err := r.file.ReadAt(filterBuf, r.footer.FilterOffset); err != nil {
+		return nil, fmt.Errorf("failed to read filter block: %w", err)
+	}
+	r.io.Read(len(filterBuf), r.cache != nil)
+	filter, charge, err := decodeFilter(r.props, filterBuf)
+	if err != nil {
+		return nil, r.corruption(r.footer.FilterOffset, fmt.Errorf("failed to decode filter: %w", err))
+	}
+	if r.cache != nil {
+		r.cache.Add(cacheKey, filter, charge, true)
+	}
+	return filter, nil
+}
+
+// Index returns the table's index block from the metadata cache, reading
+// it from disk on a miss.
+func (r *Reader) Index() ([]IndexEntry, error) {
+	cacheKey := fmt.Sprintf("%d:index", r.fileNum)
+	if r.cache == nil {
+		if r.index != nil {
+			return r.index, nil
+		}
+	} else if index, ok := r.cache.Get(cacheKey); ok {
+		return index.([]IndexEntry), nil
+	}
+	indexBuf := make([]byte, r.footer.IndexSize)
+	if _, err := r.file.ReadAt(indexBuf, r.footer.IndexOffset); err != nil {
+		return nil, fmt.Errorf("failed to read index block: %w", err)
+	}
+	r.io.Read(len(indexBuf), r.cache != nil)
+	var index []IndexEntry
+	if err := gob.NewDecoder(bytes.NewReader(indexBuf)).Decode(&index); err != nil {
+		return nil, r.corruption(r.footer.IndexOffset, fmt.Errorf("failed to decode index: %w", err))
+	}
+	if r.cache == nil {
+		r.index = index
+	} else {
+		r.cache.Add(cacheKey, index, indexCharge(index), true)
+	}
+	return index, nil
+}
+
+// indexCharge estimates the memory held by a decoded index.
+func indexCharge(index []IndexEntry) int64 {
+	charge := int64(len(index)) * int64(unsafe.Sizeof(IndexEntry{}))
+	for _, entry := range index {
+		charge += int64(len(entry.LastKey.UserKey))
+	}
+	return charge
+}
+
+// getBlock reads a data block from disk or retrieves it from the cache.
+// Readers without a block cache always read from disk.
+func (r *Reader) getBlock(entry IndexEntry) ([]byte, error) {
+	cacheKey := fmt.Sprintf("%d:%d", r.fileNum, entry.Offset)
+
+	if r.cache != nil {
+		if blockData, ok := r.cache.Get(cacheKey); ok {
+			return blockData.([]byte), nil
+		}
+	}
+	// Cache miss: Read the block from disk.
+	blockData := make([]byte, entry.Size)
+	_, err := r.file.ReadAt(blockData, entry.Offset)
+	if err != nil {
+		return nil, err
+	}
+	r.io.Read(len(blockData), r.cache != nil)
+	if blockData, err = r.verifyBlock(entry, blockData); err != nil {
+		return nil, err
+	}
+
+	// Add the newly read block to the cache.
+	if r.cache != nil {
+		r.cache.Add(cacheKey, blockData, int64(len(blockData)), false)
+	}
+	return blockData, nil
+}
+
+// verifyBlock checks the checksum of a block read from disk and returns the
+// block without it. Tables written before block checksums are not verified.
+func (r *Reader) verifyBlock(entry IndexEntry, blockData []byte) ([]byte, error) {
+	checksum := r.props.Checksum
+	if checksum == base.ChecksumLegacy {
+		return blockData, nil
+	}
+	if len(blockData) < 4 {
+		return nil, r.corruption(entry.Offset, errors.New("block too short"))
+	}
+	trailer := len(blockData) - 4
+	if binary.LittleEndian.Uint32(blockData[trailer:]) != checksum.Sum(blockData[:trailer]) {
+		return nil, r.corruption(entry.Offset, errors.New("block checksum mismatch"))
+	}
+	return blockData[:trailer], nil
+}
+
+// corruption returns a *base.CorruptionError for damaged data at offset.
+func (r *Reader) corruption(offset int64, err error) error {
+	return &base.CorruptionError{File: r.file.Name(), Offset: offset, Err: err}
+}
+
+// Get returns the value of the newest entry for the user key in the table.
+// A key deleted in this table is found, with base.ErrNotFound, so that a search
+// through several tables stops at the tombstone.
+
+func (r *Reader) Get(userKey []byte) ([]byte, bool, error) {
+	ik, value, found, err := r.GetEntry(userKey, math.MaxUint64)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if ik.Type == base.OpTypeDelete {
+		return nil, true, base.ErrNotFound
+	}
+	return value, true, nil
+}
+
+// GetEntry returns the newest entry stored for the user key with a sequence
+// number at most seq, including tombstones.
+func (r *Reader) GetEntry(userKey []byte, seq uint64) (_ base.InternalKey, _ []byte, found bool, err error) {
+	admits, tested := r.testFilter(userKey)
+	if !admits {
+		r.io.FilterChecked(true, false)
+		return base.InternalKey{}, nil, false, nil
+	}
+	present := false // Whether the table holds a version of the key, maybe newer than seq
+	if tested {
+		defer func() {
+			if err == nil {
+				r.io.FilterChecked(false, !found && !present)
+			}
+		}()
+	}
+
+	searchKey := base.InternalKey{
+		UserKey: string(userKey),
+		SeqNum:  seq,
+		Type:    base.OpTypePut,
+	}
+
+	// Find the Data block that contains this searchKey
+	index, err := r.Index()
+	if err != nil {
+		return base.InternalKey{}, nil, false, err
+	}
+	blockIndex := sort.Search(len(index), func(i int) bool {
+		return r.cmp.Compare(index[i].LastKey, searchKey) >= 0
+	})
+
+	// Newer versions of the key may end the previous block.
+	present = blockIndex > 0 && index[blockIndex-1].LastKey.UserKey == string(userKey)
+	if blockIndex >= len(index) {
+		return base.InternalKey{}, nil, false, nil
+	}
+
+	blockData, err := r.getBlock(index[blockIndex])
+	if err != nil {
+		return base.InternalKey{}, nil, false, err
+	}
+
+	// The first entry in the block at or after searchKey is the answer, as long
+	// as it still belongs to the same user key.
+	it := newBlockIterator(blockData)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if r.cmp.Compare(it.Key(), searchKey) < 0 {
+			present = present || it.Key().UserKey == string(userKey)
+			continue
+		}
+		if it.Key().UserKey != string(userKey) {
+			break
+		}
+		return it.Key(), it.Value(), true, nil
+	}
+	if err := it.Error(); err != nil {
+		return base.InternalKey{}, nil, false, r.corruption(index[blockIndex].Offset, err)
+	}
+	return base.InternalKey{}, nil, false, nil
+}
+
+// MayContainKeyRange reports whether userKey lies between the smallest and
+// largest key of the table. Tables written before properties existed are not
+// pruned.
+func (r *Reader) MayContainKeyRange(userKey []byte) bool {
+	if r.props.NumEntries == 0 {
+		return true
+	}
+	return string(userKey) >= r.props.SmallestKey && string(userKey) <= r.props.LargestKey
+}
+
+// MayContain reports whether the table's filter admits userKey. Tables
+// without a filter, or whose filter cannot be read, may contain any key.
+func (r *Reader) MayContain(userKey []byte) bool {
+	admits, _ := r.testFilter(userKey)
+	return admits
+}
+
+// testFilter reports whether the table's filter admits userKey, and whether
+// there was a filter to test.
+func (r *Reader) testFilter(userKey []byte) (admits, tested bool) {
+	filter, err := r.loadFilter()
+	if err != nil || filter == nil {
+		return true, false
+	}
+	return filter.Test(userKey), true
+}
+
+// MayContainPrefix reports whether the table may hold keys whose prefix under
+// extractor is prefix. Tables whose filter was built with a different extractor
+// cannot rule anything out.
+func (r *Reader) MayContainPrefix(extractor PrefixExtractor, prefix []byte) bool {
+	if r.props.PrefixExtractor != extractor.Name() {
+		return true
+	}
+	filter, err := r.loadFilter()
+	if err != nil || filter == nil {
+		return true
+	}
+	return filter.Test(prefix)
+}
+
+// KeyRange returns the smallest and largest user key in the table. Tables
+// written before properties existed are read to find the smallest key.
+func (r *Reader) KeyRange() (string, string, error) {
+	if r.props.NumEntries > 0 {
+		return r.props.SmallestKey, r.props.LargestKey, nil
+	}
+	index, err := r.Index()
+	if err != nil {
+		return "", "", err
+	}
+	if len(index) == 0 {
+		return "", "", fmt.Errorf("SSTable %d is empty", r.fileNum)
+	}
+	it := r.NewIterator()
+	defer it.Close()
+	it.SeekToFirst()
+	if !it.Valid() {
+		return "", "", fmt.Errorf("failed to read first key of SSTable %d: %v", r.fileNum, it.Error())
+	}
+	return it.Key().UserKey, index[len(index)-1].LastKey.UserKey, nil
+}
+
+// Properties returns the table's properties block.
+func (r *Reader) Properties() Properties {
+	return r.props
+}
+
+// Footer returns the location of the table's metadata blocks.
+func (r *Reader) Footer() Footer {
+	return r.footer
+}
+
+// Close closes the underlying file of the reader.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// Ref takes another reference to the reader.
+func (r *Reader) Ref() {
+	r.refs.Add(1)
+}
+
+// TryRef takes a reference unless the reader has already been closed.
+func (r *Reader) TryRef() bool {
+	for {
+		n := r.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if r.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Unref releases a reference and closes the file once none are left.
+func (r *Reader) Unref() {
+	if r.refs.Add(-1) == 0 {
+		r.file.Close()
+	}
+}
+
+// blockIterator iterates over a single data block in memory.
+type blockIterator struct {
+	data    []byte
+	reader  *bytes.Reader
+	key     base.InternalKey
+	value   []byte
+	valid   bool
+	err     error
+	offset  int64   // Start of the current entry
+	offsets []int64 // Start of every entry, computed on the first Prev
+}
+
+func newBlockIterator(data []byte) *blockIterator {
+	return &blockIterator{
+		data:   data,
+		reader: bytes.NewReader(data),
+	}
+}
+
+func (it *blockIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *blockIterator) Key() base.InternalKey {
+	return it.key
+}
+
+func (it *blockIterator) Value() []byte {
+	return it.value
+}
+
+func (it *blockIterator) Next() {
+	it.readNext()
+}
+
+func (it *blockIterator) SeekToFirst() {
+	it.reader.Seek(0, io.SeekStart)
+	it.readNext()
+}
+
+// Seek moves to the first entry in the block whose user key is at or after key.
+func (it *blockIterator) Seek(key []byte) {
+	for it.SeekToFirst(); it.valid && it.key.UserKey < string(key); {
+		it.readNext()
+	}
+}
+
+func (it *blockIterator) SeekForPrev(key []byte) {
+	base.SeekForPrev(it, key)
+}
+
+func (it *blockIterator) SeekToLast() {
+	offsets := it.entryOffsets()
+	if len(offsets) == 0 {
+		it.valid = false
+		return
+	}
+	it.readAt(offsets[len(offsets)-1])
+}
+
+func (it *blockIterator) Prev() {
+	offsets := it.entryOffsets()
+	i := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= it.offset })
+	if i == 0 {
+		it.valid = false
+		return
+	}
+	it.readAt(offsets[i-1])
+}
+
+// readAt decodes the entry starting at offset.
+func (it *blockIterator) readAt(offset int64) {
+	it.reader.Seek(offset, io.SeekStart)
+	it.readNext()
+}
+
+// entryOffsets returns the start of every entry in the block, found by walking
+// the size headers without decoding any keys.
+func (it *blockIterator) entryOffsets() []int64 {
+	if it.offsets != nil {
+		return it.offsets
+	}
+	offsets := []int64{}
+	for off := int64(0); off+8 <= int64(len(it.data)); {
+		keySize := binary.LittleEndian.Uint32(it.data[off:])
+		valueSize := binary.LittleEndian.Uint32(it.data[off+4:])
+		offsets = append(offsets, off)
+		off += 8 + int64(keySize) + int64(valueSize)
+	}
+	it.offsets = offsets
+	return offsets
+}
+
+func (it *blockIterator) Error() error { return it.err }
+
+func (it *blockIterator) Close() error { return nil }
+
+func (it *blockIterator) readNext() {
+	if it.reader.Len() == 0 {
+		it.valid = false
+		return
+	}
+	it.offset = it.reader.Size() - int64(it.reader.Len())
+
+	var keySize, valueSize uint32
+	if err := binary.Read(it.reader, binary.LittleEndian, &keySize); err != nil {
+		if err != io.EOF {
+			it.err = err
+		}
+		it.valid = false
+		return
+	}
+	if err := binary.Read(it.reader, binary.LittleEndian, &valueSize); err != nil {
+		it.err = err
+		it.valid = false
+		return
+	}
+
+	if int64(keySize)+int64(valueSize) > int64(it.reader.Len()) {
+		it.err = fmt.Errorf("entry at offset %d claims %d bytes past the end of its block", it.offset, keySize+valueSize)
+		it.valid = false
+		return
+	}
+	keyBytes := make([]byte, keySize)
+	if _, err := io.ReadFull(it.reader, keyBytes); err != nil {
+		it.err = err
+		it.valid = false
+		return
+	}
+
+	var ik base.InternalKey
+	if err := gob.NewDecoder(bytes.NewReader(keyBytes)).Decode(&ik); err != nil {
+		it.err = err
+		it.valid = false
+		return
+	}
+	it.key = ik
+
+	valueBytes := make([]byte, valueSize)
+	if _, err := io.ReadFull(it.reader, valueBytes); err != nil {
+		it.err = err
+		it.valid = false
+		return
+	}
+	it.value = valueBytes
+	it.valid = true
+}
+
+// NewIterator creates a new iterator over the SSTable. The iterator keeps the
+// reader open until it is closed.
+func (r *Reader) NewIterator() base.Iterator {
+	return r.newIterator(0)
+}
+
+// NewCompactionIterator returns an iterator that reads readahead bytes of data
+// blocks at once, bypassing the block cache. See newIterator.
+func (r *Reader) NewCompactionIterator(readahead int64) base.Iterator {
+	return r.newIterator(readahead)
+}
+
+// newIterator returns an iterator that, if readahead is positive, reads that
+// many bytes of data blocks at once into a private buffer instead of reading
+// block by block. It suits a single sequential pass such as a compaction.
+func (r *Reader) newIterator(readahead int64) *fileIterator {
+	r.Ref()
+	return &fileIterator{
+		reader:        r,
+		readaheadSize: readahead,
+	}
+}
+
+// fileIterator implements the Iterator interface for an entire SSTable.
+type fileIterator struct {
+	reader     *Reader
+	blockIter  *blockIterator
+	blockIndex int
+	err        error
+
+	// Readahead state. sequential counts the block boundaries crossed by Next
+	// since the last seek; prefetchedTo is the end of the blocks scheduled.
+	sequential   int
+	prefetchedTo int
+	prefetches   sync.WaitGroup
+
+	// Compaction readahead: readaheadBuf holds the file from readaheadOffset.
+	readaheadSize   int64
+	readaheadBuf    []byte
+	readaheadOffset int64
+}
+
+func (it *fileIterator) Valid() bool {
+	return it.blockIter != nil && it.blockIter.Valid()
+}
+
+func (it *fileIterator) Key() base.InternalKey {
+	return it.blockIter.Key()
+}
+
+func (it *fileIterator) Value() []byte {
+	return it.blockIter.Value()
+}
+
+func (it *fileIterator) Next() {
+	if it.blockIter == nil {
+		return
+	}
+	it.blockIter.Next()
+	if it.blockFailed() {
+		return
+	}
+	if !it.blockIter.Valid() {
+		it.blockIndex++
+		it.sequential++
+		if it.loadBlock() {
+			it.readahead()
+		}
+	}
+}
+
+// readahead reads the blocks following the current one into the block cache
+// in the background once the iterator is consumed sequentially, so scans do
+// not wait for a synchronous read at every block boundary.
+func (it *fileIterator) readahead() {
+	r := it.reader
+	if r.cache == nil || it.sequential < ReadaheadTrigger {
+		return
+	}
+	index, err := r.Index()
+	if err != nil {
+		return
+	}
+	start := max(it.blockIndex+1, it.prefetchedTo)
+	end := min(it.blockIndex+1+ReadaheadBlocks, len(index))
+	if start >= end {
+		return
+	}
+	it.prefetchedTo = end
+	r.Ref()
+	it.prefetches.Go(func() {
+		defer r.Unref()
+		for _, entry := range index[start:end] {
+			if _, err := r.getBlock(entry); err != nil {
+				return // The iterator reports the error when it gets there
+			}
+		}
+	})
+}
+
+// resetReadahead forgets the sequential run after the iterator moved
+// elsewhere.
+func (it *fileIterator) resetReadahead() {
+	it.sequential = 0
+	it.prefetchedTo = 0
+}
+
+func (it *fileIterator) Prev() {
+	if it.blockIter == nil {
+		return
+	}
+	it.resetReadahead()
+	it.blockIter.Prev()
+	if it.blockFailed() {
+		return
+	}
+	for !it.blockIter.Valid() && it.blockIndex > 0 {
+		it.blockIndex--
+		if !it.loadBlockAt(false) {
+			return
+		}
+	}
+	if !it.blockIter.Valid() {
+		it.blockIter = nil
+	}
+}
+
+func (it *fileIterator) Close() error {
+	it.blockIter = nil
+	it.prefetches.Wait()
+	if it.reader != nil {
+		it.reader.Unref()
+		it.reader = nil
+	}
+	return nil
+}
+
+func (it *fileIterator) Error() error {
+	return it.err
+}
+
+func (it *fileIterator) SeekToFirst() {
+	it.resetReadahead()
+	it.blockIndex = 0
+	it.loadBlock()
+}
+
+// Seek uses the index to find the first block whose last key is at or after
+// key and positions the iterator within it.
+func (it *fileIterator) Seek(key []byte) {
+	it.resetReadahead()
+	index, err := it.reader.Index()
+	if err != nil {
+		it.err = err
+		it.blockIter = nil
+		return
+	}
+	it.blockIndex = sort.Search(len(index), func(i int) bool {
+		return index[i].LastKey.UserKey >= string(key)
+	})
+	if !it.loadBlock() {
+		return
+	}
+	it.blockIter.Seek(key)
+	if it.blockFailed() {
+		return
+	}
+	if !it.blockIter.Valid() {
+		it.blockIndex++
+		it.loadBlock()
+	}
+}
+
+func (it *fileIterator) SeekForPrev(key []byte) {
+	base.SeekForPrev(it, key)
+}
+
+func (it *fileIterator) SeekToLast() {
+	it.resetReadahead()
+	index, err := it.reader.Index()
+	if err != nil {
+		it.err = err
+		it.blockIter = nil
+		return
+	}
+	if len(index) == 0 {
+		it.blockIter = nil
+		return
+	}
+	it.blockIndex = len(index) - 1
+	it.loadBlockAt(false)
+}
+
+// blockFailed reports whether the current block turned out to be corrupt, in
+// which case the iterator becomes invalid with the block's error.
+func (it *fileIterator) blockFailed() bool {
+	if err := it.blockIter.Error(); err != nil {
+		it.err = err
+		if index, ierr := it.reader.Index(); ierr == nil && it.blockIndex < len(index) {
+			it.err = it.reader.corruption(index[it.blockIndex].Offset, err)
+		}
+		it.blockIter = nil
+		return true
+	}
+	return false
+}
+
+func (it *fileIterator) loadBlock() bool {
+	return it.loadBlockAt(true)
+}
+
+// loadBlockAt loads the block at blockIndex and positions the block iterator
+// at its first entry, or at its last if first is false. It reports whether
+// a block was loaded.
+func (it *fileIterator) loadBlockAt(first bool) bool {
+	index, err := it.reader.Index()
+	if err != nil {
+		it.err = err
+		it.blockIter = nil
+		return false
+	}
+	if it.blockIndex >= len(index) {
+		it.blockIter = nil
+		return false
+	}
+	entry := index[it.blockIndex]
+
+	blockData, err := it.readBlock(entry)
+	if err != nil {
+		it.err = err
+		it.blockIter = nil
+		return false
+	}
+	it.blockIter = newBlockIterator(blockData)
+	if first {
+		it.blockIter.SeekToFirst()
+	} else {
+		it.blockIter.SeekToLast()
+	}
+	return !it.blockFailed()
+}
+
+// readBlock returns the data block of entry, served from the readahead buffer
+// if the iterator has one. Blocks are decoded into fresh keys and values, so
+// the buffer is reused once the iterator moves past it.
+func (it *fileIterator) readBlock(entry IndexEntry) ([]byte, error) {
+	if it.readaheadSize <= 0 {
+		return it.reader.getBlock(entry)
+	}
+	end := entry.Offset + int64(entry.Size)
+	if entry.Offset < it.readaheadOffset || end > it.readaheadOffset+int64(len(it.readaheadBuf)) {
+		// Data blocks end where the filter block starts.
+		size := max(min(it.readaheadSize, it.reader.footer.FilterOffset-entry.Offset), int64(entry.Size))
+		if int64(cap(it.readaheadBuf)) < size {
+			it.readaheadBuf = make([]byte, size)
+		}
+		it.readaheadBuf = it.readaheadBuf[:size]
+		if _, err := it.reader.file.ReadAt(it.readaheadBuf, entry.Offset); err != nil {
+			it.readaheadBuf = nil
+			return nil, err
+		}
+		it.reader.io.CompactionRead(len(it.readaheadBuf))
+		it.readaheadOffset = entry.Offset
+	}
+	blockData := it.readaheadBuf[entry.Offset-it.readaheadOffset : end-it.readaheadOffset]
+	return it.reader.verifyBlock(entry, blockData)
+}
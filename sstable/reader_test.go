@@ -0,0 +1,140 @@
+package sstable
+
+import (
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/cache"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%016d", i))
+}
+
+// writeTestTable writes the keys testKey(i) for i in [0, n) in steps of step,
+// each with value i, to a new table and returns its path.
+func writeTestTable(t *testing.T, dataBlockSize, n, step int) string {
+	path := filepath.Join(t.TempDir(), "00001.sst")
+	w, err := NewWriter(vfs.OSFS{}, path, WriterOptions{DataBlockSize: dataBlockSize, Checksum: base.ChecksumCRC32C, FilterFPRate: 0.01})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for i := 0; i < n; i += step {
+		key := base.InternalKey{UserKey: string(testKey(i)), SeqNum: uint64(i + 1), Type: base.OpTypePut}
+		if err := w.Add(key, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if err := w.Add(base.InternalKey{UserKey: string(testKey(0)), Type: base.OpTypePut}, nil); err == nil {
+		t.Errorf("Expected an error for a key added out of order")
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	return path
+}
+
+func openTestTable(t *testing.T, path string, c *cache.Cache) *Reader {
+	r, err := Open(vfs.OSFS{}, path, ReaderOptions{Cache: c})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestWriter(t *testing.T) {
+	n := 2000
+	r := openTestTable(t, writeTestTable(t, 4096, n, 1), cache.New(8<<20, 2<<20))
+	if got := r.Properties().NumEntries; got != uint64(n) {
+		t.Errorf("NumEntries = %d, want %d", got, n)
+	}
+	for _, i := range []int{0, n / 2, n - 1} {
+		if value, ok, err := r.Get(testKey(i)); err != nil || !ok || string(value) != fmt.Sprint(i) {
+			t.Errorf("Get(%d) = %q, %v, %v", i, value, ok, err)
+		}
+	}
+	if err := Verify(vfs.OSFS{}, r.file.Name()); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+}
+
+func TestIteratorSeek(t *testing.T) {
+	r := openTestTable(t, writeTestTable(t, 4096, 2000, 2), cache.New(8<<20, 2<<20))
+	if index, _ := r.Index(); len(index) < 3 {
+		t.Fatalf("Table has %d blocks, want several", len(index))
+	}
+
+	it := r.NewIterator()
+	defer it.Close()
+	for _, i := range []int{0, 1, 777, 1000, 1998} {
+		it.Seek(testKey(i))
+		want := string(testKey(i + i%2))
+		if !it.Valid() || it.Key().UserKey != want {
+			t.Errorf("Seek(%d) did not land on %s", i, want)
+		}
+	}
+	if it.Seek(testKey(1999)); it.Valid() {
+		t.Errorf("Seek past the last key is valid at %s", it.Key().UserKey)
+	}
+}
+
+func TestIteratorReadahead(t *testing.T) {
+	r := openTestTable(t, writeTestTable(t, 256, 200, 1), cache.New(8<<20, 2<<20))
+	index, err := r.Index()
+	if err != nil || len(index) < 2*ReadaheadBlocks+ReadaheadTrigger {
+		t.Fatalf("Table has %d blocks, want more: %v", len(index), err)
+	}
+	cached := func(block int) bool {
+		_, ok := r.cache.Get(fmt.Sprintf("%d:%d", r.fileNum, index[block].Offset))
+		return ok
+	}
+
+	it := r.NewIterator().(*fileIterator)
+	defer it.Close()
+	it.Seek([]byte(index[0].LastKey.UserKey))
+	it.Next()
+	if it.prefetches.Wait(); cached(2) {
+		t.Errorf("Block 2 was read ahead after a single block boundary")
+	}
+	for it.SeekToFirst(); it.blockIndex < ReadaheadTrigger; it.Next() {
+	}
+	it.prefetches.Wait()
+	for block := ReadaheadTrigger + 1; block <= ReadaheadTrigger+ReadaheadBlocks; block++ {
+		if !cached(block) {
+			t.Errorf("Block %d was not read ahead", block)
+		}
+	}
+	if cached(ReadaheadTrigger + ReadaheadBlocks + 1) {
+		t.Errorf("Read ahead more than %d blocks", ReadaheadBlocks)
+	}
+}
+
+func TestCompactionIterator(t *testing.T) {
+	r := openTestTable(t, writeTestTable(t, 256, 200, 1), nil)
+	for _, readahead := range []int64{1 << 20, 600} {
+		it := r.NewCompactionIterator(readahead).(*fileIterator)
+		refills := 0
+		lastOffset := int64(-1)
+		n := 0
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			if it.readaheadOffset != lastOffset {
+				refills++
+				lastOffset = it.readaheadOffset
+			}
+			if it.Key().UserKey != string(testKey(n)) {
+				t.Fatalf("Readahead %d: entry %d is %s", readahead, n, it.Key().UserKey)
+			}
+			n++
+		}
+		if err := it.Error(); err != nil || n != 200 {
+			t.Errorf("Readahead %d: read %d entries, err %v", readahead, n, err)
+		}
+		if readahead == 1<<20 && refills != 1 {
+			t.Errorf("Expected one read for the whole table, got %d", refills)
+		}
+		it.Close()
+	}
+}
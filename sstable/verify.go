@@ -0,0 +1,66 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/vfs"
+)
+
+// Verify checks that the footer and index of the SSTable at path on fs
+// describe the file: the index is ordered, its blocks tile the data area, and
+// the filter, index and properties blocks follow them up to the footer. Block
+// contents are not read.
+func Verify(fs vfs.FS, path string) error {
+	r, err := Open(fs, path, ReaderOptions{})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	stat, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	var footerSize [4]byte
+	if _, err := r.file.ReadAt(footerSize[:], stat.Size()-4); err != nil {
+		return err
+	}
+	footerOffset := stat.Size() - 4 - int64(binary.LittleEndian.Uint32(footerSize[:]))
+
+	f := r.footer
+	metaEnd := f.IndexOffset + int64(f.IndexSize)
+	if f.PropertiesSize > 0 {
+		if f.PropertiesOffset != metaEnd {
+			return r.corruption(f.PropertiesOffset, fmt.Errorf("properties block does not follow the index ending at %d", metaEnd))
+		}
+		metaEnd += int64(f.PropertiesSize)
+	}
+	if f.FilterOffset < 0 || f.FilterSize < 0 || f.IndexSize < 0 || f.FilterOffset+int64(f.FilterSize) != f.IndexOffset {
+		return r.corruption(f.IndexOffset, fmt.Errorf("index block does not follow the filter at %d", f.FilterOffset))
+	}
+	if metaEnd != footerOffset {
+		return r.corruption(footerOffset, fmt.Errorf("footer does not follow the metadata blocks ending at %d", metaEnd))
+	}
+
+	index, err := r.Index()
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for i, entry := range index {
+		if entry.Offset != offset || entry.Size <= 0 {
+			return r.corruption(entry.Offset, fmt.Errorf("block %d of %d bytes does not follow the previous one ending at %d", i, entry.Size, offset))
+		}
+		if i > 0 && r.cmp.Compare(index[i-1].LastKey, entry.LastKey) >= 0 {
+			return r.corruption(entry.Offset, fmt.Errorf("index entry %d is out of order", i))
+		}
+		offset += int64(entry.Size)
+	}
+	if offset != f.FilterOffset {
+		return r.corruption(offset, fmt.Errorf("data blocks end at %d, the filter starts at %d", offset, f.FilterOffset))
+	}
+	if n := len(index); n > 0 && r.props.LargestKey != "" && index[n-1].LastKey.UserKey != r.props.LargestKey {
+		return r.corruption(index[n-1].Offset, errors.New("last block does not end at the largest key of the table"))
+	}
+	return nil
+}
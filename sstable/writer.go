@@ -0,0 +1,234 @@
+package sstable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/vfs"
+)
+
+// Writer builds an SSTable from a stream of entries added in increasing
+// internal key order. Only the current data block is buffered; the index and
+// 64-bit hashes of the keys for the filter are kept until Finish writes them
+// out.
+type Writer struct {
+	file   vfs.File
+	fs     vfs.FS
+	writer *bufio.Writer
+	opts   WriterOptions
+	cmp    base.InternalKeyComparator
+
+	offset         int64
+	block          bytes.Buffer
+	lastKeyInBlock base.InternalKey
+	indexEntries   []IndexEntry
+
+	filterHashes []uint64 // filterHash of the user keys and prefixes
+	lastPrefix   []byte
+	props        Properties
+	keyTimes     bool // Whether the time range comes from key timestamps
+	hasLast      bool
+	lastKey      base.InternalKey
+}
+
+// NewWriter creates the file at path on fs and returns a writer for it. The
+// table is only complete once Finish returns; call Abort to give up on it.
+func NewWriter(fs vfs.FS, path string, opts WriterOptions) (*Writer, error) {
+	file, err := fs.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		file:   file,
+		fs:     fs,
+		writer: bufio.NewWriter(file),
+		opts:   opts,
+		props:  Properties{DataBlockSize: opts.DataBlockSize, Checksum: opts.Checksum, MinTimestamp: opts.MinTime, MaxTimestamp: opts.MaxTime},
+	}, nil
+}
+
+// Add appends an entry to the table. Keys must be added in strictly increasing
+// order: by user key, then by descending sequence number.
+func (w *Writer) Add(key base.InternalKey, value []byte) error {
+	if w.hasLast && w.cmp.Compare(w.lastKey, key) >= 0 {
+		return fmt.Errorf("sstable: key %q@%d added out of order", key.UserKey, key.SeqNum)
+	}
+	if w.opts.FilterPolicy != FilterPolicyNone && (!w.hasLast || key.UserKey != w.lastKey.UserKey) {
+		w.filterHashes = append(w.filterHashes, filterHash([]byte(key.UserKey)))
+		if w.opts.PrefixExtractor != nil {
+			// Keys are sorted, so equal prefixes are adjacent.
+			if p := w.opts.PrefixExtractor.Prefix([]byte(key.UserKey)); p != nil && !bytes.Equal(p, w.lastPrefix) {
+				w.filterHashes = append(w.filterHashes, filterHash(p))
+				w.lastPrefix = p
+			}
+		}
+	}
+	if w.hasLast && key.UserKey == w.lastKey.UserKey {
+		w.props.NumObsolete++
+	}
+	w.hasLast = true
+	w.lastKey = key
+
+	if w.block.Len() > w.opts.DataBlockSize {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+	keyBuf := new(bytes.Buffer)
+	if err := gob.NewEncoder(keyBuf).Encode(key); err != nil {
+		return err
+	}
+	keyBytes := keyBuf.Bytes()
+	binary.Write(&w.block, binary.LittleEndian, uint32(len(keyBytes)))
+	binary.Write(&w.block, binary.LittleEndian, uint32(len(value)))
+	w.block.Write(keyBytes)
+	w.block.Write(value)
+	w.lastKeyInBlock = key
+
+	if w.opts.Timestamp != nil {
+		if ts, ok := w.opts.Timestamp([]byte(key.UserKey)); ok {
+			if !w.keyTimes || ts.UnixNano() < w.props.MinTimestamp {
+				w.props.MinTimestamp = ts.UnixNano()
+			}
+			if !w.keyTimes || ts.UnixNano() > w.props.MaxTimestamp {
+				w.props.MaxTimestamp = ts.UnixNano()
+			}
+			w.keyTimes = true
+		}
+	}
+	if w.props.NumEntries == 0 {
+		w.props.SmallestKey = key.UserKey
+		w.props.SmallestSeq = key.SeqNum
+	}
+	w.props.SmallestSeq = min(w.props.SmallestSeq, key.SeqNum)
+	w.props.LargestSeq = max(w.props.LargestSeq, key.SeqNum)
+	w.props.LargestKey = key.UserKey
+	w.props.NumEntries++
+	if key.Type == base.OpTypeDelete {
+		w.props.NumDeletions++
+	}
+	return nil
+}
+
+// NumEntries returns the number of entries added so far.
+func (w *Writer) NumEntries() uint64 {
+	return w.props.NumEntries
+}
+
+// Properties returns the properties of the entries added so far. The filter
+// properties are only known to the reader of the finished table.
+func (w *Writer) Properties() Properties {
+	return w.props
+}
+
+// EstimatedSize returns the size of the table so far: the data blocks written
+// and the block being built.
+func (w *Writer) EstimatedSize() int64 {
+	return w.offset + int64(w.block.Len())
+}
+
+// flushBlock writes the buffered data block followed by its checksum and
+// records it in the index.
+func (w *Writer) flushBlock() error {
+	binary.Write(&w.block, binary.LittleEndian, w.opts.Checksum.Sum(w.block.Bytes()))
+	n, err := w.writer.Write(w.block.Bytes())
+	if err != nil {
+		return err
+	}
+	w.indexEntries = append(w.indexEntries, IndexEntry{
+		LastKey: w.lastKeyInBlock,
+		Offset:  w.offset,
+		Size:    n,
+	})
+	w.offset += int64(n)
+	w.block.Reset()
+	return nil
+}
+
+// Finish writes the last data block, the filter, index and properties blocks
+// and the footer, then syncs and closes the file.
+func (w *Writer) Finish() error {
+	err := w.finish()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *Writer) finish() error {
+	if w.block.Len() > 0 {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+
+	// Write the Filter Block
+	filterOffset := w.offset
+	filterSize, filterProps, err := writeFilter(w.writer, w.filterHashes, &w.opts)
+	if err != nil {
+		return err
+	}
+	props := w.props
+	props.FilterPolicy = filterProps.FilterPolicy
+	props.FilterBitsPerKey = filterProps.FilterBitsPerKey
+	props.FilterFPRate = filterProps.FilterFPRate
+	props.FilterKeyHashes = filterProps.FilterKeyHashes
+	if props.FilterPolicy != FilterPolicyNone && w.opts.PrefixExtractor != nil {
+		props.PrefixExtractor = w.opts.PrefixExtractor.Name()
+	}
+
+	// Write the Index Block
+	indexOffset := filterOffset + filterSize
+	indexBuf := new(bytes.Buffer)
+	if err := gob.NewEncoder(indexBuf).Encode(w.indexEntries); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(indexBuf.Bytes()); err != nil {
+		return err
+	}
+
+	// Write the Properties Block
+	propertiesOffset := indexOffset + int64(indexBuf.Len())
+	propsBuf := new(bytes.Buffer)
+	if err := gob.NewEncoder(propsBuf).Encode(props); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(propsBuf.Bytes()); err != nil {
+		return err
+	}
+
+	// Write the Footer
+	footer := Footer{
+		IndexOffset:      indexOffset,
+		IndexSize:        indexBuf.Len(),
+		FilterOffset:     filterOffset,
+		FilterSize:       int(filterSize),
+		PropertiesOffset: propertiesOffset,
+		PropertiesSize:   propsBuf.Len(),
+	}
+	footerBuffer := new(bytes.Buffer)
+	if err := gob.NewEncoder(footerBuffer).Encode(footer); err != nil {
+		return err
+	}
+	footerBytes := footerBuffer.Bytes()
+	if _, err := w.writer.Write(footerBytes); err != nil {
+		return err
+	}
+	if err := binary.Write(w.writer, binary.LittleEndian, int32(len(footerBytes))); err != nil {
+		return err
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Abort closes and removes the unfinished table.
+func (w *Writer) Abort() {
+	w.file.Close()
+	w.fs.Remove(w.file.Name())
+}
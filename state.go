@@ -0,0 +1,280 @@
+package leveldb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFile     = "state.json"
+	prevStateFile = "state.json.prev" // The state before the last saveState
+)
+
+type DBState struct {
+	NextFileNumber int   `json:"next_file_number"`
+	ActiveSSTables []int `json:"active_sstables"`
+	// LastSequence is at least the highest sequence number stored in any SSTable,
+	// so numbering resumes correctly after the WALs that held it are deleted.
+	LastSequence uint64 `json:"last_sequence"`
+	// LogNumber is the lowest number of a rotated WAL whose contents are not yet
+	// in an SSTable. Rotated WALs below it are skipped on recovery.
+	LogNumber int `json:"log_number,omitempty"`
+	// HasBlobs is set once a value has been written with PutReader.
+	HasBlobs bool `json:"has_blobs,omitempty"`
+	// TableSeqs is the range of sequence numbers in each SSTable, by file
+	// number. State files written before it existed have none.
+	TableSeqs map[int]SeqRange `json:"table_seqs,omitempty"`
+	// TimestampSize is Options.TimestampSize, which the stored keys depend on.
+	TimestampSize int `json:"timestamp_size,omitempty"`
+	// Format is stateFormat for state files that always carry a CRC.
+	Format int `json:"format,omitempty"`
+	// CRC is the checksum of the state encoded with CRC set to zero. State
+	// files written before checksums existed have none.
+	CRC uint32 `json:"crc,omitempty"`
+}
+
+// stateFormat is the current format of the state file. Files without a format
+// were written by older versions, which may not have written a CRC.
+const stateFormat = 1
+
+// checksum returns the CRC of the state without its CRC field.
+func (s DBState) checksum() (uint32, error) {
+	s.CRC = 0
+	data, err := json.Marshal(s)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(data), nil
+}
+
+// saveState serializes the current DB state to a JSON file. The new state is
+// written to a temporary file, synced and renamed over state.json, so a crash
+// leaves either the old or the new state in place. The previous state is kept
+// as state.json.prev.
+func (db *DB) saveState() error {
+	return writeState(db.opts.FS, db.dataDir, db.stateLocked())
+}
+
+// stateLocked returns the state to persist. The caller must hold db.mu.
+func (db *DB) stateLocked() DBState {
+	return DBState{
+		NextFileNumber: db.nextFileNumber,
+		ActiveSSTables: db.current.tables,
+		TableSeqs:      db.current.seqs,
+		LastSequence:   db.sequenceNum.Load(),
+		LogNumber:      db.logNumber,
+		HasBlobs:       db.hasBlobs,
+		TimestampSize:  db.opts.TimestampSize,
+	}
+}
+
+// encodeState returns the contents of a state file for state.
+func encodeState(state DBState) ([]byte, error) {
+	state.Format = stateFormat
+	crc, err := state.checksum()
+	if err != nil {
+		return nil, err
+	}
+	state.CRC = crc
+	return json.MarshalIndent(state, "", "  ")
+}
+
+// writeState saves state as the state file of the database in dir.
+func writeState(fs FS, dir string, state DBState) error {
+	data, err := encodeState(state)
+	if err != nil {
+		return err
+	}
+
+	statePath := filepath.Join(dir, stateFile)
+	tmpPath := statePath + ".tmp"
+	if err := writeFileSync(fs, tmpPath, data); err != nil {
+		return err
+	}
+	if err := fs.Rename(statePath, filepath.Join(dir, prevStateFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := fs.Rename(tmpPath, statePath); err != nil {
+		return err
+	}
+	return fs.SyncDir(dir)
+}
+
+// loadState reads the state of the database in dir. If state.json is missing
+// or corrupt, the state a crashed save left in state.json.tmp is used, or else
+// the previous state if it still describes the files in dir. A database
+// without any state file starts from the default state.
+func loadState(fs FS, dir string) (DBState, error) {
+	statePath := filepath.Join(dir, stateFile)
+	state, err := readState(fs, statePath)
+	if err == nil {
+		log.Printf("Loaded state: NextFileNumber is %d, ActiveSSTables: %v", state.NextFileNumber, state.ActiveSSTables)
+		return state, nil
+	}
+	// writeState syncs the new state before renaming it into place.
+	if pending, tmpErr := readState(fs, statePath+".tmp"); tmpErr == nil {
+		log.Printf("WARNING: Using the state of an interrupted save, %s is unusable: %v", stateFile, err)
+		return pending, nil
+	}
+	prev, prevErr := readState(fs, filepath.Join(dir, prevStateFile))
+	if prevErr == nil {
+		if prevErr = checkTables(fs, dir, prev); prevErr == nil {
+			log.Printf("WARNING: Using previous state file, %s is unusable: %v", stateFile, err)
+			return prev, nil
+		}
+		log.Printf("WARNING: Previous state file is unusable: %v", prevErr)
+	}
+	if os.IsNotExist(err) && os.IsNotExist(prevErr) {
+		log.Println("State file not found, initializing with default state.")
+		return DBState{NextFileNumber: 1, ActiveSSTables: []int{}}, nil
+	}
+	return DBState{}, err
+}
+
+// checkTables verifies that the SSTables in dir are exactly those state lists.
+// A previous state no longer matches once a compaction has deleted its inputs
+// or a flush has written a table, whose WAL is then gone.
+func checkTables(fs FS, dir string, state DBState) error {
+	listed := make(map[string]bool, len(state.ActiveSSTables))
+	for _, num := range state.ActiveSSTables {
+		name := fmt.Sprintf("%05d.sst", num)
+		if _, err := fs.Stat(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("SSTable %s is missing: %w", name, err)
+		}
+		listed[name] = true
+	}
+	files, err := vfs.Glob(fs, dir, "*.sst")
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if !listed[filepath.Base(path)] {
+			return fmt.Errorf("SSTable %s is not listed", filepath.Base(path))
+		}
+	}
+	return nil
+}
+
+// checkStateFiles verifies on open that every SSTable state lists exists and
+// is large enough to hold a footer, so that a lost table is reported right
+// away rather than by the first read that needs it. It raises
+// state.NextFileNumber past every table and WAL number in dir, so new files
+// never reuse the name of a file the state does not know about.
+func checkStateFiles(fs FS, dir string, state *DBState) error {
+	for _, num := range state.ActiveSSTables {
+		path := filepath.Join(dir, fmt.Sprintf("%05d.sst", num))
+		info, err := fs.Stat(path)
+		if err != nil {
+			return fmt.Errorf("SSTable %s listed in %s is missing: %w", path, stateFile, err)
+		}
+		if !info.Mode().IsRegular() || info.Size() <= 4 {
+			return fmt.Errorf("SSTable %s listed in %s is truncated to %d bytes", path, stateFile, info.Size())
+		}
+	}
+
+	names, err := vfs.Glob(fs, dir, "*")
+	if err != nil {
+		return err
+	}
+	highest := 0
+	for _, path := range names {
+		var num int
+		if n, ok := walFileNumber(path); ok {
+			num = n
+		} else if _, err := fmt.Sscanf(filepath.Base(path), "%d.sst", &num); err != nil {
+			continue
+		}
+		highest = max(highest, num)
+	}
+	if highest >= state.NextFileNumber {
+		log.Printf("WARNING: File number %d is in use, raising the next file number from %d", highest, state.NextFileNumber)
+		state.NextFileNumber = highest + 1
+	}
+	return nil
+}
+
+// orphanTables removes the temporary files a crash left behind in dir before
+// their rename, and returns the numbers of the SSTables that state does not
+// list: output of a flush or compaction whose state was never saved, or
+// obsolete tables kept for readers until the database was closed.
+func orphanTables(fs FS, dir string, state DBState) ([]int, error) {
+	tmpPaths, err := vfs.Glob(fs, dir, "*.sst.tmp")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range tmpPaths {
+		if err := fs.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove incomplete SSTable: %w", err)
+		}
+		log.Printf("Deleted incomplete SSTable %s", path)
+	}
+
+	listed := make(map[int]bool, len(state.ActiveSSTables))
+	for _, num := range state.ActiveSSTables {
+		listed[num] = true
+	}
+	paths, err := vfs.Glob(fs, dir, "*.sst")
+	if err != nil {
+		return nil, err
+	}
+	var orphans []int
+	for _, path := range paths {
+		var num int
+		if _, err := fmt.Sscanf(filepath.Base(path), "%d.sst", &num); err != nil || listed[num] {
+			continue
+		}
+		orphans = append(orphans, num)
+	}
+	return orphans, nil
+}
+
+func readState(fs FS, path string) (DBState, error) {
+	var state DBState
+	f, err := fs.Open(path)
+	if err != nil {
+		return state, err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, &CorruptionError{File: path, Err: err}
+	}
+	if state.Format == 0 && state.CRC == 0 {
+		return state, nil // Written before checksums existed
+	}
+	crc, err := state.checksum()
+	if err != nil {
+		return state, err
+	}
+	if crc != state.CRC {
+		return state, &CorruptionError{File: path, Err: errors.New("checksum mismatch")}
+	}
+	return state, nil
+}
+
+// writeFileSync writes data to path and fsyncs it before returning.
+func writeFileSync(fs FS, path string, data []byte) error {
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
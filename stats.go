@@ -0,0 +1,299 @@
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// EstimateNumKeys returns an approximate number of live keys without scanning
+// the database. Every tombstone is assumed to delete one older entry, and
+// overwritten keys and secondary index entries are counted, so the estimate
+// can be off in either direction.
+func (db *DB) EstimateNumKeys() uint64 {
+	db.mu.RLock()
+	mems := []*Memtable{db.mem}
+	if db.immutableMem != nil {
+		mems = append(mems, db.immutableMem)
+	}
+	version := db.current
+	version.ref()
+	db.mu.RUnlock()
+	defer version.unref()
+
+	var entries, deletions uint64
+	for _, mem := range mems {
+		n, d := mem.Counts()
+		entries += uint64(n)
+		deletions += uint64(d)
+	}
+	for _, num := range version.tables {
+		reader, err := db.findTable(num)
+		if err != nil {
+			continue
+		}
+		props := reader.Properties()
+		reader.Unref()
+		entries += props.NumEntries
+		deletions += props.NumDeletions
+	}
+	if entries < 2*deletions {
+		return 0
+	}
+	return entries - 2*deletions
+}
+
+// EstimatePendingCompactionBytes returns an estimate of the bytes compaction
+// still has to rewrite. All tables share one level, so every table overlaps
+// the older ones and is eventually merged with them: once there is more than
+// one table, or one sorted run with Options.TargetFileSize, all of them count.
+// With Options.TimeWindow only tables that share their window with a neighbor
+// count. Applications can poll the estimate, or
+// set Options.OnCompactionPressure, to shed load while compaction catches up.
+func (db *DB) EstimatePendingCompactionBytes() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.pendingCompactionBytesLocked()
+}
+
+// pendingCompactionBytesLocked implements EstimatePendingCompactionBytes. The
+// caller must hold db.mu, at least for reading.
+func (db *DB) pendingCompactionBytesLocked() int64 {
+	tables := db.current.tables
+	size := func(num int) int64 {
+		return fileSize(db.opts.FS, tablePath(db.dataDir, num))
+	}
+	var pending int64
+	if db.opts.TimeWindow == nil {
+		if db.sortedRunsLocked() > 1 {
+			for _, num := range tables {
+				pending += size(num)
+			}
+		}
+		return pending
+	}
+	for i := 0; i < len(tables); {
+		window, _ := db.tableWindowLocked(tables[i])
+		j := i + 1
+		for j < len(tables) {
+			w, _ := db.tableWindowLocked(tables[j])
+			if !w.Equal(window) {
+				break
+			}
+			j++
+		}
+		if j-i > 1 {
+			for _, num := range tables[i:j] {
+				pending += size(num)
+			}
+		}
+		i = j
+	}
+	return pending
+}
+
+// reportCompactionPressureLocked calls Options.OnCompactionPressure in the
+// background if the pending compaction bytes crossed
+// Options.PendingCompactionBytesLimit. It runs after every new Version. The
+// caller must hold db.mu.
+func (db *DB) reportCompactionPressureLocked() {
+	if db.opts.OnCompactionPressure == nil {
+		return
+	}
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+		// pressureMu orders the reports of Versions installed in quick succession.
+		db.pressureMu.Lock()
+		defer db.pressureMu.Unlock()
+		pending := db.EstimatePendingCompactionBytes()
+		over := pending > db.opts.PendingCompactionBytesLimit
+		if over != db.pressured {
+			db.pressured = over
+			db.opts.OnCompactionPressure(over, pending)
+		}
+	}()
+}
+
+// dbStats accumulates the work done by background flushes and compactions.
+type dbStats struct {
+	flushes                int
+	flushBytes             int64
+	flushTime              time.Duration
+	compactions            int
+	compactionBytesRead    int64
+	compactionBytesWritten int64
+	compactionTime         time.Duration
+	trivialMoves           int // Tables compaction kept instead of rewriting
+	priorityCompactions    int // Compactions of tables marked by isDenseLocked
+	quarantined            int // Corrupt tables moved to lost/
+}
+
+// ampCounters accumulate the bytes behind the amplification factors.
+type ampCounters struct {
+	userBytesWritten atomic.Int64 // Keys and values of the batches written
+	userBytesRead    atomic.Int64 // Keys and values returned by lookups and iterators
+}
+
+// AmplificationStats compares the bytes the application wrote and read since
+// the database was opened with the bytes the database moved to and from disk.
+type AmplificationStats struct {
+	UserBytesWritten       int64
+	WALBytesWritten        int64
+	FlushBytesWritten      int64
+	CompactionBytesWritten int64
+
+	UserBytesRead int64
+	// Bytes read from SSTables by lookups and iterators. Reads served by the
+	// block cache are free; compaction reads are not included.
+	DiskBytesRead       int64
+	CompactionBytesRead int64
+}
+
+// WriteAmplification returns the bytes written to disk, to the WAL and by
+// flushes and compactions, per byte written by the application.
+func (s AmplificationStats) WriteAmplification() float64 {
+	if s.UserBytesWritten == 0 {
+		return 0
+	}
+	return float64(s.WALBytesWritten+s.FlushBytesWritten+s.CompactionBytesWritten) / float64(s.UserBytesWritten)
+}
+
+// ReadAmplification returns the bytes read from disk per byte returned to the
+// application.
+func (s AmplificationStats) ReadAmplification() float64 {
+	if s.UserBytesRead == 0 {
+		return 0
+	}
+	return float64(s.DiskBytesRead) / float64(s.UserBytesRead)
+}
+
+// Amplification returns the write and read amplification counters. They are
+// the numbers to compare when evaluating compaction or caching changes.
+func (db *DB) Amplification() AmplificationStats {
+	db.mu.RLock()
+	stats := db.stats
+	db.mu.RUnlock()
+	return AmplificationStats{
+		UserBytesWritten:       db.amp.userBytesWritten.Load(),
+		WALBytesWritten:        db.ioTotals.WALBytesWritten.Load(),
+		FlushBytesWritten:      stats.flushBytes,
+		CompactionBytesWritten: stats.compactionBytesWritten,
+		UserBytesRead:          db.amp.userBytesRead.Load(),
+		DiskBytesRead:          db.ioTotals.DiskBytesRead.Load(),
+		CompactionBytesRead:    stats.compactionBytesRead,
+	}
+}
+
+// FilterStats counts the lookups answered by the filters of all tables since
+// the database was opened, to check the configured false positive rate
+// against the measured one.
+type FilterStats struct {
+	Checks         int64 // Table lookups that tested a filter
+	Negatives      int64 // Keys a filter ruled out
+	FalsePositives int64 // Keys a filter let through to a table without them
+
+	// BitsPerKey is the size of the bloom filter of the latest new table under
+	// Options.AdaptiveFilter, 0 without it.
+	BitsPerKey int
+}
+
+// FalsePositiveRate returns the share of the lookups of keys missing from a
+// table that its filter let through, the rate Options.FilterFPRate targets.
+func (s FilterStats) FalsePositiveRate() float64 {
+	if s.Negatives+s.FalsePositives == 0 {
+		return 0
+	}
+	return float64(s.FalsePositives) / float64(s.Negatives+s.FalsePositives)
+}
+
+// FilterStats returns the filter counters of the database. FileIOStats has
+// them per table.
+func (db *DB) FilterStats() FilterStats {
+	return FilterStats{
+		Checks:         db.ioTotals.Filter.Checks.Load(),
+		Negatives:      db.ioTotals.Filter.Negatives.Load(),
+		FalsePositives: db.ioTotals.Filter.FalsePositives.Load(),
+		BitsPerKey:     int(db.filterBits.Load()),
+	}
+}
+
+// GetProperty returns the value of a named database property. Supported names:
+//
+//	leveldb.stats - a report of file counts, sizes, amplification and
+//	                cumulative flush and compaction work
+//	leveldb.estimate-pending-compaction-bytes - see EstimatePendingCompactionBytes
+//	leveldb.file-io-stats - a report of FileIOStats, busiest files first
+func (db *DB) GetProperty(name string) (string, bool) {
+	switch name {
+	case "leveldb.stats":
+		return db.statsReport(), true
+	case "leveldb.estimate-pending-compaction-bytes":
+		return fmt.Sprint(db.EstimatePendingCompactionBytes()), true
+	case "leveldb.file-io-stats":
+		return db.fileIOReport(), true
+	}
+	return "", false
+}
+
+func (db *DB) statsReport() string {
+	db.mu.RLock()
+	version := db.current
+	version.ref()
+	stats := db.stats
+	db.mu.RUnlock()
+	defer version.unref()
+
+	var size int64
+	for _, num := range version.tables {
+		size += fileSize(db.opts.FS, tablePath(db.dataDir, num))
+	}
+	const mb = 1024 * 1024
+	var b strings.Builder
+	// All SSTables live in a single level; compaction merges within it.
+	fmt.Fprintf(&b, "                               Compactions\n")
+	fmt.Fprintf(&b, "Level  Files Size(MB) Time(sec) Read(MB) Write(MB)\n")
+	fmt.Fprintf(&b, "--------------------------------------------------\n")
+	fmt.Fprintf(&b, "%5d %6d %8.1f %9.1f %8.1f %9.1f\n", 0, len(version.tables), float64(size)/mb,
+		stats.compactionTime.Seconds(), float64(stats.compactionBytesRead)/mb, float64(stats.compactionBytesWritten)/mb)
+	fmt.Fprintf(&b, "Flushes: %d, %.1f MB written in %.1f sec (%.1f MB/s)\n", stats.flushes,
+		float64(stats.flushBytes)/mb, stats.flushTime.Seconds(), rate(stats.flushBytes, stats.flushTime))
+	fmt.Fprintf(&b, "Compactions: %d, %.1f MB read, %.1f MB written in %.1f sec (%.1f MB/s)\n", stats.compactions,
+		float64(stats.compactionBytesRead)/mb, float64(stats.compactionBytesWritten)/mb,
+		stats.compactionTime.Seconds(), rate(stats.compactionBytesRead+stats.compactionBytesWritten, stats.compactionTime))
+	fmt.Fprintf(&b, "Trivial moves: %d tables kept in place instead of rewritten\n", stats.trivialMoves)
+	fmt.Fprintf(&b, "Priority compactions: %d of tables dominated by tombstones or obsolete versions\n", stats.priorityCompactions)
+	fmt.Fprintf(&b, "Quarantined tables: %d corrupt tables moved to %s/\n", stats.quarantined, lostDir)
+	writeAmp := 0.0
+	if stats.flushBytes > 0 {
+		writeAmp = float64(stats.flushBytes+stats.compactionBytesWritten) / float64(stats.flushBytes)
+	}
+	fmt.Fprintf(&b, "Write amplification: %.2f (flushed and compacted bytes per flushed byte)\n", writeAmp)
+	fmt.Fprintf(&b, "Read amplification: %d (tables a point lookup may search)\n", len(version.tables))
+	amp := db.Amplification()
+	fmt.Fprintf(&b, "User I/O: %.1f MB written, %.1f MB read\n", float64(amp.UserBytesWritten)/mb, float64(amp.UserBytesRead)/mb)
+	fmt.Fprintf(&b, "Write amplification factor: %.2f (WAL, flushed and compacted bytes per user byte written)\n", amp.WriteAmplification())
+	fmt.Fprintf(&b, "Read amplification factor: %.2f (bytes read from tables per user byte read)\n", amp.ReadAmplification())
+	filters := db.FilterStats()
+	fmt.Fprintf(&b, "Filters: %d checks, %d negatives, %d false positives (%.4f measured rate)\n", filters.Checks,
+		filters.Negatives, filters.FalsePositives, filters.FalsePositiveRate())
+	return b.String()
+}
+
+// rate returns bytes per duration in MB/s.
+func rate(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / (1024 * 1024) / d.Seconds()
+}
+
+// fileSize returns the size of the file at path, or 0 if it cannot be read.
+func fileSize(fs FS, path string) int64 {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,82 @@
+package leveldb
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// syncPolicyFS applies Options.SyncFailurePolicy to every fsync of the files
+// and directories of a database.
+type syncPolicyFS struct {
+	FS
+	opts *Options
+	db   atomic.Pointer[DB] // Switched to read-only on failure, nil during Open
+}
+
+func (fs *syncPolicyFS) Create(name string) (File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncPolicyFile{File: f, fs: fs}, nil
+}
+
+func (fs *syncPolicyFS) Append(name string) (File, error) {
+	f, err := fs.FS.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncPolicyFile{File: f, fs: fs}, nil
+}
+
+func (fs *syncPolicyFS) SyncDir(dir string) error {
+	return fs.sync(dir, func() error { return fs.FS.SyncDir(dir) })
+}
+
+// sync runs the fsync of name and handles its failure by the policy.
+func (fs *syncPolicyFS) sync(name string, sync func() error) error {
+	err := sync()
+	if err == nil {
+		return nil
+	}
+	switch fs.opts.SyncFailurePolicy {
+	case SyncFailurePanic:
+		panic(fmt.Sprintf("leveldb: fsync of %s failed: %v", name, err))
+	case SyncFailureRetry:
+		backoff := fs.opts.SyncRetryBackoff
+		for i := 0; i < fs.opts.SyncRetries && err != nil; i++ {
+			log.Printf("WARNING: fsync of %s failed, retrying in %v: %v", name, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+			err = sync()
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	err = fmt.Errorf("%w after a failed fsync of %s: %w", ErrReadOnly, name, err)
+	if db := fs.db.Load(); db != nil && db.readOnly.CompareAndSwap(nil, &err) {
+		log.Printf("CRITICAL ERROR: %v", err)
+	}
+	return err
+}
+
+// syncPolicyFile is a file opened for writing through a syncPolicyFS.
+type syncPolicyFile struct {
+	File
+	fs *syncPolicyFS
+}
+
+func (f *syncPolicyFile) Sync() error {
+	return f.fs.sync(f.Name(), f.File.Sync)
+}
+
+// readOnlyErr returns why the database is read-only, or nil.
+func (db *DB) readOnlyErr() error {
+	if err := db.readOnly.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
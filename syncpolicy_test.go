@@ -0,0 +1,123 @@
+package leveldb
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failSyncFS is a MemFS whose next failures fsyncs of files fail.
+type failSyncFS struct {
+	*MemFS
+	failures atomic.Int32
+}
+
+func (fs *failSyncFS) Create(name string) (File, error) {
+	f, err := fs.MemFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &failSyncFile{File: f, fs: fs}, nil
+}
+
+func (fs *failSyncFS) Append(name string) (File, error) {
+	f, err := fs.MemFS.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return &failSyncFile{File: f, fs: fs}, nil
+}
+
+type failSyncFile struct {
+	File
+	fs *failSyncFS
+}
+
+func (f *failSyncFile) Sync() error {
+	if f.fs.failures.Add(-1) >= 0 {
+		return errInjected
+	}
+	return f.File.Sync()
+}
+
+func TestSyncFailureReadOnly(t *testing.T) {
+	fs := &failSyncFS{MemFS: NewMemFS()}
+	db, err := Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	wo := WriteOptions{Sync: true}
+	if err := db.Put(wo, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	fs.failures.Store(1)
+	err = db.Put(wo, []byte("b"), []byte("2"))
+	if !errors.Is(err, ErrReadOnly) || !errors.Is(err, errInjected) {
+		t.Fatalf("Put with a failed fsync returned %v, want ErrReadOnly wrapping the fsync error", err)
+	}
+	if err := db.Put(wo, []byte("c"), []byte("3")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put after a failed fsync returned %v, want ErrReadOnly", err)
+	}
+	if err := db.Flush(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Flush after a failed fsync returned %v, want ErrReadOnly", err)
+	}
+	if err := db.CompactRange(nil, nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("CompactRange after a failed fsync returned %v, want ErrReadOnly", err)
+	}
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get after a failed fsync returned %q, %v", val, err)
+	}
+	db.Close()
+
+	db, err = Open("db", &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer db.Close()
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "1" {
+		t.Errorf("Get after reopen returned %q, %v", val, err)
+	}
+	if err := db.Put(wo, []byte("c"), []byte("3")); err != nil {
+		t.Errorf("Put after reopen failed: %v", err)
+	}
+}
+
+func TestSyncFailureRetry(t *testing.T) {
+	fs := &failSyncFS{MemFS: NewMemFS()}
+	opts := &Options{FS: fs, SyncFailurePolicy: SyncFailureRetry, SyncRetries: 3, SyncRetryBackoff: time.Microsecond}
+	db, err := Open("db", opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	wo := WriteOptions{Sync: true}
+
+	fs.failures.Store(3)
+	if err := db.Put(wo, []byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put with fsyncs failing fewer times than SyncRetries failed: %v", err)
+	}
+	fs.failures.Store(4)
+	if err := db.Put(wo, []byte("b"), []byte("2")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Put with fsyncs failing more often than SyncRetries returned %v, want ErrReadOnly", err)
+	}
+	if err := db.Put(wo, []byte("c"), []byte("3")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put after the retries ran out returned %v, want ErrReadOnly", err)
+	}
+}
+
+func TestSyncFailurePanic(t *testing.T) {
+	fs := &failSyncFS{MemFS: NewMemFS()}
+	db, err := Open("db", &Options{FS: fs, SyncFailurePolicy: SyncFailurePanic})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Put with a failed fsync did not panic")
+		}
+	}()
+	fs.failures.Store(1)
+	db.Put(WriteOptions{Sync: true}, []byte("a"), []byte("1"))
+}
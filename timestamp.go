@@ -0,0 +1,171 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// With Options.TimestampSize set, the last TimestampSize bytes of every key are
+// a timestamp, compared as an unsigned big-endian number. Keys are stored as
+//
+//	escape(key without timestamp) 0x00 0x01 ^timestamp
+//
+// where escape turns every 0x00 into 0x00 0xFF. Ordering the stored keys
+// bytewise then orders by the key without timestamp first, so "a" sorts before
+// "ab" whatever their timestamps, and puts newer timestamps first.
+
+// ErrTimestampSize is returned for a key shorter than Options.TimestampSize.
+var ErrTimestampSize = errors.New("key is shorter than the timestamp size")
+
+const timestampSeparator = "\x00\x01"
+
+// encodeTimestampedKey returns the stored form of key, which ends with a
+// timestamp of tsSize bytes.
+func encodeTimestampedKey(key []byte, tsSize int) []byte {
+	user, ts := key[:len(key)-tsSize], key[len(key)-tsSize:]
+	enc := escapeTimestampedKey(user)
+	enc = append(enc, timestampSeparator...)
+	for _, b := range ts {
+		enc = append(enc, ^b)
+	}
+	return enc
+}
+
+// escapeTimestampedKey returns the stored prefix shared by all versions of
+// user, a key without timestamp.
+func escapeTimestampedKey(user []byte) []byte {
+	enc := make([]byte, 0, len(user)+len(timestampSeparator)+8)
+	for _, b := range user {
+		enc = append(enc, b)
+		if b == 0 {
+			enc = append(enc, 0xFF)
+		}
+	}
+	return enc
+}
+
+// decodeTimestampedKey splits a stored key into the key without timestamp and
+// the timestamp.
+func decodeTimestampedKey(enc string) (user, ts []byte, err error) {
+	for i := 0; i < len(enc); i++ {
+		if enc[i] != 0 {
+			user = append(user, enc[i])
+			continue
+		}
+		if i+1 == len(enc) {
+			break
+		}
+		if enc[i+1] == 0xFF {
+			user = append(user, 0)
+			i++
+			continue
+		}
+		if enc[i:i+2] != timestampSeparator {
+			break
+		}
+		for _, b := range []byte(enc[i+2:]) {
+			ts = append(ts, ^b)
+		}
+		return user, ts, nil
+	}
+	return nil, nil, fmt.Errorf("malformed timestamped key %q", enc)
+}
+
+// userKeyOf returns the key an application wrote for the stored key enc.
+func (db *DB) userKeyOf(enc string) string {
+	if db.opts.TimestampSize == 0 {
+		return enc
+	}
+	user, ts, err := decodeTimestampedKey(enc)
+	if err != nil {
+		return enc
+	}
+	return string(user) + string(ts)
+}
+
+// withTimestamps rewrites the keys of the batch to their stored form. The
+// caller has checked that every key carries a timestamp.
+func (db *DB) withTimestamps(batch *WriteBatch) *WriteBatch {
+	if db.opts.TimestampSize == 0 {
+		return batch
+	}
+	encoded := &WriteBatch{entries: make([]batchEntry, len(batch.entries))}
+	for i, e := range batch.entries {
+		e.key = encodeTimestampedKey(e.key, db.opts.TimestampSize)
+		encoded.entries[i] = e
+	}
+	return encoded
+}
+
+// GetAtTimestamp returns the value of the newest version of key whose
+// timestamp is at most ts, where key has no timestamp and ts has
+// Options.TimestampSize bytes. A Delete hides the versions older than its
+// timestamp from reads at or after it.
+func (db *DB) GetAtTimestamp(key, ts []byte) ([]byte, bool) {
+	if db.opts.TimestampSize == 0 || len(ts) != db.opts.TimestampSize {
+		log.Printf("Error reading key %q: timestamp of %d bytes, want %d", key, len(ts), db.opts.TimestampSize)
+		return nil, false
+	}
+	seq := db.sequenceNum.Load()
+	it := db.newMergingIterator(seq, nil).(*mergingIterator)
+	defer it.Close()
+
+	// The raw iterator keeps tombstones, which end the search.
+	raw := it.raw
+	prefix := escapeTimestampedKey(key)
+	prefix = append(prefix, timestampSeparator...)
+	target := append(bytes.Clone(prefix), ts...)
+	for i := len(prefix); i < len(target); i++ {
+		target[i] = ^target[i]
+	}
+	for raw.Seek(target); raw.Valid(); raw.Next() {
+		ik := raw.Key()
+		if !strings.HasPrefix(ik.UserKey, string(prefix)) {
+			break
+		}
+		if ik.SeqNum > seq {
+			continue
+		}
+		if ik.Type == OpTypeDelete {
+			return nil, false
+		}
+		return bytes.Clone(raw.Value()), true
+	}
+	if err := raw.Error(); err != nil {
+		log.Printf("Error reading key %q: %v", key, err)
+	}
+	return nil, false
+}
+
+// timestampHistory drops the versions that no read at or after a horizon
+// timestamp can see: per key, every version older than the newest one below
+// the horizon. With dropTombstones, that newest version goes too if it is a
+// tombstone.
+type timestampHistory struct {
+	horizon  []byte
+	lastUser []byte
+	covered  bool // Whether lastUser already kept its version below the horizon
+}
+
+// keep reports whether compaction keeps the newest entry of the stored key.
+func (h *timestampHistory) keep(key InternalKey, dropTombstones bool) bool {
+	user, ts, err := decodeTimestampedKey(key.UserKey)
+	if err != nil {
+		return true
+	}
+	if !bytes.Equal(user, h.lastUser) {
+		h.lastUser = user
+		h.covered = false
+	}
+	if bytes.Compare(ts, h.horizon) >= 0 {
+		return true
+	}
+	if h.covered {
+		return false
+	}
+	h.covered = true
+	return key.Type != OpTypeDelete || !dropTombstones
+}
@@ -0,0 +1,99 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func testTS(t uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, t)
+}
+
+func withTS(key string, t uint64) []byte {
+	return append([]byte(key), testTS(t)...)
+}
+
+func TestTimestampedKeys(t *testing.T) {
+	dir := t.TempDir()
+	var horizon uint64
+	opts := &Options{TimestampSize: 8, DisableAutoCompactions: true, TimestampHorizon: func() []byte { return testTS(horizon) }}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	put := func(key string, version uint64, value string) {
+		if err := db.Put(WriteOptions{}, withTS(key, version), []byte(value)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	put("a", 1, "v1")
+	put("a", 3, "v3")
+	if err := db.Delete(WriteOptions{}, withTS("a", 5)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	put("a", 7, "v7")
+	put("ab", 1, "ab1")
+	put("a\x00", 1, "a01")
+	if err := db.Put(WriteOptions{}, []byte("short"), nil); !errors.Is(err, ErrTimestampSize) {
+		t.Errorf("Expected ErrTimestampSize for a key without timestamp, got %v", err)
+	}
+
+	check := func(stage string, want map[uint64]string) {
+		t.Helper()
+		for readTS, value := range want {
+			got, found := db.GetAtTimestamp([]byte("a"), testTS(readTS))
+			if found != (value != "") || string(got) != value {
+				t.Errorf("%s: GetAtTimestamp(a, %d) = %q, %v, want %q", stage, readTS, got, found, value)
+			}
+		}
+	}
+	reads := map[uint64]string{0: "", 1: "v1", 2: "v1", 4: "v3", 5: "", 6: "", 8: "v7"}
+	check("memtable", reads)
+	if val, err := db.Get(withTS("a", 3)); err != nil || string(val) != "v3" {
+		t.Errorf("Get of an exact version = %q, %v", val, err)
+	}
+
+	// Versions order by key, then newest timestamp first, even when one key
+	// is a prefix of another.
+	it := db.NewIterator()
+	var order []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		order = append(order, it.Key().UserKey)
+	}
+	it.Close()
+	want := []string{string(withTS("a", 7)), string(withTS("a", 3)), string(withTS("a", 1)),
+		string(withTS("a\x00", 1)), string(withTS("ab", 1))}
+	if len(order) != len(want) {
+		t.Fatalf("Iterated %d keys, want %d", len(order), len(want))
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Errorf("Key %d = %q, want %q", i, order[i], want[i])
+		}
+	}
+
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	check("table", reads)
+
+	// History before the horizon is trimmed down to what a read at the
+	// horizon sees.
+	horizon = 4
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	check("compacted", map[uint64]string{2: "", 4: "v3", 5: "", 8: "v7"})
+	db.Close()
+
+	if _, err := Open(dir, &Options{TimestampSize: 4}); err == nil {
+		t.Errorf("Expected reopening with another timestamp size to fail")
+	}
+	db, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	check("reopened", map[uint64]string{4: "v3", 8: "v7"})
+}
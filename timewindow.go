@@ -0,0 +1,68 @@
+package leveldb
+
+import (
+	"log"
+	"time"
+)
+
+// tableWindowLocked returns the start of the time window table num belongs to.
+// The caller must hold db.mu.
+func (db *DB) tableWindowLocked(num int) (time.Time, bool) {
+	reader, err := db.findTable(num)
+	if err != nil {
+		log.Printf("ERROR: Failed to open SSTable %d for time-windowed compaction: %v", num, err)
+		return time.Time{}, false
+	}
+	defer reader.Unref()
+	return time.Unix(0, reader.Properties().MaxTimestamp).Truncate(db.opts.TimeWindow.Window), true
+}
+
+// pickWindowRunLocked returns the oldest run of at least MinTables adjacent
+// tables that share a window, or nil. The caller must hold db.mu.
+func (db *DB) pickWindowRunLocked() []int {
+	var run []int
+	var runWindow time.Time
+	for _, num := range db.current.tables {
+		window, ok := db.tableWindowLocked(num)
+		if !ok {
+			return nil
+		}
+		if len(run) > 0 && !window.Equal(runWindow) {
+			if len(run) >= db.opts.TimeWindow.MinTables {
+				return run
+			}
+			run = nil
+		}
+		run = append(run, num)
+		runWindow = window
+	}
+	if len(run) >= db.opts.TimeWindow.MinTables {
+		return run
+	}
+	return nil
+}
+
+// dropExpiredWindowsLocked removes the tables of every window that ended more
+// than Retention ago from the current Version. The caller must hold db.mu.
+func (db *DB) dropExpiredWindowsLocked() {
+	tw := db.opts.TimeWindow
+	if tw.Retention <= 0 {
+		return
+	}
+	cutoff := db.opts.Now().Add(-tw.Retention)
+	var live []int
+	for _, num := range db.current.tables {
+		window, ok := db.tableWindowLocked(num)
+		if ok && !window.Add(tw.Window).After(cutoff) {
+			log.Printf("Dropping SSTable %d of expired window %v", num, window)
+			continue
+		}
+		live = append(live, num)
+	}
+	if len(live) == len(db.current.tables) {
+		return
+	}
+	if err := db.installVersionLocked(live, nil); err != nil {
+		log.Printf("ERROR: Failed to drop expired windows: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// timeKey builds a key that embeds ts as big-endian Unix seconds.
+func timeKey(ts time.Time, i int) []byte {
+	key := binary.BigEndian.AppendUint64(nil, uint64(ts.Unix()))
+	return binary.BigEndian.AppendUint32(key, uint32(i))
+}
+
+func TestTimeWindowCompaction(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{TimeWindow: &TimeWindowOptions{
+		Window: time.Hour,
+		Timestamp: func(key []byte) (time.Time, bool) {
+			if len(key) < 8 {
+				return time.Time{}, false
+			}
+			return time.Unix(int64(binary.BigEndian.Uint64(key)), 0), true
+		},
+		Retention: 24 * time.Hour,
+		MinTables: 3,
+	}})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	expired := time.Now().Add(-48 * time.Hour)
+	current := time.Now().Truncate(time.Hour)
+	put := func(ts time.Time, i int) {
+		if err := db.Put(WriteOptions{}, timeKey(ts, i), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	put(expired, 0)
+	for i := 0; i < 3; i++ {
+		put(current, i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		db.mu.RLock()
+		tables := len(db.current.tables)
+		db.mu.RUnlock()
+		if tables == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the current window to be compacted into 1 table, have %d", tables)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := db.Get(timeKey(expired, 0)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the expired window to be dropped")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.Get(timeKey(current, i)); err != nil {
+			t.Errorf("Key %d of the current window is missing", i)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package leveldb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Trace operations.
+const (
+	traceOpWrite = "write"
+	traceOpGet   = "get"
+)
+
+// TraceRecord is one line of a trace file. Values are not recorded, only their
+// sizes, so traces can be taken from production without copying its data.
+type TraceRecord struct {
+	Time       int64        `json:"ts"` // Unix time in nanoseconds
+	Op         string       `json:"op"`
+	Key        []byte       `json:"key,omitempty"`     // Key of a get
+	Entries    []TraceEntry `json:"entries,omitempty"` // Updates of a write
+	Sync       bool         `json:"sync,omitempty"`
+	DisableWAL bool         `json:"disable_wal,omitempty"`
+}
+
+// TraceEntry is one update of a traced write batch.
+type TraceEntry struct {
+	Delete    bool   `json:"delete,omitempty"`
+	Key       []byte `json:"key"`
+	ValueSize int    `json:"value_size,omitempty"`
+}
+
+// tracer appends TraceRecords to Options.TraceFile.
+type tracer struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+	err  error // First write error, after which tracing stops
+}
+
+func newTracer(path string) (*tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	return &tracer{file: file, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (t *tracer) record(rec *TraceRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	rec.Time = time.Now().UnixNano()
+	t.err = t.enc.Encode(rec)
+}
+
+// traceWrite records a write batch if tracing is enabled.
+func (db *DB) traceWrite(wo WriteOptions, batch *WriteBatch) {
+	if db.tracer == nil {
+		return
+	}
+	rec := &TraceRecord{Op: traceOpWrite, Sync: wo.Sync, DisableWAL: wo.DisableWAL}
+	rec.Entries = make([]TraceEntry, len(batch.entries))
+	for i, e := range batch.entries {
+		rec.Entries[i] = TraceEntry{Delete: e.op == OpTypeDelete, Key: e.key, ValueSize: len(e.value)}
+	}
+	db.tracer.record(rec)
+}
+
+// traceGet records a lookup if tracing is enabled.
+func (db *DB) traceGet(key []byte) {
+	if db.tracer != nil {
+		db.tracer.record(&TraceRecord{Op: traceOpGet, Key: key})
+	}
+}
+
+// close flushes the trace and closes the file.
+func (t *tracer) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.err
+	if ferr := t.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReplayStats summarizes a replayed trace.
+type ReplayStats struct {
+	Writes   int
+	Gets     int
+	Duration time.Duration
+}
+
+// ReplayTrace applies the operations of a trace to db in their original order.
+// Writes get values of the recorded sizes. With speed 1 the original gaps
+// between operations are kept, a higher speed shortens them by that factor, and
+// speed 0 replays as fast as possible. Operations are issued one at a time, so
+// a replay cannot run faster than the database serves them.
+func ReplayTrace(db *DB, r io.Reader, speed float64) (ReplayStats, error) {
+	var stats ReplayStats
+	if speed < 0 {
+		return stats, fmt.Errorf("invalid replay speed %v", speed)
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	start := time.Now()
+	var first int64
+	var value []byte
+	for n := 1; ; n++ {
+		var rec TraceRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("trace record %d: %w", n, err)
+		}
+		if n == 1 {
+			first = rec.Time
+		}
+		if speed > 0 {
+			due := time.Duration(float64(rec.Time-first) / speed)
+			if wait := due - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		switch rec.Op {
+		case traceOpWrite:
+			batch := NewWriteBatch()
+			for _, e := range rec.Entries {
+				if e.Delete {
+					batch.Delete(e.Key)
+					continue
+				}
+				if e.ValueSize > len(value) {
+					value = make([]byte, e.ValueSize)
+				}
+				batch.Put(e.Key, value[:e.ValueSize])
+			}
+			if err := db.Write(WriteOptions{Sync: rec.Sync, DisableWAL: rec.DisableWAL}, batch); err != nil {
+				return stats, fmt.Errorf("trace record %d: %w", n, err)
+			}
+			stats.Writes++
+		case traceOpGet:
+			db.Get(rec.Key)
+			stats.Gets++
+		default:
+			return stats, fmt.Errorf("trace record %d: unknown op %q", n, rec.Op)
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
@@ -0,0 +1,137 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTraceReplay(t *testing.T) {
+	tracePath := filepath.Join(t.TempDir(), "ops.trace")
+	db, err := Open(t.TempDir(), &Options{TraceFile: tracePath})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := db.Put(WriteOptions{Sync: i == 0}, generateKey(i), make([]byte, i)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	batch := NewWriteBatch()
+	batch.Delete(generateKey(3))
+	batch.Put(generateKey(30), []byte("value"))
+	if err := db.Write(WriteOptions{}, batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// A write that fails is not traced.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.PutContext(ctx, WriteOptions{}, generateKey(40), []byte("value")); err == nil {
+		t.Fatalf("Expected PutContext with a canceled context to fail")
+	}
+	time.Sleep(50 * time.Millisecond)
+	db.Get(generateKey(5))
+	db.Close()
+
+	file, err := os.Open(tracePath)
+	if err != nil {
+		t.Fatalf("Failed to open trace: %v", err)
+	}
+	defer file.Close()
+	replayed := openTestDB(t)
+	stats, err := ReplayTrace(replayed, file, 2)
+	if err != nil {
+		t.Fatalf("ReplayTrace failed: %v", err)
+	}
+	if stats.Writes != 21 || stats.Gets != 1 {
+		t.Errorf("Replayed %d writes and %d gets, want 21 and 1", stats.Writes, stats.Gets)
+	}
+	if stats.Duration < 20*time.Millisecond {
+		t.Errorf("Replay at double speed took %v, want the recorded gap halved", stats.Duration)
+	}
+
+	for i := 0; i < 20; i++ {
+		val, err := replayed.Get(generateKey(i))
+		if want := i != 3; (err == nil) != want || (err == nil && len(val) != i) {
+			t.Errorf("Key %d after replay: %d bytes, %v", i, len(val), err)
+		}
+	}
+	if val, err := replayed.Get(generateKey(30)); err != nil || len(val) != len("value") {
+		t.Errorf("Key 30 after replay: %q, %v", val, err)
+	}
+}
+
+func TestTraceReplayConcurrentWriters(t *testing.T) {
+	tracePath := filepath.Join(t.TempDir(), "ops.trace")
+	db, err := Open(t.TempDir(), &Options{TraceFile: tracePath, WALSyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	// Writers waiting for the same WAL sync wake up together. Every write has
+	// its own value size, to match trace records to the sequence numbers the
+	// writes received.
+	key := []byte("shared")
+	var mu sync.Mutex
+	seqs := make(map[int]uint64)
+	var wg sync.WaitGroup
+	for w := 1; w <= 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				size := w*1000 + i
+				batch := NewWriteBatch()
+				batch.Put(key, make([]byte, size))
+				batch.OnCommit(func(r SeqRange) {
+					mu.Lock()
+					seqs[size] = r.First
+					mu.Unlock()
+				})
+				if err := db.Write(WriteOptions{Sync: true}, batch); err != nil {
+					t.Errorf("Write failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	want, err := db.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	db.Close()
+
+	data, err := os.ReadFile(tracePath)
+	if err != nil {
+		t.Fatalf("Failed to read trace: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var last uint64
+	for n := 0; dec.More(); n++ {
+		var rec TraceRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("Failed to decode trace record %d: %v", n, err)
+		}
+		if rec.Op != traceOpWrite {
+			continue
+		}
+		seq := seqs[rec.Entries[0].ValueSize]
+		if seq <= last {
+			t.Fatalf("Trace record %d has sequence number %d after %d", n, seq, last)
+		}
+		last = seq
+	}
+
+	replayed := openTestDB(t)
+	if _, err := ReplayTrace(replayed, bytes.NewReader(data), 0); err != nil {
+		t.Fatalf("ReplayTrace failed: %v", err)
+	}
+	if got, err := replayed.Get(key); err != nil || len(got) != len(want) {
+		t.Errorf("Key after replay has %d bytes (%v), want %d", len(got), err, len(want))
+	}
+}
@@ -0,0 +1,163 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/wal"
+	"slices"
+	"sort"
+)
+
+var (
+	// ErrUnknownXID is returned for a transaction id that is not prepared.
+	ErrUnknownXID = errors.New("no prepared transaction with this id")
+	// ErrXIDInUse is returned by Prepare for an id that is already prepared.
+	ErrXIDInUse = errors.New("transaction id is already prepared")
+)
+
+// txnMarker is a two-phase commit record read back from the WAL.
+type txnMarker struct {
+	op    byte
+	xid   string
+	batch *WriteBatch // Prepared batch of an OpPrepare record
+}
+
+// apply updates the prepared batches by id with the record.
+func (m *txnMarker) apply(prepared map[string]*WriteBatch) {
+	if m.op == OpPrepare {
+		prepared[m.xid] = m.batch
+	} else {
+		delete(prepared, m.xid)
+	}
+}
+
+// Prepare durably logs batch under the transaction id xid without applying
+// it, the first phase of a two-phase commit. The batch is applied by
+// CommitPrepared or discarded by Rollback, also after a crash: Open restores
+// the prepared batches, see PreparedTransactions.
+func (db *DB) Prepare(batch *WriteBatch, xid string) error {
+	if xid == "" {
+		return fmt.Errorf("%w: empty id", ErrUnknownXID)
+	}
+	if batch.Len() == 0 {
+		return errors.New("cannot prepare an empty batch")
+	}
+	if err := db.checkBatch(batch); err != nil {
+		return err
+	}
+	db.writeMu.Lock()
+	if _, ok := db.prepared[xid]; ok {
+		db.writeMu.Unlock()
+		return fmt.Errorf("%w: %q", ErrXIDInUse, xid)
+	}
+	prepared := &WriteBatch{entries: slices.Clone(batch.entries)}
+	sync, err := db.logTxnMarker(OpPrepare, xid, prepared)
+	if err == nil {
+		db.prepared[xid] = prepared
+	}
+	db.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return sync.Wait()
+}
+
+// CommitPrepared applies the batch prepared under xid atomically, like Write
+// with WriteOptions.Sync set.
+func (db *DB) CommitPrepared(xid string) error {
+	db.writeMu.Lock()
+	batch, ok := db.prepared[xid]
+	db.writeMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownXID, xid)
+	}
+	if err := db.lockWriteMu(context.Background(), batch, nil); err != nil {
+		return err
+	}
+	if db.prepared[xid] != batch {
+		// Committed or rolled back while waiting for a lock.
+		db.writeMu.Unlock()
+		return fmt.Errorf("%w: %q", ErrUnknownXID, xid)
+	}
+	batch.xid = xid
+	pending, err := db.writeLocked(WriteOptions{Sync: true}, batch)
+	batch.xid = ""
+	if err == nil {
+		delete(db.prepared, xid)
+	}
+	db.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return pending.wait()
+}
+
+// Rollback discards the batch prepared under xid.
+func (db *DB) Rollback(xid string) error {
+	db.writeMu.Lock()
+	if _, ok := db.prepared[xid]; !ok {
+		db.writeMu.Unlock()
+		return fmt.Errorf("%w: %q", ErrUnknownXID, xid)
+	}
+	sync, err := db.logTxnMarker(OpRollbackPrepared, xid, nil)
+	if err == nil {
+		delete(db.prepared, xid)
+	}
+	db.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return sync.Wait()
+}
+
+// PreparedTransactions returns the ids of the prepared transactions that are
+// neither committed nor rolled back, in sorted order. After a crash, the
+// coordinator resolves each with CommitPrepared or Rollback.
+func (db *DB) PreparedTransactions() []string {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	xids := make([]string, 0, len(db.prepared))
+	for xid := range db.prepared {
+		xids = append(xids, xid)
+	}
+	sort.Strings(xids)
+	return xids
+}
+
+// logTxnMarker appends a synced OpPrepare or OpRollbackPrepared record to the
+// WAL. The caller must hold db.writeMu.
+func (db *DB) logTxnMarker(op byte, xid string, batch *WriteBatch) (wal.PendingSync, error) {
+	if db.closed.Load() {
+		return wal.PendingSync{}, ErrClosed
+	}
+	if err := db.readOnlyErr(); err != nil {
+		return wal.PendingSync{}, err
+	}
+	entry := &LogEntry{Op: op, Key: []byte(xid)}
+	if batch != nil {
+		entry.Value = batch.encode()
+	}
+	db.mu.RLock()
+	w := db.wal
+	db.mu.RUnlock()
+	return w.Append(entry, true)
+}
+
+// relogPrepared logs the prepared batches again to wal, so they survive the
+// deletion of the logs they were first written to. The caller must hold
+// db.writeMu, unless the database is still being opened.
+func relogPrepared(wal *WAL, prepared map[string]*WriteBatch) error {
+	xids := make([]string, 0, len(prepared))
+	for xid := range prepared {
+		xids = append(xids, xid)
+	}
+	sort.Strings(xids)
+	for i, xid := range xids {
+		entry := &LogEntry{Op: OpPrepare, Key: []byte(xid), Value: prepared[xid].encode()}
+		if err := wal.Write(entry, i == len(xids)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
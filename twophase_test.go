@@ -0,0 +1,76 @@
+package leveldb
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTwoPhaseCommit(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	wo := WriteOptions{}
+	for _, xid := range []string{"commit", "rollback", "pending"} {
+		batch := NewWriteBatch()
+		batch.Put([]byte(xid), []byte("v"))
+		if err := db.Prepare(batch, xid); err != nil {
+			t.Fatalf("Prepare(%q) failed: %v", xid, err)
+		}
+	}
+	if err := db.Prepare(NewWriteBatch(), "commit"); err == nil {
+		t.Fatalf("Expected an empty batch to be rejected")
+	}
+	batch := NewWriteBatch()
+	batch.Put([]byte("k"), nil)
+	if err := db.Prepare(batch, "commit"); !errors.Is(err, ErrXIDInUse) {
+		t.Fatalf("Expected ErrXIDInUse, got %v", err)
+	}
+	if _, err := db.Get([]byte("commit")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("A prepared batch must not be visible, got %v", err)
+	}
+	// Prepared batches survive the deletion of the WAL they were logged to.
+	db.Put(wo, []byte("other"), []byte("v"))
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	if got := db.PreparedTransactions(); !slices.Equal(got, []string{"commit", "pending", "rollback"}) {
+		t.Fatalf("Expected the prepared transactions to be recovered, got %v", got)
+	}
+	if err := db.CommitPrepared("commit"); err != nil {
+		t.Fatalf("CommitPrepared failed: %v", err)
+	}
+	if err := db.Rollback("rollback"); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if err := db.CommitPrepared("rollback"); !errors.Is(err, ErrUnknownXID) {
+		t.Fatalf("Expected ErrUnknownXID, got %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	defer db.Close()
+	if got := db.PreparedTransactions(); !slices.Equal(got, []string{"pending"}) {
+		t.Fatalf("Expected only pending to remain prepared, got %v", got)
+	}
+	if val, err := db.Get([]byte("commit")); err != nil || string(val) != "v" {
+		t.Fatalf("Expected the committed batch after recovery, got %q, %v", val, err)
+	}
+	if _, err := db.Get([]byte("rollback")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected the rolled back batch to be discarded, got %v", err)
+	}
+	if len(db.RecoveryWarnings()) != 0 {
+		t.Fatalf("Two-phase commit records must not break the sequence, got %v", db.RecoveryWarnings())
+	}
+}
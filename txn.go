@@ -0,0 +1,139 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrTxnConflict is returned by Commit when a key read by the transaction was
+	// modified by another writer after the transaction began.
+	ErrTxnConflict = errors.New("transaction conflict: read key was modified concurrently")
+	// ErrTxnDone is returned when a transaction is used after Commit or Rollback.
+	ErrTxnDone = errors.New("transaction has already been committed or rolled back")
+)
+
+// OptimisticTxn buffers writes in memory and tracks the keys it reads. Nothing is
+// locked while the transaction runs; instead Commit verifies that none of the keys
+// in the read set were written after the transaction began and then applies all
+// buffered writes as one atomic WriteBatch.
+type OptimisticTxn struct {
+	db       *DB
+	startSeq uint64
+	readSet  map[string]struct{}
+	writes   map[string]batchEntry // Latest buffered write for each key, for read-your-writes
+	batch    *WriteBatch
+	done     bool
+}
+
+// Begin starts a new optimistic transaction.
+func (db *DB) Begin() *OptimisticTxn {
+	return &OptimisticTxn{
+		db:       db,
+		startSeq: db.sequenceNum.Load(),
+		readSet:  make(map[string]struct{}),
+		writes:   make(map[string]batchEntry),
+		batch:    NewWriteBatch(),
+	}
+}
+
+// Get reads a key, observing the transaction's own uncommitted writes first.
+// Keys read from the database are added to the read set validated by Commit.
+func (t *OptimisticTxn) Get(key []byte) ([]byte, bool, error) {
+	if t.done {
+		return nil, false, ErrTxnDone
+	}
+	if e, ok := t.writes[string(key)]; ok {
+		if e.op == OpTypeDelete {
+			return nil, false, nil
+		}
+		return e.value, true, nil
+	}
+	t.readSet[string(key)] = struct{}{}
+	val, err := t.db.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	return val, err == nil, err
+}
+
+// GetForUpdate is like Get but always adds key to the read set, even when the
+// transaction has written it, so Commit fails with ErrTxnConflict if another
+// writer changes key after Begin.
+func (t *OptimisticTxn) GetForUpdate(key []byte) ([]byte, bool, error) {
+	if t.done {
+		return nil, false, ErrTxnDone
+	}
+	t.readSet[string(key)] = struct{}{}
+	return t.Get(key)
+}
+
+// Put buffers a key-value pair to be written on Commit.
+func (t *OptimisticTxn) Put(key, value []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.batch.Put(key, value)
+	t.writes[string(key)] = batchEntry{op: OpTypePut, key: key, value: value}
+	return nil
+}
+
+// Delete buffers the removal of key to be applied on Commit.
+func (t *OptimisticTxn) Delete(key []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.batch.Delete(key)
+	t.writes[string(key)] = batchEntry{op: OpTypeDelete, key: key}
+	return nil
+}
+
+// Commit validates the read set and, if no conflicting write happened since Begin,
+// applies the buffered writes atomically. On ErrTxnConflict nothing is written and
+// the caller may retry with a new transaction.
+func (t *OptimisticTxn) Commit(wo WriteOptions) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	if err := t.db.checkBatch(t.batch); err != nil {
+		return err
+	}
+
+	db := t.db
+	if err := db.lockWriteMu(context.Background(), t.batch, nil); err != nil {
+		return err
+	}
+	pending, err := t.validateAndWrite(wo)
+	db.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return pending.wait()
+}
+
+// validateAndWrite checks the read set and applies the batch. The caller must
+// hold db.writeMu.
+func (t *OptimisticTxn) validateAndWrite(wo WriteOptions) (pendingWrite, error) {
+	t.db.waitForInserts()
+	for key := range t.readSet {
+		seq, err := t.db.latestSeq([]byte(key))
+		if err != nil {
+			return pendingWrite{}, err
+		}
+		if seq > t.startSeq {
+			return pendingWrite{}, ErrTxnConflict
+		}
+	}
+
+	if t.batch.Len() == 0 {
+		return pendingWrite{}, nil
+	}
+	return t.db.writeLocked(wo, t.batch)
+}
+
+// Rollback discards the transaction's buffered writes.
+func (t *OptimisticTxn) Rollback() {
+	t.done = true
+	t.batch.Reset()
+}
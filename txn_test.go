@@ -0,0 +1,119 @@
+package leveldb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOptimisticTxnConflict(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+	db.Put(wo, []byte("balance"), []byte("100"))
+
+	txn := db.Begin()
+	if val, _, _ := txn.Get([]byte("balance")); string(val) != "100" {
+		t.Fatalf("Expected balance 100, got %q", val)
+	}
+	txn.Put([]byte("balance"), []byte("50"))
+	if val, _, _ := txn.Get([]byte("balance")); string(val) != "50" {
+		t.Fatalf("Expected transaction to read its own write, got %q", val)
+	}
+
+	// A concurrent writer invalidates the read.
+	db.Put(wo, []byte("balance"), []byte("200"))
+	if err := txn.Commit(wo); !errors.Is(err, ErrTxnConflict) {
+		t.Fatalf("Expected ErrTxnConflict, got %v", err)
+	}
+	if val, _ := db.Get([]byte("balance")); string(val) != "200" {
+		t.Fatalf("Conflicting commit must not write, got %q", val)
+	}
+
+	txn = db.Begin()
+	txn.Get([]byte("balance"))
+	txn.Put([]byte("balance"), []byte("150"))
+	txn.Delete([]byte("other"))
+	if err := txn.Commit(wo); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if val, _ := db.Get([]byte("balance")); string(val) != "150" {
+		t.Fatalf("Expected balance 150, got %q", val)
+	}
+	if err := txn.Commit(wo); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("Expected ErrTxnDone, got %v", err)
+	}
+}
+
+func TestOptimisticTxnGetForUpdate(t *testing.T) {
+	db := openTestDB(t)
+	wo := WriteOptions{}
+
+	// Get of a key written by the transaction does not track it, so a
+	// concurrent write goes unnoticed.
+	txn := db.Begin()
+	txn.Put([]byte("k"), []byte("txn"))
+	txn.Get([]byte("k"))
+	db.Put(wo, []byte("k"), []byte("other"))
+	if err := txn.Commit(wo); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	txn = db.Begin()
+	txn.Put([]byte("k"), []byte("txn"))
+	if val, found, _ := txn.GetForUpdate([]byte("k")); !found || string(val) != "txn" {
+		t.Fatalf("Expected transaction to read its own write, got %q", val)
+	}
+	db.Put(wo, []byte("k"), []byte("other"))
+	if err := txn.Commit(wo); !errors.Is(err, ErrTxnConflict) {
+		t.Fatalf("Expected ErrTxnConflict, got %v", err)
+	}
+}
+
+func TestPessimisticTxnLocks(t *testing.T) {
+	db, err := Open(t.TempDir(), &Options{TxnLockTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+	wo := WriteOptions{}
+	db.Put(wo, []byte("balance"), []byte("100"))
+
+	txn := db.BeginPessimistic()
+	if val, _, err := txn.GetForUpdate([]byte("balance")); err != nil || string(val) != "100" {
+		t.Fatalf("Expected balance 100, got %q, %v", val, err)
+	}
+
+	// Another transaction cannot lock the key while txn holds it.
+	other := db.BeginPessimistic()
+	if _, _, err := other.GetForUpdate([]byte("balance")); !errors.Is(err, ErrTxnLockTimeout) {
+		t.Fatalf("Expected ErrTxnLockTimeout, got %v", err)
+	}
+	other.Rollback()
+	// Nor can a plain write, until the lock is released.
+	if err := db.Put(wo, []byte("balance"), []byte("0")); !errors.Is(err, ErrTxnLockTimeout) {
+		t.Fatalf("Expected ErrTxnLockTimeout, got %v", err)
+	}
+	if err := db.Put(wo, []byte("unlocked"), []byte("1")); err != nil {
+		t.Fatalf("Put of an unlocked key failed: %v", err)
+	}
+
+	// A blocked write goes through once txn commits.
+	put := make(chan error)
+	go func() { put <- db.Put(wo, []byte("balance"), []byte("200")) }()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case err := <-put:
+		t.Fatalf("Put of a locked key returned early: %v", err)
+	default:
+	}
+	txn.Put([]byte("balance"), []byte("50"))
+	if err := txn.Commit(wo); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := <-put; err != nil {
+		t.Fatalf("Put after commit failed: %v", err)
+	}
+	if val, _ := db.Get([]byte("balance")); string(val) != "200" {
+		t.Fatalf("Expected the later Put to win, got %q", val)
+	}
+}
@@ -0,0 +1,27 @@
+package leveldb
+
+import "github.com/quangh33/Go-LevelDB/typedkv"
+
+type typedBackend struct {
+	db *DB
+	wo WriteOptions
+}
+
+// TypedBackend adapts the DB to typedkv.Backend, applying wo to every write:
+//
+//	users := typedkv.New(db.TypedBackend(wo), typedkv.Uint64Key{}, typedkv.JSON[User]{})
+func (db *DB) TypedBackend(wo WriteOptions) typedkv.Backend {
+	return typedBackend{db: db, wo: wo}
+}
+
+func (b typedBackend) Get(key []byte) ([]byte, error) {
+	return b.db.Get(key)
+}
+
+func (b typedBackend) Put(key, value []byte) error {
+	return b.db.Put(b.wo, key, value)
+}
+
+func (b typedBackend) Delete(key []byte) error {
+	return b.db.Delete(b.wo, key)
+}
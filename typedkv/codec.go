@@ -0,0 +1,136 @@
+package typedkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"math"
+)
+
+// KeyCodec converts typed keys to bytes. Encodings must preserve ordering, so
+// that iterating the underlying store visits keys in their natural order.
+type KeyCodec[K any] interface {
+	EncodeKey(K) []byte
+	DecodeKey([]byte) (K, error)
+}
+
+// ValueCodec converts typed values to and from bytes.
+type ValueCodec[V any] interface {
+	Marshal(V) ([]byte, error)
+	Unmarshal([]byte) (V, error)
+}
+
+// StringKey encodes string keys as their raw bytes.
+type StringKey struct{}
+
+func (StringKey) EncodeKey(k string) []byte { return []byte(k) }
+
+func (StringKey) DecodeKey(b []byte) (string, error) { return string(b), nil }
+
+// BytesKey stores byte slice keys unchanged.
+type BytesKey struct{}
+
+func (BytesKey) EncodeKey(k []byte) []byte { return k }
+
+func (BytesKey) DecodeKey(b []byte) ([]byte, error) { return b, nil }
+
+// Uint64Key encodes unsigned integers big-endian so byte order matches numeric order.
+type Uint64Key struct{}
+
+func (Uint64Key) EncodeKey(k uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, k)
+}
+
+func (Uint64Key) DecodeKey(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("typedkv: uint64 key must be 8 bytes, got %d", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// Int64Key encodes signed integers big-endian with the sign bit flipped, so that
+// negative numbers sort before positive ones.
+type Int64Key struct{}
+
+func (Int64Key) EncodeKey(k int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(k)^(1<<63))
+}
+
+func (Int64Key) DecodeKey(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("typedkv: int64 key must be 8 bytes, got %d", len(b))
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ (1 << 63)), nil
+}
+
+// Float64Key encodes floats so that byte order matches numeric order: positive
+// numbers get the sign bit set, negative numbers have every bit inverted.
+type Float64Key struct{}
+
+func (Float64Key) EncodeKey(k float64) []byte {
+	bits := math.Float64bits(k)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+func (Float64Key) DecodeKey(b []byte) (float64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("typedkv: float64 key must be 8 bytes, got %d", len(b))
+	}
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
+// JSON encodes values with encoding/json.
+type JSON[V any] struct{}
+
+func (JSON[V]) Marshal(v V) ([]byte, error) { return json.Marshal(v) }
+
+func (JSON[V]) Unmarshal(b []byte) (V, error) {
+	var v V
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// Gob encodes values with encoding/gob.
+type Gob[V any] struct{}
+
+func (Gob[V]) Marshal(v V) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (Gob[V]) Unmarshal(b []byte) (V, error) {
+	var v V
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// Proto encodes protobuf messages. New allocates an empty message to decode into,
+// e.g. Proto[*pb.User]{New: func() *pb.User { return new(pb.User) }}.
+type Proto[V proto.Message] struct {
+	New func() V
+}
+
+func (Proto[V]) Marshal(v V) ([]byte, error) { return proto.Marshal(v) }
+
+func (c Proto[V]) Unmarshal(b []byte) (V, error) {
+	v := c.New()
+	err := proto.Unmarshal(b, v)
+	return v, err
+}
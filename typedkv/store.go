@@ -0,0 +1,65 @@
+// Package typedkv layers typed keys and values over the byte-oriented database
+// API, so applications don't have to marshal around every Get and Put.
+package typedkv
+
+import "fmt"
+
+// Backend is the byte-level store a Store is built on. Get reports a missing
+// key with the not-found error of the store, such as leveldb.ErrNotFound.
+type Backend interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// Store maps keys of type K to values of type V.
+type Store[K, V any] struct {
+	backend Backend
+	keys    KeyCodec[K]
+	values  ValueCodec[V]
+	prefix  []byte
+}
+
+// New creates a Store over backend using the given codecs.
+func New[K, V any](backend Backend, keys KeyCodec[K], values ValueCodec[V]) *Store[K, V] {
+	return &Store[K, V]{backend: backend, keys: keys, values: values}
+}
+
+// WithPrefix returns a Store whose keys are all stored under prefix, letting
+// several typed stores share one database without colliding.
+func (s *Store[K, V]) WithPrefix(prefix []byte) *Store[K, V] {
+	p := append(append([]byte(nil), s.prefix...), prefix...)
+	return &Store[K, V]{backend: s.backend, keys: s.keys, values: s.values, prefix: p}
+}
+
+func (s *Store[K, V]) encodeKey(key K) []byte {
+	return append(append([]byte(nil), s.prefix...), s.keys.EncodeKey(key)...)
+}
+
+// Get returns the value stored for key. A missing key fails with the error of
+// the backend, leveldb.ErrNotFound for a database.
+func (s *Store[K, V]) Get(key K) (value V, err error) {
+	raw, err := s.backend.Get(s.encodeKey(key))
+	if err != nil {
+		return value, err
+	}
+	value, err = s.values.Unmarshal(raw)
+	if err != nil {
+		return value, fmt.Errorf("typedkv: decoding value: %w", err)
+	}
+	return value, nil
+}
+
+// Put stores value under key.
+func (s *Store[K, V]) Put(key K, value V) error {
+	raw, err := s.values.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("typedkv: encoding value: %w", err)
+	}
+	return s.backend.Put(s.encodeKey(key), raw)
+}
+
+// Delete removes key.
+func (s *Store[K, V]) Delete(key K) error {
+	return s.backend.Delete(s.encodeKey(key))
+}
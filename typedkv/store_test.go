@@ -0,0 +1,84 @@
+package typedkv
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"testing"
+)
+
+var errNotFound = errors.New("not found")
+
+type mapBackend map[string][]byte
+
+func (m mapBackend) Get(key []byte) ([]byte, error) {
+	v, ok := m[string(key)]
+	if !ok {
+		return nil, errNotFound
+	}
+	return v, nil
+}
+
+func (m mapBackend) Put(key, value []byte) error {
+	m[string(key)] = value
+	return nil
+}
+
+func (m mapBackend) Delete(key []byte) error {
+	delete(m, string(key))
+	return nil
+}
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	backend := mapBackend{}
+	users := New(backend, Uint64Key{}, JSON[user]{}).WithPrefix([]byte("users/"))
+
+	if err := users.Put(42, user{Name: "ada", Age: 36}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	got, err := users.Get(42)
+	if err != nil || got.Name != "ada" || got.Age != 36 {
+		t.Fatalf("Unexpected Get result: %+v err=%v", got, err)
+	}
+	if _, ok := backend["users/\x00\x00\x00\x00\x00\x00\x00\x2a"]; !ok {
+		t.Fatalf("Expected prefixed big-endian key, got %v", backend)
+	}
+	if err := users.Delete(42); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := users.Get(42); !errors.Is(err, errNotFound) {
+		t.Fatalf("Expected key to be deleted, got %v", err)
+	}
+}
+
+func TestKeyCodecsPreserveOrder(t *testing.T) {
+	ints := []int64{-1 << 62, -5, -1, 0, 1, 7, 1 << 62}
+	floats := []float64{-1e9, -2.5, -0.5, 0, 0.25, 3, 1e12}
+	var intKeys, floatKeys [][]byte
+	for _, i := range ints {
+		intKeys = append(intKeys, Int64Key{}.EncodeKey(i))
+	}
+	for _, f := range floats {
+		floatKeys = append(floatKeys, Float64Key{}.EncodeKey(f))
+	}
+	for name, keys := range map[string][][]byte{"int64": intKeys, "float64": floatKeys} {
+		if !sort.SliceIsSorted(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 }) {
+			t.Errorf("%s encoding does not preserve order", name)
+		}
+	}
+	for _, f := range floats {
+		if got, _ := (Float64Key{}).DecodeKey(Float64Key{}.EncodeKey(f)); got != f {
+			t.Errorf("float64 round trip: expected %v, got %v", f, got)
+		}
+	}
+	for _, i := range ints {
+		if got, _ := (Int64Key{}).DecodeKey(Int64Key{}.EncodeKey(i)); got != i {
+			t.Errorf("int64 round trip: expected %v, got %v", i, got)
+		}
+	}
+}
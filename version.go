@@ -0,0 +1,122 @@
+package leveldb
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// Version is an immutable list of the SSTables that make up the database at one
+// point in time. Flushes and compactions never modify a Version; they install a
+// new one. Readers hold a reference to the Version they started with, and every
+// live Version holds a reference on each of its files, so a file replaced by
+// compaction is only deleted once no Version lists it anymore.
+type Version struct {
+	db     *DB
+	tables []int            // SSTable file numbers, oldest first
+	seqs   map[int]SeqRange // Sequence numbers of the entries of each table
+	refs   atomic.Int32
+}
+
+// newVersion creates a Version owned by the caller and pins its files. The
+// sequence numbers of each table are taken from the first of known that has
+// them; a table missing from all may hold any sequence number so far.
+func (db *DB) newVersion(tables []int, known ...map[int]SeqRange) *Version {
+	v := &Version{db: db, tables: tables, seqs: make(map[int]SeqRange, len(tables))}
+	v.refs.Store(1)
+	for _, num := range tables {
+		seqs := SeqRange{Last: db.sequenceNum.Load()}
+		for _, m := range known {
+			if r, ok := m[num]; ok {
+				seqs = r
+				break
+			}
+		}
+		v.seqs[num] = seqs
+	}
+
+	db.fileMu.Lock()
+	defer db.fileMu.Unlock()
+	for _, num := range tables {
+		db.fileRefs[num]++
+	}
+	return v
+}
+
+// readTableSeqs reads the sequence numbers of the tables missing from known
+// from their properties. Tables written before the range was recorded, or that
+// cannot be read, may hold any sequence number up to last. It opens tables, so
+// the caller must not hold db.mu.
+func (db *DB) readTableSeqs(tables []int, last uint64, known map[int]SeqRange) map[int]SeqRange {
+	read := make(map[int]SeqRange)
+	for _, num := range tables {
+		if _, ok := known[num]; ok {
+			continue
+		}
+		read[num] = SeqRange{Last: last}
+		reader, err := db.findTable(num)
+		if err != nil {
+			continue
+		}
+		props := reader.Properties()
+		reader.Unref()
+		if props.NumEntries == 0 || props.LargestSeq > 0 {
+			read[num] = SeqRange{First: props.SmallestSeq, Last: props.LargestSeq}
+		}
+	}
+	return read
+}
+
+// installVersionLocked makes tables the current set of live SSTables and
+// persists it. added holds the sequence numbers of the tables that are new. The
+// previous Version is only released after the state file stops listing its
+// files, so a crash can never leave state.json pointing at deleted tables. The
+// caller must hold db.mu.
+func (db *DB) installVersionLocked(tables []int, added map[int]SeqRange) error {
+	old := db.current
+	db.current = db.newVersion(tables, added, old.seqs)
+	db.liveTableBytes = db.tableBytes(tables)
+	for num := range db.denseTables {
+		if !slices.Contains(tables, num) {
+			delete(db.denseTables, num)
+		}
+	}
+	if err := db.saveState(); err != nil {
+		// Keep the previous files pinned, the state on disk may still list them.
+		return err
+	}
+	old.unref()
+	db.reportCompactionPressureLocked()
+	return nil
+}
+
+func (v *Version) ref() {
+	v.refs.Add(1)
+}
+
+// unref drops a reference. When the last reference is gone, the Version's files
+// are unpinned and any file no longer referenced by another Version is deleted.
+func (v *Version) unref() {
+	if v.refs.Add(-1) != 0 {
+		return
+	}
+
+	db := v.db
+	var obsolete []int
+	db.fileMu.Lock()
+	for _, num := range v.tables {
+		db.fileRefs[num]--
+		if db.fileRefs[num] == 0 {
+			delete(db.fileRefs, num)
+			delete(db.fileSeeks, num)
+			obsolete = append(obsolete, num)
+		}
+	}
+	db.fileMu.Unlock()
+
+	for _, num := range obsolete {
+		db.tableCache.Remove(num)
+	}
+	if len(obsolete) > 0 {
+		db.deleteObsoleteTables(obsolete)
+	}
+}
@@ -0,0 +1,20 @@
+package leveldb
+
+import "github.com/quangh33/Go-LevelDB/vfs"
+
+// FS is the filesystem a database keeps its files in. See vfs.FS.
+type FS = vfs.FS
+
+// File is an open file of an FS.
+type File = vfs.File
+
+// OSFS is the FS of the operating system, the default.
+type OSFS = vfs.OSFS
+
+// MemFS is an FS that keeps files in memory. See vfs.MemFS.
+type MemFS = vfs.MemFS
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return vfs.NewMemFS()
+}
@@ -0,0 +1,454 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemFS is an FS that keeps files in memory, for tests and ephemeral
+// databases. A database reopened on the same MemFS finds its files again.
+// Names are cleaned with filepath.Clean; the current and root directories
+// always exist.
+type MemFS struct {
+	mu      sync.Mutex
+	files   map[string]*memNode
+	dirs    map[string]bool
+	locks   map[string]int      // Holders of each lock, -1 for Lock
+	strict  bool                // Tracks what a crash would leave, for CrashClone
+	durable map[string]*memNode // File names as of the last SyncDir, if strict
+}
+
+// memNode is the contents of a file, shared by all of its names and open
+// handles, like an inode.
+type memNode struct {
+	mu      sync.RWMutex
+	data    []byte
+	synced  []byte // Data as of the last Sync, if the MemFS is strict
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memNode), dirs: make(map[string]bool), locks: make(map[string]int)}
+}
+
+// NewStrictMemFS returns an empty MemFS that keeps track of what survives a
+// crash, for CrashClone. Every Sync copies the contents of the file.
+func NewStrictMemFS() *MemFS {
+	fs := NewMemFS()
+	fs.strict = true
+	fs.durable = make(map[string]*memNode)
+	return fs
+}
+
+// CrashClone returns the MemFS a crash would leave behind: the contents each
+// file had at its last Sync, under the names its directory had at the last
+// SyncDir. Directories survive once created. fs must be strict.
+func (fs *MemFS) CrashClone() *MemFS {
+	if !fs.strict {
+		panic("vfs: CrashClone of a MemFS that is not strict")
+	}
+	next := NewStrictMemFS()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for dir := range fs.dirs {
+		next.dirs[dir] = true
+	}
+	restored := make(map[*memNode]*memNode) // Hard links stay shared
+	for name, node := range fs.durable {
+		n, ok := restored[node]
+		if !ok {
+			node.mu.RLock()
+			n = &memNode{data: slices.Clone(node.synced), synced: slices.Clone(node.synced), modTime: node.modTime}
+			node.mu.RUnlock()
+			restored[node] = n
+		}
+		next.files[name] = n
+		next.durable[name] = n
+	}
+	return next
+}
+
+func memPathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// dirExistsLocked reports whether dir exists. The caller must hold fs.mu.
+func (fs *MemFS) dirExistsLocked(dir string) bool {
+	return fs.dirs[dir] || filepath.Dir(dir) == dir || dir == "."
+}
+
+func (fs *MemFS) Open(name string) (File, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node, ok := fs.files[name]
+	if !ok {
+		return nil, memPathError("open", name, os.ErrNotExist)
+	}
+	return &memFile{name: name, node: node, readable: true}, nil
+}
+
+func (fs *MemFS) Create(name string) (File, error) {
+	return fs.openForWrite("create", name, true)
+}
+
+func (fs *MemFS) Append(name string) (File, error) {
+	return fs.openForWrite("open", name, false)
+}
+
+// openForWrite opens name for writing, creating it if needed and truncating
+// it if truncate is set, or else appending to it.
+func (fs *MemFS) openForWrite(op, name string, truncate bool) (File, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.dirExistsLocked(filepath.Dir(name)) {
+		return nil, memPathError(op, name, os.ErrNotExist)
+	}
+	if fs.dirs[name] {
+		return nil, memPathError(op, name, os.ErrExist)
+	}
+	node, ok := fs.files[name]
+	if !ok {
+		node = &memNode{modTime: time.Now()}
+		fs.files[name] = node
+	} else if truncate {
+		node.mu.Lock()
+		node.data = node.data[:0]
+		node.modTime = time.Now()
+		node.mu.Unlock()
+	}
+	return &memFile{name: name, node: node, writable: true, append: !truncate, strict: fs.strict}, nil
+}
+
+func (fs *MemFS) Rename(oldname, newname string) error {
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node, ok := fs.files[oldname]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	if !fs.dirExistsLocked(filepath.Dir(newname)) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldname)
+	fs.files[newname] = node
+	return nil
+}
+
+func (fs *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; ok {
+		delete(fs.files, name)
+		return nil
+	}
+	if !fs.dirs[name] {
+		return memPathError("remove", name, os.ErrNotExist)
+	}
+	for path := range fs.files {
+		if filepath.Dir(path) == name {
+			return memPathError("remove", name, os.ErrExist)
+		}
+	}
+	for dir := range fs.dirs {
+		if filepath.Dir(dir) == name {
+			return memPathError("remove", name, os.ErrExist)
+		}
+	}
+	delete(fs.dirs, name)
+	return nil
+}
+
+func (fs *MemFS) Link(oldname, newname string) error {
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node, ok := fs.files[oldname]
+	if !ok || !fs.dirExistsLocked(filepath.Dir(newname)) {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	if _, ok := fs.files[newname]; ok || fs.dirs[newname] {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	fs.files[newname] = node
+	return nil
+}
+
+func (fs *MemFS) Mkdir(dir string) error {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[dir]; ok || fs.dirExistsLocked(dir) {
+		return memPathError("mkdir", dir, os.ErrExist)
+	}
+	if !fs.dirExistsLocked(filepath.Dir(dir)) {
+		return memPathError("mkdir", dir, os.ErrNotExist)
+	}
+	fs.dirs[dir] = true
+	return nil
+}
+
+func (fs *MemFS) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for ; !fs.dirExistsLocked(dir); dir = filepath.Dir(dir) {
+		if _, ok := fs.files[dir]; ok {
+			return memPathError("mkdir", dir, os.ErrExist)
+		}
+		fs.dirs[dir] = true
+	}
+	return nil
+}
+
+func (fs *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if node, ok := fs.files[name]; ok {
+		return node.info(name), nil
+	}
+	if fs.dirExistsLocked(name) {
+		return memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, memPathError("stat", name, os.ErrNotExist)
+}
+
+func (fs *MemFS) List(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.dirExistsLocked(dir) {
+		return nil, memPathError("open", dir, os.ErrNotExist)
+	}
+	var names []string
+	for path := range fs.files {
+		if filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	for path := range fs.dirs {
+		if filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *MemFS) Lock(name string) (io.Closer, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.locks[name] != 0 {
+		return nil, ErrLocked
+	}
+	fs.locks[name] = -1
+	return &memLock{fs: fs, name: name}, nil
+}
+
+func (fs *MemFS) LockShared(name string) (io.Closer, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.locks[name] < 0 {
+		return nil, ErrLocked
+	}
+	fs.locks[name]++
+	return &memLock{fs: fs, name: name, shared: true}, nil
+}
+
+// SyncDir does nothing unless the MemFS is strict, when it records the names
+// in dir for CrashClone.
+func (fs *MemFS) SyncDir(dir string) error {
+	if !fs.strict {
+		return nil
+	}
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for name := range fs.durable {
+		if filepath.Dir(name) == dir {
+			delete(fs.durable, name)
+		}
+	}
+	for name, node := range fs.files {
+		if filepath.Dir(name) == dir {
+			fs.durable[name] = node
+		}
+	}
+	return nil
+}
+
+type memLock struct {
+	fs     *MemFS
+	name   string
+	shared bool
+	once   sync.Once
+}
+
+func (l *memLock) Close() error {
+	l.once.Do(func() {
+		l.fs.mu.Lock()
+		defer l.fs.mu.Unlock()
+		if l.shared && l.fs.locks[l.name] > 1 {
+			l.fs.locks[l.name]--
+		} else {
+			delete(l.fs.locks, l.name)
+		}
+	})
+	return nil
+}
+
+func (n *memNode) info(name string) memFileInfo {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return memFileInfo{name: filepath.Base(name), size: int64(len(n.data)), modTime: n.modTime}
+}
+
+// memFile is an open handle of a MemFS file.
+type memFile struct {
+	name               string
+	node               *memNode
+	pos                int64
+	readable, writable bool
+	append             bool // Writes go to the end of the file
+	strict             bool // Sync records the contents for CrashClone
+	closed             bool
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, memPathError("read", f.name, os.ErrClosed)
+	}
+	if !f.readable {
+		return 0, memPathError("read", f.name, os.ErrPermission)
+	}
+	if off < 0 {
+		return 0, memPathError("read", f.name, os.ErrInvalid)
+	}
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, memPathError("write", f.name, os.ErrClosed)
+	}
+	if !f.writable {
+		return 0, memPathError("write", f.name, os.ErrPermission)
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if f.append {
+		f.pos = int64(len(f.node.data))
+	}
+	if gap := f.pos - int64(len(f.node.data)); gap > 0 {
+		f.node.data = append(f.node.data, make([]byte, gap)...)
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data[:f.pos], p...)
+	} else {
+		copy(f.node.data[f.pos:], p)
+	}
+	f.pos = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, memPathError("seek", f.name, os.ErrClosed)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		f.node.mu.RLock()
+		offset += int64(len(f.node.data))
+		f.node.mu.RUnlock()
+	}
+	if offset < 0 {
+		return 0, memPathError("seek", f.name, os.ErrInvalid)
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, memPathError("stat", f.name, os.ErrClosed)
+	}
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed {
+		return memPathError("sync", f.name, os.ErrClosed)
+	}
+	if f.strict {
+		f.node.mu.Lock()
+		f.node.synced = slices.Clone(f.node.data)
+		f.node.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return memPathError("close", f.name, os.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() any           { return nil }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
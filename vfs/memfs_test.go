@@ -0,0 +1,91 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, fs FS, name, data string, sync bool) {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatalf("Create %s failed: %v", name, err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatalf("Write %s failed: %v", name, err)
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			t.Fatalf("Sync %s failed: %v", name, err)
+		}
+	}
+}
+
+func readFile(t *testing.T, fs FS, name string) (string, bool) {
+	t.Helper()
+	f, err := fs.Open(name)
+	if os.IsNotExist(err) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatalf("Open %s failed: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("Read %s failed: %v", name, err)
+	}
+	return string(data), true
+}
+
+func TestMemFSCrashClone(t *testing.T) {
+	fs := NewStrictMemFS()
+	if err := fs.MkdirAll("db"); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	writeFile(t, fs, filepath.Join("db", "synced"), "durable", true)
+	writeFile(t, fs, filepath.Join("db", "removed"), "gone", true)
+	if err := fs.Link(filepath.Join("db", "synced"), filepath.Join("db", "link")); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if err := fs.SyncDir("db"); err != nil {
+		t.Fatalf("SyncDir failed: %v", err)
+	}
+
+	// Unsynced data, a name created and a name removed after the SyncDir are
+	// all lost.
+	f, err := fs.Append(filepath.Join("db", "synced"))
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	f.Write([]byte(" but not this"))
+	f.Close()
+	writeFile(t, fs, filepath.Join("db", "unnamed"), "synced data", true)
+	if err := fs.Remove(filepath.Join("db", "removed")); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	crashed := fs.CrashClone()
+	for name, want := range map[string]string{"synced": "durable", "link": "durable", "removed": "gone"} {
+		if got, ok := readFile(t, crashed, filepath.Join("db", name)); !ok || got != want {
+			t.Errorf("%s after crash = %q (exists %v), want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := readFile(t, crashed, filepath.Join("db", "unnamed")); ok {
+		t.Errorf("File created after the last SyncDir survived the crash")
+	}
+
+	// Hard links stay shared after the crash.
+	f, err = crashed.Append(filepath.Join("db", "link"))
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	f.Write([]byte("!"))
+	f.Close()
+	if got, _ := readFile(t, crashed, filepath.Join("db", "synced")); got != "durable!" {
+		t.Errorf("synced after writing its link = %q, want %q", got, "durable!")
+	}
+}
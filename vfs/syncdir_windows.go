@@ -0,0 +1,7 @@
+package vfs
+
+// syncDir does nothing on Windows, where directories cannot be fsynced: NTFS
+// journals renames and file creation with the metadata of the directory.
+func syncDir(dir string) error {
+	return nil
+}
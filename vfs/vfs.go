@@ -0,0 +1,160 @@
+// Package vfs is the filesystem interface that databases keep their files
+// behind, with the OS and in-memory implementations.
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gofrs/flock"
+)
+
+// ErrLocked is returned by FS.Lock and FS.LockShared for a lock that is held.
+var ErrLocked = errors.New("database is locked by another process")
+
+// FS is the filesystem a database keeps its files in. The engine reaches the
+// disk only through it, so a database can run on memory, inject faults in
+// tests or use other storage. Paths are built with filepath.
+type FS interface {
+	// Open opens a file for reading.
+	Open(name string) (File, error)
+	// Create creates or truncates a file for writing.
+	Create(name string) (File, error)
+	// Append opens a file for writing at its end, creating it if needed.
+	Append(name string) (File, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	// Link makes newname a second name of the file oldname.
+	Link(oldname, newname string) error
+	// Mkdir creates the directory dir, which must not exist yet.
+	Mkdir(dir string) error
+	// MkdirAll creates dir and any missing parents.
+	MkdirAll(dir string) error
+	Stat(name string) (os.FileInfo, error)
+	// List returns the names of the entries in dir, in sorted order.
+	List(dir string) ([]string, error)
+	// Lock takes an exclusive lock named name, failing with ErrLocked if
+	// another process or database holds it. Closing the result unlocks it.
+	Lock(name string) (io.Closer, error)
+	// LockShared takes a lock named name that others may hold at the same
+	// time with LockShared, failing with ErrLocked while it is held by Lock.
+	LockShared(name string) (io.Closer, error)
+	// SyncDir makes the creations, renames and removals in dir durable.
+	SyncDir(dir string) error
+}
+
+// File is an open file of an FS.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+// OSFS is the FS of the operating system, the default.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (OSFS) Create(name string) (File, error) {
+	return os.Create(name)
+}
+
+func (OSFS) Append(name string) (File, error) {
+	return os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+func (OSFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (OSFS) Mkdir(dir string) error {
+	return os.Mkdir(dir, 0755)
+}
+
+func (OSFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+func (OSFS) Lock(name string) (io.Closer, error) {
+	lock := flock.New(name)
+	locked, err := lock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, ErrLocked
+	}
+	return lock, nil
+}
+
+func (OSFS) LockShared(name string) (io.Closer, error) {
+	lock := flock.New(name)
+	locked, err := lock.TryRLock()
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, ErrLocked
+	}
+	return lock, nil
+}
+
+func (OSFS) SyncDir(dir string) error {
+	return syncDir(dir)
+}
+
+// Glob returns the paths of the files in dir whose name matches pattern, in
+// sorted order, like filepath.Glob.
+func Glob(fs FS, dir, pattern string) ([]string, error) {
+	names, err := fs.List(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var paths []string
+	for _, name := range names {
+		if ok, err := filepath.Match(pattern, name); err != nil {
+			return nil, err
+		} else if ok {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,100 @@
+package leveldb
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingFS is the OS filesystem that records the files opened through it,
+// and fails to create files with a name ending in failSuffix.
+type recordingFS struct {
+	OSFS
+	mu         sync.Mutex
+	opened     map[string]bool
+	failSuffix string
+}
+
+var errInjected = errors.New("injected failure")
+
+func (fs *recordingFS) record(name string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.opened[filepath.Base(name)] = true
+}
+
+func (fs *recordingFS) Open(name string) (File, error) {
+	fs.record(name)
+	return fs.OSFS.Open(name)
+}
+
+func (fs *recordingFS) Create(name string) (File, error) {
+	fs.record(name)
+	fs.mu.Lock()
+	fail := fs.failSuffix != "" && strings.HasSuffix(name, fs.failSuffix)
+	fs.mu.Unlock()
+	if fail {
+		return nil, errInjected
+	}
+	return fs.OSFS.Create(name)
+}
+
+func (fs *recordingFS) Append(name string) (File, error) {
+	fs.record(name)
+	return fs.OSFS.Append(name)
+}
+
+func (fs *recordingFS) Lock(name string) (io.Closer, error) {
+	fs.record(name)
+	return fs.OSFS.Lock(name)
+}
+
+func TestOptionsFS(t *testing.T) {
+	dir := t.TempDir()
+	fs := &recordingFS{opened: make(map[string]bool)}
+	db, err := Open(dir, &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	wo := WriteOptions{}
+	for i := 0; i < 2; i++ {
+		db.Put(wo, []byte("a"), []byte{byte(i)})
+		if err := db.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("CompactRange failed: %v", err)
+	}
+	db.Close()
+
+	for _, name := range []string{"LOCK", "db.wal", "00001.sst.tmp", "00003.sst.tmp", "state.json.tmp"} {
+		if !fs.opened[name] {
+			t.Errorf("%s was not opened through Options.FS, opened %v", name, fs.opened)
+		}
+	}
+
+	fs.opened = make(map[string]bool)
+	db, err = Open(dir, &Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer db.Close()
+	if val, err := db.Get([]byte("a")); err != nil || string(val) != "\x01" {
+		t.Fatalf("Get returned %q, %v", val, err)
+	}
+	if !fs.opened["state.json"] || !fs.opened["00003.sst"] {
+		t.Errorf("Reads did not go through Options.FS, opened %v", fs.opened)
+	}
+
+	fs.mu.Lock()
+	fs.failSuffix = ".sst.tmp"
+	fs.mu.Unlock()
+	db.Put(wo, []byte("b"), []byte("1"))
+	if err := db.Flush(); !errors.Is(err, errInjected) {
+		t.Errorf("Flush returned %v, want the error of the FS", err)
+	}
+}
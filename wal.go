@@ -1,103 +1,58 @@
-package main
+package leveldb
 
 import (
-	"bufio"
-	"encoding/binary"
-	"fmt"
-	"hash/crc32"
+	"github.com/quangh33/Go-LevelDB/wal"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
+	"time"
 )
 
 const (
-	OpPut byte = iota
-	OpDelete
+	OpPut    = wal.OpPut
+	OpDelete = wal.OpDelete
+	// OpBatch wraps an encoded WriteBatch. Its SeqNum is the sequence number
+	// of the first update in the batch.
+	OpBatch = wal.OpBatch
 )
 
-// LogEntry represents a single operation in the WAL.
-type LogEntry struct {
-	Op     byte
-	Key    []byte
-	Value  []byte
-	SeqNum uint64
-}
-
-type WAL struct {
-	file *os.File
-	mu   sync.Mutex
-	bw   *bufio.Writer
-}
-
-// NewWAL opens or creates a WAL file at the given path.
-func NewWAL(path string) (*WAL, error) {
-	// Open the file with flags for appending, creating if it doesn't exist, and writing.
-	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return nil, err
-	}
+// Two-phase commit records, see Prepare. Their key is the transaction id.
+const (
+	// OpPrepare logs a prepared batch, encoded like OpBatch, without sequence
+	// numbers.
+	OpPrepare = wal.OpPrepare
+	// OpCommitPrepared applies a prepared batch like OpBatch, which it
+	// repeats, so the log of the OpPrepare record may be gone.
+	OpCommitPrepared = wal.OpCommitPrepared
+	// OpRollbackPrepared discards a prepared batch.
+	OpRollbackPrepared = wal.OpRollbackPrepared
+)
 
-	return &WAL{
-		file: file,
-		bw:   bufio.NewWriter(file),
-	}, nil
+// LogEntry represents a single operation in the WAL.
+type LogEntry = wal.LogEntry
+
+// WAL is a write-ahead log file. See wal.WAL.
+type WAL = wal.WAL
+
+// NewWAL opens or creates a WAL file at the given path. A new file checksums
+// its records with checksum; an existing one keeps the type it was created
+// with. If syncInterval is positive, a background goroutine fsyncs the file on
+// that interval and synchronous writes wait for it instead of syncing on their
+// own.
+func NewWAL(path string, syncInterval time.Duration, checksum ChecksumType) (*WAL, error) {
+	return wal.Open(OSFS{}, path, wal.Options{SyncInterval: syncInterval, Checksum: checksum})
 }
 
-// Close closes the WAL file.
-func (w *WAL) Close() error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	return w.file.Close()
-}
-
-// Write atomically writes a single log entry to the WAL.
-// [Checksum (4 bytes)][Header][KV]
-// Header =  [Seq (8 byte)] [Key Size (4 bytes)] [Value Size (4 bytes)] [Operation (1 byte)]
-// KV     =  [Key] [Value]
-func (w *WAL) Write(entry *LogEntry, sync bool) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	keySize := len(entry.Key)
-	valueSize := len(entry.Value)
-
-	// Total size: seq (8) + key_size (4) + value_size (4) + op (1) + key + value
-	entrySize := 8 + 4 + 4 + 1 + keySize + valueSize
-	buf := make([]byte, entrySize)
-
-	// Encode the entry fields into the buffer
-	binary.LittleEndian.PutUint64(buf[0:8], entry.SeqNum)
-	binary.LittleEndian.PutUint32(buf[8:12], uint32(keySize))
-	binary.LittleEndian.PutUint32(buf[12:16], uint32(valueSize))
-	buf[16] = entry.Op
-	copy(buf[17:17+keySize], entry.Key)
-	copy(buf[17+keySize:], entry.Value)
-
-	// Calculate checksum over the encoded data
-	checksum := crc32.ChecksumIEEE(buf)
-
-	// 1. Write checksum to the buffered writer
-	if err := binary.Write(w.bw, binary.LittleEndian, checksum); err != nil {
-		return err
-	}
-
-	// 2. Write the rest of the entry data
-	if _, err := w.bw.Write(buf); err != nil {
-		return err
-	}
-
-	// 3. Flush the buffer to the underlying file
-	// a.k.a moving data from application buffer to OS buffer
-	if err := w.bw.Flush(); err != nil {
-		return err
-	}
-
-	if sync {
-		// 4. Fsync to guarantee the write to persistent storage
-		return w.file.Sync()
-	}
-	return nil
+// openWAL opens the WAL at path with the options of the database, counting
+// its I/O under its file name.
+func (db *DB) openWAL(path string) (*WAL, error) {
+	return wal.Open(db.opts.FS, path, wal.Options{
+		SyncInterval: db.opts.WALSyncInterval,
+		Checksum:     db.opts.Checksum,
+		IO:           db.fileIO(filepath.Base(path)),
+	})
 }
 
 type RecoveredValue struct {
@@ -108,64 +63,167 @@ type RecoveredValue struct {
 // Replay reads all entries from the WAL file at the given path and reconstructs
 // the in-memory state by replaying the operations.
 func Replay(path string) (map[InternalKey]RecoveredValue, uint64, error) {
-	// Open the file for reading only.
-	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	data, maxSeqNum, _, err := replayWAL(OSFS{}, path, nil)
+	return data, maxSeqNum, err
+}
+
+// seqRange is the range of sequence numbers of one WAL record.
+type seqRange struct {
+	first, last uint64
+}
+
+// replayWAL is Replay that also returns the sequence numbers of the records,
+// in log order. If prepared is not nil, the two-phase commit records update it
+// to the batches prepared and not yet committed or rolled back, by id.
+func replayWAL(fs FS, path string, prepared map[string]*WriteBatch) (map[InternalKey]RecoveredValue, uint64, []seqRange, error) {
+	file, err := fs.Open(path)
 	if err != nil {
 		// If the file doesn't exist, it means no data to recover.
 		if os.IsNotExist(err) {
-			return make(map[InternalKey]RecoveredValue), 0, nil
+			return make(map[InternalKey]RecoveredValue), 0, nil, nil
 		}
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 	defer file.Close()
 
-	data := make(map[InternalKey]RecoveredValue)
-	var maxSeqNum uint64 = 0
-	reader := bufio.NewReader(file)
+	reader, err := wal.NewReader(file)
+	if err != nil {
+		return nil, 0, nil, err
+	}
 
+	// Records are framed sequentially, but checksums are verified and batches
+	// decoded by a pool of workers, one chunk of records at a time.
+	type chunk struct {
+		records [][]byte // Raw records, verified by the workers
+		offsets []int64  // File offset of each record
+		entries []replayedEntry
+		ranges  []seqRange
+		maxSeq  uint64
+		err     error
+	}
+	var chunks []*chunk
+	work := make(chan *chunk)
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Go(func() {
+			for c := range work {
+				c.entries, c.ranges, c.maxSeq, c.err = decodeRecords(reader, path, c.records, c.offsets)
+				c.records, c.offsets = nil, nil
+			}
+		})
+	}
+
+	current := &chunk{}
+	var readErr error
 	for {
-		// [Checksum (4 bytes)][Header][KV]
-		// Header =  [Seq (8 byte)] [Key Size (4 bytes)] [Value Size (4 bytes)] [Operation (1 byte)]
-		// KV     =  [Key] [Value]
-		var storedChecksum uint32
-		err := binary.Read(reader, binary.LittleEndian, &storedChecksum)
+		record, offset, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, 0, err
+			readErr = err
+			break
 		}
+		current.records = append(current.records, record)
+		current.offsets = append(current.offsets, offset)
+		if len(current.records) == replayChunkSize {
+			chunks = append(chunks, current)
+			work <- current
+			current = &chunk{}
+		}
+	}
+	if len(current.records) > 0 {
+		chunks = append(chunks, current)
+		work <- current
+	}
+	close(work)
+	wg.Wait()
 
-		headerBuf := make([]byte, 8+4+4+1)
-		if _, err := io.ReadFull(reader, headerBuf); err != nil {
-			return nil, 0, fmt.Errorf("could not read header: %w", err)
+	// Apply the chunks in log order, so the first corrupt record is reported.
+	data := make(map[InternalKey]RecoveredValue)
+	var ranges []seqRange
+	var maxSeqNum uint64 = 0
+	for _, c := range chunks {
+		if c.err != nil {
+			return nil, 0, nil, c.err
+		}
+		for _, e := range c.entries {
+			if e.txn != nil {
+				if prepared != nil {
+					e.txn.apply(prepared)
+				}
+				continue
+			}
+			data[e.key] = RecoveredValue{Value: e.value, Type: e.key.Type}
 		}
+		ranges = append(ranges, c.ranges...)
+		maxSeqNum = max(maxSeqNum, c.maxSeq)
+	}
+	if readErr != nil {
+		return nil, 0, nil, readErr
+	}
+	return data, maxSeqNum, ranges, nil
+}
 
-		seqNum := binary.LittleEndian.Uint64(headerBuf[0:8])
-		keySize := binary.LittleEndian.Uint32(headerBuf[8:12])
-		valueSize := binary.LittleEndian.Uint32(headerBuf[12:16])
-		op := headerBuf[16]
+// replayChunkSize is the number of WAL records handed to a replay worker at once.
+const replayChunkSize = 1024
 
-		kvBuf := make([]byte, keySize+valueSize)
-		if _, err := io.ReadFull(reader, kvBuf); err != nil {
-			return nil, 0, fmt.Errorf("could not read key/value: %w", err)
-		}
+type replayedEntry struct {
+	key   InternalKey
+	value []byte
+	txn   *txnMarker // Set instead of key and value for two-phase commit records
+}
 
-		fullPayload := append(headerBuf, kvBuf...)
-		actualChecksum := crc32.ChecksumIEEE(fullPayload)
-		if storedChecksum != actualChecksum {
-			return nil, 0, fmt.Errorf("data corruption: checksum mismatch")
+// decodeRecords verifies and decodes raw records that reader read from the WAL
+// at path, at the given offsets. It also returns the sequence numbers of each
+// record.
+func decodeRecords(reader *wal.Reader, path string, records [][]byte, offsets []int64) ([]replayedEntry, []seqRange, uint64, error) {
+	var entries []replayedEntry
+	ranges := make([]seqRange, 0, len(records))
+	var maxSeqNum uint64
+	for i, record := range records {
+		entry, err := reader.Decode(record, offsets[i])
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		seqNum := entry.SeqNum
+		first := seqNum
+		op, key, value := entry.Op, entry.Key, entry.Value
+
+		switch op {
+		case OpPrepare, OpRollbackPrepared:
+			marker := &txnMarker{op: op, xid: string(key)}
+			if op == OpPrepare {
+				batch, err := decodeBatch(value)
+				if err != nil {
+					return nil, nil, 0, &CorruptionError{File: path, Offset: offsets[i], Err: err}
+				}
+				marker.batch = batch
+			}
+			entries = append(entries, replayedEntry{txn: marker})
+			continue // No sequence numbers
+		case OpBatch, OpCommitPrepared:
+			batch, err := decodeBatch(value)
+			if err != nil {
+				return nil, nil, 0, &CorruptionError{File: path, Offset: offsets[i], Err: err}
+			}
+			for i, e := range batch.entries {
+				internalKey := InternalKey{UserKey: string(e.key), SeqNum: seqNum + uint64(i), Type: e.op}
+				entries = append(entries, replayedEntry{key: internalKey, value: e.value})
+			}
+			seqNum += uint64(batch.Len()) - 1
+			if op == OpCommitPrepared {
+				entries = append(entries, replayedEntry{txn: &txnMarker{op: op, xid: string(key)}})
+			}
+		default:
+			internalKey := InternalKey{UserKey: string(key), SeqNum: seqNum, Type: op}
+			entries = append(entries, replayedEntry{key: internalKey, value: value})
 		}
 
+		ranges = append(ranges, seqRange{first: first, last: seqNum})
 		if seqNum > maxSeqNum {
 			maxSeqNum = seqNum
 		}
-		key := kvBuf[:keySize]
-		value := kvBuf[keySize:]
-
-		internalKey := InternalKey{UserKey: string(key), SeqNum: seqNum, Type: op}
-		data[internalKey] = RecoveredValue{Value: value, Type: op}
 	}
-
-	return data, maxSeqNum, nil
+	return entries, ranges, maxSeqNum, nil
 }
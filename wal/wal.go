@@ -0,0 +1,409 @@
+// Package wal writes and reads the write-ahead logs of a database: files of
+// checksummed records that are appended to before a write is applied, and
+// replayed on recovery.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	OpPut byte = iota
+	OpDelete
+	// OpBatch wraps an encoded WriteBatch. Its SeqNum is the sequence number
+	// of the first update in the batch.
+	OpBatch
+)
+
+// Two-phase commit records. Their key is the transaction id. They are numbered
+// apart from the OpTypes that single-update records use as their operation.
+const (
+	// OpPrepare logs a prepared batch, encoded like OpBatch, without sequence
+	// numbers.
+	OpPrepare byte = 0x10 + iota
+	// OpCommitPrepared applies a prepared batch like OpBatch, which it
+	// repeats, so the log of the OpPrepare record may be gone.
+	OpCommitPrepared
+	// OpRollbackPrepared discards a prepared batch.
+	OpRollbackPrepared
+)
+
+// LogEntry represents a single operation in the WAL.
+type LogEntry struct {
+	Op     byte
+	Key    []byte
+	Value  []byte
+	SeqNum uint64
+}
+
+// magic starts the header of every WAL file, followed by the ChecksumType of
+// its records. Files written before the header existed start directly with the
+// first record and use IEEE CRC32.
+const magic = "GLDBWAL"
+
+// HeaderSize is the size of the header at the start of a WAL file.
+const HeaderSize = len(magic) + 1
+
+// Header returns the header of a WAL file whose records use checksum.
+func Header(checksum base.ChecksumType) []byte {
+	return append([]byte(magic), byte(checksum))
+}
+
+// Options configure a WAL opened with Open.
+type Options struct {
+	// SyncInterval, if positive, makes a background goroutine fsync the file
+	// on that interval, and synchronous writes wait for it instead of syncing
+	// on their own.
+	SyncInterval time.Duration
+	// Checksum checksums the records of a new file. An existing one keeps the
+	// type it was created with.
+	Checksum base.ChecksumType
+	IO       *base.FileIO // Per-file I/O statistics, nil outside a database
+}
+
+type WAL struct {
+	file     vfs.File
+	mu       sync.Mutex
+	bw       *bufio.Writer
+	checksum base.ChecksumType
+	io       *base.FileIO // Per-file I/O statistics, nil outside a database
+
+	// Periodic sync state, only used when syncInterval > 0.
+	syncInterval time.Duration
+	syncCond     *sync.Cond // Broadcast on mu after every background sync
+	written      uint64     // Number of records written
+	synced       uint64     // Number of records known to be on stable storage
+	syncErr      error
+	done         chan struct{}
+	loopDone     chan struct{}
+}
+
+// Open opens or creates the WAL file at path on fs.
+func Open(fs vfs.FS, path string, opts Options) (*WAL, error) {
+	// Open the file for appending, creating it if it doesn't exist.
+	file, err := fs.Append(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	checksum := opts.Checksum
+	if info.Size() == 0 {
+		if _, err := file.Write(Header(checksum)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write WAL header: %w", err)
+		}
+		// A synced record must not be lost with the name of its file.
+		if err := fs.SyncDir(filepath.Dir(path)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	} else if checksum, err = readHeader(fs, path); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &WAL{
+		file:         file,
+		bw:           bufio.NewWriter(file),
+		checksum:     checksum,
+		io:           opts.IO,
+		syncInterval: opts.SyncInterval,
+	}
+	if w.syncInterval > 0 {
+		w.syncCond = sync.NewCond(&w.mu)
+		w.done = make(chan struct{})
+		w.loopDone = make(chan struct{})
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// Name returns the path the WAL was opened with.
+func (w *WAL) Name() string {
+	return w.file.Name()
+}
+
+// Close closes the WAL file. With periodic sync enabled, outstanding records
+// are synced first so that no waiting writer is left behind.
+func (w *WAL) Close() error {
+	if w.syncInterval > 0 {
+		close(w.done)
+		<-w.loopDone
+		w.syncPending()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// syncLoop fsyncs the WAL every syncInterval until the WAL is closed.
+func (w *WAL) syncLoop() {
+	defer close(w.loopDone)
+	ticker := time.NewTicker(w.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.syncPending()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// syncPending fsyncs the file if records were written since the last sync and
+// wakes up the writers waiting for them.
+func (w *WAL) syncPending() {
+	w.mu.Lock()
+	target := w.written
+	if target == w.synced {
+		w.mu.Unlock()
+		return
+	}
+	w.mu.Unlock()
+
+	// Appends may continue while the fsync is in progress; they are covered by
+	// the next sync.
+	err := w.file.Sync()
+
+	w.mu.Lock()
+	if err != nil {
+		w.syncErr = err
+	} else if target > w.synced {
+		w.synced = target
+	}
+	w.syncCond.Broadcast()
+	w.mu.Unlock()
+}
+
+// PendingSync is a pending wait for a record to reach stable storage.
+type PendingSync struct {
+	wal    *WAL
+	record uint64
+}
+
+// Wait blocks until the background sync covered the record. The zero
+// PendingSync returns immediately.
+func (s PendingSync) Wait() error {
+	w := s.wal
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.synced < s.record && w.syncErr == nil {
+		w.syncCond.Wait()
+	}
+	return w.syncErr
+}
+
+// Write atomically writes a single log entry to the WAL.
+// [Checksum (4 bytes)][Header][KV]
+// Header =  [Seq (8 byte)] [Key Size (4 bytes)] [Value Size (4 bytes)] [Operation (1 byte)]
+// KV     =  [Key] [Value]
+func (w *WAL) Write(entry *LogEntry, sync bool) error {
+	pending, err := w.Append(entry, sync)
+	if err != nil {
+		return err
+	}
+	return pending.Wait()
+}
+
+// Append writes the entry like Write, but with periodic sync enabled a sync
+// write returns a PendingSync to wait on instead of blocking, so that the
+// caller can release its own locks first.
+func (w *WAL) Append(entry *LogEntry, sync bool) (PendingSync, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	keySize := len(entry.Key)
+	valueSize := len(entry.Value)
+
+	// Total size: seq (8) + key_size (4) + value_size (4) + op (1) + key + value
+	entrySize := 8 + 4 + 4 + 1 + keySize + valueSize
+	buf := make([]byte, entrySize)
+
+	// Encode the entry fields into the buffer
+	binary.LittleEndian.PutUint64(buf[0:8], entry.SeqNum)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(keySize))
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(valueSize))
+	buf[16] = entry.Op
+	copy(buf[17:17+keySize], entry.Key)
+	copy(buf[17+keySize:], entry.Value)
+
+	// Calculate checksum over the encoded data
+	checksum := w.checksum.Sum(buf)
+
+	// 1. Write checksum to the buffered writer
+	if err := binary.Write(w.bw, binary.LittleEndian, checksum); err != nil {
+		return PendingSync{}, err
+	}
+
+	// 2. Write the rest of the entry data
+	if _, err := w.bw.Write(buf); err != nil {
+		return PendingSync{}, err
+	}
+
+	// 3. Flush the buffer to the underlying file
+	// a.k.a moving data from application buffer to OS buffer
+	if err := w.bw.Flush(); err != nil {
+		return PendingSync{}, err
+	}
+	w.written++
+	w.io.Write(4 + len(buf))
+
+	if !sync {
+		return PendingSync{}, nil
+	}
+	if w.syncInterval > 0 {
+		// 4a. Let the background goroutine fsync this record with its neighbours
+		return PendingSync{wal: w, record: w.written}, nil
+	}
+	// 4. Fsync to guarantee the write to persistent storage
+	return PendingSync{}, w.file.Sync()
+}
+
+// Reader reads back the records of a WAL file.
+type Reader struct {
+	path     string
+	reader   *bufio.Reader
+	checksum base.ChecksumType
+	offset   int64 // Of the next record
+}
+
+// NewReader returns a reader of the records of file, which must be positioned
+// at its start.
+func NewReader(file vfs.File) (*Reader, error) {
+	reader := bufio.NewReader(file)
+	checksum, err := parseHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := readerOffset(file, reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{path: file.Name(), reader: reader, checksum: checksum, offset: offset}, nil
+}
+
+// Checksum returns the checksum type of the records.
+func (r *Reader) Checksum() base.ChecksumType {
+	return r.checksum
+}
+
+// Next returns the next raw record and its offset in the file, without
+// verifying it, so that Decode can verify records in parallel. It returns
+// io.EOF at the end of the log, and a *base.CorruptionError for a header with
+// impossible sizes.
+func (r *Reader) Next() ([]byte, int64, error) {
+	offset := r.offset
+	record, err := readRecord(r.reader)
+	if err != nil {
+		var ce *base.CorruptionError
+		if errors.As(err, &ce) {
+			ce.File, ce.Offset = r.path, offset
+		}
+		return nil, offset, err
+	}
+	r.offset += int64(len(record))
+	return record, offset, nil
+}
+
+// Decode verifies the checksum of a record that Next returned at offset and
+// decodes it. The key and value of the entry point into record. It is safe to
+// call concurrently.
+func (r *Reader) Decode(record []byte, offset int64) (LogEntry, error) {
+	payload := record[4:]
+	if binary.LittleEndian.Uint32(record[0:4]) != r.checksum.Sum(payload) {
+		return LogEntry{}, &base.CorruptionError{File: r.path, Offset: offset, Err: errors.New("checksum mismatch")}
+	}
+	keySize := binary.LittleEndian.Uint32(payload[8:12])
+	kv := payload[17:]
+	return LogEntry{Op: payload[16], Key: kv[:keySize], Value: kv[keySize:], SeqNum: binary.LittleEndian.Uint64(payload[0:8])}, nil
+}
+
+// readHeader returns the checksum type of the WAL file at path.
+func readHeader(fs vfs.FS, path string) (base.ChecksumType, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return parseHeader(bufio.NewReader(file))
+}
+
+// parseHeader consumes the header at the start of reader and returns the
+// checksum type it names. Files without a header use base.ChecksumLegacy.
+func parseHeader(reader *bufio.Reader) (base.ChecksumType, error) {
+	head, err := reader.Peek(HeaderSize)
+	if err != nil || string(head[:len(magic)]) != magic {
+		return base.ChecksumLegacy, nil
+	}
+	checksum := base.ChecksumType(head[len(magic)])
+	if !checksum.Valid() {
+		return 0, fmt.Errorf("unknown WAL checksum type %d", checksum)
+	}
+	reader.Discard(HeaderSize)
+	return checksum, nil
+}
+
+// readerOffset returns the offset in file of the next byte reader returns.
+func readerOffset(file io.Seeker, reader *bufio.Reader) (int64, error) {
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	return pos - int64(reader.Buffered()), nil
+}
+
+// readChunkSize is the largest buffer readRecord allocates before reading.
+const readChunkSize = 1 << 20
+
+// readRecord reads the next raw record without verifying it. A header with
+// impossible sizes is reported as a *CorruptionError, without File and Offset.
+// [Checksum (4 bytes)][Header][KV]
+// Header =  [Seq (8 byte)] [Key Size (4 bytes)] [Value Size (4 bytes)] [Operation (1 byte)]
+// KV     =  [Key] [Value]
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+	head := make([]byte, 4+8+4+4+1)
+	if _, err := io.ReadFull(reader, head[:4]); err != nil {
+		return nil, err // io.EOF at a record boundary
+	}
+	if _, err := io.ReadFull(reader, head[4:]); err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	keySize := binary.LittleEndian.Uint32(head[12:16])
+	valueSize := binary.LittleEndian.Uint32(head[16:20])
+	size := uint64(keySize) + uint64(valueSize)
+	if size > base.MaxEncodedSize {
+		return nil, &base.CorruptionError{Err: fmt.Errorf("record header claims %d bytes", size)}
+	}
+
+	// The sizes are not verified yet, and a torn header can claim gigabytes,
+	// so the buffer only grows as the data actually arrives.
+	record := bytes.NewBuffer(make([]byte, 0, len(head)+int(min(size, readChunkSize))))
+	record.Write(head)
+	if _, err := io.CopyN(record, reader, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("could not read key/value: %w", err)
+	}
+	return record.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/quangh33/Go-LevelDB/internal/base"
+	"github.com/quangh33/Go-LevelDB/vfs"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadRecordTornHeader(t *testing.T) {
+	for _, sizes := range [][2]uint32{{0xFFFFFFFF, 0xFFFFFFFF}, {16, 3 << 30}} {
+		head := make([]byte, 4+8+4+4+1)
+		binary.LittleEndian.PutUint32(head[12:16], sizes[0])
+		binary.LittleEndian.PutUint32(head[16:20], sizes[1])
+		r := bufio.NewReader(bytes.NewReader(append(head, "short"...)))
+		if _, err := readRecord(r); err == nil || err == io.EOF {
+			t.Errorf("Expected a corruption error for sizes %v, got %v", sizes, err)
+		}
+	}
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	fs := vfs.NewMemFS()
+	w, err := Open(fs, "db.wal", Options{Checksum: base.ChecksumXXHash64Low32})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	const n = 10
+	for seq := uint64(1); seq <= n; seq++ {
+		entry := &LogEntry{Op: OpPut, Key: []byte(fmt.Sprintf("key%d", seq)), Value: []byte("value"), SeqNum: seq}
+		if err := w.Write(entry, true); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	w.Close()
+
+	// Damage the value of the last record.
+	f, err := fs.Open("db.wal")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	raw, _ := io.ReadAll(f)
+	f.Close()
+	raw[len(raw)-1] ^= 0xFF
+	if f, err = fs.Create("db.wal"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write(raw)
+	f.Close()
+
+	f, err = fs.Open("db.wal")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	if r.Checksum() != base.ChecksumXXHash64Low32 {
+		t.Errorf("Checksum = %v, want %v", r.Checksum(), base.ChecksumXXHash64Low32)
+	}
+	wantOffset := int64(HeaderSize)
+	for seq := uint64(1); ; seq++ {
+		record, offset, err := r.Next()
+		if err == io.EOF {
+			t.Fatalf("Reached the end after %d records, want a corrupt record %d", seq-1, n)
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if offset != wantOffset {
+			t.Errorf("Record %d at offset %d, want %d", seq, offset, wantOffset)
+		}
+		wantOffset += int64(len(record))
+		entry, err := r.Decode(record, offset)
+		if seq == n {
+			var ce *base.CorruptionError
+			if !errors.As(err, &ce) || ce.File != "db.wal" || ce.Offset != offset {
+				t.Errorf("Decode of the damaged record returned %v, want a corruption at offset %d", err, offset)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if entry.SeqNum != seq || string(entry.Key) != fmt.Sprintf("key%d", seq) || string(entry.Value) != "value" {
+			t.Errorf("Record %d = %+v", seq, entry)
+		}
+	}
+}
+
+func TestPeriodicSync(t *testing.T) {
+	w, err := Open(vfs.NewMemFS(), "db.wal", Options{SyncInterval: 5 * time.Millisecond, Checksum: base.ChecksumCRC32C})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Go(func() {
+			for i := 0; i < 10; i++ {
+				entry := &LogEntry{Op: OpPut, Key: []byte(fmt.Sprintf("key%d-%d", g, i)), SeqNum: uint64(g*10 + i + 1)}
+				if err := w.Write(entry, true); err != nil {
+					t.Errorf("Write failed: %v", err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	written, synced := w.written, w.synced
+	w.mu.Unlock()
+	if synced != written {
+		t.Fatalf("Expected every acknowledged sync write to be synced, written=%d synced=%d", written, synced)
+	}
+}
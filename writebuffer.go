@@ -0,0 +1,99 @@
+package leveldb
+
+import (
+	"github.com/quangh33/Go-LevelDB/cache"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// WriteBufferManager bounds the memory of the memtables of every database that
+// shares it, so a process running several databases has one knob for their
+// total write buffer. Once the memtables exceed their share of the budget, the
+// database being written flushes its memtable early.
+type WriteBufferManager struct {
+	bufferSize       int64
+	chargeBlockCache bool
+
+	memtables atomic.Int64 // Bytes in active and immutable memtables
+
+	mu     sync.Mutex                     // Serializes register and unregister
+	caches atomic.Pointer[[]*cache.Cache] // Block caches of the open databases
+}
+
+// NewWriteBufferManager returns a manager with a budget of bufferSize bytes.
+// If chargeBlockCache is set, the block caches of the databases count against
+// the budget too.
+func NewWriteBufferManager(bufferSize int, chargeBlockCache bool) *WriteBufferManager {
+	return &WriteBufferManager{bufferSize: int64(bufferSize), chargeBlockCache: chargeBlockCache}
+}
+
+// BufferSize returns the memory budget in bytes.
+func (m *WriteBufferManager) BufferSize() int64 {
+	return m.bufferSize
+}
+
+// MemoryUsage returns the bytes held by memtables and, if charged, by block
+// caches of all databases using the manager.
+func (m *WriteBufferManager) MemoryUsage() int64 {
+	usage := m.memtables.Load()
+	if m.chargeBlockCache {
+		usage += m.cacheUsage()
+	}
+	return usage
+}
+
+// cacheUsage returns the bytes held by the block caches.
+func (m *WriteBufferManager) cacheUsage() int64 {
+	var usage int64
+	if caches := m.caches.Load(); caches != nil {
+		for _, c := range *caches {
+			usage += c.Usage()
+		}
+	}
+	return usage
+}
+
+// shouldFlush reports whether the memtables exceed their share of the budget:
+// what the charged block caches leave of it, but at least a quarter, so that
+// warm caches alone do not flush every memtable.
+func (m *WriteBufferManager) shouldFlush() bool {
+	memtables := m.memtables.Load()
+	if memtables > m.bufferSize {
+		return true
+	}
+	if !m.chargeBlockCache || memtables <= m.bufferSize/4 {
+		return false
+	}
+	return memtables > m.bufferSize-m.cacheUsage()
+}
+
+func (m *WriteBufferManager) reserve(n int64) {
+	m.memtables.Add(n)
+}
+
+func (m *WriteBufferManager) free(n int64) {
+	m.memtables.Add(-n)
+}
+
+// register and unregister replace the list of caches rather than change it, so
+// shouldFlush reads it without locking.
+func (m *WriteBufferManager) register(c *cache.Cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var caches []*cache.Cache
+	if old := m.caches.Load(); old != nil {
+		caches = slices.Clone(*old)
+	}
+	caches = append(caches, c)
+	m.caches.Store(&caches)
+}
+
+func (m *WriteBufferManager) unregister(c *cache.Cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old := m.caches.Load(); old != nil {
+		caches := slices.DeleteFunc(slices.Clone(*old), func(other *cache.Cache) bool { return other == c })
+		m.caches.Store(&caches)
+	}
+}